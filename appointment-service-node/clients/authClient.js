@@ -1,6 +1,7 @@
 const axios = require('axios');
 const serviceConfig = require('../config/serviceConfig');
 const { AUTH_SERVICE_URL } = require('../config/serviceConfig');
+const { serviceAuthHeaders } = require('./serviceToken');
 
 class AuthClient {
   constructor() {
@@ -9,7 +10,9 @@ class AuthClient {
 
   async getUserById(userId) {
     try {
-      const response = await axios.get(`${this.baseUrl}/users/${userId}`);
+      const response = await axios.get(`${this.baseUrl}/users/${userId}`, {
+        headers: await serviceAuthHeaders(),
+      });
       return response.data;
     } catch (error) {
       throw new Error('Failed to fetch user from auth service');
@@ -21,7 +24,9 @@ class AuthClient {
 
 const getDoctorDetails = async (doctorId) => {
   try {
-    const response = await axios.get(`${AUTH_SERVICE_URL}/doctors/${doctorId}`);
+    const response = await axios.get(`${AUTH_SERVICE_URL}/doctors/${doctorId}`, {
+      headers: await serviceAuthHeaders(),
+    });
     return response.data;
   } catch (error) {
     console.error(`Failed to fetch doctor details for ID: ${doctorId}`, error);
@@ -29,4 +34,22 @@ const getDoctorDetails = async (doctorId) => {
   }
 };
 
-module.exports = { getDoctorDetails, AuthClient: new AuthClient() };
+// Tells the auth service a doctor is seeing a patient, or no longer is when
+// the appointment is cancelled or deleted. Failures are logged and leave the
+// appointment itself untouched.
+const syncCareRelationship = async (appointment, status = appointment.status) => {
+  if (!serviceConfig.authClientId) {
+    return;
+  }
+  try {
+    await axios.put(
+      `${serviceConfig.authApiUrl}/patients/${appointment.patientId}/appointments/${appointment._id}`,
+      { doctorId: appointment.doctorId, status, endTime: appointment.endTime },
+      { headers: await serviceAuthHeaders() },
+    );
+  } catch (error) {
+    console.error(`Failed to report appointment ${appointment._id} to the auth service:`, error.message);
+  }
+};
+
+module.exports = { getDoctorDetails, syncCareRelationship, AuthClient: new AuthClient() };
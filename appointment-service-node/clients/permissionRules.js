@@ -0,0 +1,55 @@
+const axios = require('axios');
+const serviceConfig = require('../config/serviceConfig');
+
+// Tokens carry permissions compressed: doctor:* for every doctor permission,
+// and nothing another grant already implies (patient:history implies
+// patient:view). The auth service publishes the rules, which are applied
+// here so handlers can compare plain permission names.
+const RULES_CACHE_TTL_MS = 5 * 60 * 1000;
+
+let rulesCache = { rules: null, fetchedAt: 0 };
+
+const fetchRules = async () => {
+  const response = await axios.get(serviceConfig.authPermissionRulesUrl);
+  rulesCache = { rules: response.data, fetchedAt: Date.now() };
+};
+
+const getRules = async () => {
+  if (!rulesCache.rules || Date.now() - rulesCache.fetchedAt > RULES_CACHE_TTL_MS) {
+    await fetchRules();
+  }
+  return rulesCache.rules;
+};
+
+const resourceOf = (permission) => permission.split(':')[0];
+
+// The grant plus everything it implies, transitively
+const impliedBy = (rules, grant, seen = new Set()) => {
+  if (seen.has(grant)) return seen;
+  seen.add(grant);
+  for (const implied of rules.implies[grant] || []) {
+    impliedBy(rules, implied, seen);
+  }
+  return seen;
+};
+
+const covers = (rules, grant, permission) => {
+  if (grant === rules.all_permissions) return true;
+  if (grant.endsWith(rules.wildcard_suffix)) return resourceOf(grant) === resourceOf(permission);
+  return impliedBy(rules, grant).has(permission);
+};
+
+// Every permission the grants satisfy, plus grants the rules do not list
+// (doctor:self, patient:self), which only ever match themselves
+const expandWith = (rules, grants) => {
+  const list = grants || [];
+  const outside = list.filter((grant) => grant !== rules.all_permissions
+    && !grant.endsWith(rules.wildcard_suffix)
+    && !rules.permissions.includes(grant));
+  const held = rules.permissions.filter((permission) => list.some((grant) => covers(rules, grant, permission)));
+  return [...held, ...new Set(outside)];
+};
+
+const expand = async (grants) => expandWith(await getRules(), grants);
+
+module.exports = { expand, expandWith };
@@ -0,0 +1,37 @@
+const axios = require('axios');
+const serviceConfig = require('../config/serviceConfig');
+
+// This service's own access token for calls to other services, from the
+// auth service's client_credentials grant. Renewed a minute before expiry.
+const RENEW_BEFORE_EXPIRY_MS = 60 * 1000;
+
+let cached = null;
+
+const getServiceToken = async () => {
+  if (cached && cached.expiresAt - RENEW_BEFORE_EXPIRY_MS > Date.now()) {
+    return cached.token;
+  }
+  const response = await axios.post(
+    serviceConfig.authTokenUrl,
+    new URLSearchParams({ grant_type: 'client_credentials' }).toString(),
+    {
+      auth: { username: serviceConfig.authClientId, password: serviceConfig.authClientSecret },
+      headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
+    },
+  );
+  cached = {
+    token: response.data.access_token,
+    expiresAt: Date.now() + response.data.expires_in * 1000,
+  };
+  return cached.token;
+};
+
+// Headers for outgoing calls; empty when no service account is configured
+const serviceAuthHeaders = async () => {
+  if (!serviceConfig.authClientId) {
+    return {};
+  }
+  return { Authorization: `Bearer ${await getServiceToken()}` };
+};
+
+module.exports = { serviceAuthHeaders };
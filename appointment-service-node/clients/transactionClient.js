@@ -1,5 +1,6 @@
 const axios = require('axios');
 const serviceConfig = require('../config/serviceConfig');
+const { serviceAuthHeaders } = require('./serviceToken');
 
 class TransactionClient {
   constructor() {
@@ -8,7 +9,9 @@ class TransactionClient {
 
   async createTransaction(transactionData) {
     try {
-      const response = await axios.post(`${this.baseUrl}/transactions`, transactionData);
+      const response = await axios.post(`${this.baseUrl}/transactions`, transactionData, {
+        headers: await serviceAuthHeaders(),
+      });
       return response.data;
     } catch (error) {
       throw new Error('Failed to create transaction in transaction service');
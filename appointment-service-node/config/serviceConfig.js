@@ -6,6 +6,20 @@ const serviceConfig = {
   port: process.env.PORT || 8080,
   mongoURI: process.env.MONGO_URI || 'mongodb://localhost:27017/appointment_management_db',
   authServiceUrl: process.env.AUTH_SERVICE_URL || 'http://localhost:3001',
+  authJwksUrl: process.env.AUTH_JWKS_URL || 'http://localhost:8000/.well-known/jwks.json',
+  // How the compressed permissions in tokens expand
+  authPermissionRulesUrl: process.env.AUTH_PERMISSION_RULES_URL || 'http://localhost:8000/.well-known/permission-rules.json',
+  // Access tokens name this audience; must match ACCESS_TOKEN_AUDIENCE there
+  authTokenAudience: process.env.AUTH_TOKEN_AUDIENCE || 'healthcare-api',
+  // Service account this service introspects tokens and calls other services
+  // as; without one, tokens are only checked locally and revocations go
+  // unnoticed until they expire
+  authIntrospectionUrl: process.env.AUTH_INTROSPECTION_URL || 'http://localhost:8000/oauth/introspect',
+  authTokenUrl: process.env.AUTH_TOKEN_URL || 'http://localhost:8000/oauth/token',
+  authClientId: process.env.AUTH_CLIENT_ID,
+  authClientSecret: process.env.AUTH_CLIENT_SECRET,
+  // Appointments are reported here so doctors can open their patients' records
+  authApiUrl: process.env.AUTH_API_URL || 'http://localhost:8000/api/v1',
   transactionServiceUrl: process.env.TRANSACTION_SERVICE_URL || 'http://localhost:3002',
 };
 
@@ -1,17 +1,46 @@
 const { Appointment, STATUS_PENDING, STATUS_CONFIRMED, STATUS_CANCELLED, STATUS_COMPLETED } = require('../models/Appointment');
-const { getDoctorDetails } = require('../clients/authClient');
+const { getDoctorDetails, syncCareRelationship } = require('../clients/authClient');
+
+const STAFF_ROLES = ['admin', 'super_admin'];
+
+const forbidden = (message) => Object.assign(new Error(message), { statusCode: 403 });
+
+// The patient themselves, or scheduling staff
+const speaksForPatient = (user, patientId) => Boolean(user) && (
+  STAFF_ROLES.includes(user.role)
+  || (user.role === 'patient' && user.user_id === String(patientId))
+);
+
+// An appointment opens the patient's records to its doctor only once the
+// patient or staff have confirmed it; the doctor who would gain access
+// cannot confirm their way in. Booking alone does not open them either.
+const opensRecords = (appointment) => Boolean(appointment.confirmedBy)
+  && speaksForPatient({ user_id: appointment.confirmedBy, role: appointment.confirmedByRole }, appointment.patientId);
 
 class AppointmentController {
-  async scheduleAppointment(appointmentData) {
+  async scheduleAppointment(appointmentData, user) {
+    if (!speaksForPatient(user, appointmentData.patientId)) {
+      throw forbidden('Only the patient or scheduling staff may book an appointment');
+    }
     // TODO: Validate time slot availability (requires doctor schedule service)
-    const appointment = new Appointment(appointmentData);
+    const appointment = new Appointment({
+      ...appointmentData,
+      status: STATUS_PENDING,
+      confirmedBy: undefined,
+      confirmedByRole: undefined,
+    });
     await appointment.save();
     // TODO: Initialize patient record if needed (requires patient record service)
     return appointment;
   }
 
-  async confirmAppointment(id, price) {
-    const updateData = { status: STATUS_CONFIRMED, updatedAt: new Date() };
+  async confirmAppointment(id, price, user) {
+    const updateData = {
+      status: STATUS_CONFIRMED,
+      confirmedBy: user.user_id,
+      confirmedByRole: user.role,
+      updatedAt: new Date(),
+    };
     if (price !== undefined) {
       updateData.price = price;
     }
@@ -28,6 +57,9 @@ class AppointmentController {
       const paymentController = require('./paymentController');
       await paymentController.createPayment(appointment._id, price, null);
     }
+    if (opensRecords(appointment)) {
+      await syncCareRelationship(appointment);
+    }
     return appointment;
   }
 
@@ -43,17 +75,21 @@ class AppointmentController {
     return appointment;
   }
 
+  // Started appointments stay confirmed, so only confirmed ones can complete
   async completeAppointment(id, notes, prescription) {
-    const appointment = await Appointment.findById(id);
+    const appointment = await Appointment.findOneAndUpdate(
+      { _id: id, status: STATUS_CONFIRMED },
+      { status: STATUS_COMPLETED, notes, updatedAt: new Date() },
+      { new: true }
+    );
     if (!appointment) {
-      throw new Error('Appointment not found');
+      throw new Error('Appointment not found or not in confirmed status');
     }
     // TODO: Add doctor note to patient record (requires patient record service)
     // TODO: Create prescription if provided (requires prescription service)
-    appointment.status = STATUS_COMPLETED;
-    appointment.notes = notes;
-    appointment.updatedAt = new Date();
-    await appointment.save();
+    if (opensRecords(appointment)) {
+      await syncCareRelationship(appointment);
+    }
     return appointment;
   }
 
@@ -66,6 +102,7 @@ class AppointmentController {
     if (!appointment) {
       throw new Error('Appointment not found or cannot be canceled');
     }
+    await syncCareRelationship(appointment);
     return appointment;
   }
 
@@ -93,6 +130,9 @@ class AppointmentController {
 
   async deleteAppointment(id) {
     const appointment = await Appointment.findByIdAndDelete(id);
+    if (appointment) {
+      await syncCareRelationship(appointment, STATUS_CANCELLED);
+    }
     return appointment;
   }
 
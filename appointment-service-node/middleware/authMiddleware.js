@@ -1,7 +1,117 @@
-const jwt = require('jsonwebtoken');
+const crypto = require('crypto');
+const axios = require('axios');
 const serviceConfig = require('../config/serviceConfig');
+const permissionRules = require('../clients/permissionRules');
 
-const authMiddleware = (req, res, next) => {
+// Tokens are signed by the authentication service's rotating key ring, so
+// they are verified against its published JWKS instead of a shared secret.
+const JWKS_CACHE_TTL_MS = 5 * 60 * 1000;
+const MIN_JWKS_REFETCH_INTERVAL_MS = 5 * 1000;
+
+const verifiers = {
+  RS256: (data, key, signature) => crypto.verify('sha256', data, key, signature),
+  ES256: (data, key, signature) => crypto.verify('sha256', data, { key, dsaEncoding: 'ieee-p1363' }, signature),
+  EdDSA: (data, key, signature) => crypto.verify(null, data, key, signature),
+};
+
+// The key ring also signs ID tokens, which must not pass for access tokens
+const ACCESS_TOKEN_TYPE = 'at+jwt';
+// Tolerated difference between this host's clock and the auth service's
+const CLOCK_SKEW_SECONDS = 60;
+
+let jwksCache = { keys: new Map(), fetchedAt: 0 };
+
+const fetchJwks = async () => {
+  const response = await axios.get(serviceConfig.authJwksUrl);
+  const keys = new Map();
+  for (const jwk of response.data.keys || []) {
+    keys.set(jwk.kid, { alg: jwk.alg, publicKey: crypto.createPublicKey({ key: jwk, format: 'jwk' }) });
+  }
+  jwksCache = { keys, fetchedAt: Date.now() };
+};
+
+const getSigningKey = async (kid) => {
+  const age = Date.now() - jwksCache.fetchedAt;
+  if (age > JWKS_CACHE_TTL_MS || (!jwksCache.keys.has(kid) && age > MIN_JWKS_REFETCH_INTERVAL_MS)) {
+    await fetchJwks();
+  }
+  return jwksCache.keys.get(kid);
+};
+
+const verifyToken = async (token) => {
+  const parts = token.split('.');
+  if (parts.length !== 3) {
+    throw new Error('Malformed token');
+  }
+  const header = JSON.parse(Buffer.from(parts[0], 'base64url').toString('utf8'));
+  const payload = JSON.parse(Buffer.from(parts[1], 'base64url').toString('utf8'));
+
+  const key = await getSigningKey(header.kid);
+  if (!key || key.alg !== header.alg || !verifiers[key.alg]) {
+    throw new Error('Unknown signing key');
+  }
+  const signingInput = Buffer.from(`${parts[0]}.${parts[1]}`);
+  if (!verifiers[key.alg](signingInput, key.publicKey, Buffer.from(parts[2], 'base64url'))) {
+    throw new Error('Invalid signature');
+  }
+  if (String(header.typ || '').toLowerCase().replace(/^application\//, '') !== ACCESS_TOKEN_TYPE) {
+    throw new Error('Not an access token');
+  }
+  if (![].concat(payload.aud || []).includes(serviceConfig.authTokenAudience)) {
+    throw new Error('Token was issued for another audience');
+  }
+  // Every access token expires; one without exp would be good forever
+  const now = Math.floor(Date.now() / 1000);
+  if (typeof payload.exp !== 'number' || payload.exp + CLOCK_SKEW_SECONDS <= now) {
+    throw new Error('Token expired');
+  }
+  if (payload.nbf !== undefined && (typeof payload.nbf !== 'number' || payload.nbf - CLOCK_SKEW_SECONDS > now)) {
+    throw new Error('Token not yet valid');
+  }
+  return payload;
+};
+
+// Introspection answers, reused for the max-age the auth service allows
+const INTROSPECTION_CACHE_MAX_ENTRIES = 10000;
+const introspectionCache = new Map();
+
+const maxAgeOf = (cacheControl) => {
+  const match = /max-age=(\d+)/.exec(cacheControl || '');
+  return match ? parseInt(match[1], 10) : 0;
+};
+
+// Asks the auth service whether a token is still usable: not revoked, its
+// session not ended and its user not disabled
+const isTokenActive = async (token) => {
+  const key = crypto.createHash('sha256').update(token).digest('hex');
+  const cached = introspectionCache.get(key);
+  if (cached && cached.expiresAt > Date.now()) {
+    return cached.active;
+  }
+
+  const response = await axios.post(
+    serviceConfig.authIntrospectionUrl,
+    new URLSearchParams({ token, token_type_hint: 'access_token' }).toString(),
+    {
+      auth: { username: serviceConfig.authClientId, password: serviceConfig.authClientSecret },
+      headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
+    },
+  );
+  const active = response.data.active === true;
+  const maxAge = maxAgeOf(response.headers['cache-control']);
+  if (maxAge > 0) {
+    if (introspectionCache.size >= INTROSPECTION_CACHE_MAX_ENTRIES) {
+      for (const [cachedKey, entry] of introspectionCache) {
+        if (entry.expiresAt <= Date.now()) introspectionCache.delete(cachedKey);
+      }
+      if (introspectionCache.size >= INTROSPECTION_CACHE_MAX_ENTRIES) introspectionCache.clear();
+    }
+    introspectionCache.set(key, { active, expiresAt: Date.now() + maxAge * 1000 });
+  }
+  return active;
+};
+
+const authMiddleware = async (req, res, next) => {
   const authHeader = req.headers.authorization;
   if (!authHeader || !authHeader.startsWith('Bearer ')) {
     return res.status(401).json({ error: 'Unauthorized' });
@@ -9,12 +119,34 @@ const authMiddleware = (req, res, next) => {
 
   const token = authHeader.split(' ')[1];
   try {
-    const decoded = jwt.verify(token, process.env.JWT_SECRET || serviceConfig.jwtSecret || 'default_jwt_secret');
-    req.user = decoded;
-    next();
+    req.user = await verifyToken(token);
   } catch (err) {
     return res.status(401).json({ error: 'Invalid token' });
   }
+  if (serviceConfig.authClientId) {
+    let active;
+    try {
+      active = await isTokenActive(token);
+    } catch (err) {
+      return res.status(503).json({ error: 'Authentication service unavailable' });
+    }
+    if (!active) {
+      return res.status(401).json({ error: 'Invalid token' });
+    }
+  }
+  try {
+    req.user.permissions = await permissionRules.expand(req.user.permissions);
+  } catch (err) {
+    return res.status(503).json({ error: 'Authentication service unavailable' });
+  }
+  // Support staff impersonating a user: the auth service keeps the audit
+  // trail, the log line here ties this service's requests to the real actor
+  if (req.user.act) {
+    res.on('finish', () => {
+      console.log(`Impersonated request by ${req.user.act.email || req.user.act.sub} as ${req.user.email}: ${req.method} ${req.originalUrl} ${res.statusCode}`);
+    });
+  }
+  next();
 };
 
 module.exports = authMiddleware;
@@ -17,6 +17,9 @@ const appointmentSchema = new mongoose.Schema({
   },
   price: { type: Number, default: 0 },
   notes: { type: String, default: '' },
+  // Who confirmed it; decides whether the doctor may open the patient's records
+  confirmedBy: { type: String },
+  confirmedByRole: { type: String },
   createdAt: { type: Date, default: Date.now },
   updatedAt: { type: Date, default: Date.now },
 });
@@ -1,6 +1,7 @@
 const express = require('express');
 const router = express.Router();
 const appointmentController = require('../controllers/appointmentController');
+const authMiddleware = require('../middleware/authMiddleware');
 const mongoose = require('mongoose');
 
 // Helper middleware to validate ObjectId params
@@ -13,13 +14,16 @@ function validateObjectId(param) {
   };
 }
 
+// Every appointment route needs a token from the auth service
+router.use(authMiddleware);
+
 // Schedule a new appointment
 router.post('/', async (req, res) => {
   try {
-    const appointment = await appointmentController.scheduleAppointment(req.body);
+    const appointment = await appointmentController.scheduleAppointment(req.body, req.user);
     res.status(201).json(appointment);
   } catch (err) {
-    res.status(500).json({ error: err.message });
+    res.status(err.statusCode || 500).json({ error: err.message });
   }
 });
 
@@ -27,7 +31,7 @@ router.post('/', async (req, res) => {
 router.put('/:id/confirm', validateObjectId('id'), async (req, res) => {
   try {
     const { price } = req.body;
-    const appointment = await appointmentController.confirmAppointment(req.params.id, price);
+    const appointment = await appointmentController.confirmAppointment(req.params.id, price, req.user);
     res.json({ message: 'Appointment confirmed successfully', appointment });
   } catch (err) {
     res.status(500).json({ error: err.message });
@@ -0,0 +1,67 @@
+const { test, beforeEach } = require('node:test');
+const assert = require('node:assert/strict');
+const crypto = require('crypto');
+const { app, careRelationshipSyncs } = require('./support/app');
+
+const appointmentController = app('controllers/appointmentController');
+
+const id = () => crypto.randomBytes(12).toString('hex');
+
+const patientId = id();
+const doctorId = id();
+const PATIENT = { user_id: patientId, role: 'patient' };
+const DOCTOR = { user_id: doctorId, role: 'doctor' };
+const STAFF = { user_id: id(), role: 'admin' };
+
+const book = (user, overrides = {}) => appointmentController.scheduleAppointment({
+  patientId,
+  doctorId,
+  startTime: new Date(Date.now() + 60 * 60 * 1000),
+  endTime: new Date(Date.now() + 90 * 60 * 1000),
+  ...overrides,
+}, user);
+
+beforeEach(() => {
+  careRelationshipSyncs.length = 0;
+});
+
+test('only the patient or scheduling staff may book', async () => {
+  await book(PATIENT);
+  await book(STAFF);
+  await assert.rejects(book(DOCTOR), (error) => error.statusCode === 403);
+  await assert.rejects(book({ user_id: id(), role: 'patient' }), (error) => error.statusCode === 403);
+  // A booking cannot arrive already confirmed
+  const booked = await book(PATIENT, { status: 'confirmed', confirmedBy: doctorId, confirmedByRole: 'doctor' });
+  assert.equal(booked.status, 'pending');
+  assert.equal(booked.confirmedBy, undefined);
+});
+
+test('a doctor confirming their own appointment gains no access to the patient', async () => {
+  const booked = await book(STAFF);
+  await appointmentController.confirmAppointment(booked._id, undefined, DOCTOR);
+  await appointmentController.completeAppointment(booked._id, 'Seen');
+  assert.deepEqual(careRelationshipSyncs, []);
+});
+
+test('the patient or staff confirming opens the records to the doctor', async () => {
+  for (const confirmer of [PATIENT, STAFF]) {
+    const booked = await book(PATIENT);
+    await appointmentController.confirmAppointment(booked._id, undefined, confirmer);
+    await appointmentController.completeAppointment(booked._id, 'Seen');
+    assert.deepEqual(careRelationshipSyncs.splice(0).map((sync) => sync.status), ['confirmed', 'completed']);
+  }
+});
+
+test('only confirmed appointments can be completed', async () => {
+  const pending = await book(PATIENT);
+  await assert.rejects(appointmentController.completeAppointment(pending._id, 'Seen'), /not in confirmed status/);
+
+  const cancelled = await book(PATIENT);
+  await appointmentController.cancelAppointment(cancelled._id, 'Unwell');
+  await assert.rejects(appointmentController.completeAppointment(cancelled._id, 'Seen'), /not in confirmed status/);
+
+  const completed = await book(PATIENT);
+  await appointmentController.confirmAppointment(completed._id, undefined, PATIENT);
+  await appointmentController.completeAppointment(completed._id, 'Seen');
+  await assert.rejects(appointmentController.completeAppointment(completed._id, 'Again'), /not in confirmed status/);
+});
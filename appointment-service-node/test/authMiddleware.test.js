@@ -0,0 +1,52 @@
+const { test, before } = require('node:test');
+const assert = require('node:assert/strict');
+const crypto = require('crypto');
+const path = require('path');
+const { app, published } = require('./support/app');
+
+const authMiddleware = app('middleware/authMiddleware');
+const serviceConfig = app('config/serviceConfig');
+
+// Tokens signed the way the auth service signs them
+const authRoot = path.join(__dirname, '..', '..', 'authentication-service-node');
+const jwt = require(path.join(authRoot, 'utils', 'jwt'));
+const authRules = require(path.join(authRoot, 'utils', 'permissionRules'));
+
+const { publicKey, privateKey } = crypto.generateKeyPairSync('ed25519');
+const now = () => Math.floor(Date.now() / 1000);
+
+const sign = (claims, expiresIn = 60) => jwt.sign(
+  { user_id: 'user-1', role: 'patient', permissions: ['patient:self'], aud: serviceConfig.authTokenAudience, ...claims },
+  { privateKey, alg: 'EdDSA', kid: 'k1', expiresIn, jwtid: crypto.randomUUID(), typ: 'at+jwt' },
+);
+
+// Runs the middleware and answers with the status it sent, or 'next'
+const authenticate = (token) => new Promise((resolve) => {
+  const req = { headers: { authorization: `Bearer ${token}` } };
+  const res = { status: (code) => ({ json: () => resolve(code) }) };
+  authMiddleware(req, res, () => resolve('next'));
+});
+
+before(() => {
+  published[serviceConfig.authJwksUrl] = { keys: [{ ...publicKey.export({ format: 'jwk' }), kid: 'k1', alg: 'EdDSA' }] };
+  published[serviceConfig.authPermissionRulesUrl] = authRules.document();
+});
+
+test('accepts a current access token', async () => {
+  assert.equal(await authenticate(sign({})), 'next');
+});
+
+test('refuses tokens without an expiry or past it beyond the clock skew', async () => {
+  assert.equal(await authenticate(sign({}, 0)), 401);
+  assert.equal(await authenticate(sign({ exp: 'never' }, 0)), 401);
+  assert.equal(await authenticate(sign({ exp: now() - 120 }, 0)), 401);
+  // A clock slightly ahead of the auth service's still accepts it
+  assert.equal(await authenticate(sign({ exp: now() - 10 }, 0)), 'next');
+});
+
+test('refuses tokens not yet valid beyond the clock skew', async () => {
+  assert.equal(await authenticate(sign({ nbf: now() + 120 })), 401);
+  assert.equal(await authenticate(sign({ nbf: 'later' })), 401);
+  assert.equal(await authenticate(sign({ nbf: now() + 10 })), 'next');
+  assert.equal(await authenticate(sign({ nbf: now() - 10 })), 'next');
+});
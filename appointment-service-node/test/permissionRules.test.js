@@ -0,0 +1,34 @@
+const { test } = require('node:test');
+const assert = require('node:assert/strict');
+const path = require('path');
+const { app, published } = require('./support/app');
+
+const permissionRules = app('clients/permissionRules');
+const serviceConfig = app('config/serviceConfig');
+
+// The rules as the auth service publishes them, so a change there that this
+// service cannot follow fails here
+const authRules = require(path.join(__dirname, '..', '..', 'authentication-service-node', 'utils', 'permissionRules'));
+
+test('expands compressed token permissions with the published rules', async () => {
+  await assert.rejects(permissionRules.expand(['doctor:*']), /Request failed/);
+  published[serviceConfig.authPermissionRulesUrl] = authRules.document();
+
+  assert.deepEqual(await permissionRules.expand(['patient:history']), ['patient:view', 'patient:history']);
+  assert.deepEqual(await permissionRules.expand(['patient:self', 'patient:view']), ['patient:view', 'patient:self']);
+  assert.deepEqual(await permissionRules.expand([]), []);
+});
+
+test('arrives at the same permissions as the auth service', () => {
+  const rules = authRules.document();
+  const grantSets = [
+    ['doctor:*'],
+    ['doctor:approve', 'patient:history'],
+    ['all_permissions'],
+    ['admin:update', 'system:logs', 'doctor:self'],
+    rules.permissions,
+  ];
+  for (const grants of grantSets) {
+    assert.deepEqual(permissionRules.expandWith(rules, authRules.compress(grants)), authRules.expand(grants));
+  }
+});
@@ -0,0 +1,65 @@
+const Module = require('module');
+const path = require('path');
+// The auth service's in-memory models; both services' tests share the one copy
+const fakeModel = require('../../../authentication-service-node/test/support/fakeModel');
+
+// Loads the service's modules with in-memory models and without the auth
+// service, so tests run without MongoDB or the other services. GET requests
+// are answered from the published documents tests put in place. Require this
+// before anything from the app; each test file runs in its own process.
+
+const ROOT = path.join(__dirname, '..', '..');
+const MODELS = path.join(ROOT, 'models') + path.sep;
+const AUTH_CLIENT = path.join(ROOT, 'clients', 'authClient.js');
+
+const models = {};
+
+// Enough of mongoose for the model files to define their schemas
+class Schema {
+  pre() {}
+}
+Schema.Types = { ObjectId: String };
+const fakeMongoose = {
+  Schema,
+  model: (name) => {
+    if (!models[name]) models[name] = fakeModel();
+    return models[name];
+  },
+};
+
+// What the service told the auth service about appointments, oldest first
+const careRelationshipSyncs = [];
+const fakeAuthClient = {
+  getDoctorDetails: async (doctorId) => ({ _id: doctorId }),
+  syncCareRelationship: async (appointment, status = appointment.status) => {
+    careRelationshipSyncs.push({ appointmentId: appointment._id, doctorId: appointment.doctorId, status });
+  },
+};
+
+// Responses to GET requests, by URL
+const published = {};
+const fakeAxios = {
+  get: async (url) => {
+    if (!(url in published)) throw new Error(`Request failed: GET ${url}`);
+    return { data: published[url] };
+  },
+};
+
+const load = Module._load;
+Module._load = function loadWithFakes(request, parent, isMain) {
+  if (request === 'mongoose' && parent && parent.filename.startsWith(MODELS)) {
+    return fakeMongoose;
+  }
+  if (request === 'axios') {
+    return fakeAxios;
+  }
+  const filename = parent ? Module._resolveFilename(request, parent, isMain) : request;
+  if (filename === AUTH_CLIENT) {
+    return fakeAuthClient;
+  }
+  return load.apply(this, arguments);
+};
+
+const app = (relative) => require(path.join(ROOT, relative));
+
+module.exports = { app, models, careRelationshipSyncs, published };
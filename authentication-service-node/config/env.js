@@ -1,7 +1,23 @@
+const path = require('path');
 const dotenv = require('dotenv');
+const { ALGORITHMS, isSupportedAlgorithm } = require('../utils/jwt');
 
 dotenv.config();
 
+// Mail goes out over SMTP unless the file log is chosen explicitly, so
+// reset and verification links never end up in a shared log by default
+const mailTransport = process.env.MAIL_TRANSPORT || 'smtp';
+// "phi" keeps accounts with unverified email away from patient data
+const emailVerificationPolicy = process.env.EMAIL_VERIFICATION_POLICY || 'off';
+// What happens to the admins someone created when that admin loses
+// permissions: "flag" marks their excess for review, "cascade" removes it
+const delegationRevocationMode = process.env.DELEGATION_REVOCATION_MODE || 'flag';
+// Any of lower, upper, digit, symbol
+const passwordRequiredClasses = (process.env.PASSWORD_REQUIRED_CLASSES || '')
+  .split(',')
+  .map((name) => name.trim())
+  .filter(Boolean);
+
 const loadEnv = () => {
   if (!process.env.MONGO_URI) {
     throw new Error('MONGO_URI environment variable is not set');
@@ -9,24 +25,133 @@ const loadEnv = () => {
   if (!process.env.JWT_SECRET) {
     throw new Error('JWT_SECRET environment variable is not set');
   }
-  if (!process.env.REDIS_HOST) {
+  // Redis is optional for a single instance; once configured it must be complete
+  if (process.env.REDIS_HOST) {
+    if (!process.env.REDIS_PORT) {
+      throw new Error('REDIS_PORT environment variable is not set');
+    }
+    if (!process.env.REDIS_USERNAME) {
+      throw new Error('REDIS_USERNAME environment variable is not set');
+    }
+    if (!process.env.REDIS_PASSWORD) {
+      throw new Error('REDIS_PASSWORD environment variable is not set');
+    }
+  } else if (process.env.APP_ENV === 'production') {
     throw new Error('REDIS_HOST environment variable is not set');
   }
-  if (!process.env.REDIS_PORT) {
-    throw new Error('REDIS_PORT environment variable is not set');
+  if (process.env.JWT_SIGNING_ALG && !isSupportedAlgorithm(process.env.JWT_SIGNING_ALG)) {
+    throw new Error(`JWT_SIGNING_ALG must be one of ${Object.keys(ALGORITHMS).join(', ')}`);
+  }
+  if (!['smtp', 'log'].includes(mailTransport)) {
+    throw new Error('MAIL_TRANSPORT must be one of smtp, log');
+  }
+  if (mailTransport === 'smtp' && !process.env.EMAIL_HOST) {
+    throw new Error('EMAIL_HOST environment variable is not set');
+  }
+  if (mailTransport === 'log' && !process.env.MAIL_LOG_FILE) {
+    throw new Error('MAIL_LOG_FILE environment variable is not set');
+  }
+  const unknownClasses = passwordRequiredClasses.filter((name) => !['lower', 'upper', 'digit', 'symbol'].includes(name));
+  if (unknownClasses.length > 0) {
+    throw new Error(`Unknown PASSWORD_REQUIRED_CLASSES: ${unknownClasses.join(', ')}`);
   }
-  if (!process.env.REDIS_USERNAME) {
-    throw new Error('REDIS_USERNAME environment variable is not set');
+  if (!['off', 'phi'].includes(emailVerificationPolicy)) {
+    throw new Error('EMAIL_VERIFICATION_POLICY must be one of off, phi');
   }
-  if (!process.env.REDIS_PASSWORD) {
-    throw new Error('REDIS_PASSWORD environment variable is not set');
+  if (!['flag', 'cascade'].includes(delegationRevocationMode)) {
+    throw new Error('DELEGATION_REVOCATION_MODE must be one of flag, cascade');
   }
   // Add other required environment variables checks here
 };
 
 module.exports = {
   loadEnv,
+  // Encrypts signing keys at rest; tokens themselves are signed asymmetrically
   JWT_SECRET: process.env.JWT_SECRET,
+  JWT_SIGNING_ALG: process.env.JWT_SIGNING_ALG || 'RS256',
+  JWT_KEY_ROTATION_DAYS: parseInt(process.env.JWT_KEY_ROTATION_DAYS || '30', 10),
+  // The aud of every access token; the other services only accept this one
+  ACCESS_TOKEN_AUDIENCE: process.env.ACCESS_TOKEN_AUDIENCE || 'healthcare-api',
+  // Roles that must complete a second factor at login
+  MFA_REQUIRED_ROLES: (process.env.MFA_REQUIRED_ROLES || 'super_admin,admin,doctor')
+    .split(',')
+    .map((role) => role.trim())
+    .filter(Boolean),
+  MFA_ISSUER_NAME: process.env.MFA_ISSUER_NAME || 'Healthcare',
+  // Passkeys are bound to this relying party ID and accepted from these origins
+  WEBAUTHN_RP_ID: process.env.WEBAUTHN_RP_ID || 'localhost',
+  WEBAUTHN_RP_NAME: process.env.WEBAUTHN_RP_NAME || 'Healthcare',
+  WEBAUTHN_ORIGINS: (process.env.WEBAUTHN_ORIGINS || 'http://localhost:3000')
+    .split(',')
+    .map((origin) => origin.trim())
+    .filter(Boolean),
+  OIDC_ISSUER: process.env.OIDC_ISSUER || `http://localhost:${process.env.PORT || 5000}`,
+  // Frontend page that signs the user in during the authorization code flow
+  OIDC_LOGIN_URL: process.env.OIDC_LOGIN_URL || 'http://localhost:3000/oauth/authorize',
+  MAIL_TRANSPORT: mailTransport,
+  MAIL_FROM: process.env.MAIL_FROM || process.env.EMAIL_USERNAME || 'no-reply@localhost',
+  // The log transport appends messages here instead of printing them
+  MAIL_LOG_FILE: process.env.MAIL_LOG_FILE,
+  EMAIL_HOST: process.env.EMAIL_HOST,
+  EMAIL_PORT: parseInt(process.env.EMAIL_PORT || '587', 10),
+  EMAIL_USERNAME: process.env.EMAIL_USERNAME,
+  EMAIL_PASSWORD: process.env.EMAIL_PASSWORD,
+  // Frontend page that accepts the token from a reset email
+  PASSWORD_RESET_URL: process.env.PASSWORD_RESET_URL || 'http://localhost:3000/reset-password',
+  PASSWORD_RESET_TTL_MINUTES: parseInt(process.env.PASSWORD_RESET_TTL_MINUTES || '30', 10),
+  EMAIL_VERIFICATION_URL: process.env.EMAIL_VERIFICATION_URL || 'http://localhost:3000/verify-email',
+  EMAIL_VERIFICATION_TTL_HOURS: parseInt(process.env.EMAIL_VERIFICATION_TTL_HOURS || '24', 10),
+  EMAIL_VERIFICATION_POLICY: emailVerificationPolicy,
+  PASSWORD_MIN_LENGTH: parseInt(process.env.PASSWORD_MIN_LENGTH || '8', 10),
+  PASSWORD_MAX_LENGTH: parseInt(process.env.PASSWORD_MAX_LENGTH || '64', 10),
+  PASSWORD_REQUIRED_CLASSES: passwordRequiredClasses,
+  // Minimum strength score from 0 (trivial) to 4 (very strong)
+  PASSWORD_MIN_STRENGTH: parseInt(process.env.PASSWORD_MIN_STRENGTH || '2', 10),
+  PASSWORD_HISTORY_SIZE: parseInt(process.env.PASSWORD_HISTORY_SIZE || '5', 10),
+  // Set to an empty value to skip the breached-password check
+  BREACHED_PASSWORDS_PATH: process.env.BREACHED_PASSWORDS_PATH !== undefined
+    ? process.env.BREACHED_PASSWORDS_PATH
+    : path.join(__dirname, '..', 'data', 'breached-passwords.txt'),
+  LOGIN_ACCOUNT_FAILURE_THRESHOLD: parseInt(process.env.LOGIN_ACCOUNT_FAILURE_THRESHOLD || '5', 10),
+  LOGIN_IP_FAILURE_THRESHOLD: parseInt(process.env.LOGIN_IP_FAILURE_THRESHOLD || '20', 10),
+  LOGIN_LOCK_BASE_SECONDS: parseInt(process.env.LOGIN_LOCK_BASE_SECONDS || '60', 10),
+  LOGIN_LOCK_MAX_SECONDS: parseInt(process.env.LOGIN_LOCK_MAX_SECONDS || '3600', 10),
+  LOGIN_FAILURE_WINDOW_MINUTES: parseInt(process.env.LOGIN_FAILURE_WINDOW_MINUTES || '15', 10),
+  // Express "trust proxy" setting, so req.ip is the client behind the gateway or ingress
+  TRUST_PROXY: process.env.TRUST_PROXY || 'loopback',
+  // Sessions unused for this long end, even before their absolute lifetime
+  SESSION_IDLE_TIMEOUT_MINUTES: parseInt(process.env.SESSION_IDLE_TIMEOUT_MINUTES || '30', 10),
+  // API keys always expire; callers may pick a lifetime up to the maximum
+  API_KEY_DEFAULT_TTL_DAYS: parseInt(process.env.API_KEY_DEFAULT_TTL_DAYS || '90', 10),
+  API_KEY_MAX_TTL_DAYS: parseInt(process.env.API_KEY_MAX_TTL_DAYS || '365', 10),
+  // Introspection responses may be cached this long by the calling service,
+  // so revocations reach resource servers within this delay
+  INTROSPECTION_CACHE_SECONDS: parseInt(process.env.INTROSPECTION_CACHE_SECONDS || '30', 10),
+  // Doctors keep access to a patient's records this long after an appointment
+  CARE_RELATIONSHIP_APPOINTMENT_DAYS: parseInt(process.env.CARE_RELATIONSHIP_APPOINTMENT_DAYS || '90', 10),
+  // Upper bound on any appointment link, counted from when it is reported
+  CARE_RELATIONSHIP_MAX_DAYS: parseInt(process.env.CARE_RELATIONSHIP_MAX_DAYS || '180', 10),
+  // Break-glass grants open one patient's records to a clinician without a
+  // care relationship; the clinician picks a lifetime up to the maximum
+  BREAK_GLASS_DEFAULT_MINUTES: parseInt(process.env.BREAK_GLASS_DEFAULT_MINUTES || '60', 10),
+  BREAK_GLASS_MAX_MINUTES: parseInt(process.env.BREAK_GLASS_MAX_MINUTES || '240', 10),
+  // Compliance officers told about every grant; when empty, admins holding
+  // break_glass:review are told instead
+  BREAK_GLASS_NOTIFY_EMAILS: (process.env.BREAK_GLASS_NOTIFY_EMAILS || '')
+    .split(',')
+    .map((email) => email.trim())
+    .filter(Boolean),
+  DELEGATION_REVOCATION_MODE: delegationRevocationMode,
+  // Frontend page that finishes a sign-in through an external identity provider
+  FEDERATED_LOGIN_URL: process.env.FEDERATED_LOGIN_URL || 'http://localhost:3000/login/federated',
+  // SAML service provider entity ID; defaults to the metadata URL
+  SAML_SP_ENTITY_ID: process.env.SAML_SP_ENTITY_ID,
+  // PEM files: the key signs AuthnRequests and the certificate is published in
+  // the metadata. SAML sign-in stays off until both are set.
+  SAML_SP_PRIVATE_KEY_PATH: process.env.SAML_SP_PRIVATE_KEY_PATH,
+  SAML_SP_CERTIFICATE_PATH: process.env.SAML_SP_CERTIFICATE_PATH,
+  // How often staff accounts are checked against their LDAP directories
+  LDAP_SYNC_INTERVAL_MINUTES: parseInt(process.env.LDAP_SYNC_INTERVAL_MINUTES || '60', 10),
   MONGO_URI: process.env.MONGO_URI,
   REDIS_HOST: process.env.REDIS_HOST,
   REDIS_PORT: process.env.REDIS_PORT,
@@ -1,10 +1,18 @@
 const asyncHandler = require('express-async-handler');
 const adminService = require('../services/adminService');
+const authService = require('../services/authServiceInstance');
+const mfaService = require('../services/mfaService');
+const delegationService = require('../services/delegationService');
+const { requestContext } = require('../utils/requestContext');
 
 const createAdmin = asyncHandler(async (req, res) => {
-  const { email, password, permissions } = req.body;
-  const creatorId = req.user.user_id;
-  await adminService.createAdmin(creatorId, email, password, permissions);
+  const { email, password, permissions, roles } = req.body;
+  try {
+    await adminService.createAdmin(req.user, email, password, permissions, roles);
+  } catch (error) {
+    res.status(delegationService.isGrantDenied(error) ? 403 : 400);
+    throw error;
+  }
   res.status(201).json({ message: 'Admin created' });
 });
 
@@ -30,8 +38,60 @@ const deleteAdmin = asyncHandler(async (req, res) => {
 
 const updateAdminPermissions = asyncHandler(async (req, res) => {
   const permissions = req.body.permissions;
-  await adminService.updateAdminPermissions(req.params.id, permissions);
-  res.json({ message: 'Admin permissions updated' });
+  let result;
+  try {
+    result = await adminService.updateAdminPermissions(req.user, req.params.id, permissions, requestContext(req));
+  } catch (error) {
+    if (error.message === 'Admin not found') res.status(404);
+    else res.status(delegationService.isGrantDenied(error) ? 403 : 400);
+    throw error;
+  }
+  res.json({ message: 'Admin permissions updated', ...result });
+});
+
+// Who created the admin, up to a super admin, and the admins created below them
+const getDelegation = asyncHandler(async (req, res) => {
+  let delegation;
+  try {
+    delegation = await delegationService.describe(req.params.id);
+  } catch (error) {
+    res.status(404);
+    throw error;
+  }
+  res.json(delegation);
+});
+
+// Admins holding permissions their creator has lost, for review
+const listDelegationFlags = asyncHandler(async (req, res) => {
+  const flagged = await delegationService.listFlagged();
+  res.json(flagged);
+});
+
+const revokeUserTokens = asyncHandler(async (req, res) => {
+  await authService.revokeAllTokensForUser(req.params.userId);
+  res.json({ message: 'All tokens revoked for user' });
+});
+
+const listLockouts = asyncHandler(async (req, res) => {
+  const lockouts = await authService.loginThrottle.listLocked(req.query.kind);
+  res.json(lockouts);
+});
+
+const clearLockout = asyncHandler(async (req, res) => {
+  try {
+    await authService.loginThrottle.clear(req.params.id);
+  } catch (error) {
+    res.status(404);
+    throw error;
+  }
+  res.json({ message: 'Lockout cleared' });
+});
+
+const resetUserMfa = asyncHandler(async (req, res) => {
+  await mfaService.resetForUser(req.params.userId);
+  // The user has to enroll again, so existing sessions are ended too
+  await authService.revokeAllTokensForUser(req.params.userId);
+  res.json({ message: 'MFA reset for user' });
 });
 
 module.exports = {
@@ -41,4 +101,10 @@ module.exports = {
   updateAdmin,
   deleteAdmin,
   updateAdminPermissions,
+  getDelegation,
+  listDelegationFlags,
+  revokeUserTokens,
+  listLockouts,
+  clearLockout,
+  resetUserMfa,
 };
@@ -0,0 +1,88 @@
+const asyncHandler = require('express-async-handler');
+const apiKeyService = require('../services/apiKeyService');
+
+// Self-service: keys owned by the caller
+const createKey = asyncHandler(async (req, res) => {
+  let result;
+  try {
+    result = await apiKeyService.createKey(req.user, req.body);
+  } catch (error) {
+    res.status(400);
+    throw error;
+  }
+  // The key is only ever shown here
+  res.status(201).json({ ...result.apiKey, key: result.key });
+});
+
+const listKeys = asyncHandler(async (req, res) => {
+  const keys = await apiKeyService.listKeys(req.user.user_id);
+  res.json(keys);
+});
+
+const getKey = asyncHandler(async (req, res) => {
+  try {
+    const apiKey = await apiKeyService.getKey(req.user.user_id, req.params.id);
+    res.json(apiKey);
+  } catch (error) {
+    res.status(404);
+    throw error;
+  }
+});
+
+const updateKey = asyncHandler(async (req, res) => {
+  try {
+    const apiKey = await apiKeyService.updateKey(req.user, req.params.id, req.body);
+    res.json(apiKey);
+  } catch (error) {
+    res.status(error.message === 'API key not found' ? 404 : 400);
+    throw error;
+  }
+});
+
+const revokeKey = asyncHandler(async (req, res) => {
+  try {
+    await apiKeyService.revokeKey(req.user.user_id, req.params.id);
+  } catch (error) {
+    res.status(404);
+    throw error;
+  }
+  res.json({ message: 'API key revoked' });
+});
+
+const rotateKey = asyncHandler(async (req, res) => {
+  let result;
+  try {
+    result = await apiKeyService.rotateKey(req.user, req.params.id, req.body);
+  } catch (error) {
+    res.status(error.message === 'API key not found' ? 404 : 400);
+    throw error;
+  }
+  res.status(201).json({ ...result.apiKey, key: result.key });
+});
+
+// Admin: any account's keys
+const listUserKeys = asyncHandler(async (req, res) => {
+  const keys = await apiKeyService.listKeys(req.params.userId);
+  res.json(keys);
+});
+
+const revokeUserKey = asyncHandler(async (req, res) => {
+  try {
+    await apiKeyService.revokeKey(req.params.userId, req.params.keyId);
+  } catch (error) {
+    res.status(404);
+    throw error;
+  }
+  res.json({ message: 'API key revoked' });
+});
+
+module.exports = {
+  createKey,
+  listKeys,
+  getKey,
+  updateKey,
+  revokeKey,
+  rotateKey,
+  listUserKeys,
+  revokeUserKey,
+};
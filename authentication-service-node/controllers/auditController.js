@@ -0,0 +1,18 @@
+const asyncHandler = require('express-async-handler');
+const auditLog = require('../services/auditLog');
+
+// Filters: action, actorId, subjectId, sessionId, before, limit
+const listEvents = asyncHandler(async (req, res) => {
+  let events;
+  try {
+    events = await auditLog.list(req.query);
+  } catch (error) {
+    res.status(400);
+    throw error;
+  }
+  res.json(events);
+});
+
+module.exports = {
+  listEvents,
+};
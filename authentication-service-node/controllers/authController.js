@@ -1,20 +1,26 @@
 const asyncHandler = require('express-async-handler');
 const authService = require('../services/authServiceInstance');
+const loginResponse = require('../utils/loginResponse');
+const permissionRules = require('../utils/permissionRules');
+const { requestContext } = require('../utils/requestContext');
 
-const jwt = require('jsonwebtoken');
 const login = asyncHandler(async (req, res) => {
-  const { email, password } = req.body;
-  const token = await authService.login(email, password);
-  // Decode token to extract user info
-  const decoded = jwt.decode(token);
-  const user = {
-    id: decoded.user_id,
-    email: decoded.email,
-    role: decoded.role,
-    permissions: decoded.permissions,
-    patientId: decoded.patientId || null, // Include patientId if available
-  };
-  res.json({ token, user });
+  const { email, password, role } = req.body;
+  let result;
+  try {
+    result = await authService.login(email, password, requestContext(req), role || null);
+  } catch (error) {
+    if (error.retryAfterSeconds) {
+      res.set('Retry-After', String(error.retryAfterSeconds));
+    }
+    throw error;
+  }
+  if (result.mfaRequired) {
+    // Second step goes through /mfa/verify or, for first-time staff, /mfa/challenge/enroll
+    const { mfaToken, methods, enrollmentRequired } = result;
+    return res.json({ mfaRequired: true, mfaToken, methods, enrollmentRequired });
+  }
+  res.json(loginResponse(result));
 });
 
 const initializeSuperAdmin = asyncHandler(async (req, res) => {
@@ -28,35 +34,46 @@ const initializeSuperAdmin = asyncHandler(async (req, res) => {
 });
 
 const refreshToken = asyncHandler(async (req, res) => {
-  const { token } = req.body;
-  const newToken = await authService.refreshToken(token);
-  res.json({ token: newToken });
+  const { refreshToken } = req.body;
+  try {
+    const tokens = await authService.refreshToken(refreshToken, null, requestContext(req));
+    res.json({ token: tokens.token, refreshToken: tokens.refreshToken });
+  } catch (error) {
+    res.status(401);
+    throw error;
+  }
 });
 
 const revokeToken = asyncHandler(async (req, res) => {
-  const { token } = req.body;
-  await authService.revokeToken(token);
+  const { token, refreshToken } = req.body;
+  if (refreshToken) {
+    await authService.revokeRefreshToken(refreshToken);
+  }
+  if (token) {
+    await authService.revokeToken(token);
+  }
   res.json({ message: 'Token revoked' });
 });
 
-const googleLogin = asyncHandler(async (req, res) => {
-  // Implement Google login redirect logic
-  res.json({ message: 'Google login - to be implemented' });
-});
-
-const googleCallback = asyncHandler(async (req, res) => {
-  // Implement Google login callback logic
-  res.json({ message: 'Google callback - to be implemented' });
+const changePassword = asyncHandler(async (req, res) => {
+  const { currentPassword, newPassword } = req.body;
+  try {
+    await authService.changePassword(req.user, currentPassword, newPassword);
+  } catch (error) {
+    res.status(400);
+    throw error;
+  }
+  res.json({ message: 'Password changed, please sign in again' });
 });
 
-const facebookLogin = asyncHandler(async (req, res) => {
-  // Implement Facebook login redirect logic
-  res.json({ message: 'Facebook login - to be implemented' });
+const getPasswordPolicy = asyncHandler(async (req, res) => {
+  res.json(authService.passwordPolicy.describe());
 });
 
-const facebookCallback = asyncHandler(async (req, res) => {
-  // Implement Facebook login callback logic
-  res.json({ message: 'Facebook callback - to be implemented' });
+// Every permission the caller holds, with wildcards and implications spelled
+// out; token payloads carry the compressed form
+const getPermissions = asyncHandler(async (req, res) => {
+  res.json({ permissions: permissionRules.expand(req.user.permissions) });
 });
 
 module.exports = {
@@ -64,8 +81,7 @@ module.exports = {
   initializeSuperAdmin,
   refreshToken,
   revokeToken,
-  googleLogin,
-  googleCallback,
-  facebookLogin,
-  facebookCallback,
+  changePassword,
+  getPasswordPolicy,
+  getPermissions,
 };
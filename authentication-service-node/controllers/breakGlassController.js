@@ -0,0 +1,71 @@
+const asyncHandler = require('express-async-handler');
+const breakGlassService = require('../services/breakGlassService');
+const { requestContext } = require('../utils/requestContext');
+
+// Doctor: emergency access to a patient they have no care relationship with.
+// Body: justification, optional durationMinutes
+const requestAccess = asyncHandler(async (req, res) => {
+  let grant;
+  try {
+    grant = await breakGlassService.request(req.user, req.params.id, req.body, requestContext(req));
+  } catch (error) {
+    res.status(error.message === 'Patient not found' ? 404 : 400);
+    throw error;
+  }
+  res.status(201).json(grant);
+});
+
+const endAccess = asyncHandler(async (req, res) => {
+  let grant;
+  try {
+    grant = await breakGlassService.end(req.user, req.params.id, requestContext(req));
+  } catch (error) {
+    res.status(404);
+    throw error;
+  }
+  res.json(grant);
+});
+
+// Review queue. Filters: status (pending by default, or all), userId,
+// patientId, before, limit
+const listGrants = asyncHandler(async (req, res) => {
+  let grants;
+  try {
+    grants = await breakGlassService.listGrants(req.query);
+  } catch (error) {
+    res.status(400);
+    throw error;
+  }
+  res.json(grants);
+});
+
+const getGrant = asyncHandler(async (req, res) => {
+  let grant;
+  try {
+    grant = await breakGlassService.getGrant(req.params.grantId);
+  } catch (error) {
+    res.status(404);
+    throw error;
+  }
+  res.json(grant);
+});
+
+// Body: decision (accepted or flagged), optional note
+const reviewGrant = asyncHandler(async (req, res) => {
+  let grant;
+  try {
+    grant = await breakGlassService.review(req.user, req.params.grantId, req.body, requestContext(req));
+  } catch (error) {
+    res.status(error.message === 'Break-glass grant not found' ? 404 : 400);
+    throw error;
+  }
+  res.json(grant);
+});
+
+module.exports = {
+  requestAccess,
+  endAccess,
+  listGrants,
+  getGrant,
+  reviewGrant,
+};
@@ -0,0 +1,27 @@
+const asyncHandler = require('express-async-handler');
+const emailVerificationService = require('../services/emailVerificationService');
+
+const resendVerification = asyncHandler(async (req, res) => {
+  try {
+    await emailVerificationService.resend(req.body.email);
+  } catch (error) {
+    res.status(400);
+    throw error;
+  }
+  res.status(202).json({ message: 'If the address needs verifying, a new link has been sent' });
+});
+
+const confirmVerification = asyncHandler(async (req, res) => {
+  try {
+    await emailVerificationService.confirm(req.body.token);
+  } catch (error) {
+    res.status(400);
+    throw error;
+  }
+  res.json({ message: 'Email address verified' });
+});
+
+module.exports = {
+  resendVerification,
+  confirmVerification,
+};
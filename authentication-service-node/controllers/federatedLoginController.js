@@ -0,0 +1,102 @@
+const asyncHandler = require('express-async-handler');
+const federatedLoginService = require('../services/federatedLoginService');
+const identityProviderService = require('../services/identityProviderService');
+const samlProviderService = require('../services/samlProviderService');
+const loginResponse = require('../utils/loginResponse');
+const { requestContext } = require('../utils/requestContext');
+
+// OpenID Connect and SAML providers; protocol tells the frontend which
+// endpoints to use
+const listProviders = asyncHandler(async (req, res) => {
+  const oidcProviders = await identityProviderService.listEnabledProviders();
+  const samlProviders = await samlProviderService.listEnabledProviders();
+  res.json([
+    ...oidcProviders.map((provider) => ({ ...provider, protocol: 'oidc' })),
+    ...samlProviders.map((provider) => ({ ...provider, protocol: 'saml' })),
+  ]);
+});
+
+// The frontend keeps browserKey and sends the user to authorizationUrl
+const beginLogin = asyncHandler(async (req, res) => {
+  const { role, returnTo } = req.body;
+  let result;
+  try {
+    result = await federatedLoginService.beginLogin(req.params.slug, { role, returnTo });
+  } catch (error) {
+    res.status(error.message === 'Unknown identity provider' ? 404 : 502);
+    throw error;
+  }
+  res.json(result);
+});
+
+// Providers redirect here with GET, or POST when using response_mode=form_post
+const callback = asyncHandler(async (req, res) => {
+  const params = req.method === 'POST' ? req.body : req.query;
+  res.redirect(await federatedLoginService.handleCallback(req.params.slug, params));
+});
+
+const completeLogin = asyncHandler(async (req, res) => {
+  const { code, browserKey } = req.body;
+  let result;
+  try {
+    result = await federatedLoginService.completeLogin(code, browserKey, requestContext(req));
+  } catch (error) {
+    res.status(401);
+    throw error;
+  }
+  if (result.mfaRequired) {
+    const { mfaToken, methods, enrollmentRequired } = result;
+    return res.json({ mfaRequired: true, mfaToken, methods, enrollmentRequired });
+  }
+  res.json(loginResponse(result));
+});
+
+// Self-service: identities linked to the caller's account
+const listIdentities = asyncHandler(async (req, res) => {
+  const identities = await federatedLoginService.listIdentities(req.user);
+  res.json(identities);
+});
+
+const beginLink = asyncHandler(async (req, res) => {
+  let result;
+  try {
+    result = await federatedLoginService.beginLink(req.user, req.body.provider);
+  } catch (error) {
+    res.status(error.message === 'Unknown identity provider' ? 404 : 400);
+    throw error;
+  }
+  res.json(result);
+});
+
+const completeLink = asyncHandler(async (req, res) => {
+  const { code, browserKey } = req.body;
+  let identity;
+  try {
+    identity = await federatedLoginService.completeLink(req.user, code, browserKey);
+  } catch (error) {
+    res.status(400);
+    throw error;
+  }
+  res.status(201).json(identity);
+});
+
+const unlinkIdentity = asyncHandler(async (req, res) => {
+  try {
+    await federatedLoginService.unlinkIdentity(req.user, req.params.id);
+  } catch (error) {
+    res.status(error.message === 'Linked identity not found' ? 404 : 400);
+    throw error;
+  }
+  res.json({ message: 'Identity unlinked' });
+});
+
+module.exports = {
+  listProviders,
+  beginLogin,
+  callback,
+  completeLogin,
+  listIdentities,
+  beginLink,
+  completeLink,
+  unlinkIdentity,
+};
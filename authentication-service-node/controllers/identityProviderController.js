@@ -0,0 +1,55 @@
+const asyncHandler = require('express-async-handler');
+const identityProviderService = require('../services/identityProviderService');
+
+const createProvider = asyncHandler(async (req, res) => {
+  let provider;
+  try {
+    provider = await identityProviderService.createProvider(req.user.user_id, req.body);
+  } catch (error) {
+    res.status(400);
+    throw error;
+  }
+  res.status(201).json(provider);
+});
+
+const listProviders = asyncHandler(async (req, res) => {
+  const providers = await identityProviderService.listProviders();
+  res.json(providers);
+});
+
+const getProvider = asyncHandler(async (req, res) => {
+  const provider = await identityProviderService.getProvider(req.params.slug);
+  if (!provider) {
+    res.status(404);
+    throw new Error('Identity provider not found');
+  }
+  res.json(identityProviderService.toPublic(provider));
+});
+
+const updateProvider = asyncHandler(async (req, res) => {
+  try {
+    const provider = await identityProviderService.updateProvider(req.params.slug, req.body);
+    res.json(provider);
+  } catch (error) {
+    res.status(error.message === 'Identity provider not found' ? 404 : 400);
+    throw error;
+  }
+});
+
+const deleteProvider = asyncHandler(async (req, res) => {
+  try {
+    await identityProviderService.deleteProvider(req.params.slug);
+  } catch (error) {
+    res.status(error.message === 'Identity provider not found' ? 404 : 409);
+    throw error;
+  }
+  res.json({ message: 'Identity provider deleted' });
+});
+
+module.exports = {
+  createProvider,
+  listProviders,
+  getProvider,
+  updateProvider,
+  deleteProvider,
+};
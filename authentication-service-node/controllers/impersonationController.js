@@ -0,0 +1,38 @@
+const asyncHandler = require('express-async-handler');
+const impersonationService = require('../services/impersonationService');
+const loginResponse = require('../utils/loginResponse');
+const { requestContext } = require('../utils/requestContext');
+
+// Admin: sign in as a doctor or patient. No refresh token is issued; the
+// client keeps its own session to return to when this one ends.
+const startImpersonation = asyncHandler(async (req, res) => {
+  let result;
+  try {
+    result = await impersonationService.start(req.user, {
+      userId: req.params.userId,
+      role: req.body.role,
+      reason: req.body.reason,
+    }, requestContext(req));
+  } catch (error) {
+    res.status(error.message === 'User not found' ? 404 : 400);
+    throw error;
+  }
+  const { token, user } = loginResponse({ token: result.token });
+  res.status(201).json({ token, expiresIn: result.expiresIn, expiresAt: result.expiresAt, reason: result.reason, user });
+});
+
+// Called with the impersonation token
+const endImpersonation = asyncHandler(async (req, res) => {
+  try {
+    await impersonationService.end(req.user, requestContext(req));
+  } catch (error) {
+    res.status(400);
+    throw error;
+  }
+  res.json({ message: 'Impersonation ended' });
+});
+
+module.exports = {
+  startImpersonation,
+  endImpersonation,
+};
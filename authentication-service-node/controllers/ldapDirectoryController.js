@@ -0,0 +1,77 @@
+const asyncHandler = require('express-async-handler');
+const ldapDirectoryService = require('../services/ldapDirectoryService');
+const delegationService = require('../services/delegationService');
+const directorySyncService = require('../services/directorySyncService');
+
+const grantErrorStatus = (error) => (delegationService.isGrantDenied(error) ? 403 : 400);
+
+const createDirectory = asyncHandler(async (req, res) => {
+  let directory;
+  try {
+    directory = await ldapDirectoryService.createDirectory(req.user, req.body);
+  } catch (error) {
+    res.status(grantErrorStatus(error));
+    throw error;
+  }
+  res.status(201).json(directory);
+});
+
+const listDirectories = asyncHandler(async (req, res) => {
+  const directories = await ldapDirectoryService.listDirectories();
+  res.json(directories);
+});
+
+const getDirectory = asyncHandler(async (req, res) => {
+  const directory = await ldapDirectoryService.getDirectory(req.params.slug);
+  if (!directory) {
+    res.status(404);
+    throw new Error('Directory not found');
+  }
+  res.json(ldapDirectoryService.toPublic(directory));
+});
+
+const updateDirectory = asyncHandler(async (req, res) => {
+  try {
+    const directory = await ldapDirectoryService.updateDirectory(req.user, req.params.slug, req.body);
+    res.json(directory);
+  } catch (error) {
+    res.status(error.message === 'Directory not found' ? 404 : grantErrorStatus(error));
+    throw error;
+  }
+});
+
+const deleteDirectory = asyncHandler(async (req, res) => {
+  try {
+    await ldapDirectoryService.deleteDirectory(req.params.slug);
+  } catch (error) {
+    res.status(404);
+    throw error;
+  }
+  res.json({ message: 'Directory deleted' });
+});
+
+// Runs the account sync for one directory now
+const syncDirectory = asyncHandler(async (req, res) => {
+  const directory = await ldapDirectoryService.getDirectory(req.params.slug);
+  if (!directory) {
+    res.status(404);
+    throw new Error('Directory not found');
+  }
+  let report;
+  try {
+    report = await directorySyncService.syncDirectory(directory);
+  } catch (error) {
+    res.status(502);
+    throw error;
+  }
+  res.json(report);
+});
+
+module.exports = {
+  createDirectory,
+  listDirectories,
+  getDirectory,
+  updateDirectory,
+  deleteDirectory,
+  syncDirectory,
+};
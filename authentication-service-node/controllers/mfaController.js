@@ -0,0 +1,72 @@
+const asyncHandler = require('express-async-handler');
+const mfaService = require('../services/mfaService');
+const loginResponse = require('../utils/loginResponse');
+
+// Runs an MFA step and answers 401 when the factor or challenge is rejected,
+// or 429 while failed attempts keep the account locked
+const withUnauthorized = async (res, fn) => {
+  try {
+    return await fn();
+  } catch (error) {
+    if (error.retryAfterSeconds) {
+      res.set('Retry-After', String(error.retryAfterSeconds));
+    }
+    res.status(error.statusCode || 401);
+    throw error;
+  }
+};
+
+const verifyChallenge = asyncHandler(async (req, res) => {
+  const { mfaToken, code, recoveryCode } = req.body;
+  const tokens = await withUnauthorized(res, () => mfaService.verifyChallenge(mfaToken, { code, recoveryCode }));
+  res.json(loginResponse(tokens));
+});
+
+const beginChallengeEnrollment = asyncHandler(async (req, res) => {
+  const enrollment = await withUnauthorized(res, () => mfaService.beginChallengeEnrollment(req.body.mfaToken));
+  res.json(enrollment);
+});
+
+const confirmChallengeEnrollment = asyncHandler(async (req, res) => {
+  const { mfaToken, code } = req.body;
+  const result = await withUnauthorized(res, () => mfaService.confirmChallengeEnrollment(mfaToken, code));
+  res.json({ ...loginResponse(result), recoveryCodes: result.recoveryCodes });
+});
+
+const getStatus = asyncHandler(async (req, res) => {
+  const status = await mfaService.getStatus(req.user.user_id, req.user.role);
+  res.json(status);
+});
+
+const beginEnrollment = asyncHandler(async (req, res) => {
+  const { code, recoveryCode } = req.body;
+  const enrollment = await mfaService.beginSelfEnrollment(req.user, { code, recoveryCode });
+  res.json(enrollment);
+});
+
+const confirmEnrollment = asyncHandler(async (req, res) => {
+  const recoveryCodes = await mfaService.confirmEnrollment(req.user.user_id, req.user.role, req.body.code);
+  res.json({ recoveryCodes });
+});
+
+const regenerateRecoveryCodes = asyncHandler(async (req, res) => {
+  const recoveryCodes = await mfaService.regenerateRecoveryCodes(req.user.user_id, req.user.role, req.body.code);
+  res.json({ recoveryCodes });
+});
+
+const disable = asyncHandler(async (req, res) => {
+  const { code, recoveryCode } = req.body;
+  await mfaService.disable(req.user.user_id, req.user.role, { code, recoveryCode });
+  res.json({ message: 'MFA disabled' });
+});
+
+module.exports = {
+  verifyChallenge,
+  beginChallengeEnrollment,
+  confirmChallengeEnrollment,
+  getStatus,
+  beginEnrollment,
+  confirmEnrollment,
+  regenerateRecoveryCodes,
+  disable,
+};
@@ -0,0 +1,46 @@
+const asyncHandler = require('express-async-handler');
+const oauthClientService = require('../services/oauthClientService');
+
+const createClient = asyncHandler(async (req, res) => {
+  const { client, clientSecret } = await oauthClientService.createClient(req.user.user_id, req.body);
+  // The secret is only ever shown here
+  res.status(201).json({ ...client, clientSecret });
+});
+
+const listClients = asyncHandler(async (req, res) => {
+  const clients = await oauthClientService.listClients();
+  res.json(clients);
+});
+
+const getClient = asyncHandler(async (req, res) => {
+  const client = await oauthClientService.getClient(req.params.clientId);
+  if (!client) {
+    res.status(404);
+    throw new Error('OAuth client not found');
+  }
+  res.json(oauthClientService.toPublic(client));
+});
+
+const updateClient = asyncHandler(async (req, res) => {
+  const client = await oauthClientService.updateClient(req.params.clientId, req.body);
+  res.json(client);
+});
+
+const rotateClientSecret = asyncHandler(async (req, res) => {
+  const clientSecret = await oauthClientService.rotateClientSecret(req.params.clientId);
+  res.json({ clientId: req.params.clientId, clientSecret });
+});
+
+const deleteClient = asyncHandler(async (req, res) => {
+  await oauthClientService.deleteClient(req.params.clientId);
+  res.json({ message: 'OAuth client deleted' });
+});
+
+module.exports = {
+  createClient,
+  listClients,
+  getClient,
+  updateClient,
+  rotateClientSecret,
+  deleteClient,
+};
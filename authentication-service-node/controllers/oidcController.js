@@ -0,0 +1,84 @@
+const asyncHandler = require('express-async-handler');
+const oidcService = require('../services/oidcService');
+const env = require('../config/env');
+const { requestContext } = require('../utils/requestContext');
+
+const { OAuthError } = oidcService;
+
+const sendOAuthError = (res, error) => {
+  if (!(error instanceof OAuthError)) throw error;
+  res.status(error.status).json({ error: error.error, error_description: error.message });
+};
+
+// Validates the request and hands the user to the frontend login page, which
+// signs the user in, asks for consent and then completes the request through
+// approveAuthorization
+const authorize = asyncHandler(async (req, res) => {
+  try {
+    await oidcService.validateAuthorizationRequest(req.query);
+  } catch (error) {
+    if (error instanceof OAuthError && error.redirectUri) {
+      return res.redirect(error.redirectUri);
+    }
+    return sendOAuthError(res, error);
+  }
+  const loginUrl = new URL(env.OIDC_LOGIN_URL);
+  for (const [name, value] of Object.entries(req.query)) {
+    // Only the user answers the consent screen, never the client's URL
+    if (name === 'consent') continue;
+    loginUrl.searchParams.set(name, value);
+  }
+  res.redirect(loginUrl.toString());
+});
+
+// Answers { redirectTo } or { consentRequired }; the frontend follows redirectTo,
+// which carries the error for the client when the request failed
+const approveAuthorization = asyncHandler(async (req, res) => {
+  try {
+    res.json(await oidcService.authorize(req.body, req.user, requestContext(req)));
+  } catch (error) {
+    if (error instanceof OAuthError && error.redirectUri) {
+      return res.json({ redirectTo: error.redirectUri });
+    }
+    sendOAuthError(res, error);
+  }
+});
+
+const token = asyncHandler(async (req, res) => {
+  res.set('Cache-Control', 'no-store');
+  try {
+    const response = await oidcService.exchangeToken(req.headers.authorization, req.body);
+    res.json(response);
+  } catch (error) {
+    sendOAuthError(res, error);
+  }
+});
+
+// Resource servers may cache the answer for the advertised max-age
+const introspect = asyncHandler(async (req, res) => {
+  let response;
+  try {
+    response = await oidcService.introspect(req.headers.authorization, req.body);
+  } catch (error) {
+    res.set('Cache-Control', 'no-store');
+    return sendOAuthError(res, error);
+  }
+  res.set('Cache-Control', `private, max-age=${oidcService.introspectionMaxAge(response)}`);
+  res.json(response);
+});
+
+const userInfo = asyncHandler(async (req, res) => {
+  try {
+    res.json(await oidcService.userInfo(req.user));
+  } catch (error) {
+    sendOAuthError(res, error);
+  }
+});
+
+module.exports = {
+  authorize,
+  approveAuthorization,
+  token,
+  introspect,
+  userInfo,
+};
@@ -0,0 +1,28 @@
+const asyncHandler = require('express-async-handler');
+const passwordResetService = require('../services/passwordResetService');
+
+const requestReset = asyncHandler(async (req, res) => {
+  try {
+    await passwordResetService.requestReset(req.body.email, req.ip);
+  } catch (error) {
+    res.status(400);
+    throw error;
+  }
+  res.status(202).json({ message: 'If an account exists for that email, a reset link has been sent' });
+});
+
+const resetPassword = asyncHandler(async (req, res) => {
+  const { token, password } = req.body;
+  try {
+    await passwordResetService.resetPassword(token, password);
+  } catch (error) {
+    res.status(400);
+    throw error;
+  }
+  res.json({ message: 'Password has been reset' });
+});
+
+module.exports = {
+  requestReset,
+  resetPassword,
+};
@@ -1,5 +1,6 @@
 const asyncHandler = require('express-async-handler');
 const patientService = require('../services/patientService');
+const careRelationshipService = require('../services/careRelationshipService');
 
 const registerPatient = asyncHandler(async (req, res) => {
   const patientData = req.body;
@@ -10,7 +11,8 @@ const registerPatient = asyncHandler(async (req, res) => {
 });
 
 const listPatients = asyncHandler(async (req, res) => {
-  const patients = await patientService.listPatients();
+  const ownPatientsOnly = req.policyDecision && req.policyDecision.constraint === 'own_patients';
+  const patients = await patientService.listPatients(ownPatientsOnly ? req.user.user_id : null);
   res.json(patients);
 });
 
@@ -30,10 +32,52 @@ const addPatientHistory = asyncHandler(async (req, res) => {
   res.json({ message: 'Patient history added' });
 });
 
+const listCareTeam = asyncHandler(async (req, res) => {
+  const links = await patientService.listCareTeam(req.params.id);
+  res.json(links);
+});
+
+// Body: doctorId, optional expiresAt and note
+const addCareTeamMember = asyncHandler(async (req, res) => {
+  try {
+    const link = await careRelationshipService.addCareTeamMember(req.user.user_id, req.params.id, req.body);
+    res.status(201).json(link);
+  } catch (error) {
+    res.status(error.message.endsWith('not found') ? 404 : 400);
+    throw error;
+  }
+});
+
+const removeCareTeamMember = asyncHandler(async (req, res) => {
+  try {
+    await careRelationshipService.removeCareTeamMember(req.params.id, req.params.doctorId);
+  } catch (error) {
+    res.status(404);
+    throw error;
+  }
+  res.json({ message: 'Care team link removed' });
+});
+
+// Reported by the appointment service. Body: doctorId, status (pending,
+// confirmed, completed or cancelled), endTime
+const syncAppointment = asyncHandler(async (req, res) => {
+  try {
+    const link = await careRelationshipService.syncAppointment(req.user.user_id, req.params.id, req.params.appointmentId, req.body);
+    res.json(link ? link : { message: 'Appointment link removed' });
+  } catch (error) {
+    res.status(error.message.endsWith('not found') ? 404 : 400);
+    throw error;
+  }
+});
+
 module.exports = {
   registerPatient,
   listPatients,
   getPatient,
   getPatientHistory,
   addPatientHistory,
+  listCareTeam,
+  addCareTeamMember,
+  removeCareTeamMember,
+  syncAppointment,
 };
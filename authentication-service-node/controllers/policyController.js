@@ -0,0 +1,22 @@
+const asyncHandler = require('express-async-handler');
+const policyEngine = require('../services/policyEngine');
+
+const listPolicies = asyncHandler(async (req, res) => {
+  res.json(policyEngine.describe());
+});
+
+// Body: policy, userId, role, resourceId
+const explainDecision = asyncHandler(async (req, res) => {
+  try {
+    const decision = await policyEngine.explainFor(req.body);
+    res.json(decision);
+  } catch (error) {
+    res.status(error.message === 'User not found' ? 404 : 400);
+    throw error;
+  }
+});
+
+module.exports = {
+  listPolicies,
+  explainDecision,
+};
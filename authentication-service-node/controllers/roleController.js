@@ -0,0 +1,84 @@
+const asyncHandler = require('express-async-handler');
+const roleService = require('../services/roleService');
+const delegationService = require('../services/delegationService');
+const { requestContext } = require('../utils/requestContext');
+
+// Validation problems are 400s; granting what the caller does not hold is a 403
+const grantErrorStatus = (error) => (delegationService.isGrantDenied(error) ? 403 : 400);
+
+const createRole = asyncHandler(async (req, res) => {
+  let role;
+  try {
+    role = await roleService.createRole(req.user, req.body);
+  } catch (error) {
+    res.status(grantErrorStatus(error));
+    throw error;
+  }
+  res.status(201).json(role);
+});
+
+const listRoles = asyncHandler(async (req, res) => {
+  const roles = await roleService.listRoles();
+  res.json(roles);
+});
+
+const getRole = asyncHandler(async (req, res) => {
+  const role = await roleService.getRole(req.params.name);
+  if (!role) {
+    res.status(404);
+    throw new Error('Role not found');
+  }
+  res.json(roleService.toPublic(role));
+});
+
+const updateRole = asyncHandler(async (req, res) => {
+  try {
+    const role = await roleService.updateRole(req.user, req.params.name, req.body, requestContext(req));
+    res.json(role);
+  } catch (error) {
+    res.status(error.message === 'Role not found' ? 404 : grantErrorStatus(error));
+    throw error;
+  }
+});
+
+const deleteRole = asyncHandler(async (req, res) => {
+  try {
+    await roleService.deleteRole(req.params.name);
+  } catch (error) {
+    res.status(error.message === 'Role not found' ? 404 : 400);
+    throw error;
+  }
+  res.json({ message: 'Role deleted' });
+});
+
+// Body: roles, the names of every role the admin should hold
+const assignRoles = asyncHandler(async (req, res) => {
+  try {
+    const result = await roleService.assignRoles(req.user, req.params.id, req.body.roles, requestContext(req));
+    res.json(result);
+  } catch (error) {
+    res.status(error.message === 'Admin not found' ? 404 : grantErrorStatus(error));
+    throw error;
+  }
+});
+
+// Query: role, the role the user holds the profile in (default admin)
+const getUserPermissions = asyncHandler(async (req, res) => {
+  try {
+    const result = await roleService.userPermissions(req.params.userId, req.query.role || 'admin');
+    res.json(result);
+  } catch (error) {
+    res.status(404);
+    throw error;
+  }
+});
+
+module.exports = {
+  createRole,
+  listRoles,
+  getRole,
+  updateRole,
+  deleteRole,
+  assignRoles,
+  getUserPermissions,
+};
@@ -0,0 +1,55 @@
+const asyncHandler = require('express-async-handler');
+const samlLoginService = require('../services/samlLoginService');
+const loginResponse = require('../utils/loginResponse');
+const { requestContext } = require('../utils/requestContext');
+
+const metadata = asyncHandler(async (req, res) => {
+  let xml;
+  try {
+    xml = samlLoginService.metadata();
+  } catch (error) {
+    res.status(404);
+    throw error;
+  }
+  res.type('application/samlmetadata+xml').send(xml);
+});
+
+// The frontend keeps browserKey and sends the user to authorizationUrl
+const beginLogin = asyncHandler(async (req, res) => {
+  let result;
+  try {
+    result = await samlLoginService.beginLogin(req.params.slug, { returnTo: req.body.returnTo });
+  } catch (error) {
+    res.status(['Unknown SAML provider', 'SAML is not configured'].includes(error.message) ? 404 : 500);
+    throw error;
+  }
+  res.json(result);
+});
+
+// Assertion consumer service: IdPs post responses here (HTTP-POST binding)
+const acs = asyncHandler(async (req, res) => {
+  res.redirect(303, await samlLoginService.handleAcs(req.body));
+});
+
+const completeLogin = asyncHandler(async (req, res) => {
+  const { code, browserKey } = req.body;
+  let result;
+  try {
+    result = await samlLoginService.completeLogin(code, browserKey, requestContext(req));
+  } catch (error) {
+    res.status(401);
+    throw error;
+  }
+  if (result.mfaRequired) {
+    const { mfaToken, methods, enrollmentRequired } = result;
+    return res.json({ mfaRequired: true, mfaToken, methods, enrollmentRequired });
+  }
+  res.json(loginResponse(result));
+});
+
+module.exports = {
+  metadata,
+  beginLogin,
+  acs,
+  completeLogin,
+};
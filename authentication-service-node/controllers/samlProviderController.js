@@ -0,0 +1,58 @@
+const asyncHandler = require('express-async-handler');
+const samlProviderService = require('../services/samlProviderService');
+const delegationService = require('../services/delegationService');
+
+const grantErrorStatus = (error) => (delegationService.isGrantDenied(error) ? 403 : 400);
+
+const createProvider = asyncHandler(async (req, res) => {
+  let provider;
+  try {
+    provider = await samlProviderService.createProvider(req.user, req.body);
+  } catch (error) {
+    res.status(grantErrorStatus(error));
+    throw error;
+  }
+  res.status(201).json(provider);
+});
+
+const listProviders = asyncHandler(async (req, res) => {
+  const providers = await samlProviderService.listProviders();
+  res.json(providers);
+});
+
+const getProvider = asyncHandler(async (req, res) => {
+  const provider = await samlProviderService.getProvider(req.params.slug);
+  if (!provider) {
+    res.status(404);
+    throw new Error('SAML provider not found');
+  }
+  res.json(samlProviderService.toPublic(provider));
+});
+
+const updateProvider = asyncHandler(async (req, res) => {
+  try {
+    const provider = await samlProviderService.updateProvider(req.user, req.params.slug, req.body);
+    res.json(provider);
+  } catch (error) {
+    res.status(error.message === 'SAML provider not found' ? 404 : grantErrorStatus(error));
+    throw error;
+  }
+});
+
+const deleteProvider = asyncHandler(async (req, res) => {
+  try {
+    await samlProviderService.deleteProvider(req.params.slug);
+  } catch (error) {
+    res.status(error.message === 'SAML provider not found' ? 404 : 409);
+    throw error;
+  }
+  res.json({ message: 'SAML provider deleted' });
+});
+
+module.exports = {
+  createProvider,
+  listProviders,
+  getProvider,
+  updateProvider,
+  deleteProvider,
+};
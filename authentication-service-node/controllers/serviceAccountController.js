@@ -0,0 +1,71 @@
+const asyncHandler = require('express-async-handler');
+const serviceAccountService = require('../services/serviceAccountService');
+const delegationService = require('../services/delegationService');
+
+const grantErrorStatus = (error) => (delegationService.isGrantDenied(error) ? 403 : 400);
+
+const createAccount = asyncHandler(async (req, res) => {
+  let result;
+  try {
+    result = await serviceAccountService.createAccount(req.user, req.body);
+  } catch (error) {
+    res.status(grantErrorStatus(error));
+    throw error;
+  }
+  // The secret is only ever shown here
+  res.status(201).json({ ...result.serviceAccount, clientSecret: result.clientSecret });
+});
+
+const listAccounts = asyncHandler(async (req, res) => {
+  const accounts = await serviceAccountService.listAccounts();
+  res.json(accounts);
+});
+
+const getAccount = asyncHandler(async (req, res) => {
+  const account = await serviceAccountService.getAccount(req.params.clientId);
+  if (!account) {
+    res.status(404);
+    throw new Error('Service account not found');
+  }
+  res.json(serviceAccountService.toPublic(account));
+});
+
+const updateAccount = asyncHandler(async (req, res) => {
+  try {
+    const account = await serviceAccountService.updateAccount(req.user, req.params.clientId, req.body);
+    res.json(account);
+  } catch (error) {
+    res.status(error.message === 'Service account not found' ? 404 : grantErrorStatus(error));
+    throw error;
+  }
+});
+
+const rotateSecret = asyncHandler(async (req, res) => {
+  let clientSecret;
+  try {
+    clientSecret = await serviceAccountService.rotateSecret(req.params.clientId);
+  } catch (error) {
+    res.status(error.message === 'Service account not found' ? 404 : 400);
+    throw error;
+  }
+  res.json({ clientId: req.params.clientId, clientSecret });
+});
+
+const deleteAccount = asyncHandler(async (req, res) => {
+  try {
+    await serviceAccountService.deleteAccount(req.params.clientId);
+  } catch (error) {
+    res.status(404);
+    throw error;
+  }
+  res.json({ message: 'Service account deleted' });
+});
+
+module.exports = {
+  createAccount,
+  listAccounts,
+  getAccount,
+  updateAccount,
+  rotateSecret,
+  deleteAccount,
+};
@@ -0,0 +1,64 @@
+const asyncHandler = require('express-async-handler');
+const authService = require('../services/authServiceInstance');
+
+// Self-service: the caller's own sessions across all devices
+const listSessions = asyncHandler(async (req, res) => {
+  const sessions = await authService.listSessions(req.user.user_id, req.user.sid);
+  res.json(sessions);
+});
+
+const getSession = asyncHandler(async (req, res) => {
+  try {
+    const session = await authService.getSession(req.user.user_id, req.params.id, req.user.sid);
+    res.json(session);
+  } catch (error) {
+    res.status(404);
+    throw error;
+  }
+});
+
+const revokeSession = asyncHandler(async (req, res) => {
+  try {
+    await authService.revokeSession(req.user.user_id, req.params.id);
+  } catch (error) {
+    res.status(404);
+    throw error;
+  }
+  res.json({ message: 'Session revoked' });
+});
+
+const revokeOtherSessions = asyncHandler(async (req, res) => {
+  await authService.revokeOtherSessions(req.user.user_id, req.user.sid);
+  res.json({ message: 'Signed out of all other sessions' });
+});
+
+// Admin: any account's sessions
+const listUserSessions = asyncHandler(async (req, res) => {
+  const sessions = await authService.listSessions(req.params.userId);
+  res.json(sessions);
+});
+
+const revokeUserSession = asyncHandler(async (req, res) => {
+  try {
+    await authService.revokeSession(req.params.userId, req.params.sessionId);
+  } catch (error) {
+    res.status(404);
+    throw error;
+  }
+  res.json({ message: 'Session revoked' });
+});
+
+const signOutEverywhere = asyncHandler(async (req, res) => {
+  await authService.revokeAllTokensForUser(req.params.userId);
+  res.json({ message: 'User signed out of all sessions' });
+});
+
+module.exports = {
+  listSessions,
+  getSession,
+  revokeSession,
+  revokeOtherSessions,
+  listUserSessions,
+  revokeUserSession,
+  signOutEverywhere,
+};
@@ -1,5 +1,6 @@
 const asyncHandler = require('express-async-handler');
 const systemService = require('../services/systemService');
+const authService = require('../services/authServiceInstance');
 
 const getSystemConfig = asyncHandler(async (req, res) => {
   const config = await systemService.getSystemConfig();
@@ -21,9 +22,15 @@ const getSystemLogs = asyncHandler(async (req, res) => {
   res.json(logs);
 });
 
+const rotateSigningKeys = asyncHandler(async (req, res) => {
+  await authService.keyRing.rotateNow();
+  res.json({ message: 'Signing key rotated' });
+});
+
 module.exports = {
   getSystemConfig,
   updateSystemConfig,
   getSystemMetrics,
   getSystemLogs,
+  rotateSigningKeys,
 };
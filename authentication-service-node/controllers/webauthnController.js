@@ -0,0 +1,76 @@
+const asyncHandler = require('express-async-handler');
+const webauthnService = require('../services/webauthnService');
+const loginResponse = require('../utils/loginResponse');
+const { requestContext } = require('../utils/requestContext');
+
+const registrationOptions = asyncHandler(async (req, res) => {
+  const options = await webauthnService.registrationOptions(req.user);
+  res.json(options);
+});
+
+const verifyRegistration = asyncHandler(async (req, res) => {
+  const { credential, name } = req.body;
+  const saved = await webauthnService.verifyRegistration(req.user, credential, name);
+  res.status(201).json(saved);
+});
+
+const listCredentials = asyncHandler(async (req, res) => {
+  const credentials = await webauthnService.listCredentials(req.user.user_id, req.user.role);
+  res.json(credentials);
+});
+
+const deleteCredential = asyncHandler(async (req, res) => {
+  await webauthnService.deleteCredential(req.user.user_id, req.user.role, req.params.id);
+  res.json({ message: 'Passkey removed' });
+});
+
+const loginOptions = asyncHandler(async (req, res) => {
+  const options = await webauthnService.loginOptions(req.body.email);
+  res.json(options);
+});
+
+const login = asyncHandler(async (req, res) => {
+  const { ceremonyId, credential } = req.body;
+  try {
+    const tokens = await webauthnService.login(ceremonyId, credential, requestContext(req));
+    res.json(loginResponse(tokens));
+  } catch (error) {
+    res.status(401);
+    throw error;
+  }
+});
+
+const mfaOptions = asyncHandler(async (req, res) => {
+  try {
+    const options = await webauthnService.mfaOptions(req.body.mfaToken);
+    res.json(options);
+  } catch (error) {
+    res.status(401);
+    throw error;
+  }
+});
+
+const verifyMfa = asyncHandler(async (req, res) => {
+  const { mfaToken, ceremonyId, credential } = req.body;
+  try {
+    const tokens = await webauthnService.verifyMfa(mfaToken, ceremonyId, credential);
+    res.json(loginResponse(tokens));
+  } catch (error) {
+    if (error.retryAfterSeconds) {
+      res.set('Retry-After', String(error.retryAfterSeconds));
+    }
+    res.status(error.statusCode || 401);
+    throw error;
+  }
+});
+
+module.exports = {
+  registrationOptions,
+  verifyRegistration,
+  listCredentials,
+  deleteCredential,
+  loginOptions,
+  login,
+  mfaOptions,
+  verifyMfa,
+};
@@ -0,0 +1,27 @@
+const asyncHandler = require('express-async-handler');
+const authService = require('../services/authServiceInstance');
+const oidcService = require('../services/oidcService');
+const permissionRules = require('../utils/permissionRules');
+
+const getJwks = asyncHandler(async (req, res) => {
+  const jwks = await authService.keyRing.jwks();
+  res.set('Cache-Control', 'public, max-age=300');
+  res.json(jwks);
+});
+
+const getOpenIdConfiguration = asyncHandler(async (req, res) => {
+  res.set('Cache-Control', 'public, max-age=3600');
+  res.json(oidcService.discoveryDocument());
+});
+
+// How token permissions expand, for services that check them
+const getPermissionRules = (req, res) => {
+  res.set('Cache-Control', 'public, max-age=300');
+  res.json(permissionRules.document());
+};
+
+module.exports = {
+  getJwks,
+  getOpenIdConfiguration,
+  getPermissionRules,
+};
@@ -1,73 +1,100 @@
-const jwt = require('jsonwebtoken');
 const asyncHandler = require('express-async-handler');
-const redis = require('redis');
-const { promisify } = require('util');
-
-const redisHost = process.env.REDIS_HOST || 'redis-17951.c16.us-east-1-2.ec2.redns.redis-cloud.com';
-const redisPort = process.env.REDIS_PORT || 17951;
-
-console.log(`Connecting to Redis at ${redisHost}:${redisPort}`);
-
-const redisClient = redis.createClient({
-  socket: {
-    host: redisHost,
-    port: redisPort,
-  },
-});
-
-redisClient.on('error', (err) => {
-  console.error('Redis Client Error', err);
-});
-
-(async () => {
-  await redisClient.connect();
-})();
-
-const getAsync = async (key) => {
-  try {
-    const value = await redisClient.get(key);
-    return value;
-  } catch (err) {
-    console.error('Redis get error:', err);
+const authService = require('../services/authServiceInstance');
+const apiKeyService = require('../services/apiKeyService');
+const impersonationService = require('../services/impersonationService');
+const policyEngine = require('../services/policyEngine');
+const breakGlassService = require('../services/breakGlassService');
+const Principal = require('../utils/principal');
+const { requestContext } = require('../utils/requestContext');
+const { CacheUnavailableError } = require('../utils/cacheStore');
+const { PermissionPolicyExplain } = require('../utils/permissions');
+
+// Resolves whichever credential the request presented to a principal
+const authenticate = async (req) => {
+  const apiKey = req.headers['x-api-key'];
+  if (apiKey) {
+    return apiKeyService.authenticate(apiKey, requestContext(req));
+  }
+  const authHeader = req.headers.authorization;
+  if (!authHeader || !authHeader.startsWith('Bearer')) {
     return null;
   }
+  const token = authHeader.split(' ')[1];
+  return Principal.fromAccessToken(await authService.validateToken(token));
 };
 
 const validateToken = asyncHandler(async (req, res, next) => {
-  let token;
-
-  if (
-    req.headers.authorization &&
-    req.headers.authorization.startsWith('Bearer')
-  ) {
-    try {
-      token = req.headers.authorization.split(' ')[1];
-
-      // Check if token is blacklisted
-      const isBlacklisted = await getAsync(`blacklist_${token}`);
-      if (isBlacklisted) {
-        res.status(401);
-        throw new Error('Not authorized, token revoked');
-      }
-
-      const decoded = jwt.verify(token, process.env.JWT_SECRET);
-      req.user = decoded;
-      next();
-    } catch (error) {
-      res.status(401);
-      throw new Error('Not authorized, token failed');
+  let principal;
+  try {
+    principal = await authenticate(req);
+  } catch (error) {
+    // Revocation could not be checked; the token is not trusted either way
+    if (error instanceof CacheUnavailableError) {
+      res.status(503);
+      throw error;
     }
+    res.status(401);
+    throw new Error(`Not authorized, ${error.message}`);
   }
-
-  if (!token) {
+  if (!principal) {
     res.status(401);
     throw new Error('Not authorized, no token');
   }
+  req.user = principal;
+  if (principal.impersonating) {
+    // Recorded once the outcome is known
+    res.on('finish', () => {
+      impersonationService.recordRequest(principal, {
+        method: req.method,
+        path: req.originalUrl,
+        statusCode: res.statusCode,
+      }, requestContext(req));
+    });
+  }
+  next();
 });
 
+// Account management (passwords, MFA, sessions, keys) needs a signed-in
+// user; delegated credentials such as API keys cannot manage the account,
+// and neither can support staff impersonating its owner
+const requireInteractive = (req, res, next) => {
+  if (!req.user || !req.user.interactive) {
+    res.status(403);
+    throw new Error('Forbidden: this action requires a signed-in session');
+  }
+  if (req.user.impersonating) {
+    res.status(403);
+    throw new Error('Forbidden: not available while impersonating');
+  }
+  next();
+};
+
+// Userinfo describes the user an access token was issued for, whether to the
+// user's own session or to an OAuth client; API keys and services have none
+const requireUserAccessToken = (req, res, next) => {
+  const userMethods = [Principal.AUTH_METHODS.ACCESS_TOKEN, Principal.AUTH_METHODS.OAUTH_CLIENT];
+  if (!req.user || !userMethods.includes(req.user.auth_method)) {
+    res.status(403);
+    throw new Error('Forbidden: this action requires a user access token');
+  }
+  next();
+};
+
+// Guards PHI-bearing routes when the email verification policy is enabled
+const requireVerifiedEmail = (req, res, next) => {
+  if (req.user && authService.blocksUnverifiedEmail(req.user)) {
+    res.status(403);
+    throw new Error('Forbidden: verify your email address first');
+  }
+  next();
+};
+
+// Checks the caller's role only. An API key or OAuth client acting for a
+// super admin has the role too; pair with requireInteractive where the role
+// should mean the user's full authority.
 const requireRole = (role) => {
   return (req, res, next) => {
-    if (!req.user || req.user.role !== role) {
+    if (!req.user || !req.user.hasRole(role)) {
       res.status(403);
       throw new Error('Forbidden: insufficient role');
     }
@@ -82,35 +109,58 @@ const requirePermission = (requiredPermission) => {
       throw new Error('Unauthorized');
     }
 
-    console.log('User in requirePermission:', req.user);
-    console.log('Requested ID:', req.params.id);
-
-    // Allow super_admin to bypass permission checks
-    if (req.user.role === 'super_admin') {
-      return next();
+    // Super admins hold all_permissions; API keys only their scopes
+    if (!req.user.can(requiredPermission)) {
+      res.status(403);
+      throw new Error('Forbidden: insufficient permissions');
     }
 
-    // Automatically grant PermissionDoctorView if accessing own profile
-    if (
-      requiredPermission === 'PermissionDoctorView' &&
-      req.params.id &&
-      req.params.id === req.user.id
-    ) {
-      return next();
-    }
+    next();
+  };
+};
 
-    if (requiredPermission === 'PermissionDoctorUpdate') {
-      console.log('Update Profile - User:', req.user);
-      console.log('Update Profile - Requested ID:', req.params.id);
-    }
+// Holders of policy:explain can ask why a request was allowed or denied
+const wantsExplanation = (req) => req.get('X-Policy-Explain') === 'true' && req.user.can(PermissionPolicyExplain);
 
-    if (!req.user.permissions || !req.user.permissions.includes(requiredPermission)) {
+const traceOf = (decision) => decision.trace.map(({ rule, matched, reason }) => ({ rule, matched, reason }));
+
+// Checks a named policy against the record the route's :id param names.
+// The decision is left on req.policyDecision for handlers that have to
+// honour its constraint, e.g. by narrowing a list.
+const requirePolicy = (policyName) => {
+  return asyncHandler(async (req, res, next) => {
+    if (!req.user) {
+      res.status(401);
+      throw new Error('Unauthorized');
+    }
+    const decision = await policyEngine.evaluate(policyName, req.user, { id: req.params.id });
+    const explain = wantsExplanation(req);
+    if (!decision.allowed) {
       res.status(403);
-      throw new Error('Forbidden: insufficient permissions');
+      const error = new Error(`Forbidden: denied by policy ${policyName}`);
+      if (explain) {
+        error.reasons = traceOf(decision);
+      }
+      throw error;
     }
-
+    if (explain) {
+      res.set('X-Policy-Decision', JSON.stringify({ policy: policyName, rule: decision.rule, trace: traceOf(decision) }));
+    }
+    if (decision.rule === 'break-glass') {
+      const { breakGlassGrantId } = decision.trace[decision.trace.length - 1];
+      await breakGlassService.recordAccess(req.user, breakGlassGrantId, { method: req.method, path: req.originalUrl }, requestContext(req));
+    }
+    req.policyDecision = decision;
     next();
-  };
+  });
 };
 
-module.exports = { validateToken, requireRole, requirePermission };
+module.exports = {
+  validateToken,
+  requireInteractive,
+  requireUserAccessToken,
+  requireVerifiedEmail,
+  requireRole,
+  requirePermission,
+  requirePolicy,
+};
@@ -5,10 +5,12 @@ const notFound = (req, res, next) => {
 };
 
 const errorHandler = (err, req, res, next) => {
-  const statusCode = res.statusCode === 200 ? 500 : res.statusCode;
+  const statusCode = res.statusCode === 200 ? (err.statusCode || 500) : res.statusCode;
   res.status(statusCode);
   res.json({
     message: err.message,
+    // Structured details, e.g. every password rule that was broken
+    reasons: err.reasons,
     stack: process.env.NODE_ENV === 'production' ? null : err.stack,
   });
 };
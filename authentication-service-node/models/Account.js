@@ -0,0 +1,27 @@
+const mongoose = require('mongoose');
+
+// The single identity behind one or more role profiles (SuperAdmin, Admin,
+// Doctor, Patient). Credentials and sign-in status live here; profiles hold
+// role-specific data and point back through accountId.
+const accountSchema = new mongoose.Schema({
+  // Stored normalized (trimmed, lower case) so one address means one account
+  email: { type: String, required: true, unique: true },
+  passwordHash: { type: String },
+  roles: [{
+    _id: false,
+    role: { type: String, enum: ['super_admin', 'admin', 'doctor', 'patient'], required: true },
+    profileId: { type: mongoose.Schema.Types.ObjectId, required: true },
+  }],
+  status: { type: String, enum: ['active', 'disabled'], default: 'active' },
+  // Set to "directory" when the staff directory sync disabled the account
+  disabledReason: { type: String },
+  emailVerified: { type: Boolean, default: false },
+  emailVerifiedAt: { type: Date },
+  lastLoginAt: { type: Date },
+}, { timestamps: true });
+
+accountSchema.index({ 'roles.profileId': 1 });
+
+const Account = mongoose.model('Account', accountSchema);
+
+module.exports = Account;
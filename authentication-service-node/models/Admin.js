@@ -3,9 +3,22 @@ const mongoose = require('mongoose');
 const adminSchema = new mongoose.Schema({
   username: { type: String, required: true },
   email: { type: String, required: true, unique: true },
-  passwordHash: { type: String, required: true },
-  createdBy: { type: mongoose.Schema.Types.ObjectId, ref: 'SuperAdmin' },
+  accountId: { type: mongoose.Schema.Types.ObjectId, ref: 'Account', index: true },
+  // The super admin or admin who created this admin; grants made by an
+  // admin are limited to what their creator holds
+  createdBy: { type: mongoose.Schema.Types.ObjectId, index: true },
+  createdByRole: { type: String, enum: ['super_admin', 'admin'] },
+  // Granted directly, including by LDAP and SAML group mappings
   permissions: [{ type: String }],
+  roles: [{ type: String }],
+  // The union of the roles' permissions, kept in step when a role changes
+  rolePermissions: [{ type: String }],
+  // Set while the admin holds permissions their creator has since lost
+  delegationFlag: {
+    excessPermissions: [{ type: String }],
+    creatorId: { type: mongoose.Schema.Types.ObjectId },
+    flaggedAt: { type: Date },
+  },
 }, { timestamps: true });
 
 const Admin = mongoose.model('Admin', adminSchema);
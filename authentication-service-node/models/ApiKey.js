@@ -0,0 +1,24 @@
+const mongoose = require('mongoose');
+
+const apiKeySchema = new mongoose.Schema({
+  // SHA-256 of the full key; the key itself is only shown when created
+  keyHash: { type: String, required: true, unique: true },
+  // First characters of the key, enough to recognise it in listings
+  prefix: { type: String, required: true },
+  name: { type: String, required: true },
+  ownerId: { type: String, required: true },
+  ownerRole: { type: String, required: true },
+  scopes: [{ type: String }],
+  expiresAt: { type: Date, default: null },
+  lastUsedAt: { type: Date, default: null },
+  lastUsedIp: { type: String },
+  revokedAt: { type: Date, default: null },
+  // Set on keys created by rotating another key
+  rotatedFrom: { type: mongoose.Schema.Types.ObjectId, default: null },
+}, { timestamps: true });
+
+apiKeySchema.index({ ownerId: 1, ownerRole: 1 });
+
+const ApiKey = mongoose.model('ApiKey', apiKeySchema);
+
+module.exports = ApiKey;
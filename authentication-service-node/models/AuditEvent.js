@@ -0,0 +1,32 @@
+const mongoose = require('mongoose');
+
+// Who did what to whom. actor is the person behind the request; subject is
+// the user acted on or acted as, when there is one.
+const auditEventSchema = new mongoose.Schema({
+  action: { type: String, required: true },
+  actor: {
+    userId: { type: String, required: true },
+    role: { type: String, required: true },
+    email: { type: String },
+  },
+  subject: {
+    userId: { type: String },
+    role: { type: String },
+  },
+  // Session the event happened in; for impersonation, the impersonation session
+  sessionId: { type: String },
+  reason: { type: String },
+  method: { type: String },
+  path: { type: String },
+  statusCode: { type: Number },
+  ip: { type: String },
+  userAgent: { type: String },
+}, { timestamps: true });
+
+auditEventSchema.index({ 'actor.userId': 1, createdAt: -1 });
+auditEventSchema.index({ 'subject.userId': 1, createdAt: -1 });
+auditEventSchema.index({ sessionId: 1, createdAt: 1 });
+
+const AuditEvent = mongoose.model('AuditEvent', auditEventSchema);
+
+module.exports = AuditEvent;
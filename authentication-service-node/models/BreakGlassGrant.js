@@ -0,0 +1,36 @@
+const mongoose = require('mongoose');
+
+// Emergency access by a clinician to one patient's records, outside any care
+// relationship. Lapses at expiresAt or when ended; every grant waits in the
+// review queue until a compliance officer accepts or flags it.
+const breakGlassGrantSchema = new mongoose.Schema({
+  userId: { type: mongoose.Schema.Types.ObjectId, required: true },
+  role: { type: String, required: true },
+  email: { type: String },
+  patientId: { type: mongoose.Schema.Types.ObjectId, ref: 'Patient', required: true },
+  justification: { type: String, required: true },
+  expiresAt: { type: Date, required: true },
+  // null until ended, or until a later request closes it once lapsed
+  endedAt: { type: Date, default: null },
+  accessCount: { type: Number, default: 0 },
+  lastAccessAt: { type: Date },
+  review: {
+    status: { type: String, enum: ['pending', 'accepted', 'flagged'], default: 'pending' },
+    reviewerId: { type: String },
+    reviewerEmail: { type: String },
+    note: { type: String },
+    reviewedAt: { type: Date },
+  },
+}, { timestamps: true });
+
+breakGlassGrantSchema.index({ userId: 1, patientId: 1, expiresAt: -1 });
+// One open grant per clinician and patient, however many requests race
+breakGlassGrantSchema.index(
+  { userId: 1, patientId: 1 },
+  { unique: true, partialFilterExpression: { endedAt: { $type: 'null' } } },
+);
+breakGlassGrantSchema.index({ 'review.status': 1, createdAt: -1 });
+
+const BreakGlassGrant = mongoose.model('BreakGlassGrant', breakGlassGrantSchema);
+
+module.exports = BreakGlassGrant;
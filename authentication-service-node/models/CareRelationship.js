@@ -0,0 +1,24 @@
+const mongoose = require('mongoose');
+
+// Why a doctor may see a patient's records. Care-team links are added by
+// staff; appointment links are reported by the appointment service and
+// lapse some time after the appointment.
+const careRelationshipSchema = new mongoose.Schema({
+  doctorId: { type: mongoose.Schema.Types.ObjectId, ref: 'Doctor', required: true },
+  patientId: { type: mongoose.Schema.Types.ObjectId, ref: 'Patient', required: true },
+  source: { type: String, enum: ['care_team', 'appointment'], required: true },
+  // Set for appointment links; one link per appointment
+  appointmentId: { type: String },
+  note: { type: String },
+  // No expiry means the link lasts until it is removed
+  expiresAt: { type: Date },
+  createdBy: { type: String },
+}, { timestamps: true });
+
+careRelationshipSchema.index({ doctorId: 1, patientId: 1 });
+careRelationshipSchema.index({ patientId: 1 });
+careRelationshipSchema.index({ appointmentId: 1 }, { unique: true, sparse: true });
+
+const CareRelationship = mongoose.model('CareRelationship', careRelationshipSchema);
+
+module.exports = CareRelationship;
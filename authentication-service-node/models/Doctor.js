@@ -3,7 +3,7 @@ const mongoose = require('mongoose');
 const doctorSchema = new mongoose.Schema({
   username: { type: String },
   email: { type: String, required: true, unique: true },
-  passwordHash: { type: String, required: true },
+  accountId: { type: mongoose.Schema.Types.ObjectId, ref: 'Account', index: true },
   isApproved: { type: Boolean, default: false },
   available: { type: Boolean, default: false },
   name: { type: String, required: true },
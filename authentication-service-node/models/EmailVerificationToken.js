@@ -0,0 +1,16 @@
+const mongoose = require('mongoose');
+
+const emailVerificationTokenSchema = new mongoose.Schema({
+  tokenHash: { type: String, required: true, unique: true },
+  accountId: { type: String, required: true, index: true },
+  // The address being verified; the token is void if the account's email changes
+  email: { type: String, required: true },
+  expiresAt: { type: Date, required: true },
+  usedAt: { type: Date, default: null },
+}, { timestamps: true });
+
+emailVerificationTokenSchema.index({ expiresAt: 1 }, { expireAfterSeconds: 0 });
+
+const EmailVerificationToken = mongoose.model('EmailVerificationToken', emailVerificationTokenSchema);
+
+module.exports = EmailVerificationToken;
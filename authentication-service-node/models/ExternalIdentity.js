@@ -0,0 +1,18 @@
+const mongoose = require('mongoose');
+
+const externalIdentitySchema = new mongoose.Schema({
+  accountId: { type: mongoose.Schema.Types.ObjectId, ref: 'Account', required: true, index: true },
+  // Slug of the identity provider
+  provider: { type: String, required: true },
+  // The provider's stable user identifier (the ID token "sub" claim)
+  subject: { type: String, required: true },
+  email: { type: String },
+  emailVerified: { type: Boolean, default: false },
+  lastLoginAt: { type: Date },
+}, { timestamps: true });
+
+externalIdentitySchema.index({ provider: 1, subject: 1 }, { unique: true });
+
+const ExternalIdentity = mongoose.model('ExternalIdentity', externalIdentitySchema);
+
+module.exports = ExternalIdentity;
@@ -0,0 +1,36 @@
+const mongoose = require('mongoose');
+
+const identityProviderSchema = new mongoose.Schema({
+  // Appears in the login and callback URLs
+  slug: { type: String, required: true, unique: true },
+  displayName: { type: String, required: true },
+  preset: {
+    type: String,
+    enum: ['oidc', 'google', 'microsoft', 'apple'],
+    default: 'oidc',
+  },
+  // Discovery starts from here; presets fill it in
+  issuer: { type: String, required: true },
+  clientId: { type: String, required: true },
+  clientSecretEncrypted: { type: String },
+  tokenEndpointAuthMethod: {
+    type: String,
+    enum: ['none', 'client_secret_basic', 'client_secret_post'],
+    default: 'client_secret_post',
+  },
+  // Sign in with Apple builds its client secret from a private key
+  appleTeamId: { type: String },
+  appleKeyId: { type: String },
+  applePrivateKeyEncrypted: { type: String },
+  scopes: [{ type: String }],
+  // Roles that may sign in through this provider
+  allowedRoles: [{ type: String }],
+  // Role given to new accounts on first sign-in; empty only admits linked identities
+  autoProvisionRole: { type: String, enum: ['patient', null], default: null },
+  enabled: { type: Boolean, default: true },
+  createdBy: { type: mongoose.Schema.Types.ObjectId },
+}, { timestamps: true });
+
+const IdentityProvider = mongoose.model('IdentityProvider', identityProviderSchema);
+
+module.exports = IdentityProvider;
@@ -0,0 +1,44 @@
+const mongoose = require('mongoose');
+
+const ldapDirectorySchema = new mongoose.Schema({
+  slug: { type: String, required: true, unique: true },
+  displayName: { type: String, required: true },
+  // ldap:// or ldaps:// URL of the directory server
+  url: { type: String, required: true },
+  // Upgrade ldap:// connections with StartTLS
+  startTls: { type: Boolean, default: false },
+  // PEM CA certificate for directories with a private CA
+  caCertificate: { type: String },
+  // Service account used to look users and groups up
+  bindDn: { type: String, required: true },
+  bindPasswordEncrypted: { type: String, required: true },
+  userBaseDn: { type: String, required: true },
+  // {email} is replaced with the escaped address being signed in
+  userFilter: { type: String, default: '(&(objectClass=person)(mail={email}))' },
+  nameAttribute: { type: String, default: 'cn' },
+  // Groups are searched for under groupBaseDn with groupFilter ({dn} is the
+  // user's DN); without a base the user's memberOf attribute is used
+  groupBaseDn: { type: String },
+  groupFilter: { type: String, default: '(|(member={dn})(uniqueMember={dn}))' },
+  // Sign-ins for addresses in these domains are checked against the directory
+  emailDomains: [{ type: String }],
+  // Groups that grant a staff role; admin groups also carry permission sets
+  groupMappings: [{
+    _id: false,
+    group: { type: String, required: true },
+    role: { type: String, enum: ['admin', 'doctor'], required: true },
+    permissions: [{ type: String }],
+  }],
+  enabled: { type: Boolean, default: true },
+  lastSyncAt: { type: Date },
+  lastSyncError: { type: String },
+  createdBy: { type: mongoose.Schema.Types.ObjectId },
+  // Whose permissions bound what this grants; admins provisioned through it
+  // count as created by them for delegation review
+  grantedBy: { type: mongoose.Schema.Types.ObjectId },
+  grantedByRole: { type: String, enum: ['super_admin', 'admin'] },
+}, { timestamps: true });
+
+const LdapDirectory = mongoose.model('LdapDirectory', ldapDirectorySchema);
+
+module.exports = LdapDirectory;
@@ -0,0 +1,20 @@
+const mongoose = require('mongoose');
+
+// Failed login counter for one account or one source IP, shared by every replica
+const loginThrottleSchema = new mongoose.Schema({
+  kind: { type: String, enum: ['account', 'ip'], required: true },
+  subject: { type: String, required: true },
+  failures: { type: Number, default: 0 },
+  lastFailureAt: { type: Date },
+  lockedUntil: { type: Date, default: null },
+  // Counters are forgotten after a quiet period and once any lock has passed
+  expiresAt: { type: Date, required: true },
+}, { timestamps: true });
+
+loginThrottleSchema.index({ kind: 1, subject: 1 }, { unique: true });
+loginThrottleSchema.index({ lockedUntil: 1 });
+loginThrottleSchema.index({ expiresAt: 1 }, { expireAfterSeconds: 0 });
+
+const LoginThrottle = mongoose.model('LoginThrottle', loginThrottleSchema);
+
+module.exports = LoginThrottle;
@@ -0,0 +1,20 @@
+const mongoose = require('mongoose');
+
+const mfaEnrollmentSchema = new mongoose.Schema({
+  userId: { type: String, required: true },
+  role: { type: String, required: true },
+  enabled: { type: Boolean, default: false },
+  // TOTP seeds are encrypted at rest; pending holds an unconfirmed enrollment
+  totpSecretEncrypted: { type: String },
+  pendingTotpSecretEncrypted: { type: String },
+  // Last accepted TOTP time step, so a code cannot be replayed
+  lastUsedStep: { type: Number, default: 0 },
+  recoveryCodeHashes: [{ type: String }],
+  enabledAt: { type: Date },
+}, { timestamps: true });
+
+mfaEnrollmentSchema.index({ userId: 1, role: 1 }, { unique: true });
+
+const MfaEnrollment = mongoose.model('MfaEnrollment', mfaEnrollmentSchema);
+
+module.exports = MfaEnrollment;
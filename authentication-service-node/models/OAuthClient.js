@@ -0,0 +1,24 @@
+const mongoose = require('mongoose');
+
+const oauthClientSchema = new mongoose.Schema({
+  clientId: { type: String, required: true, unique: true },
+  // Empty for public clients (token_endpoint_auth_method "none")
+  clientSecretHash: { type: String },
+  name: { type: String, required: true },
+  redirectUris: [{ type: String, required: true }],
+  allowedScopes: [{ type: String }],
+  // Roles allowed to sign in to this client; empty allows every role
+  allowedRoles: [{ type: String }],
+  tokenEndpointAuthMethod: {
+    type: String,
+    enum: ['none', 'client_secret_basic', 'client_secret_post'],
+    default: 'client_secret_basic',
+  },
+  // Resource servers that check access tokens at the introspection endpoint
+  canIntrospect: { type: Boolean, default: false },
+  createdBy: { type: mongoose.Schema.Types.ObjectId },
+}, { timestamps: true });
+
+const OAuthClient = mongoose.model('OAuthClient', oauthClientSchema);
+
+module.exports = OAuthClient;
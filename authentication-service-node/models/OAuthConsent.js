@@ -0,0 +1,16 @@
+const mongoose = require('mongoose');
+
+// Scopes a user has agreed to give an OAuth client; asking for anything more
+// shows the consent screen again
+const oauthConsentSchema = new mongoose.Schema({
+  userId: { type: String, required: true },
+  role: { type: String, required: true },
+  clientId: { type: String, required: true },
+  scopes: [{ type: String }],
+}, { timestamps: true });
+
+oauthConsentSchema.index({ userId: 1, role: 1, clientId: 1 }, { unique: true });
+
+const OAuthConsent = mongoose.model('OAuthConsent', oauthConsentSchema);
+
+module.exports = OAuthConsent;
@@ -0,0 +1,14 @@
+const mongoose = require('mongoose');
+
+// Previous password hashes, used to stop users cycling back to old passwords
+const passwordHistorySchema = new mongoose.Schema({
+  userId: { type: String, required: true },
+  role: { type: String, required: true },
+  passwordHash: { type: String, required: true },
+}, { timestamps: true });
+
+passwordHistorySchema.index({ userId: 1, role: 1, createdAt: -1 });
+
+const PasswordHistory = mongoose.model('PasswordHistory', passwordHistorySchema);
+
+module.exports = PasswordHistory;
@@ -0,0 +1,15 @@
+const mongoose = require('mongoose');
+
+const passwordResetTokenSchema = new mongoose.Schema({
+  tokenHash: { type: String, required: true, unique: true },
+  accountId: { type: String, required: true, index: true },
+  expiresAt: { type: Date, required: true },
+  usedAt: { type: Date, default: null },
+  requestedIp: { type: String },
+}, { timestamps: true });
+
+passwordResetTokenSchema.index({ expiresAt: 1 }, { expireAfterSeconds: 0 });
+
+const PasswordResetToken = mongoose.model('PasswordResetToken', passwordResetTokenSchema);
+
+module.exports = PasswordResetToken;
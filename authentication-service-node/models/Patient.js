@@ -3,7 +3,7 @@ const mongoose = require('mongoose');
 const patientSchema = new mongoose.Schema({
   name: { type: String, required: true },
   email: { type: String, required: true, unique: true },
-  passwordHash: { type: String, required: true },
+  accountId: { type: mongoose.Schema.Types.ObjectId, ref: 'Account', index: true },
   phone: { type: String },
   address: { type: String },
   dateOfBirth: { type: Date },
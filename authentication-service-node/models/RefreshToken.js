@@ -0,0 +1,24 @@
+const mongoose = require('mongoose');
+
+const refreshTokenSchema = new mongoose.Schema({
+  tokenHash: { type: String, required: true, unique: true },
+  familyId: { type: String, required: true, index: true },
+  userId: { type: String, required: true, index: true },
+  role: { type: String, required: true },
+  clientId: { type: String, default: null },
+  scope: { type: String, default: null },
+  // Authentication methods used at login, carried into rotated access tokens
+  amr: [{ type: String }],
+  // When the user signed in (seconds), the auth_time of every token in the family
+  authTime: { type: Number },
+  expiresAt: { type: Date, required: true },
+  rotatedAt: { type: Date, default: null },
+  revokedAt: { type: Date, default: null },
+}, { timestamps: true });
+
+// Let MongoDB drop tokens once their family has expired
+refreshTokenSchema.index({ expiresAt: 1 }, { expireAfterSeconds: 0 });
+
+const RefreshToken = mongoose.model('RefreshToken', refreshTokenSchema);
+
+module.exports = RefreshToken;
@@ -0,0 +1,15 @@
+const mongoose = require('mongoose');
+
+// A named set of permissions assigned to admins. Built-in roles are created
+// at startup and cannot be deleted.
+const roleSchema = new mongoose.Schema({
+  name: { type: String, required: true, unique: true },
+  description: { type: String },
+  permissions: [{ type: String }],
+  builtIn: { type: Boolean, default: false },
+  createdBy: { type: String },
+}, { timestamps: true });
+
+const Role = mongoose.model('Role', roleSchema);
+
+module.exports = Role;
@@ -0,0 +1,48 @@
+const mongoose = require('mongoose');
+
+const samlProviderSchema = new mongoose.Schema({
+  // Appears in the login URL
+  slug: { type: String, required: true, unique: true },
+  displayName: { type: String, required: true },
+  // The IdP's entity ID, matched against the Issuer of its responses
+  entityId: { type: String, required: true },
+  // HTTP-Redirect binding endpoint for AuthnRequests
+  ssoUrl: { type: String, required: true },
+  // PEM signing certificates; more than one while the IdP rolls over keys
+  certificates: [{ type: String, required: true }],
+  // Email domains the IdP speaks for. Existing accounts in these domains are
+  // linked on first sign-in.
+  allowedDomains: [{ type: String }],
+  // SAML attribute names for each profile field; email falls back to the NameID
+  attributeMapping: {
+    email: { type: String },
+    name: { type: String },
+    role: { type: String },
+    permissions: { type: String },
+    specialization: { type: String },
+    phone: { type: String },
+    address: { type: String },
+    licenseNumber: { type: String },
+    experience: { type: String },
+  },
+  // Values of the role attribute and the role each grants
+  roleMapping: [{
+    _id: false,
+    value: { type: String, required: true },
+    role: { type: String, enum: ['admin', 'doctor'], required: true },
+  }],
+  // Admin permissions the IdP may assign; anything else it sends is ignored
+  grantablePermissions: [{ type: String }],
+  // Doctors provisioned from this IdP skip the approval queue
+  autoApproveDoctors: { type: Boolean, default: false },
+  enabled: { type: Boolean, default: true },
+  createdBy: { type: mongoose.Schema.Types.ObjectId },
+  // Whose permissions bound what this grants; admins provisioned through it
+  // count as created by them for delegation review
+  grantedBy: { type: mongoose.Schema.Types.ObjectId },
+  grantedByRole: { type: String, enum: ['super_admin', 'admin'] },
+}, { timestamps: true });
+
+const SamlProvider = mongoose.model('SamlProvider', samlProviderSchema);
+
+module.exports = SamlProvider;
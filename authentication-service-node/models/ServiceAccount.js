@@ -0,0 +1,29 @@
+const mongoose = require('mongoose');
+
+// A backend service that signs in as itself with the client_credentials
+// grant. Its access tokens carry role "service" and its permissions.
+const serviceAccountSchema = new mongoose.Schema({
+  clientId: { type: String, required: true, unique: true },
+  name: { type: String, required: true },
+  description: { type: String },
+  tokenEndpointAuthMethod: {
+    type: String,
+    enum: ['client_secret_basic', 'client_secret_post', 'private_key_jwt'],
+    default: 'client_secret_basic',
+  },
+  // Only the hash of the secret is kept; unused with private_key_jwt
+  clientSecretHash: { type: String },
+  // JWK set with the public keys client assertions are signed with
+  jwks: { type: mongoose.Schema.Types.Mixed },
+  permissions: [{ type: String }],
+  enabled: { type: Boolean, default: true },
+  lastUsedAt: { type: Date },
+  createdBy: { type: mongoose.Schema.Types.ObjectId },
+  // Whose permissions bound what the account holds
+  grantedBy: { type: mongoose.Schema.Types.ObjectId },
+  grantedByRole: { type: String, enum: ['super_admin', 'admin'] },
+}, { timestamps: true });
+
+const ServiceAccount = mongoose.model('ServiceAccount', serviceAccountSchema);
+
+module.exports = ServiceAccount;
@@ -0,0 +1,26 @@
+const mongoose = require('mongoose');
+
+// One signed-in device. sessionId is the refresh token family and the sid
+// claim of every access token issued within it.
+const sessionSchema = new mongoose.Schema({
+  sessionId: { type: String, required: true, unique: true },
+  userId: { type: String, required: true },
+  role: { type: String, required: true },
+  clientId: { type: String, default: null },
+  amr: [{ type: String }],
+  device: { type: String },
+  userAgent: { type: String },
+  ip: { type: String },
+  lastSeenAt: { type: Date, required: true },
+  lastSeenIp: { type: String },
+  // Absolute timeout; the refresh token family expires at the same moment
+  expiresAt: { type: Date, required: true },
+  revokedAt: { type: Date, default: null },
+}, { timestamps: true });
+
+sessionSchema.index({ userId: 1, role: 1, lastSeenAt: -1 });
+sessionSchema.index({ expiresAt: 1 }, { expireAfterSeconds: 0 });
+
+const Session = mongoose.model('Session', sessionSchema);
+
+module.exports = Session;
@@ -0,0 +1,16 @@
+const mongoose = require('mongoose');
+
+const signingKeySchema = new mongoose.Schema({
+  kid: { type: String, required: true, unique: true },
+  generation: { type: Number, required: true, unique: true },
+  alg: { type: String, required: true },
+  publicJwk: { type: mongoose.Schema.Types.Mixed, required: true },
+  encryptedPrivateKey: { type: String, required: true },
+  activatesAt: { type: Date, required: true },
+  retiresAt: { type: Date, required: true },
+  expiresAt: { type: Date, required: true },
+}, { timestamps: true });
+
+const SigningKey = mongoose.model('SigningKey', signingKeySchema);
+
+module.exports = SigningKey;
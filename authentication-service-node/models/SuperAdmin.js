@@ -3,7 +3,7 @@ const mongoose = require('mongoose');
 const superAdminSchema = new mongoose.Schema({
   username: { type: String, required: true, default: 'superadmin' },
   email: { type: String, required: true, unique: true },
-  passwordHash: { type: String, required: true },
+  accountId: { type: mongoose.Schema.Types.ObjectId, ref: 'Account', index: true },
   permissions: [{ type: String }],
 }, { timestamps: true });
 
@@ -0,0 +1,20 @@
+const mongoose = require('mongoose');
+
+const webAuthnCredentialSchema = new mongoose.Schema({
+  userId: { type: String, required: true },
+  role: { type: String, required: true },
+  credentialId: { type: String, required: true, unique: true },
+  publicKeyJwk: { type: mongoose.Schema.Types.Mixed, required: true },
+  coseAlg: { type: Number, required: true },
+  signCount: { type: Number, default: 0 },
+  transports: [{ type: String }],
+  aaguid: { type: String },
+  name: { type: String },
+  lastUsedAt: { type: Date },
+}, { timestamps: true });
+
+webAuthnCredentialSchema.index({ userId: 1, role: 1 });
+
+const WebAuthnCredential = mongoose.model('WebAuthnCredential', webAuthnCredentialSchema);
+
+module.exports = WebAuthnCredential;
@@ -3,19 +3,25 @@ const dotenv = require('dotenv');
 
 dotenv.config();
 
-const client = redis.createClient({
-  socket: {
-    host: process.env.REDIS_HOST,
-    port: process.env.REDIS_PORT ? parseInt(process.env.REDIS_PORT) : undefined,
-  },
-  username: process.env.REDIS_USERNAME,
-  password: process.env.REDIS_PASSWORD,
-});
+// Without REDIS_HOST the service runs as a single instance and keeps its
+// cache in memory (see utils/cacheStore.js)
+let client = null;
 
-client.on('error', (err) => console.error('Redis Client Error', err));
+if (process.env.REDIS_HOST) {
+  client = redis.createClient({
+    socket: {
+      host: process.env.REDIS_HOST,
+      port: process.env.REDIS_PORT ? parseInt(process.env.REDIS_PORT) : undefined,
+    },
+    username: process.env.REDIS_USERNAME,
+    password: process.env.REDIS_PASSWORD,
+  });
 
-(async () => {
-  await client.connect();
-})();
+  client.on('error', (err) => console.error('Redis Client Error', err));
+
+  client.connect().catch((err) => {
+    console.error('Error connecting to Redis:', err.message);
+  });
+}
 
 module.exports = client;
@@ -2,18 +2,128 @@ const express = require('express');
 const router = express.Router();
 const asyncHandler = require('express-async-handler');
 const adminController = require('../controllers/adminController');
-const { validateToken, requirePermission, requireRole } = require('../middleware/authMiddleware');
-const { PermissionAdminCreate, PermissionAdminList, PermissionAdminView, PermissionAdminUpdate, PermissionAdminDelete } = require('../utils/permissions');
+const oauthClientController = require('../controllers/oauthClientController');
+const sessionController = require('../controllers/sessionController');
+const apiKeyController = require('../controllers/apiKeyController');
+const identityProviderController = require('../controllers/identityProviderController');
+const samlProviderController = require('../controllers/samlProviderController');
+const ldapDirectoryController = require('../controllers/ldapDirectoryController');
+const impersonationController = require('../controllers/impersonationController');
+const auditController = require('../controllers/auditController');
+const serviceAccountController = require('../controllers/serviceAccountController');
+const policyController = require('../controllers/policyController');
+const roleController = require('../controllers/roleController');
+const breakGlassController = require('../controllers/breakGlassController');
+const { validateToken, requireInteractive, requirePermission, requireRole } = require('../middleware/authMiddleware');
+const {
+  PermissionAdminCreate,
+  PermissionAdminList,
+  PermissionAdminView,
+  PermissionAdminUpdate,
+  PermissionAdminDelete,
+  PermissionTokenRevoke,
+  PermissionMfaReset,
+  PermissionLockoutManage,
+  PermissionSessionManage,
+  PermissionApiKeyManage,
+  PermissionOAuthClientManage,
+  PermissionIdentityProviderManage,
+  PermissionSamlProviderManage,
+  PermissionLdapDirectoryManage,
+  PermissionUserImpersonate,
+  PermissionAuditView,
+  PermissionServiceAccountManage,
+  PermissionPolicyExplain,
+  PermissionRoleManage,
+  PermissionBreakGlassReview,
+} = require('../utils/permissions');
 
 // Apply authentication middleware
 router.use(validateToken);
 
+// OAuth Client Registration Routes (registered before /:id so they are not shadowed)
+router.post('/oauth-clients', requirePermission(PermissionOAuthClientManage), asyncHandler(oauthClientController.createClient));
+router.get('/oauth-clients', requirePermission(PermissionOAuthClientManage), asyncHandler(oauthClientController.listClients));
+router.get('/oauth-clients/:clientId', requirePermission(PermissionOAuthClientManage), asyncHandler(oauthClientController.getClient));
+router.put('/oauth-clients/:clientId', requirePermission(PermissionOAuthClientManage), asyncHandler(oauthClientController.updateClient));
+router.post('/oauth-clients/:clientId/secret', requirePermission(PermissionOAuthClientManage), asyncHandler(oauthClientController.rotateClientSecret));
+router.delete('/oauth-clients/:clientId', requirePermission(PermissionOAuthClientManage), asyncHandler(oauthClientController.deleteClient));
+
+// Service accounts for service-to-service calls
+router.post('/service-accounts', requirePermission(PermissionServiceAccountManage), asyncHandler(serviceAccountController.createAccount));
+router.get('/service-accounts', requirePermission(PermissionServiceAccountManage), asyncHandler(serviceAccountController.listAccounts));
+router.get('/service-accounts/:clientId', requirePermission(PermissionServiceAccountManage), asyncHandler(serviceAccountController.getAccount));
+router.put('/service-accounts/:clientId', requirePermission(PermissionServiceAccountManage), asyncHandler(serviceAccountController.updateAccount));
+router.post('/service-accounts/:clientId/secret', requirePermission(PermissionServiceAccountManage), asyncHandler(serviceAccountController.rotateSecret));
+router.delete('/service-accounts/:clientId', requirePermission(PermissionServiceAccountManage), asyncHandler(serviceAccountController.deleteAccount));
+
+// External identity providers users can sign in with
+router.post('/identity-providers', requirePermission(PermissionIdentityProviderManage), asyncHandler(identityProviderController.createProvider));
+router.get('/identity-providers', requirePermission(PermissionIdentityProviderManage), asyncHandler(identityProviderController.listProviders));
+router.get('/identity-providers/:slug', requirePermission(PermissionIdentityProviderManage), asyncHandler(identityProviderController.getProvider));
+router.put('/identity-providers/:slug', requirePermission(PermissionIdentityProviderManage), asyncHandler(identityProviderController.updateProvider));
+router.delete('/identity-providers/:slug', requirePermission(PermissionIdentityProviderManage), asyncHandler(identityProviderController.deleteProvider));
+
+// Hospital SAML identity providers for staff sign-in
+router.post('/saml-providers', requirePermission(PermissionSamlProviderManage), asyncHandler(samlProviderController.createProvider));
+router.get('/saml-providers', requirePermission(PermissionSamlProviderManage), asyncHandler(samlProviderController.listProviders));
+router.get('/saml-providers/:slug', requirePermission(PermissionSamlProviderManage), asyncHandler(samlProviderController.getProvider));
+router.put('/saml-providers/:slug', requirePermission(PermissionSamlProviderManage), asyncHandler(samlProviderController.updateProvider));
+router.delete('/saml-providers/:slug', requirePermission(PermissionSamlProviderManage), asyncHandler(samlProviderController.deleteProvider));
+
+// Staff LDAP / Active Directory directories
+router.post('/ldap-directories', requirePermission(PermissionLdapDirectoryManage), asyncHandler(ldapDirectoryController.createDirectory));
+router.get('/ldap-directories', requirePermission(PermissionLdapDirectoryManage), asyncHandler(ldapDirectoryController.listDirectories));
+router.get('/ldap-directories/:slug', requirePermission(PermissionLdapDirectoryManage), asyncHandler(ldapDirectoryController.getDirectory));
+router.put('/ldap-directories/:slug', requirePermission(PermissionLdapDirectoryManage), asyncHandler(ldapDirectoryController.updateDirectory));
+router.delete('/ldap-directories/:slug', requirePermission(PermissionLdapDirectoryManage), asyncHandler(ldapDirectoryController.deleteDirectory));
+router.post('/ldap-directories/:slug/sync', requirePermission(PermissionLdapDirectoryManage), asyncHandler(ldapDirectoryController.syncDirectory));
+
+// Audit trail of sensitive actions
+router.get('/audit-events', requirePermission(PermissionAuditView), asyncHandler(auditController.listEvents));
+
+// Roles: named permission sets assigned to admins
+router.post('/roles', requirePermission(PermissionRoleManage), asyncHandler(roleController.createRole));
+router.get('/roles', requirePermission(PermissionRoleManage), asyncHandler(roleController.listRoles));
+router.get('/roles/:name', requirePermission(PermissionRoleManage), asyncHandler(roleController.getRole));
+router.put('/roles/:name', requirePermission(PermissionRoleManage), asyncHandler(roleController.updateRole));
+router.delete('/roles/:name', requirePermission(PermissionRoleManage), asyncHandler(roleController.deleteRole));
+
+// Review queue for break-glass access to patient records
+router.get('/break-glass', requirePermission(PermissionBreakGlassReview), asyncHandler(breakGlassController.listGrants));
+router.get('/break-glass/:grantId', requirePermission(PermissionBreakGlassReview), asyncHandler(breakGlassController.getGrant));
+router.put('/break-glass/:grantId/review', requirePermission(PermissionBreakGlassReview), asyncHandler(breakGlassController.reviewGrant));
+
+// Access policies and why they allow or deny a given user
+router.get('/policies', requirePermission(PermissionPolicyExplain), asyncHandler(policyController.listPolicies));
+router.post('/policies/explain', requirePermission(PermissionPolicyExplain), asyncHandler(policyController.explainDecision));
+
+// Login Lockout Routes
+router.get('/lockouts', requirePermission(PermissionLockoutManage), asyncHandler(adminController.listLockouts));
+router.delete('/lockouts/:id', requirePermission(PermissionLockoutManage), asyncHandler(adminController.clearLockout));
+
+// Delegation: who created whom, and admins left holding more than their creator
+router.get('/delegation-flags', requirePermission(PermissionAdminView), asyncHandler(adminController.listDelegationFlags));
+
 // Admin Management Routes
 router.post('/', requirePermission(PermissionAdminCreate), asyncHandler(adminController.createAdmin));
 router.get('/', requirePermission(PermissionAdminList), asyncHandler(adminController.listAdmins));
 router.get('/:id', requirePermission(PermissionAdminView), asyncHandler(adminController.getAdmin));
 router.put('/:id', requirePermission(PermissionAdminUpdate), asyncHandler(adminController.updateAdmin));
 router.delete('/:id', requirePermission(PermissionAdminDelete), asyncHandler(adminController.deleteAdmin));
-router.put('/:id/permissions', requireRole('super_admin'), asyncHandler(adminController.updateAdminPermissions));
+router.put('/:id/permissions', requireInteractive, requireRole('super_admin'), asyncHandler(adminController.updateAdminPermissions));
+router.put('/:id/roles', requirePermission(PermissionRoleManage), asyncHandler(roleController.assignRoles));
+router.get('/:id/delegation', requirePermission(PermissionAdminView), asyncHandler(adminController.getDelegation));
+
+// Token Revocation Routes
+router.get('/users/:userId/permissions', requirePermission(PermissionAdminView), asyncHandler(roleController.getUserPermissions));
+router.post('/users/:userId/revoke-tokens', requirePermission(PermissionTokenRevoke), asyncHandler(adminController.revokeUserTokens));
+router.get('/users/:userId/sessions', requirePermission(PermissionSessionManage), asyncHandler(sessionController.listUserSessions));
+router.delete('/users/:userId/sessions', requirePermission(PermissionSessionManage), asyncHandler(sessionController.signOutEverywhere));
+router.delete('/users/:userId/sessions/:sessionId', requirePermission(PermissionSessionManage), asyncHandler(sessionController.revokeUserSession));
+router.get('/users/:userId/api-keys', requirePermission(PermissionApiKeyManage), asyncHandler(apiKeyController.listUserKeys));
+router.delete('/users/:userId/api-keys/:keyId', requirePermission(PermissionApiKeyManage), asyncHandler(apiKeyController.revokeUserKey));
+router.post('/users/:userId/impersonate', requireInteractive, requirePermission(PermissionUserImpersonate), asyncHandler(impersonationController.startImpersonation));
+router.post('/users/:userId/mfa/reset', requirePermission(PermissionMfaReset), asyncHandler(adminController.resetUserMfa));
 
 module.exports = router;
@@ -2,15 +2,89 @@ const express = require('express');
 const router = express.Router();
 const asyncHandler = require('express-async-handler');
 const authController = require('../controllers/authController');
+const mfaController = require('../controllers/mfaController');
+const webauthnController = require('../controllers/webauthnController');
+const passwordResetController = require('../controllers/passwordResetController');
+const emailVerificationController = require('../controllers/emailVerificationController');
+const sessionController = require('../controllers/sessionController');
+const apiKeyController = require('../controllers/apiKeyController');
+const federatedLoginController = require('../controllers/federatedLoginController');
+const samlController = require('../controllers/samlController');
+const impersonationController = require('../controllers/impersonationController');
+const { validateToken, requireInteractive } = require('../middleware/authMiddleware');
+
+// Routes acting on the signed-in user's own account refuse API keys
+const signedIn = [validateToken, requireInteractive];
 
 // Public Authentication Routes
 router.post('/login', asyncHandler(authController.login));
 router.post('/initialize-super-admin', asyncHandler(authController.initializeSuperAdmin));
 router.post('/refresh', asyncHandler(authController.refreshToken));
 router.post('/revoke', asyncHandler(authController.revokeToken));
-router.get('/google', asyncHandler(authController.googleLogin));
-router.get('/google/callback', asyncHandler(authController.googleCallback));
-router.get('/facebook', asyncHandler(authController.facebookLogin));
-router.get('/facebook/callback', asyncHandler(authController.facebookCallback));
+router.get('/password/policy', asyncHandler(authController.getPasswordPolicy));
+router.get('/permissions', validateToken, asyncHandler(authController.getPermissions));
+router.post('/password/change', signedIn, asyncHandler(authController.changePassword));
+router.post('/password/forgot', asyncHandler(passwordResetController.requestReset));
+router.post('/password/reset', asyncHandler(passwordResetController.resetPassword));
+router.post('/email/verify', asyncHandler(emailVerificationController.confirmVerification));
+router.post('/email/verify/resend', asyncHandler(emailVerificationController.resendVerification));
+
+// Sign-in through external identity providers
+router.get('/providers', asyncHandler(federatedLoginController.listProviders));
+router.post('/providers/complete', asyncHandler(federatedLoginController.completeLogin));
+router.post('/providers/:slug/login', asyncHandler(federatedLoginController.beginLogin));
+router.get('/providers/:slug/callback', asyncHandler(federatedLoginController.callback));
+router.post('/providers/:slug/callback', express.urlencoded({ extended: false }), asyncHandler(federatedLoginController.callback));
+
+// SAML single sign-on for hospital staff
+router.get('/saml/metadata', asyncHandler(samlController.metadata));
+router.post('/saml/acs', express.urlencoded({ extended: false, limit: '1mb' }), asyncHandler(samlController.acs));
+router.post('/saml/complete', asyncHandler(samlController.completeLogin));
+router.post('/saml/:slug/login', asyncHandler(samlController.beginLogin));
+
+// Second step of a login that returned an MFA challenge
+router.post('/mfa/verify', asyncHandler(mfaController.verifyChallenge));
+router.post('/mfa/challenge/enroll', asyncHandler(mfaController.beginChallengeEnrollment));
+router.post('/mfa/challenge/enroll/confirm', asyncHandler(mfaController.confirmChallengeEnrollment));
+router.post('/mfa/webauthn/options', asyncHandler(webauthnController.mfaOptions));
+router.post('/mfa/webauthn/verify', asyncHandler(webauthnController.verifyMfa));
+
+// Self-service MFA management
+router.get('/mfa', signedIn, asyncHandler(mfaController.getStatus));
+router.post('/mfa/enroll', signedIn, asyncHandler(mfaController.beginEnrollment));
+router.post('/mfa/enroll/confirm', signedIn, asyncHandler(mfaController.confirmEnrollment));
+router.post('/mfa/recovery-codes', signedIn, asyncHandler(mfaController.regenerateRecoveryCodes));
+router.delete('/mfa', signedIn, asyncHandler(mfaController.disable));
+
+// Passkey (WebAuthn) login and management
+router.post('/webauthn/login/options', asyncHandler(webauthnController.loginOptions));
+router.post('/webauthn/login', asyncHandler(webauthnController.login));
+router.post('/webauthn/register/options', signedIn, asyncHandler(webauthnController.registrationOptions));
+router.post('/webauthn/register', signedIn, asyncHandler(webauthnController.verifyRegistration));
+router.get('/webauthn/credentials', signedIn, asyncHandler(webauthnController.listCredentials));
+router.delete('/webauthn/credentials/:id', signedIn, asyncHandler(webauthnController.deleteCredential));
+
+// Self-service session management
+router.get('/sessions', signedIn, asyncHandler(sessionController.listSessions));
+router.delete('/sessions', signedIn, asyncHandler(sessionController.revokeOtherSessions));
+router.get('/sessions/:id', signedIn, asyncHandler(sessionController.getSession));
+router.delete('/sessions/:id', signedIn, asyncHandler(sessionController.revokeSession));
+
+// Self-service API keys
+router.post('/api-keys', signedIn, asyncHandler(apiKeyController.createKey));
+router.get('/api-keys', signedIn, asyncHandler(apiKeyController.listKeys));
+router.get('/api-keys/:id', signedIn, asyncHandler(apiKeyController.getKey));
+router.patch('/api-keys/:id', signedIn, asyncHandler(apiKeyController.updateKey));
+router.delete('/api-keys/:id', signedIn, asyncHandler(apiKeyController.revokeKey));
+router.post('/api-keys/:id/rotate', signedIn, asyncHandler(apiKeyController.rotateKey));
+
+// Self-service linked identities
+router.get('/identities', signedIn, asyncHandler(federatedLoginController.listIdentities));
+router.post('/identities', signedIn, asyncHandler(federatedLoginController.beginLink));
+router.post('/identities/complete', signedIn, asyncHandler(federatedLoginController.completeLink));
+router.delete('/identities/:id', signedIn, asyncHandler(federatedLoginController.unlinkIdentity));
+
+// Ends the impersonation the presented token belongs to
+router.delete('/impersonation', validateToken, asyncHandler(impersonationController.endImpersonation));
 
 module.exports = router;
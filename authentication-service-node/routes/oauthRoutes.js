@@ -0,0 +1,20 @@
+const express = require('express');
+const router = express.Router();
+const asyncHandler = require('express-async-handler');
+const oidcController = require('../controllers/oidcController');
+const { validateToken, requireInteractive, requireUserAccessToken } = require('../middleware/authMiddleware');
+
+// Authorizing clients needs the user's own session; userinfo also takes
+// access tokens issued to clients
+const signedIn = [validateToken, requireInteractive];
+const userToken = [validateToken, requireUserAccessToken];
+
+// OpenID Connect Provider Routes
+router.get('/authorize', asyncHandler(oidcController.authorize));
+router.post('/authorize', signedIn, asyncHandler(oidcController.approveAuthorization));
+router.post('/token', express.urlencoded({ extended: false }), asyncHandler(oidcController.token));
+router.post('/introspect', express.urlencoded({ extended: false }), asyncHandler(oidcController.introspect));
+router.get('/userinfo', userToken, asyncHandler(oidcController.userInfo));
+router.post('/userinfo', userToken, asyncHandler(oidcController.userInfo));
+
+module.exports = router;
@@ -2,22 +2,41 @@ const express = require('express');
 const router = express.Router();
 const asyncHandler = require('express-async-handler');
 const patientController = require('../controllers/patientController');
-const { validateToken, requirePermission } = require('../middleware/authMiddleware');
+const breakGlassController = require('../controllers/breakGlassController');
 const {
-  PermissionPatientList,
-  PermissionPatientView,
-  PermissionPatientHistory,
+  validateToken,
+  requireInteractive,
+  requireVerifiedEmail,
+  requirePermission,
+  requirePolicy,
+} = require('../middleware/authMiddleware');
+const {
+  PermissionCareTeamManage,
+  PermissionCareRelationshipSync,
+  PermissionBreakGlassRequest,
 } = require('../utils/permissions');
 
 router.post('/register', asyncHandler(patientController.registerPatient));
 
 // Apply authentication middleware to all routes except /register
 router.use(validateToken);
+// Patient records are PHI
+router.use(requireVerifiedEmail);
 
 // Patient Management Routes
-router.get('/', requirePermission(PermissionPatientList), asyncHandler(patientController.listPatients));
-router.get('/:id', requirePermission(PermissionPatientView), asyncHandler(patientController.getPatient));
-router.get('/:id/history', requirePermission(PermissionPatientHistory), asyncHandler(patientController.getPatientHistory));
-router.post('/:id/history', requirePermission(PermissionPatientHistory), asyncHandler(patientController.addPatientHistory));
+router.get('/', requirePolicy('patient.list'), asyncHandler(patientController.listPatients));
+router.get('/:id', requirePolicy('patient.read'), asyncHandler(patientController.getPatient));
+router.get('/:id/history', requirePolicy('patient.history.read'), asyncHandler(patientController.getPatientHistory));
+router.post('/:id/history', requirePolicy('patient.history.write'), asyncHandler(patientController.addPatientHistory));
+
+// Care relationships, which decide the doctors a patient's records are open to
+router.get('/:id/care-team', requirePolicy('patient.read'), asyncHandler(patientController.listCareTeam));
+router.post('/:id/care-team', requirePermission(PermissionCareTeamManage), asyncHandler(patientController.addCareTeamMember));
+router.delete('/:id/care-team/:doctorId', requirePermission(PermissionCareTeamManage), asyncHandler(patientController.removeCareTeamMember));
+router.put('/:id/appointments/:appointmentId', requirePermission(PermissionCareRelationshipSync), asyncHandler(patientController.syncAppointment));
+
+// Break-glass: time-boxed emergency access for a doctor outside the care team
+router.post('/:id/break-glass', requireInteractive, requirePermission(PermissionBreakGlassRequest), asyncHandler(breakGlassController.requestAccess));
+router.delete('/:id/break-glass', requireInteractive, requirePermission(PermissionBreakGlassRequest), asyncHandler(breakGlassController.endAccess));
 
 module.exports = router;
@@ -2,7 +2,7 @@ const express = require('express');
 const router = express.Router();
 const asyncHandler = require('express-async-handler');
 const systemController = require('../controllers/systemController');
-const { validateToken, requirePermission } = require('../middleware/authMiddleware');
+const { validateToken, requireInteractive, requirePermission, requireRole } = require('../middleware/authMiddleware');
 const {
   PermissionSystemConfig,
   PermissionSystemMetrics,
@@ -17,5 +17,6 @@ router.get('/config', requirePermission(PermissionSystemConfig), asyncHandler(sy
 router.put('/config', requirePermission(PermissionSystemConfig), asyncHandler(systemController.updateSystemConfig));
 router.get('/metrics', requirePermission(PermissionSystemMetrics), asyncHandler(systemController.getSystemMetrics));
 router.get('/logs', requirePermission(PermissionSystemLogs), asyncHandler(systemController.getSystemLogs));
+router.post('/keys/rotate', requireInteractive, requireRole('super_admin'), asyncHandler(systemController.rotateSigningKeys));
 
 module.exports = router;
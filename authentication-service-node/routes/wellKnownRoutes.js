@@ -0,0 +1,11 @@
+const express = require('express');
+const router = express.Router();
+const asyncHandler = require('express-async-handler');
+const wellKnownController = require('../controllers/wellKnownController');
+
+// Public discovery documents
+router.get('/jwks.json', asyncHandler(wellKnownController.getJwks));
+router.get('/openid-configuration', asyncHandler(wellKnownController.getOpenIdConfiguration));
+router.get('/permission-rules.json', wellKnownController.getPermissionRules);
+
+module.exports = router;
@@ -0,0 +1,50 @@
+// Links existing SuperAdmin, Admin, Doctor and Patient documents to Account
+// records and moves their credentials there. Safe to run more than once:
+// profiles that already have an account are skipped.
+//
+//   MONGO_URI=... npm run migrate:accounts
+require('dotenv').config();
+const mongoose = require('mongoose');
+const AccountStore = require('../services/accountStore');
+const Account = require('../models/Account');
+const PasswordHistory = require('../models/PasswordHistory');
+const SuperAdmin = require('../models/SuperAdmin');
+const Admin = require('../models/Admin');
+const Doctor = require('../models/Doctor');
+const Patient = require('../models/Patient');
+
+const main = async () => {
+  if (!process.env.MONGO_URI) {
+    throw new Error('MONGO_URI environment variable is not set');
+  }
+  await mongoose.connect(process.env.MONGO_URI);
+  // The unique email index must exist before accounts are written
+  await Account.init();
+
+  const accountStore = new AccountStore({
+    accountModel: Account,
+    profileModels: {
+      super_admin: SuperAdmin,
+      admin: Admin,
+      doctor: Doctor,
+      patient: Patient,
+    },
+    passwordHistoryModel: PasswordHistory,
+  });
+  const report = await accountStore.migrateLegacyProfiles();
+
+  console.log(`Accounts created: ${report.accountsCreated}`);
+  console.log(`Profiles linked: ${report.profilesLinked}`);
+  if (report.conflicts.length > 0) {
+    console.log(`Profiles left unlinked (${report.conflicts.length}); they cannot sign in until resolved:`);
+    for (const conflict of report.conflicts) {
+      console.log(`  ${conflict.role} ${conflict.profileId} <${conflict.email}>: ${conflict.reason}`);
+    }
+  }
+  await mongoose.disconnect();
+};
+
+main().catch((error) => {
+  console.error('Account migration failed:', error.message);
+  process.exit(1);
+});
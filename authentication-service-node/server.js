@@ -11,10 +11,17 @@ const patientRoutes = require('./routes/patientRoutes');
 const systemRoutes = require('./routes/systemRoutes');
 const healthRoutes = require('./routes/healthRoutes');
 const permissionsRoutes = require('./routes/permissionsRoutes');
+const wellKnownRoutes = require('./routes/wellKnownRoutes');
+const oauthRoutes = require('./routes/oauthRoutes');
+const authService = require('./services/authServiceInstance');
+const directorySyncService = require('./services/directorySyncService');
+const roleService = require('./services/roleService');
+const env = require('./config/env');
 
 const { errorHandler, notFound } = require('./middleware/errorMiddleware');
 
 const app = express();
+app.set('trust proxy', env.TRUST_PROXY);
 
 // Middleware
 app.use(cors());
@@ -33,11 +40,54 @@ mongoose.connect(mongoURI, {
   useUnifiedTopology: true,
 }).then(() => {
   console.log('Connected to MongoDB');
+  scheduleKeyRotation();
+  scheduleDirectorySync();
+  warnAboutUnlinkedProfiles();
+  roleService.ensureBuiltInRoles().catch((err) => {
+    console.error('Error creating built-in roles:', err.message);
+  });
 }).catch((err) => {
   console.error('Error connecting to MongoDB:', err.message);
   process.exit(1);
 });
 
+// Profiles created before accounts existed cannot sign in until migrated
+const warnAboutUnlinkedProfiles = () => {
+  authService.accountStore.countUnlinkedProfiles().then((count) => {
+    if (count > 0) {
+      console.warn(`${count} user profiles have no account; run "npm run migrate:accounts"`);
+    }
+  }).catch((err) => {
+    console.error('Error checking for unmigrated profiles:', err.message);
+  });
+};
+
+// Make sure a signing key exists and the next one is published ahead of time
+const KEY_ROTATION_CHECK_INTERVAL_MS = 60 * 60 * 1000;
+const scheduleKeyRotation = () => {
+  const rotate = () => authService.keyRing.rotateIfDue().catch((err) => {
+    console.error('Error rotating signing keys:', err.message);
+  });
+  rotate();
+  setInterval(rotate, KEY_ROTATION_CHECK_INTERVAL_MS);
+};
+
+// Disable staff accounts that have left their LDAP directory
+const scheduleDirectorySync = () => {
+  const intervalMs = env.LDAP_SYNC_INTERVAL_MINUTES * 60 * 1000;
+  const sync = () => directorySyncService.syncAll(intervalMs / 1000).then((reports) => {
+    for (const report of reports) {
+      if (report.error) {
+        console.error(`Error syncing directory ${report.directory}:`, report.error);
+      }
+    }
+  }).catch((err) => {
+    console.error('Error syncing directories:', err.message);
+  });
+  sync();
+  setInterval(sync, intervalMs);
+};
+
 // Routes
 app.use('/api/v1/auth', authRoutes);
 app.use('/api/v1/admins', adminRoutes);
@@ -46,6 +96,8 @@ app.use('/api/v1/patients', patientRoutes);
 app.use('/api/v1/system', systemRoutes);
 app.use('/health', healthRoutes);
 app.use('/api/v1/permissions', permissionsRoutes);
+app.use('/.well-known', wellKnownRoutes);
+app.use('/oauth', oauthRoutes);
 
 // Error Handling Middleware
 app.use(notFound);
@@ -0,0 +1,147 @@
+// Roles in the order a login picks them when the caller does not ask for one
+const ROLE_PRECEDENCE = ['super_admin', 'admin', 'doctor', 'patient'];
+
+// Password history is kept per account under this pseudo-role
+const ACCOUNT_HISTORY_ROLE = 'account';
+
+const normalizeEmail = (email) => (typeof email === 'string' ? email.trim().toLowerCase() : '');
+
+class AccountStore {
+  constructor({ accountModel, profileModels, passwordHistoryModel }) {
+    this.accountModel = accountModel;
+    this.profileModels = profileModels;
+    this.passwordHistoryModel = passwordHistoryModel;
+  }
+
+  async findByEmail(email) {
+    return this.accountModel.findOne({ email: normalizeEmail(email) });
+  }
+
+  async findById(accountId) {
+    return accountId ? this.accountModel.findById(accountId) : null;
+  }
+
+  async forProfile(profile) {
+    return this.findById(profile.accountId);
+  }
+
+  // The account's roles, most privileged first
+  rolesOf(account) {
+    return account.roles
+      .map((entry) => entry.role)
+      .sort((a, b) => ROLE_PRECEDENCE.indexOf(a) - ROLE_PRECEDENCE.indexOf(b));
+  }
+
+  profileIdFor(account, role) {
+    const entry = account.roles.find((candidate) => candidate.role === role);
+    return entry ? entry.profileId : null;
+  }
+
+  async create({ email, passwordHash, role, profileId, emailVerified = false }) {
+    return this.accountModel.create({
+      email: normalizeEmail(email),
+      passwordHash,
+      roles: [{ role, profileId }],
+      emailVerified,
+      emailVerifiedAt: emailVerified ? new Date() : undefined,
+    });
+  }
+
+  async addRole(accountId, role, profileId) {
+    await this.accountModel.updateOne({ _id: accountId }, { $push: { roles: { role, profileId } } });
+  }
+
+  async recordLogin(accountId) {
+    await this.accountModel.updateOne({ _id: accountId }, { lastLoginAt: new Date() });
+  }
+
+  // Unlinks a deleted profile; an account left without roles is removed
+  async removeRole(role, profileId) {
+    const account = await this.accountModel.findOneAndUpdate(
+      { 'roles.profileId': profileId },
+      { $pull: { roles: { role, profileId } } },
+      { new: true },
+    );
+    if (account && account.roles.length === 0) {
+      await this.accountModel.deleteOne({ _id: account._id, roles: { $size: 0 } });
+    }
+  }
+
+  // One-off migration from per-role credentials. Profiles are linked to the
+  // account for their normalized email, most privileged role first, and the
+  // account keeps the password of the profile that created it. A later
+  // profile with a password of its own is left unlinked and reported as
+  // 'separate password' for an operator to resolve: bcrypt salts every hash,
+  // so two hashes of one password never compare equal and there is no telling
+  // whether the passwords match, while merging would let either open both
+  // roles. Only a profile without a password, or with a copy of the
+  // account's hash, joins it.
+  async migrateLegacyProfiles() {
+    const report = { accountsCreated: 0, profilesLinked: 0, conflicts: [] };
+    for (const role of ROLE_PRECEDENCE) {
+      const model = this.profileModels[role];
+      // lean() returns the stored document, including fields no longer in the schema
+      const profiles = await model.find({ accountId: { $exists: false } }).lean();
+      for (const profile of profiles) {
+        const email = normalizeEmail(profile.email);
+        let account = await this.accountModel.findOne({ email });
+        if (account && this.profileIdFor(account, role)) {
+          report.conflicts.push({ email, role, profileId: profile._id, reason: 'duplicate role' });
+          continue;
+        }
+        if (account && profile.passwordHash && account.passwordHash !== profile.passwordHash) {
+          report.conflicts.push({ email, role, profileId: profile._id, reason: 'separate password' });
+          continue;
+        }
+
+        // Staff accounts were created by administrators, never self-registered
+        const emailVerified = Boolean(profile.emailVerified) || ['super_admin', 'admin'].includes(role);
+        if (account) {
+          await this.addRole(account._id, role, profile._id);
+          if (emailVerified && !account.emailVerified) {
+            await this.accountModel.updateOne(
+              { _id: account._id },
+              { emailVerified: true, emailVerifiedAt: profile.emailVerifiedAt || new Date() },
+            );
+          }
+        } else {
+          account = await this.accountModel.create({
+            email,
+            passwordHash: profile.passwordHash,
+            roles: [{ role, profileId: profile._id }],
+            emailVerified,
+            emailVerifiedAt: emailVerified ? profile.emailVerifiedAt || new Date() : undefined,
+          });
+          report.accountsCreated += 1;
+        }
+
+        await model.updateOne(
+          { _id: profile._id },
+          {
+            $set: { accountId: account._id, email },
+            $unset: { passwordHash: '', emailVerified: '', emailVerifiedAt: '' },
+          },
+        );
+        await this.passwordHistoryModel.updateMany(
+          { userId: profile._id.toString(), role },
+          { userId: account._id.toString(), role: ACCOUNT_HISTORY_ROLE },
+        );
+        report.profilesLinked += 1;
+      }
+    }
+    return report;
+  }
+
+  async countUnlinkedProfiles() {
+    let count = 0;
+    for (const role of ROLE_PRECEDENCE) {
+      count += await this.profileModels[role].countDocuments({ accountId: { $exists: false } });
+    }
+    return count;
+  }
+}
+
+module.exports = AccountStore;
+module.exports.ROLE_PRECEDENCE = ROLE_PRECEDENCE;
+module.exports.ACCOUNT_HISTORY_ROLE = ACCOUNT_HISTORY_ROLE;
+module.exports.normalizeEmail = normalizeEmail;
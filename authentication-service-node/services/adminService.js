@@ -1,25 +1,37 @@
 const Admin = require('../models/Admin');
-const bcrypt = require('bcryptjs');
+const authService = require('./authServiceInstance');
+const roleService = require('./roleService');
+const delegationService = require('./delegationService');
+
+// What the admin update endpoint may change. The email is the sign-in
+// identity, passwords go through the password policy, and permissions, roles
+// and creator fields through their own checked paths.
+const PROFILE_FIELDS = ['username'];
 
 class AdminService {
-  async createAdmin(creatorId, email, password, permissions) {
+  // Admins created without roles or permissions get the default admin role.
+  // The creator has to hold everything the new admin is given.
+  async createAdmin(creator, email, password, permissions, roles) {
     if (!email.includes('@')) {
       throw new Error('Invalid email format');
     }
-    const hashedPassword = await bcrypt.hash(password, 10);
+    const granted = roleService.validatePermissions(permissions || []);
+    let roleNames = roles || [];
+    if (granted.length === 0 && roleNames.length === 0) {
+      roleNames = [roleService.DEFAULT_ADMIN_ROLE];
+    }
+    const assignedRoles = await roleService.findRoles(roleNames);
+    delegationService.assertCanGrant(creator, [...granted, ...assignedRoles.flatMap((role) => role.permissions)]);
     const username = email.substring(0, email.indexOf('@'));
-    const admin = new Admin({
+    const { profile } = await authService.registerAccount('admin', {
       username,
       email,
-      passwordHash: hashedPassword,
-      createdBy: creatorId,
-      permissions: permissions && permissions.length > 0 ? permissions : this.defaultAdminPermissions(),
-    });
-    await admin.save();
-  }
-
-  defaultAdminPermissions() {
-    return ['admin:create', 'admin:list', 'admin:view', 'admin:update', 'admin:delete'];
+      createdBy: creator.user_id,
+      createdByRole: creator.role,
+      permissions: granted,
+      roles: assignedRoles.map((role) => role.name),
+    }, password);
+    await roleService.refreshRolePermissions(profile, assignedRoles);
   }
 
   async listAdmins() {
@@ -31,15 +43,23 @@ class AdminService {
   }
 
   async updateAdmin(adminId, updateData) {
-    return Admin.findByIdAndUpdate(adminId, updateData, { new: true });
+    const changes = {};
+    for (const field of PROFILE_FIELDS) {
+      if (updateData[field] !== undefined) changes[field] = updateData[field];
+    }
+    return Admin.findByIdAndUpdate(adminId, changes, { new: true });
   }
 
   async deleteAdmin(adminId) {
-    return Admin.findByIdAndDelete(adminId);
+    const admin = await Admin.findByIdAndDelete(adminId);
+    if (admin) {
+      await authService.accountStore.removeRole('admin', admin._id);
+    }
+    return admin;
   }
 
-  async updateAdminPermissions(adminId, permissions) {
-    return Admin.findByIdAndUpdate(adminId, { permissions }, { new: true });
+  async updateAdminPermissions(claims, adminId, permissions, context = {}) {
+    return roleService.setDirectPermissions(claims, adminId, permissions, context);
   }
 }
 
@@ -0,0 +1,232 @@
+const { ObjectId } = require('mongoose').Types;
+const ApiKey = require('../models/ApiKey');
+const authService = require('./authServiceInstance');
+const apiKeyFormat = require('../utils/apiKeyFormat');
+const cacheStore = require('../utils/cacheStore');
+const permissions = require('../utils/permissions');
+const Principal = require('../utils/principal');
+const permissionRules = require('../utils/permissionRules');
+const env = require('../config/env');
+
+const KNOWN_SCOPES = Object.values(permissions);
+const MAX_KEYS_PER_USER = 20;
+const MAX_ROTATION_GRACE_MINUTES = 24 * 60;
+// Usage writes are batched: a key is marked used at most once a minute
+const LAST_USED_RESOLUTION_SECONDS = 60;
+
+const DAY_MS = 24 * 60 * 60 * 1000;
+
+class ApiKeyService {
+  // Scopes a key may carry are limited to what its owner holds right now
+  ownerPermissions(identity) {
+    return KNOWN_SCOPES.filter((scope) => permissionRules.holds(identity.permissions, scope));
+  }
+
+  async loadOwner(ownerId, role) {
+    const loaded = await authService.loadActiveProfile(ownerId, role);
+    if (!loaded) {
+      throw new Error('Key owner not found');
+    }
+    return authService.identityFor(loaded.user, role, loaded.account);
+  }
+
+  validateName(name) {
+    if (typeof name !== 'string' || !name.trim()) {
+      throw new Error('Key name is required');
+    }
+    if (name.trim().length > 100) {
+      throw new Error('Key name must be at most 100 characters');
+    }
+    return name.trim();
+  }
+
+  validateScopes(scopes, identity) {
+    if (!Array.isArray(scopes) || scopes.length === 0) {
+      throw new Error('At least one scope is required');
+    }
+    const unknown = scopes.filter((scope) => !KNOWN_SCOPES.includes(scope));
+    if (unknown.length > 0) {
+      throw new Error(`Unknown scopes: ${unknown.join(', ')}`);
+    }
+    const granted = this.ownerPermissions(identity);
+    const excess = scopes.filter((scope) => !granted.includes(scope));
+    if (excess.length > 0) {
+      throw new Error(`Scopes exceed your permissions: ${excess.join(', ')}`);
+    }
+    return [...new Set(scopes)];
+  }
+
+  lifetimeDays(expiresInDays) {
+    const days = expiresInDays === undefined ? env.API_KEY_DEFAULT_TTL_DAYS : Number(expiresInDays);
+    if (!Number.isInteger(days) || days < 1 || days > env.API_KEY_MAX_TTL_DAYS) {
+      throw new Error(`Key lifetime must be between 1 and ${env.API_KEY_MAX_TTL_DAYS} days`);
+    }
+    return days;
+  }
+
+  // Returns the key once; only its hash is stored
+  async createKey(claims, { name, scopes, expiresInDays }) {
+    const identity = await this.loadOwner(claims.user_id, claims.role);
+    const keyData = {
+      name: this.validateName(name),
+      scopes: this.validateScopes(scopes, identity),
+      expiresAt: new Date(Date.now() + this.lifetimeDays(expiresInDays) * DAY_MS),
+    };
+    const active = await ApiKey.countDocuments({
+      ownerId: claims.user_id,
+      ownerRole: claims.role,
+      revokedAt: null,
+      expiresAt: { $gt: new Date() },
+    });
+    if (active >= MAX_KEYS_PER_USER) {
+      throw new Error(`At most ${MAX_KEYS_PER_USER} active API keys are allowed`);
+    }
+    return this.storeKey(claims.user_id, claims.role, keyData);
+  }
+
+  async storeKey(ownerId, ownerRole, { name, scopes, expiresAt, rotatedFrom = null }) {
+    const key = apiKeyFormat.generate();
+    const apiKey = await ApiKey.create({
+      keyHash: apiKeyFormat.hash(key),
+      prefix: apiKeyFormat.displayPrefix(key),
+      name,
+      ownerId: ownerId.toString(),
+      ownerRole,
+      scopes,
+      expiresAt,
+      rotatedFrom,
+    });
+    return { apiKey: this.toPublic(apiKey), key };
+  }
+
+  toPublic(apiKey) {
+    const now = new Date();
+    return {
+      id: apiKey._id,
+      name: apiKey.name,
+      prefix: apiKey.prefix,
+      ownerId: apiKey.ownerId,
+      ownerRole: apiKey.ownerRole,
+      scopes: apiKey.scopes,
+      expiresAt: apiKey.expiresAt,
+      lastUsedAt: apiKey.lastUsedAt,
+      lastUsedIp: apiKey.lastUsedIp,
+      revokedAt: apiKey.revokedAt,
+      rotatedFrom: apiKey.rotatedFrom,
+      active: !apiKey.revokedAt && apiKey.expiresAt > now,
+      createdAt: apiKey.createdAt,
+    };
+  }
+
+  async listKeys(ownerId) {
+    const keys = await ApiKey.find({ ownerId: ownerId.toString() }).sort({ createdAt: -1 });
+    return keys.map((apiKey) => this.toPublic(apiKey));
+  }
+
+  async findOwnedKey(ownerId, keyId) {
+    const apiKey = ObjectId.isValid(keyId)
+      ? await ApiKey.findOne({ _id: keyId, ownerId: ownerId.toString() })
+      : null;
+    if (!apiKey) {
+      throw new Error('API key not found');
+    }
+    return apiKey;
+  }
+
+  async getKey(ownerId, keyId) {
+    return this.toPublic(await this.findOwnedKey(ownerId, keyId));
+  }
+
+  // Renames a key or narrows/changes its scopes; the secret stays the same
+  async updateKey(claims, keyId, { name, scopes }) {
+    const apiKey = await this.findOwnedKey(claims.user_id, keyId);
+    if (apiKey.revokedAt) {
+      throw new Error('API key has been revoked');
+    }
+    const updates = {};
+    if (name !== undefined) {
+      updates.name = this.validateName(name);
+    }
+    if (scopes !== undefined) {
+      updates.scopes = this.validateScopes(scopes, await this.loadOwner(claims.user_id, claims.role));
+    }
+    Object.assign(apiKey, updates);
+    await apiKey.save();
+    return this.toPublic(apiKey);
+  }
+
+  async revokeKey(ownerId, keyId) {
+    const apiKey = await this.findOwnedKey(ownerId, keyId);
+    if (!apiKey.revokedAt) {
+      apiKey.revokedAt = new Date();
+      await apiKey.save();
+    }
+  }
+
+  // Issues a replacement with the same name, scopes and lifetime. The old key
+  // keeps working for the grace period so deployments can switch over.
+  async rotateKey(claims, keyId, { gracePeriodMinutes = 0 } = {}) {
+    const grace = Number(gracePeriodMinutes);
+    if (!Number.isInteger(grace) || grace < 0 || grace > MAX_ROTATION_GRACE_MINUTES) {
+      throw new Error(`Grace period must be between 0 and ${MAX_ROTATION_GRACE_MINUTES} minutes`);
+    }
+    const apiKey = await this.findOwnedKey(claims.user_id, keyId);
+    const now = new Date();
+    if (apiKey.revokedAt || apiKey.expiresAt <= now) {
+      throw new Error('Only active API keys can be rotated');
+    }
+    const identity = await this.loadOwner(claims.user_id, claims.role);
+    // Scopes the owner has since lost are dropped rather than carried over
+    const granted = this.ownerPermissions(identity);
+    const scopes = apiKey.scopes.filter((scope) => granted.includes(scope));
+    if (scopes.length === 0) {
+      throw new Error('You no longer hold any of this key\'s scopes');
+    }
+    const lifetimeMs = Math.max(apiKey.expiresAt - apiKey.createdAt, DAY_MS);
+
+    const rotated = await this.storeKey(claims.user_id, claims.role, {
+      name: apiKey.name,
+      scopes,
+      expiresAt: new Date(now.getTime() + lifetimeMs),
+      rotatedFrom: apiKey._id,
+    });
+    if (grace === 0) {
+      apiKey.revokedAt = now;
+    } else {
+      apiKey.expiresAt = new Date(Math.min(apiKey.expiresAt.getTime(), now.getTime() + grace * 60 * 1000));
+    }
+    await apiKey.save();
+    return rotated;
+  }
+
+  // Resolves an X-API-Key header to a principal. The key only grants the
+  // scopes its owner still holds, so demoting an admin narrows their keys too.
+  async authenticate(key, context = {}) {
+    if (!apiKeyFormat.isWellFormed(key)) {
+      throw new Error('Malformed API key');
+    }
+    const now = new Date();
+    const apiKey = await ApiKey.findOne({ keyHash: apiKeyFormat.hash(key) });
+    if (!apiKey || apiKey.revokedAt || apiKey.expiresAt <= now) {
+      throw new Error('Invalid or expired API key');
+    }
+    const identity = await this.loadOwner(apiKey.ownerId, apiKey.ownerRole);
+    const granted = this.ownerPermissions(identity);
+
+    const seenKey = `api_key_seen:${apiKey._id}`;
+    if (!await cacheStore.get(seenKey)) {
+      const update = { lastUsedAt: now };
+      if (context.ip) update.lastUsedIp = context.ip;
+      await ApiKey.updateOne({ _id: apiKey._id }, update);
+      await cacheStore.set(seenKey, '1', LAST_USED_RESOLUTION_SECONDS);
+    }
+
+    return Principal.fromApiKey({
+      identity,
+      apiKeyId: apiKey._id,
+      scopes: apiKey.scopes.filter((scope) => granted.includes(scope)),
+    });
+  }
+}
+
+module.exports = new ApiKeyService();
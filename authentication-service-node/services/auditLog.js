@@ -0,0 +1,64 @@
+const AuditEvent = require('../models/AuditEvent');
+
+const MAX_PAGE_SIZE = 200;
+
+// Append-only record of sensitive actions. Writes never fail the request
+// they describe: a lost audit event is logged, the response still goes out.
+class AuditLog {
+  async record({ action, actor, subject = null, sessionId, reason, method, path, statusCode, context = {} }) {
+    try {
+      await AuditEvent.create({
+        action,
+        actor: { userId: actor.userId.toString(), role: actor.role, email: actor.email },
+        subject: subject ? { userId: subject.userId.toString(), role: subject.role } : undefined,
+        sessionId,
+        reason,
+        method,
+        path,
+        statusCode,
+        ip: context.ip,
+        userAgent: context.userAgent,
+      });
+    } catch (error) {
+      console.error(`Error recording audit event ${action} by ${actor.userId}:`, error.message);
+    }
+  }
+
+  // Newest first; before pages back through older events
+  async list({ action, actorId, subjectId, sessionId, before, limit } = {}) {
+    const filter = {};
+    if (action) filter.action = action;
+    if (actorId) filter['actor.userId'] = actorId;
+    if (subjectId) filter['subject.userId'] = subjectId;
+    if (sessionId) filter.sessionId = sessionId;
+    if (before) {
+      const date = new Date(before);
+      if (Number.isNaN(date.getTime())) {
+        throw new Error('before must be a date');
+      }
+      filter.createdAt = { $lt: date };
+    }
+    const pageSize = Math.min(parseInt(limit, 10) || 50, MAX_PAGE_SIZE);
+    const events = await AuditEvent.find(filter).sort({ createdAt: -1 }).limit(pageSize);
+    return events.map((event) => this.toPublic(event));
+  }
+
+  toPublic(event) {
+    return {
+      id: event._id,
+      action: event.action,
+      actor: event.actor,
+      subject: event.subject && event.subject.userId ? event.subject : null,
+      sessionId: event.sessionId,
+      reason: event.reason,
+      method: event.method,
+      path: event.path,
+      statusCode: event.statusCode,
+      ip: event.ip,
+      userAgent: event.userAgent,
+      createdAt: event.createdAt,
+    };
+  }
+}
+
+module.exports = new AuditLog();
@@ -1,36 +1,186 @@
+const crypto = require('crypto');
 const bcrypt = require('bcryptjs');
-const jwt = require('jsonwebtoken');
-const redis = require('redis');
-const { promisify } = require('util');
+const jwt = require('../utils/jwt');
 const { ObjectId } = require('mongoose').Types;
+const cacheStore = require('../utils/cacheStore');
+const permissionRules = require('../utils/permissionRules');
+const { normalizeEmail, ACCOUNT_HISTORY_ROLE } = require('./accountStore');
 
-const redisClient = redis.createClient();
-const getAsync = promisify(redisClient.get).bind(redisClient);
-const setAsync = promisify(redisClient.set).bind(redisClient);
+// Access tokens are short-lived; sessions are kept alive with refresh tokens.
+// Revocation markers only need to outlive the access tokens issued before them.
+const ACCESS_TOKEN_LIFETIME_SECONDS = 15 * 60;
+// RFC 9068. ID tokens are signed with the same keys, so access tokens say
+// what they are and verifiers accept nothing else.
+const ACCESS_TOKEN_TYPE = 'at+jwt';
+
+const MFA_CHALLENGE_TTL_SECONDS = 5 * 60;
+const MFA_CHALLENGE_MAX_ATTEMPTS = 5;
+
+const hashToken = (token) => crypto.createHash('sha256').update(token).digest('hex');
 
 class AuthService {
-  constructor({ superAdminModel, adminModel, doctorModel, patientModel, jwtSecret }) {
+  constructor({
+    superAdminModel,
+    adminModel,
+    doctorModel,
+    patientModel,
+    refreshTokenModel,
+    accountStore,
+    mfaEnrollmentModel,
+    webAuthnCredentialModel,
+    keyRing,
+    passwordPolicy,
+    loginThrottle,
+    sessionStore,
+    directoryAuthenticator = null,
+    mfaRequiredRoles = [],
+    emailVerificationPolicy = 'off',
+    accessTokenAudience = null,
+  }) {
     this.superAdminModel = superAdminModel;
     this.adminModel = adminModel;
     this.doctorModel = doctorModel;
     this.patientModel = patientModel;
-    this.jwtSecret = jwtSecret;
-    this.loginAttempts = new Map();
+    this.refreshTokenModel = refreshTokenModel;
+    this.accountStore = accountStore;
+    this.mfaEnrollmentModel = mfaEnrollmentModel;
+    this.webAuthnCredentialModel = webAuthnCredentialModel;
+    this.mfaRequiredRoles = mfaRequiredRoles;
+    this.emailVerificationPolicy = emailVerificationPolicy;
+    this.keyRing = keyRing;
+    this.passwordPolicy = passwordPolicy;
+    this.loginThrottle = loginThrottle;
+    this.sessionStore = sessionStore;
+    this.directoryAuthenticator = directoryAuthenticator;
+    this.accessTokenAudience = accessTokenAudience;
   }
 
   async initializeSuperAdmin(email, password) {
-    const existing = await this.superAdminModel.findOne({ email });
-    if (existing) {
+    if (await this.superAdminModel.findOne()) {
       throw new Error('Super admin already exists');
     }
-    const hashedPassword = await bcrypt.hash(password, 10);
-    const superAdmin = new this.superAdminModel({
+    await this.registerAccount('super_admin', {
       username: 'superadmin',
       email,
-      passwordHash: hashedPassword,
       permissions: this.defaultSuperAdminPermissions(),
+    }, password);
+  }
+
+  // Creates a role profile and the account it signs in with. Self-registration
+  // may add a role to an existing account, but only with that account's
+  // password, so one person can be both a doctor and a patient.
+  async registerAccount(role, profileData, password, { joinExisting = false } = {}) {
+    const email = normalizeEmail(profileData.email);
+    if (!this.validateEmail(email)) {
+      throw new Error('Invalid email format');
+    }
+    const existing = await this.accountStore.findByEmail(email);
+    if (existing && (!joinExisting || this.accountStore.profileIdFor(existing, role))) {
+      throw new Error('User already exists');
+    }
+    if (existing && (!existing.passwordHash || !await bcrypt.compare(password || '', existing.passwordHash))) {
+      throw new Error('An account with this email already exists; register with its current password to add this role');
+    }
+
+    let account = existing;
+    const profile = await this.createProfile(role, profileData, async (profileId) => {
+      if (account) {
+        await this.accountStore.addRole(account._id, role, profileId);
+        return account;
+      }
+      const passwordHash = await this.passwordPolicy.hash(password, {
+        userInputs: [email, profileData.name, profileData.username],
+      });
+      account = await this.accountStore.create({ email, passwordHash, role, profileId });
+      await this.passwordPolicy.remember(account._id, ACCOUNT_HISTORY_ROLE, passwordHash);
+      return account;
+    });
+    return { profile, account, joinedExisting: Boolean(existing) };
+  }
+
+  // An account created on first sign-in through an identity provider. It has
+  // no password and the provider has already verified the address.
+  async registerFederatedAccount(role, profileData) {
+    const email = normalizeEmail(profileData.email);
+    if (!this.validateEmail(email)) {
+      throw new Error('Invalid email format');
+    }
+    if (await this.accountStore.findByEmail(email)) {
+      throw new Error('User already exists');
+    }
+    let account;
+    const profile = await this.createProfile(role, profileData, async (profileId) => {
+      account = await this.accountStore.create({ email, role, profileId, emailVerified: true });
+      return account;
     });
-    await superAdmin.save();
+    return { profile, account };
+  }
+
+  // Adds a role to an account on sign-in through an identity provider that
+  // vouches for it
+  async addFederatedRole(account, role, profileData) {
+    if (this.accountStore.profileIdFor(account, role)) {
+      throw new Error(`This account already has the ${role} role`);
+    }
+    const profile = await this.createProfile(role, { ...profileData, email: account.email }, async (profileId) => {
+      await this.accountStore.addRole(account._id, role, profileId);
+      return account;
+    });
+    account.roles.push({ role, profileId: profile._id });
+    return profile;
+  }
+
+  // Saves a new role profile once attachAccount(profileId) has linked it to an
+  // account; the link is undone when the profile cannot be saved
+  async createProfile(role, profileData, attachAccount) {
+    const model = this.modelForRole(role);
+    const profile = new model({ ...profileData, email: normalizeEmail(profileData.email) });
+    const account = await attachAccount(profile._id);
+    profile.accountId = account._id;
+    try {
+      await profile.save();
+    } catch (error) {
+      await this.accountStore.removeRole(role, profile._id);
+      throw error;
+    }
+    return profile;
+  }
+
+  passwordContext(account) {
+    return {
+      userInputs: [account.email],
+      userId: account._id,
+      role: ACCOUNT_HISTORY_ROLE,
+      currentPasswordHash: account.passwordHash,
+    };
+  }
+
+  // Checks a new password for an existing account against the policy and history
+  async hashNewPassword(account, password) {
+    return this.passwordPolicy.hash(password, this.passwordContext(account));
+  }
+
+  // Stores a hash from hashNewPassword; whoever knew the old password is
+  // signed out everywhere, in every role
+  async replacePassword(account, passwordHash) {
+    account.passwordHash = passwordHash;
+    await account.save();
+    await this.passwordPolicy.remember(account._id, ACCOUNT_HISTORY_ROLE, passwordHash);
+    for (const entry of account.roles) {
+      await this.revokeAllTokensForUser(entry.profileId);
+    }
+    await this.loginThrottle.clearAccount(account.email);
+  }
+
+  async changePassword(claims, currentPassword, newPassword) {
+    const loaded = await this.loadActiveProfile(claims.user_id, claims.role);
+    const account = loaded && loaded.account;
+    if (!account || !currentPassword || !account.passwordHash
+      || !await bcrypt.compare(currentPassword, account.passwordHash)) {
+      throw new Error('Current password is incorrect');
+    }
+    const passwordHash = await this.hashNewPassword(account, newPassword);
+    await this.replacePassword(account, passwordHash);
   }
 
   defaultSuperAdminPermissions() {
@@ -48,141 +198,635 @@ class AuthService {
       'doctor:view',
       'patient:list',
       'patient:view',
+      'care_team:manage',
+      'token:revoke',
+      'lockout:manage',
+      'session:manage',
+      'api_key:manage',
       'system:config',
       'system:metrics',
       'system:logs',
     ];
   }
 
-  async login(email, password) {
-    if (!this.validateEmail(email)) {
+  // Granted directly plus through the admin's roles
+  adminPermissions(admin) {
+    return [...new Set([...(admin.permissions || []), ...(admin.rolePermissions || [])])];
+  }
+
+  // What service accounts (role "service") may be granted: reading the
+  // directory of users, checking other callers' tokens and reporting
+  // appointments, which let doctors see their patients
+  servicePermissions() {
+    return [
+      'doctor:list',
+      'doctor:view',
+      'patient:list',
+      'patient:view',
+      'token:introspect',
+      'care_relationship:sync',
+    ];
+  }
+
+  // context describes the client ({ ip, userAgent }) for throttling and the
+  // session record. An account holding several roles signs in to the one
+  // requested, or to its most privileged role that may currently sign in.
+  async login(email, password, context = {}, requestedRole = null) {
+    const normalized = normalizeEmail(email);
+    if (!this.validateEmail(normalized)) {
       throw new Error('Invalid email format');
     }
-    if (this.isAccountLocked(email)) {
-      throw new Error('Account is locked due to multiple failed login attempts');
+    await this.loginThrottle.assertAllowed(normalized, context.ip);
+
+    const directory = this.directoryAuthenticator && await this.directoryAuthenticator.directoryFor(normalized);
+    if (directory) {
+      return this.directoryLogin(directory, normalized, password, context, requestedRole);
     }
 
-    // Try super admin
-    let user = await this.superAdminModel.findOne({ email });
-    if (user && await bcrypt.compare(password, user.passwordHash)) {
-      this.recordLoginAttempt(email, true);
-      return this.generateToken(user._id, email, 'super_admin', this.defaultSuperAdminPermissions());
+    const account = await this.accountStore.findByEmail(normalized);
+    if (!account || !account.passwordHash || !await bcrypt.compare(password || '', account.passwordHash)) {
+      await this.loginThrottle.recordFailure(normalized, context.ip);
+      throw new Error('Invalid email or password');
     }
 
-    // Try admin
-    user = await this.adminModel.findOne({ email });
-    if (user && await bcrypt.compare(password, user.passwordHash)) {
-      this.recordLoginAttempt(email, true);
-      return this.generateToken(user._id, email, 'admin', user.permissions);
+    const { user, role } = await this.selectProfile(account, requestedRole);
+    await this.accountStore.recordLogin(account._id);
+    return this.completePrimaryLogin(user, role, account, context);
+  }
+
+  // Staff in a domain served by a directory sign in with their directory
+  // password; local password hashes are not consulted. Their directory groups
+  // decide which staff roles they may use and an admin's permissions.
+  async directoryLogin(directory, email, password, context, requestedRole) {
+    let directoryUser;
+    try {
+      directoryUser = await this.directoryAuthenticator.authenticate(directory, email, password);
+    } catch (error) {
+      console.error(`Directory ${directory.slug} is unavailable:`, error.message);
+      throw new Error('The staff directory is unavailable; please try again later');
+    }
+    if (!directoryUser) {
+      await this.loginThrottle.recordFailure(email, context.ip);
+      throw new Error('Invalid email or password');
+    }
+    if (directoryUser.roles.length === 0) {
+      throw new Error('Your directory account has no access to this service');
     }
 
-    // Try doctor
-    user = await this.doctorModel.findOne({ email });
-    if (user && await bcrypt.compare(password, user.passwordHash)) {
-      if (!user.isApproved) {
-        throw new Error('Doctor account not approved');
+    let account = await this.accountStore.findByEmail(email);
+    if (!account) {
+      if (!directoryUser.roles.includes('admin')) {
+        throw new Error('No staff profile exists for this account; ask an administrator to create one');
       }
-      this.recordLoginAttempt(email, true);
-      return this.generateToken(user._id, email, 'doctor', ['doctor:self']);
+      ({ account } = await this.registerFederatedAccount('admin', this.directoryAdminProfile(directory, email, directoryUser)));
+    }
+    await this.applyDirectoryUser(directory, account, email, directoryUser);
+
+    const { user, role } = await this.selectProfile(account, requestedRole, directoryUser.roles);
+    await this.accountStore.recordLogin(account._id);
+    return this.completePrimaryLogin(user, role, account, context);
+  }
+
+  // The admin who set the directory's group mappings counts as the creator,
+  // so directory admins are covered by delegation review
+  directoryAdminProfile(directory, email, directoryUser) {
+    return {
+      email,
+      username: directoryUser.name || email.split('@')[0],
+      permissions: directoryUser.permissions,
+      createdBy: directory.grantedBy,
+      createdByRole: directory.grantedByRole,
+    };
+  }
+
+  // Brings an account in line with what the directory says about it: an
+  // account the directory sync disabled is enabled again, an admin profile is
+  // created or gets the permissions of its groups
+  async applyDirectoryUser(directory, account, email, directoryUser) {
+    if (account.status === 'disabled' && account.disabledReason === 'directory') {
+      account.status = 'active';
+      account.disabledReason = undefined;
+      await account.save();
+    }
+    if (!directoryUser.roles.includes('admin')) {
+      return;
+    }
+    const adminId = this.accountStore.profileIdFor(account, 'admin');
+    if (adminId) {
+      await this.adminModel.updateOne({ _id: adminId }, { permissions: directoryUser.permissions });
+    } else {
+      await this.addFederatedRole(account, 'admin', this.directoryAdminProfile(directory, email, directoryUser));
     }
+  }
 
-    // Try patient
-    user = await this.patientModel.findOne({ email });
-    if (user && await bcrypt.compare(password, user.passwordHash)) {
-      if (!user.isApproved) {
-        throw new Error('Patient account not approved');
+  // allowedRoles narrows the choice for sign-in methods limited to some roles
+  async selectProfile(account, requestedRole = null, allowedRoles = null) {
+    const held = this.accountStore.rolesOf(account)
+      .filter((role) => !requestedRole || role === requestedRole);
+    if (held.length === 0) {
+      throw new Error(`This account has no ${requestedRole} role`);
+    }
+    const roles = held.filter((role) => !allowedRoles || allowedRoles.includes(role));
+    if (roles.length === 0) {
+      throw new Error('This account may not sign in this way');
+    }
+    let lastError = new Error('Invalid email or password');
+    for (const role of roles) {
+      const user = await this.modelForRole(role).findById(this.accountStore.profileIdFor(account, role));
+      if (!user) continue;
+      try {
+        this.assertAccountActive(user, role, account);
+        return { user, role };
+      } catch (error) {
+        lastError = error;
       }
-      this.recordLoginAttempt(email, true);
-      // Include patientId in the token payload
-      return this.generateToken(user._id, email, 'patient', ['patient:self', 'patient:view'], user._id);
     }
+    throw lastError;
+  }
 
-    this.recordLoginAttempt(email, false);
-    throw new Error('Invalid email or password');
+  async secondFactorMethods(userId, role) {
+    const methods = [];
+    if (await this.mfaEnrollmentModel.findOne({ userId: userId.toString(), role, enabled: true })) {
+      methods.push('totp');
+    }
+    if (await this.webAuthnCredentialModel.countDocuments({ userId: userId.toString(), role }) > 0) {
+      methods.push('webauthn');
+    }
+    return methods;
   }
 
-  validateEmail(email) {
-    const emailRegex = /^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$/;
-    return emailRegex.test(email);
+  async hasSecondFactor(userId, role) {
+    return (await this.secondFactorMethods(userId, role)).length > 0;
+  }
+
+  // Changing the second factors of an account that has one needs a session
+  // that was itself verified with one
+  async assertSecondFactorSession(claims) {
+    const verified = (claims.amr || []).some((method) => ['otp', 'hwk'].includes(method));
+    if (!verified && await this.hasSecondFactor(claims.user_id, claims.role)) {
+      throw new Error('Sign in with your second factor first');
+    }
   }
 
-  isAccountLocked(email) {
-    const attempts = this.loginAttempts.get(email) || [];
-    if (attempts.length < 5) return false;
+  // Finishes a password or identity provider login: issues tokens directly,
+  // or an MFA challenge when the user has a second factor or their role
+  // requires one. Failed logins are only forgotten once tokens are issued,
+  // so knowing the password alone does not reset the account's lockout.
+  async completePrimaryLogin(user, role, account, context = {}, amr = ['pwd']) {
+    const methods = await this.secondFactorMethods(user._id, role);
+    if (methods.length === 0 && !this.mfaRequiredRoles.includes(role)) {
+      const tokens = await this.issueTokens(this.identityFor(user, role, account), { amr, context });
+      await this.loginThrottle.recordSuccess(account.email);
+      return tokens;
+    }
+
+    const mfaToken = crypto.randomBytes(32).toString('base64url');
+    await cacheStore.set(`mfa_challenge:${hashToken(mfaToken)}`, JSON.stringify({
+      userId: user._id.toString(),
+      role,
+      // Failed second factors count against the account like wrong passwords
+      email: account.email,
+      amr,
+      expiresAt: Date.now() + MFA_CHALLENGE_TTL_SECONDS * 1000,
+      context,
+    }), MFA_CHALLENGE_TTL_SECONDS);
+    return { mfaRequired: true, mfaToken, methods, enrollmentRequired: methods.length === 0 };
+  }
 
-    const fifteenMinutesAgo = Date.now() - 15 * 60 * 1000;
-    const recentFailures = attempts.slice(-5).filter(a => !a.success && a.timestamp > fifteenMinutesAgo).length;
-    return recentFailures >= 5;
+  async getMfaChallenge(mfaToken) {
+    const stored = mfaToken ? await cacheStore.get(`mfa_challenge:${hashToken(mfaToken)}`) : null;
+    if (!stored) {
+      throw new Error('Invalid or expired MFA challenge');
+    }
+    return JSON.parse(stored);
   }
 
-  recordLoginAttempt(email, success) {
-    const attempts = this.loginAttempts.get(email) || [];
-    if (attempts.length >= 10) {
-      attempts.shift();
+  // Loads a challenge to check a second factor against. Each check uses up
+  // one of the challenge's attempts before it runs, so parallel guesses
+  // cannot exceed the limit, and none run while the account is locked out.
+  async beginMfaAttempt(mfaToken) {
+    const challenge = await this.getMfaChallenge(mfaToken);
+    await this.loginThrottle.assertAllowed(challenge.email, challenge.context && challenge.context.ip);
+    const key = hashToken(mfaToken);
+    const ttl = Math.ceil((challenge.expiresAt - Date.now()) / 1000);
+    const attempts = await cacheStore.incr(`mfa_attempts:${key}`, ttl);
+    if (attempts > MFA_CHALLENGE_MAX_ATTEMPTS || ttl <= 0) {
+      await cacheStore.del(`mfa_challenge:${key}`);
+      throw new Error('Invalid or expired MFA challenge');
     }
-    attempts.push({ email, timestamp: Date.now(), success });
-    this.loginAttempts.set(email, attempts);
+    return challenge;
   }
 
-  generateToken(userId, email, role, permissions, patientId = null) {
-    let expiration;
+  async recordMfaChallengeFailure(challenge) {
+    await this.loginThrottle.recordFailure(challenge.email, challenge.context && challenge.context.ip);
+  }
+
+  // factor is the amr value of the second factor: "otp" or "hwk". The
+  // challenge is consumed first, so it completes at most once.
+  async completeMfaChallenge(mfaToken, challenge, factor = 'otp') {
+    if (!await cacheStore.take(`mfa_challenge:${hashToken(mfaToken)}`)) {
+      throw new Error('Invalid or expired MFA challenge');
+    }
+    const amr = [...(challenge.amr || ['pwd']), factor];
+    return this.loginWithVerifiedCredential(challenge.userId, challenge.role, amr, challenge.context);
+  }
+
+  // Issues tokens for a user whose credentials were checked elsewhere
+  // (second factor, passkey); the login is complete, so its failed attempts
+  // are forgotten
+  async loginWithVerifiedCredential(userId, role, amr, context = {}) {
+    const loaded = await this.loadActiveProfile(userId, role);
+    if (!loaded) {
+      throw new Error('Invalid credentials');
+    }
+    const tokens = await this.issueTokens(this.identityFor(loaded.user, role, loaded.account), { amr, context });
+    await this.loginThrottle.recordSuccess(loaded.account.email);
+    return tokens;
+  }
+
+  // Loads a role profile with its account. Returns null when either is gone
+  // and throws when the account may not sign in in that role.
+  async loadActiveProfile(userId, role) {
+    const model = this.modelForRole(role);
+    const user = model ? await model.findById(userId) : null;
+    const account = user ? await this.accountStore.forProfile(user) : null;
+    if (!user || !account) {
+      return null;
+    }
+    this.assertAccountActive(user, role, account);
+    return { user, account };
+  }
+
+  validateEmail(email) {
+    const emailRegex = /^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$/;
+    return emailRegex.test(email);
+  }
+
+  modelForRole(role) {
+    switch (role) {
+      case 'super_admin':
+        return this.superAdminModel;
+      case 'admin':
+        return this.adminModel;
+      case 'doctor':
+        return this.doctorModel;
+      case 'patient':
+        return this.patientModel;
+      default:
+        return null;
+    }
+  }
+
+  // Claims carried by access tokens issued to a user of the given role.
+  // Only self-registered accounts have to prove they own their address.
+  identityFor(user, role, account) {
+    const identity = {
+      userId: user._id,
+      accountId: account._id,
+      email: account.email,
+      role,
+      emailVerified: ['doctor', 'patient'].includes(role) ? Boolean(account.emailVerified) : true,
+    };
     switch (role) {
       case 'super_admin':
-        expiration = '24h';
+        identity.permissions = this.defaultSuperAdminPermissions();
         break;
       case 'admin':
-        expiration = '12h';
+        identity.permissions = this.adminPermissions(user);
         break;
       case 'doctor':
-        expiration = '8h';
+        // The patient policies limit these to the doctor's own patients
+        identity.permissions = ['doctor:self', 'patient:list', 'patient:view', 'patient:history', 'break_glass:request'];
         break;
       case 'patient':
-        expiration = '4h';
+        identity.permissions = ['patient:self', 'patient:view'];
+        // Include patientId in the token payload
+        identity.patientId = user._id;
         break;
       default:
-        expiration = '1h';
+        identity.permissions = [];
+    }
+    return identity;
+  }
+
+  // Under the "phi" policy unverified accounts can sign in but not read PHI
+  blocksUnverifiedEmail(claims) {
+    return this.emailVerificationPolicy === 'phi' && claims.email_verified === false;
+  }
+
+  assertAccountActive(user, role, account) {
+    if (account.status === 'disabled') {
+      throw new Error('Account is disabled');
+    }
+    if (role === 'doctor' && !user.isApproved) {
+      throw new Error('Doctor account not approved');
     }
+    if (role === 'patient' && !user.isApproved) {
+      throw new Error('Patient account not approved');
+    }
+  }
 
+  // How long a login stays usable through refresh token rotation
+  sessionLifetimeSeconds(role) {
+    switch (role) {
+      case 'super_admin':
+        return 24 * 60 * 60;
+      case 'admin':
+        return 12 * 60 * 60;
+      case 'doctor':
+        return 8 * 60 * 60;
+      case 'patient':
+        return 4 * 60 * 60;
+      default:
+        return 60 * 60;
+    }
+  }
+
+  async generateToken(userId, email, role, permissions, patientId = null, sessionId = null, extraClaims = {}, expiresIn = ACCESS_TOKEN_LIFETIME_SECONDS) {
     const payload = {
+      ...extraClaims,
       user_id: userId.toString(),
       email,
       role,
-      permissions,
+      // Wildcards and implications keep the token small; Principal.can and
+      // the services using the published rules read them back the same way
+      permissions: permissionRules.compress(permissions),
+      // iat only has whole seconds; revokeAllTokensForUser needs to tell a
+      // token issued just after a revocation from one issued just before
+      iat_ms: Date.now(),
     };
+    if (this.accessTokenAudience) {
+      payload.aud = this.accessTokenAudience;
+    }
 
     if (patientId) {
       payload.patientId = patientId.toString();
     }
+    if (sessionId) {
+      payload.sid = sessionId;
+    }
+
+    const key = await this.keyRing.getSigningKey();
+    return jwt.sign(payload, {
+      privateKey: key.privateKey,
+      alg: key.alg,
+      kid: key.kid,
+      expiresIn,
+      jwtid: crypto.randomUUID(),
+      typ: ACCESS_TOKEN_TYPE,
+    });
+  }
+
+  // Access tokens only: ID tokens and tokens meant for another audience fail
+  async verifySignature(token, options = {}) {
+    const claims = await jwt.verify(
+      token,
+      (header) => this.keyRing.getVerificationKey(header.kid),
+      { ...options, typ: ACCESS_TOKEN_TYPE },
+    );
+    if (this.accessTokenAudience && ![].concat(claims.aud || []).includes(this.accessTokenAudience)) {
+      throw new Error('invalid audience');
+    }
+    return claims;
+  }
+
+  // Issues an access token plus an opaque refresh token. Refresh tokens of one
+  // login share a family that expires with the session, so rotation never
+  // extends a session past its role's lifetime. Tokens issued to an OAuth
+  // client carry its client_id and granted scope through every rotation.
+  // A new family starts a session record describing the client. authTime is
+  // when the user actually signed in, kept as auth_time across rotations.
+  async issueTokens(identity, {
+    familyId = null,
+    familyExpiresAt = null,
+    clientId = null,
+    scope = null,
+    amr = null,
+    authTime = null,
+    context = {},
+  } = {}) {
+    const family = familyId || crypto.randomUUID();
+    const authenticatedAt = authTime || Math.floor(Date.now() / 1000);
+    const expiresAt = familyExpiresAt
+      || new Date(Date.now() + this.sessionLifetimeSeconds(identity.role) * 1000);
+    if (!familyId) {
+      await this.sessionStore.create({
+        sessionId: family,
+        userId: identity.userId,
+        role: identity.role,
+        clientId,
+        amr,
+        expiresAt,
+        context,
+      });
+    }
+
+    const refreshToken = crypto.randomBytes(32).toString('base64url');
+    await this.refreshTokenModel.create({
+      tokenHash: hashToken(refreshToken),
+      familyId: family,
+      userId: identity.userId.toString(),
+      role: identity.role,
+      clientId,
+      scope,
+      amr,
+      authTime: authenticatedAt,
+      expiresAt,
+    });
+
+    const extraClaims = { email_verified: identity.emailVerified !== false, auth_time: authenticatedAt };
+    if (identity.accountId) extraClaims.account_id = identity.accountId.toString();
+    if (clientId) extraClaims.client_id = clientId;
+    if (scope) extraClaims.scope = scope;
+    if (amr) extraClaims.amr = amr;
+
+    const token = await this.generateToken(
+      identity.userId,
+      identity.email,
+      identity.role,
+      this.scopedPermissions(identity.permissions, scope),
+      identity.patientId,
+      family,
+      extraClaims,
+    );
+    return { token, refreshToken, expiresIn: ACCESS_TOKEN_LIFETIME_SECONDS };
+  }
 
-    return jwt.sign(payload, this.jwtSecret, { expiresIn: expiration });
+  // Tokens issued to OAuth clients carry only the permissions the user both
+  // holds and granted the client as scopes; other scopes grant nothing
+  scopedPermissions(held, scope) {
+    if (!scope) return held;
+    return [...new Set(scope.split(' '))]
+      .filter((name) => permissionRules.isValidGrant(name) && permissionRules.holds(held, name));
   }
 
-  async refreshToken(token) {
+  async validateToken(token) {
+    let decoded;
     try {
-      const decoded = jwt.verify(token, this.jwtSecret);
-      return this.generateToken(decoded.user_id, decoded.email, decoded.role, decoded.permissions);
+      decoded = await this.verifySignature(token);
     } catch (error) {
-      throw new Error('Invalid token');
+      throw new Error('Invalid or expired token');
     }
+    if (await this.isTokenRevoked(decoded)) {
+      throw new Error('Token has been revoked');
+    }
+    if (decoded.sid && !await this.sessionStore.touch(decoded.sid)) {
+      throw new Error('Session has expired');
+    }
+    // Admin permissions are managed as data; a change applies from the next
+    // request rather than when the token expires
+    if (decoded.role === 'admin') {
+      const admin = await this.adminModel.findById(decoded.user_id);
+      if (!admin) {
+        throw new Error('User no longer exists');
+      }
+      decoded.permissions = this.scopedPermissions(this.adminPermissions(admin), decoded.scope);
+    }
+    return decoded;
+  }
+
+  async isTokenRevoked(claims) {
+    // Tokens issued before jti was introduced cannot be revoked individually
+    if (!claims.jti) return true;
+
+    if (await cacheStore.get(`revoked_jti:${claims.jti}`)) {
+      return true;
+    }
+    if (claims.sid && await cacheStore.get(`revoked_family:${claims.sid}`)) {
+      return true;
+    }
+    // Signing a support user out everywhere also ends their impersonations
+    const userIds = claims.act ? [claims.user_id, claims.act.sub] : [claims.user_id];
+    for (const userId of userIds) {
+      const revokedBefore = await cacheStore.get(`revoked_user:${userId}`);
+      const issuedAt = claims.iat_ms || claims.iat * 1000;
+      if (revokedBefore !== null && issuedAt <= parseInt(revokedBefore, 10)) {
+        return true;
+      }
+    }
+    return false;
+  }
+
+  async refreshToken(refreshToken, clientId = null, context = {}) {
+    if (!refreshToken) {
+      throw new Error('Refresh token is required');
+    }
+    const record = await this.refreshTokenModel.findOne({ tokenHash: hashToken(refreshToken) });
+    if (!record || record.revokedAt) {
+      throw new Error('Invalid refresh token');
+    }
+    // Only the client a token was issued to may redeem it
+    if ((record.clientId || null) !== clientId) {
+      throw new Error('Invalid refresh token');
+    }
+    if (record.expiresAt <= new Date()) {
+      throw new Error('Refresh token expired');
+    }
+    if (!await this.sessionStore.touch(record.familyId, context, { force: true })) {
+      await this.revokeTokenFamily(record.familyId);
+      throw new Error('Session has expired');
+    }
+
+    // Mark the token used; losing this race means it was replayed
+    const rotated = await this.refreshTokenModel.findOneAndUpdate(
+      { _id: record._id, rotatedAt: null, revokedAt: null },
+      { rotatedAt: new Date() },
+    );
+    if (!rotated) {
+      await this.revokeTokenFamily(record.familyId);
+      throw new Error('Refresh token reuse detected, session revoked');
+    }
+
+    const loaded = await this.loadActiveProfile(record.userId, record.role);
+    if (!loaded) {
+      await this.revokeTokenFamily(record.familyId);
+      throw new Error('Invalid refresh token');
+    }
+
+    return this.issueTokens(this.identityFor(loaded.user, record.role, loaded.account), {
+      familyId: record.familyId,
+      familyExpiresAt: record.expiresAt,
+      clientId: record.clientId,
+      scope: record.scope,
+      amr: record.amr && record.amr.length > 0 ? record.amr : null,
+      authTime: record.authTime,
+    });
+  }
+
+  async revokeTokenFamily(familyId) {
+    await this.refreshTokenModel.updateMany(
+      { familyId, revokedAt: null },
+      { revokedAt: new Date() },
+    );
+    await cacheStore.set(`revoked_family:${familyId}`, 'revoked', ACCESS_TOKEN_LIFETIME_SECONDS);
+    await this.sessionStore.markRevoked({ sessionId: familyId });
+  }
+
+  async revokeRefreshToken(refreshToken) {
+    const record = await this.refreshTokenModel.findOne({ tokenHash: hashToken(refreshToken) });
+    if (!record) {
+      throw new Error('Failed to revoke token: Invalid refresh token');
+    }
+    await this.revokeTokenFamily(record.familyId);
   }
 
   async revokeToken(token) {
+    let decoded;
     try {
-      // Decode token to get expiration time
-      const decoded = jwt.decode(token);
-      if (!decoded || !decoded.exp) {
-        throw new Error('Invalid token');
-      }
-      const now = Math.floor(Date.now() / 1000);
-      const ttl = decoded.exp - now;
-      if (ttl <= 0) {
-        // Token already expired
-        return;
-      }
-      // Store the token in Redis blacklist with TTL
-      await setAsync(`blacklist_${token}`, 'revoked', 'EX', ttl);
+      decoded = await this.verifySignature(token, { ignoreExpiration: true });
     } catch (error) {
-      throw new Error('Failed to revoke token: ' + error.message);
+      throw new Error('Failed to revoke token: Invalid token');
+    }
+    if (!decoded.jti || !decoded.exp) {
+      throw new Error('Failed to revoke token: Token has no jti');
+    }
+    const ttl = decoded.exp - Math.floor(Date.now() / 1000);
+    if (ttl <= 0) {
+      // Token already expired
+      return;
+    }
+    await cacheStore.set(`revoked_jti:${decoded.jti}`, 'revoked', ttl);
+  }
+
+  async revokeAllTokensForUser(userId) {
+    await this.refreshTokenModel.updateMany(
+      { userId: userId.toString(), revokedAt: null },
+      { revokedAt: new Date() },
+    );
+    // Every access token for this user issued up to now is rejected by
+    // isTokenRevoked; milliseconds so a sign-in right after still works
+    await cacheStore.set(`revoked_user:${userId}`, Date.now(), ACCESS_TOKEN_LIFETIME_SECONDS);
+    await this.sessionStore.markRevoked({ userId: userId.toString() });
+  }
+
+  async listSessions(userId, currentSessionId = null) {
+    const sessions = await this.sessionStore.listActive(userId);
+    return sessions.map((session) => this.sessionStore.describe(session, currentSessionId));
+  }
+
+  async getSession(userId, sessionId, currentSessionId = null) {
+    const session = await this.sessionStore.find(userId, sessionId);
+    if (!session) {
+      throw new Error('Session not found');
+    }
+    return this.sessionStore.describe(session, currentSessionId);
+  }
+
+  // Ends one session of the given user: its refresh tokens and access tokens stop working
+  async revokeSession(userId, sessionId) {
+    const session = await this.sessionStore.find(userId, sessionId);
+    if (!session) {
+      throw new Error('Session not found');
+    }
+    await this.revokeTokenFamily(session.sessionId);
+  }
+
+  async revokeOtherSessions(userId, currentSessionId) {
+    const sessions = await this.sessionStore.listActive(userId);
+    for (const session of sessions) {
+      if (session.sessionId !== currentSessionId) {
+        await this.revokeTokenFamily(session.sessionId);
+      }
     }
   }
 }
@@ -3,16 +3,80 @@ const SuperAdmin = require('../models/SuperAdmin');
 const Admin = require('../models/Admin');
 const Doctor = require('../models/Doctor');
 const Patient = require('../models/Patient');
+const RefreshToken = require('../models/RefreshToken');
+const Account = require('../models/Account');
+const PasswordHistory = require('../models/PasswordHistory');
+const SigningKey = require('../models/SigningKey');
+const MfaEnrollment = require('../models/MfaEnrollment');
+const WebAuthnCredential = require('../models/WebAuthnCredential');
+const LoginThrottleModel = require('../models/LoginThrottle');
+const Session = require('../models/Session');
+const AccountStore = require('./accountStore');
+const KeyRing = require('./keyRing');
+const LoginThrottle = require('./loginThrottle');
+const SessionStore = require('./sessionStore');
+const ldapDirectoryService = require('./ldapDirectoryService');
+const cacheStore = require('../utils/cacheStore');
+const passwordPolicy = require('./passwordPolicy');
 const env = require('../config/env');
 
 env.loadEnv();
 
+const keyRing = new KeyRing({
+  signingKeyModel: SigningKey,
+  alg: env.JWT_SIGNING_ALG,
+  rotationIntervalSeconds: env.JWT_KEY_ROTATION_DAYS * 24 * 60 * 60,
+  // Publish new keys a day ahead so verifiers caching the JWKS pick them up
+  publishLeadSeconds: 24 * 60 * 60,
+  // Retired keys stay published well past the longest token lifetime
+  verifyOverlapSeconds: 2 * 24 * 60 * 60,
+  encryptionSecret: env.JWT_SECRET,
+});
+
+const loginThrottle = new LoginThrottle({
+  model: LoginThrottleModel,
+  accountThreshold: env.LOGIN_ACCOUNT_FAILURE_THRESHOLD,
+  // Shared NATs and proxies get more room before an IP is slowed down
+  ipThreshold: env.LOGIN_IP_FAILURE_THRESHOLD,
+  baseLockSeconds: env.LOGIN_LOCK_BASE_SECONDS,
+  maxLockSeconds: env.LOGIN_LOCK_MAX_SECONDS,
+  windowSeconds: env.LOGIN_FAILURE_WINDOW_MINUTES * 60,
+});
+
+const accountStore = new AccountStore({
+  accountModel: Account,
+  profileModels: {
+    super_admin: SuperAdmin,
+    admin: Admin,
+    doctor: Doctor,
+    patient: Patient,
+  },
+  passwordHistoryModel: PasswordHistory,
+});
+
+const sessionStore = new SessionStore({
+  model: Session,
+  cacheStore,
+  idleTimeoutSeconds: env.SESSION_IDLE_TIMEOUT_MINUTES * 60,
+});
+
 const authServiceInstance = new AuthService({
   superAdminModel: SuperAdmin,
   adminModel: Admin,
   doctorModel: Doctor,
   patientModel: Patient,
-  jwtSecret: env.JWT_SECRET || 'default_jwt_secret',
+  refreshTokenModel: RefreshToken,
+  accountStore,
+  mfaEnrollmentModel: MfaEnrollment,
+  webAuthnCredentialModel: WebAuthnCredential,
+  keyRing,
+  passwordPolicy,
+  loginThrottle,
+  sessionStore,
+  directoryAuthenticator: ldapDirectoryService,
+  mfaRequiredRoles: env.MFA_REQUIRED_ROLES,
+  emailVerificationPolicy: env.EMAIL_VERIFICATION_POLICY,
+  accessTokenAudience: env.ACCESS_TOKEN_AUDIENCE,
 });
 
 // Override admin permissions to use defaultAdminPermissions
@@ -0,0 +1,290 @@
+const { ObjectId } = require('mongoose').Types;
+const BreakGlassGrant = require('../models/BreakGlassGrant');
+const Admin = require('../models/Admin');
+const SuperAdmin = require('../models/SuperAdmin');
+const Patient = require('../models/Patient');
+const authService = require('./authServiceInstance');
+const auditLog = require('./auditLog');
+const mailer = require('../utils/mailer');
+const permissionRules = require('../utils/permissionRules');
+const { PermissionBreakGlassReview } = require('../utils/permissions');
+const env = require('../config/env');
+
+const CLINICIAN_ROLES = ['doctor'];
+// Long enough that "emergency" alone is not accepted
+const MIN_JUSTIFICATION_LENGTH = 20;
+const MAX_JUSTIFICATION_LENGTH = 1000;
+const MAX_NOTE_LENGTH = 1000;
+const REVIEW_DECISIONS = ['accepted', 'flagged'];
+const MAX_PAGE_SIZE = 200;
+
+const actorOf = (claims) => ({ userId: claims.user_id, role: claims.role, email: claims.email });
+
+// Emergency access for a clinician who has no care relationship with a
+// patient. The clinician states why and gets a grant for that one patient
+// that lapses after a few hours at most; the patient policies honour it
+// (see policyEngine). Granting, every request made under the grant and its
+// end all go to the audit log, compliance officers are emailed when a grant
+// is made, and each grant waits in a review queue until one of them accepts
+// or flags it.
+class BreakGlassService {
+  validateJustification(justification) {
+    const trimmed = typeof justification === 'string' ? justification.trim() : '';
+    if (trimmed.length < MIN_JUSTIFICATION_LENGTH) {
+      throw new Error(`A justification of at least ${MIN_JUSTIFICATION_LENGTH} characters is required`);
+    }
+    if (trimmed.length > MAX_JUSTIFICATION_LENGTH) {
+      throw new Error(`Justification must be at most ${MAX_JUSTIFICATION_LENGTH} characters`);
+    }
+    return trimmed;
+  }
+
+  lifetimeMinutes(durationMinutes) {
+    const minutes = durationMinutes === undefined ? env.BREAK_GLASS_DEFAULT_MINUTES : Number(durationMinutes);
+    if (!Number.isInteger(minutes) || minutes < 1 || minutes > env.BREAK_GLASS_MAX_MINUTES) {
+      throw new Error(`Break-glass access lasts between 1 and ${env.BREAK_GLASS_MAX_MINUTES} minutes`);
+    }
+    return minutes;
+  }
+
+  async findActive(userId, patientId) {
+    if (!ObjectId.isValid(userId) || !ObjectId.isValid(patientId)) {
+      return null;
+    }
+    return BreakGlassGrant.findOne({ userId, patientId, endedAt: null, expiresAt: { $gt: new Date() } });
+  }
+
+  toPublic(grant) {
+    return {
+      id: grant._id,
+      userId: grant.userId,
+      role: grant.role,
+      email: grant.email,
+      patientId: grant.patientId,
+      justification: grant.justification,
+      expiresAt: grant.expiresAt,
+      endedAt: grant.endedAt,
+      active: !grant.endedAt && grant.expiresAt > new Date(),
+      accessCount: grant.accessCount || 0,
+      lastAccessAt: grant.lastAccessAt,
+      review: {
+        status: grant.review.status,
+        reviewerId: grant.review.reviewerId,
+        reviewerEmail: grant.review.reviewerEmail,
+        note: grant.review.note,
+        reviewedAt: grant.review.reviewedAt,
+      },
+      createdAt: grant.createdAt,
+    };
+  }
+
+  async request(claims, patientId, { justification, durationMinutes } = {}, context = {}) {
+    if (!CLINICIAN_ROLES.includes(claims.role)) {
+      throw new Error('Only clinicians can request break-glass access');
+    }
+    const reason = this.validateJustification(justification);
+    const minutes = this.lifetimeMinutes(durationMinutes);
+    await authService.assertSecondFactorSession(claims);
+
+    const patient = ObjectId.isValid(patientId) ? await Patient.findById(patientId) : null;
+    if (!patient) {
+      throw new Error('Patient not found');
+    }
+    if (await this.findActive(claims.user_id, patient._id)) {
+      throw new Error('You already have break-glass access to this patient');
+    }
+    await this.closeLapsed(claims.user_id, patient._id);
+
+    let grant;
+    try {
+      grant = await BreakGlassGrant.create({
+        userId: claims.user_id,
+        role: claims.role,
+        email: claims.email,
+        patientId: patient._id,
+        justification: reason,
+        expiresAt: new Date(Date.now() + minutes * 60 * 1000),
+        endedAt: null,
+        review: { status: 'pending' },
+      });
+    } catch (error) {
+      // A concurrent request opened one first
+      if (error.code !== 11000) throw error;
+      throw new Error('You already have break-glass access to this patient');
+    }
+    await auditLog.record({
+      action: 'break_glass.grant',
+      actor: actorOf(claims),
+      subject: { userId: patient._id, role: 'patient' },
+      sessionId: claims.sid,
+      reason,
+      context,
+    });
+    await this.notifyCompliance(grant);
+    return this.toPublic(grant);
+  }
+
+  // Grants that lapsed without being ended still count as open for the
+  // unique index; they end when they expired
+  async closeLapsed(userId, patientId) {
+    const lapsed = await BreakGlassGrant.find({ userId, patientId, endedAt: null, expiresAt: { $lte: new Date() } });
+    for (const grant of lapsed) {
+      await BreakGlassGrant.updateOne({ _id: grant._id, endedAt: null }, { endedAt: grant.expiresAt });
+    }
+  }
+
+  // Ends the caller's own grant before it lapses
+  async end(claims, patientId, context = {}) {
+    const grant = await this.findActive(claims.user_id, patientId);
+    if (!grant) {
+      throw new Error('No active break-glass access to this patient');
+    }
+    grant.endedAt = new Date();
+    await grant.save();
+    await auditLog.record({
+      action: 'break_glass.end',
+      actor: actorOf(claims),
+      subject: { userId: grant.patientId, role: 'patient' },
+      sessionId: claims.sid,
+      context,
+    });
+    return this.toPublic(grant);
+  }
+
+  // Called for each request a policy allowed through a break-glass grant
+  async recordAccess(principal, grantId, { method, path }, context = {}) {
+    const grant = await BreakGlassGrant.findByIdAndUpdate(
+      grantId,
+      { $inc: { accessCount: 1 }, $set: { lastAccessAt: new Date() } },
+      { new: true },
+    );
+    if (!grant) return;
+    await auditLog.record({
+      action: 'break_glass.access',
+      actor: actorOf(principal),
+      subject: { userId: grant.patientId, role: 'patient' },
+      sessionId: principal.sid,
+      reason: grant.justification,
+      method,
+      path,
+      context,
+    });
+  }
+
+  // BREAK_GLASS_NOTIFY_EMAILS when set; otherwise the admins who can review
+  // grants, or the super admins when no admin can
+  async complianceRecipients() {
+    if (env.BREAK_GLASS_NOTIFY_EMAILS.length > 0) {
+      return env.BREAK_GLASS_NOTIFY_EMAILS;
+    }
+    const reviewers = (await Admin.find())
+      .filter((admin) => permissionRules.holds(authService.adminPermissions(admin), PermissionBreakGlassReview));
+    const recipients = reviewers.length > 0 ? reviewers : await SuperAdmin.find();
+    return [...new Set(recipients.map((user) => user.email))];
+  }
+
+  async notifyCompliance(grant) {
+    const recipients = await this.complianceRecipients();
+    if (recipients.length === 0) {
+      console.error(`No compliance officer to notify of break-glass grant ${grant._id}`);
+      return;
+    }
+    try {
+      await mailer.send({
+        to: recipients,
+        subject: 'Break-glass access to a patient record',
+        text: [
+          `${grant.email || grant.userId} (${grant.role}) used break-glass access to the records of patient ${grant.patientId}.`,
+          '',
+          `Justification: ${grant.justification}`,
+          `Granted: ${grant.createdAt.toISOString()}`,
+          `Expires: ${grant.expiresAt.toISOString()}`,
+          '',
+          `Please review grant ${grant._id} in the break-glass review queue and accept or flag it.`,
+        ].join('\n'),
+      });
+    } catch (error) {
+      console.error(`Failed to send break-glass notification for grant ${grant._id}: ${error.message}`);
+    }
+  }
+
+  // The review queue: newest first, pending ones by default
+  async listGrants({ status = 'pending', userId, patientId, before, limit } = {}) {
+    const filter = {};
+    if (status !== 'all') {
+      if (![...REVIEW_DECISIONS, 'pending'].includes(status)) {
+        throw new Error('status must be one of pending, accepted, flagged, all');
+      }
+      filter['review.status'] = status;
+    }
+    if (userId) filter.userId = userId;
+    if (patientId) filter.patientId = patientId;
+    if (before) {
+      const date = new Date(before);
+      if (Number.isNaN(date.getTime())) {
+        throw new Error('before must be a date');
+      }
+      filter.createdAt = { $lt: date };
+    }
+    const pageSize = Math.min(parseInt(limit, 10) || 50, MAX_PAGE_SIZE);
+    const grants = await BreakGlassGrant.find(filter).sort({ createdAt: -1 }).limit(pageSize);
+    return grants.map((grant) => this.toPublic(grant));
+  }
+
+  async loadGrant(grantId) {
+    const grant = ObjectId.isValid(grantId) ? await BreakGlassGrant.findById(grantId) : null;
+    if (!grant) {
+      throw new Error('Break-glass grant not found');
+    }
+    return grant;
+  }
+
+  // A grant with the audit events recorded under it, for the reviewer
+  async getGrant(grantId) {
+    const grant = await this.loadGrant(grantId);
+    const events = await auditLog.list({
+      actorId: grant.userId.toString(),
+      subjectId: grant.patientId.toString(),
+      limit: MAX_PAGE_SIZE,
+    });
+    const until = grant.endedAt || grant.expiresAt;
+    return {
+      ...this.toPublic(grant),
+      events: events
+        .filter((event) => event.action.startsWith('break_glass.'))
+        .filter((event) => event.createdAt >= grant.createdAt && (event.action === 'break_glass.end' || event.createdAt <= until)),
+    };
+  }
+
+  // A decision can be revised; every decision is audited
+  async review(claims, grantId, { decision, note } = {}, context = {}) {
+    if (!REVIEW_DECISIONS.includes(decision)) {
+      throw new Error('decision must be one of accepted, flagged');
+    }
+    if (note !== undefined && (typeof note !== 'string' || note.length > MAX_NOTE_LENGTH)) {
+      throw new Error(`Note must be text of at most ${MAX_NOTE_LENGTH} characters`);
+    }
+    const grant = await this.loadGrant(grantId);
+    if (grant.userId.toString() === claims.user_id.toString()) {
+      throw new Error('You cannot review your own break-glass access');
+    }
+    grant.review = {
+      status: decision,
+      reviewerId: claims.user_id.toString(),
+      reviewerEmail: claims.email,
+      note: note ? note.trim() : undefined,
+      reviewedAt: new Date(),
+    };
+    await grant.save();
+    await auditLog.record({
+      action: `break_glass.${decision}`,
+      actor: actorOf(claims),
+      subject: { userId: grant.userId, role: grant.role },
+      reason: note ? note.trim() : undefined,
+      context,
+    });
+    return this.toPublic(grant);
+  }
+}
+
+module.exports = new BreakGlassService();
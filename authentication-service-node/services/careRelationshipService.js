@@ -0,0 +1,128 @@
+const { ObjectId } = require('mongoose').Types;
+const CareRelationship = require('../models/CareRelationship');
+const Doctor = require('../models/Doctor');
+const Patient = require('../models/Patient');
+const env = require('../config/env');
+
+const MAX_NOTE_LENGTH = 500;
+const DAY_MS = 24 * 60 * 60 * 1000;
+// Only appointments the doctor has taken on open the patient's records
+const LINKING_STATUSES = ['confirmed', 'completed'];
+const UNLINKING_STATUSES = ['pending', 'cancelled'];
+
+const activeFilter = (now = new Date()) => ({
+  $or: [{ expiresAt: null }, { expiresAt: { $gt: now } }],
+});
+
+// Links between doctors and the patients they treat, which the patient
+// policies check before a doctor may read a record
+class CareRelationshipService {
+  async findActive(doctorId, patientId) {
+    if (!ObjectId.isValid(doctorId) || !ObjectId.isValid(patientId)) {
+      return null;
+    }
+    return CareRelationship.findOne({ doctorId, patientId, ...activeFilter() });
+  }
+
+  async patientIdsFor(doctorId) {
+    const links = await CareRelationship.find({ doctorId, ...activeFilter() });
+    return [...new Set(links.map((link) => link.patientId.toString()))];
+  }
+
+  async listForPatient(patientId) {
+    const links = await CareRelationship.find({ patientId, ...activeFilter() }).sort({ createdAt: 1 });
+    return links.map((link) => this.toPublic(link));
+  }
+
+  toPublic(link) {
+    return {
+      id: link._id,
+      doctorId: link.doctorId,
+      patientId: link.patientId,
+      source: link.source,
+      appointmentId: link.appointmentId,
+      note: link.note,
+      expiresAt: link.expiresAt,
+      createdBy: link.createdBy,
+      createdAt: link.createdAt,
+    };
+  }
+
+  async assertParticipants(doctorId, patientId) {
+    const patient = ObjectId.isValid(patientId) ? await Patient.findById(patientId) : null;
+    if (!patient) throw new Error('Patient not found');
+    const doctor = ObjectId.isValid(doctorId) ? await Doctor.findById(doctorId) : null;
+    if (!doctor || !doctor.isApproved) throw new Error('Doctor not found');
+  }
+
+  parseExpiry(expiresAt) {
+    if (expiresAt === undefined || expiresAt === null) {
+      return null;
+    }
+    const date = new Date(expiresAt);
+    if (Number.isNaN(date.getTime()) || date <= new Date()) {
+      throw new Error('expiresAt must be a future date');
+    }
+    return date;
+  }
+
+  // Adding a doctor who is already on the team updates the link
+  async addCareTeamMember(actorId, patientId, { doctorId, expiresAt, note } = {}) {
+    await this.assertParticipants(doctorId, patientId);
+    if (note !== undefined && (typeof note !== 'string' || note.length > MAX_NOTE_LENGTH)) {
+      throw new Error(`note must be at most ${MAX_NOTE_LENGTH} characters`);
+    }
+    const link = await CareRelationship.findOneAndUpdate(
+      { doctorId, patientId, source: 'care_team' },
+      { expiresAt: this.parseExpiry(expiresAt), note, createdBy: actorId.toString() },
+      { upsert: true, new: true },
+    );
+    return this.toPublic(link);
+  }
+
+  async removeCareTeamMember(patientId, doctorId) {
+    const removed = ObjectId.isValid(doctorId) && ObjectId.isValid(patientId)
+      ? await CareRelationship.findOneAndDelete({ doctorId, patientId, source: 'care_team' })
+      : null;
+    if (!removed) throw new Error('Care team link not found');
+  }
+
+  // Called by the appointment service whenever an appointment changes or
+  // goes away. Confirmed and completed appointments grant access until some
+  // days after they end, but never for longer than
+  // CARE_RELATIONSHIP_MAX_DAYS from now, whatever end time is reported;
+  // pending and cancelled ones grant nothing.
+  async syncAppointment(serviceId, patientId, appointmentId, { doctorId, status, endTime } = {}) {
+    if (typeof appointmentId !== 'string' || !appointmentId) {
+      throw new Error('appointmentId is required');
+    }
+    if (UNLINKING_STATUSES.includes(status)) {
+      await CareRelationship.deleteOne({ appointmentId, source: 'appointment' });
+      return null;
+    }
+    if (!LINKING_STATUSES.includes(status)) {
+      throw new Error(`status must be one of ${[...LINKING_STATUSES, ...UNLINKING_STATUSES].join(', ')}`);
+    }
+    await this.assertParticipants(doctorId, patientId);
+    const end = new Date(endTime);
+    if (Number.isNaN(end.getTime())) {
+      throw new Error('endTime must be a date');
+    }
+    const link = await CareRelationship.findOneAndUpdate(
+      { appointmentId, source: 'appointment' },
+      {
+        doctorId,
+        patientId,
+        expiresAt: new Date(Math.min(
+          end.getTime() + env.CARE_RELATIONSHIP_APPOINTMENT_DAYS * DAY_MS,
+          Date.now() + env.CARE_RELATIONSHIP_MAX_DAYS * DAY_MS,
+        )),
+        createdBy: serviceId.toString(),
+      },
+      { upsert: true, new: true },
+    );
+    return this.toPublic(link);
+  }
+}
+
+module.exports = new CareRelationshipService();
@@ -0,0 +1,207 @@
+const { ObjectId } = require('mongoose').Types;
+const Admin = require('../models/Admin');
+const Role = require('../models/Role');
+const SuperAdmin = require('../models/SuperAdmin');
+const authService = require('./authServiceInstance');
+const auditLog = require('./auditLog');
+const permissionRules = require('../utils/permissionRules');
+const env = require('../config/env');
+
+// Deeper than any real chain; stops a createdBy loop in bad data
+const MAX_CHAIN_DEPTH = 20;
+
+const actorOf = (claims) => ({ userId: claims.user_id, role: claims.role, email: claims.email });
+
+// Admins hand out permissions to the admins they create, but never more than
+// they hold themselves. Each admin records who created it, so the chain from
+// any admin up to a super admin can be followed, and when an admin loses
+// permissions the admins below them are checked: in "flag" mode whatever they
+// hold beyond their creator is marked for review, in "cascade" mode it is
+// taken away, and that continues down the chain.
+class DelegationService {
+  // Permissions among grants that the holder's own grants do not satisfy
+  exceeding(held, grants) {
+    return permissionRules.exceeding(held, grants);
+  }
+
+  // Every place permissions are handed out goes through this: admins, roles,
+  // SAML providers, directory group mappings and service accounts
+  assertCanGrant(actor, grants, already = []) {
+    permissionRules.assertCanGrant(actor.permissions, grants, already);
+  }
+
+  isGrantDenied(error) {
+    return permissionRules.isGrantDenied(error);
+  }
+
+  // Admins created before creator roles were recorded may have been created
+  // by either a super admin or an admin
+  async creatorOf(admin) {
+    if (!admin.createdBy) return null;
+    if (admin.createdByRole !== 'super_admin') {
+      const creator = await Admin.findById(admin.createdBy);
+      if (creator) return { role: 'admin', user: creator, permissions: authService.adminPermissions(creator) };
+    }
+    if (admin.createdByRole !== 'admin') {
+      const creator = await SuperAdmin.findById(admin.createdBy);
+      if (creator) return { role: 'super_admin', user: creator, permissions: authService.defaultSuperAdminPermissions() };
+    }
+    return null;
+  }
+
+  summarize(admin) {
+    return {
+      id: admin._id,
+      email: admin.email,
+      roles: admin.roles || [],
+      flag: this.flagOf(admin),
+    };
+  }
+
+  flagOf(admin) {
+    const flag = admin.delegationFlag;
+    if (!flag || !flag.flaggedAt) return null;
+    return {
+      excessPermissions: flag.excessPermissions,
+      creatorId: flag.creatorId,
+      flaggedAt: flag.flaggedAt,
+    };
+  }
+
+  // From the admin's creator up to the super admin at the top. A creator
+  // that has been deleted ends the chain.
+  async chainOf(admin) {
+    const chain = [];
+    const seen = new Set([admin._id.toString()]);
+    let current = admin;
+    while (current.createdBy && chain.length < MAX_CHAIN_DEPTH) {
+      const creator = await this.creatorOf(current);
+      if (!creator) {
+        chain.push({ id: current.createdBy, role: current.createdByRole || null, deleted: true });
+        break;
+      }
+      chain.push({ id: creator.user._id, role: creator.role, email: creator.user.email });
+      if (creator.role !== 'admin' || seen.has(creator.user._id.toString())) break;
+      seen.add(creator.user._id.toString());
+      current = creator.user;
+    }
+    return chain;
+  }
+
+  async delegatesOf(creatorId, seen = new Set()) {
+    seen.add(creatorId.toString());
+    const created = await Admin.find({ createdBy: creatorId });
+    const tree = [];
+    for (const admin of created) {
+      if (seen.has(admin._id.toString())) continue;
+      tree.push({ ...this.summarize(admin), delegates: await this.delegatesOf(admin._id, seen) });
+    }
+    return tree;
+  }
+
+  // Who created the admin, in order up the chain, and every admin created
+  // below them. Works for super admins too, who only have delegates.
+  async describe(userId) {
+    const admin = ObjectId.isValid(userId) ? await Admin.findById(userId) : null;
+    if (admin) {
+      return {
+        ...this.summarize(admin),
+        role: 'admin',
+        createdBy: await this.chainOf(admin),
+        delegates: await this.delegatesOf(admin._id),
+      };
+    }
+    const superAdmin = ObjectId.isValid(userId) ? await SuperAdmin.findById(userId) : null;
+    if (!superAdmin) throw new Error('Admin not found');
+    return {
+      id: superAdmin._id,
+      email: superAdmin.email,
+      role: 'super_admin',
+      createdBy: [],
+      delegates: await this.delegatesOf(superAdmin._id),
+    };
+  }
+
+  async listFlagged() {
+    const admins = await Admin.find({ 'delegationFlag.flaggedAt': { $exists: true } }).sort({ 'delegationFlag.flaggedAt': -1 });
+    return admins.map((admin) => ({ ...this.summarize(admin), createdBy: admin.createdBy }));
+  }
+
+  // Runs after an admin's permissions changed: their own flag is brought up
+  // to date and the admins they created are checked against what they hold now
+  async afterChange(claims, admin, context = {}) {
+    if (this.flagOf(admin)) {
+      const creator = await this.creatorOf(admin);
+      await this.updateFlag(claims, admin, creator ? this.exceeding(creator.permissions, authService.adminPermissions(admin)) : [], context);
+    }
+    await this.reviewDelegates(claims, admin, context);
+  }
+
+  async reviewDelegates(claims, creator, context, seen = new Set()) {
+    seen.add(creator._id.toString());
+    const held = authService.adminPermissions(creator);
+    for (const delegate of await Admin.find({ createdBy: creator._id })) {
+      if (seen.has(delegate._id.toString())) continue;
+      const excess = this.exceeding(held, authService.adminPermissions(delegate));
+      if (excess.length > 0 && env.DELEGATION_REVOCATION_MODE === 'cascade') {
+        await this.trim(claims, delegate, held, excess, context);
+        await this.reviewDelegates(claims, delegate, context, seen);
+      } else {
+        await this.updateFlag(claims, delegate, excess, context);
+      }
+    }
+  }
+
+  async updateFlag(claims, admin, excess, context) {
+    const current = this.flagOf(admin);
+    if (excess.length === 0) {
+      if (current) {
+        admin.delegationFlag = undefined;
+        await admin.save();
+      }
+      return;
+    }
+    const unchanged = current && current.excessPermissions.length === excess.length
+      && excess.every((permission) => current.excessPermissions.includes(permission));
+    if (unchanged) return;
+    admin.delegationFlag = { excessPermissions: excess, creatorId: admin.createdBy, flaggedAt: new Date() };
+    await admin.save();
+    await auditLog.record({
+      action: 'delegation.flag',
+      actor: actorOf(claims),
+      subject: { userId: admin._id, role: 'admin' },
+      reason: `Holds permissions their creator no longer holds: ${excess.join(', ')}`,
+      context,
+    });
+  }
+
+  // Keeps what the creator still holds. Roles granting anything more are
+  // unassigned and the part of them the creator holds becomes direct grants.
+  async trim(claims, admin, held, excess, context) {
+    const within = (grants) => permissionRules.expand(grants)
+      .filter((permission) => permissionRules.holds(held, permission));
+    const assigned = await Role.find({ name: { $in: admin.roles || [] } });
+    const keptRoles = assigned.filter((role) => this.exceeding(held, role.permissions).length === 0);
+    const droppedRoles = assigned.filter((role) => !keptRoles.includes(role));
+
+    const direct = [
+      ...(admin.permissions || []).filter((grant) => this.exceeding(held, [grant]).length === 0),
+      ...within((admin.permissions || []).filter((grant) => this.exceeding(held, [grant]).length > 0)),
+      ...within(droppedRoles.flatMap((role) => role.permissions)),
+    ];
+    admin.permissions = [...new Set(direct)];
+    admin.roles = keptRoles.map((role) => role.name);
+    admin.rolePermissions = [...new Set(keptRoles.flatMap((role) => role.permissions))];
+    admin.delegationFlag = undefined;
+    await admin.save();
+    await auditLog.record({
+      action: 'delegation.cascade',
+      actor: actorOf(claims),
+      subject: { userId: admin._id, role: 'admin' },
+      reason: `Removed permissions their creator no longer holds: ${excess.join(', ')}`,
+      context,
+    });
+  }
+}
+
+module.exports = new DelegationService();
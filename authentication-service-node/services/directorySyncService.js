@@ -0,0 +1,93 @@
+const authService = require('./authServiceInstance');
+const ldapDirectoryService = require('./ldapDirectoryService');
+const cacheStore = require('../utils/cacheStore');
+
+const STAFF_ROLES = ['admin', 'doctor'];
+
+// Keeps local staff accounts in step with the directories that own their
+// email domains. Accounts whose user has left the directory, or no longer
+// belongs to any mapped group, are disabled and signed out; the next
+// successful directory sign-in enables them again.
+class DirectorySyncService {
+  // Runs every enabled directory; one replica at a time per directory
+  async syncAll(lockSeconds) {
+    const reports = [];
+    for (const directory of await ldapDirectoryService.listEnabledDirectories()) {
+      if (!await cacheStore.setIfAbsent(`ldap_sync:${directory.slug}`, '1', lockSeconds)) {
+        continue;
+      }
+      reports.push(await this.syncDirectory(directory).catch((error) => ({ directory: directory.slug, error: error.message })));
+    }
+    return reports;
+  }
+
+  async syncDirectory(directory) {
+    const report = { directory: directory.slug, checked: 0, disabled: 0, enabled: 0, updated: 0 };
+    let client;
+    try {
+      client = await ldapDirectoryService.connect(directory);
+      const accounts = await authService.accountStore.accountModel.find({ 'roles.role': { $in: STAFF_ROLES } });
+      for (const account of accounts) {
+        const email = account.email;
+        if (!directory.emailDomains.includes(email.slice(email.lastIndexOf('@') + 1))) continue;
+        report.checked += 1;
+        const directoryUser = await ldapDirectoryService.lookup(client, directory, email);
+        const result = await this.syncAccount(account, directoryUser);
+        if (result) report[result] += 1;
+      }
+      directory.lastSyncError = undefined;
+    } catch (error) {
+      directory.lastSyncError = error.message;
+      throw error;
+    } finally {
+      if (client) client.close();
+      directory.lastSyncAt = new Date();
+      await directory.save();
+    }
+    return report;
+  }
+
+  // Returns what was done: 'disabled', 'enabled', 'updated' or null
+  async syncAccount(account, directoryUser) {
+    const staffProfiles = account.roles.filter((entry) => STAFF_ROLES.includes(entry.role));
+    if (!directoryUser || directoryUser.roles.length === 0) {
+      if (account.status === 'disabled') return null;
+      account.status = 'disabled';
+      account.disabledReason = 'directory';
+      await account.save();
+      for (const entry of account.roles) {
+        await authService.revokeAllTokensForUser(entry.profileId);
+      }
+      return 'disabled';
+    }
+
+    const wasDisabled = account.status === 'disabled' && account.disabledReason === 'directory';
+    let changed = false;
+    for (const entry of staffProfiles) {
+      if (!directoryUser.roles.includes(entry.role)) {
+        // Removed from the role's groups: sessions in that role end now
+        await authService.revokeAllTokensForUser(entry.profileId);
+        changed = true;
+      }
+    }
+    const adminEntry = staffProfiles.find((entry) => entry.role === 'admin');
+    if (adminEntry) {
+      const permissions = directoryUser.roles.includes('admin') ? directoryUser.permissions : [];
+      const admin = await authService.adminModel.findById(adminEntry.profileId);
+      if (admin && [...admin.permissions].sort().join(' ') !== [...permissions].sort().join(' ')) {
+        // Picked up by the next token refresh
+        await authService.adminModel.updateOne({ _id: admin._id }, { permissions });
+        changed = true;
+      }
+    }
+    if (wasDisabled) {
+      account.status = 'active';
+      account.disabledReason = undefined;
+      await account.save();
+      return 'enabled';
+    }
+    return changed ? 'updated' : null;
+  }
+}
+
+module.exports = new DirectorySyncService();
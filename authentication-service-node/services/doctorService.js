@@ -1,5 +1,6 @@
 const Doctor = require('../models/Doctor');
-const bcrypt = require('bcryptjs');
+const authService = require('./authServiceInstance');
+const emailVerificationService = require('./emailVerificationService');
 
 class DoctorService {
   async registerDoctor(doctorData, password) {
@@ -10,26 +11,13 @@ class DoctorService {
         throw new Error(`Missing required field: ${field}`);
       }
     }
-    if (!this.validateEmail(doctorData.email)) {
-      throw new Error('Invalid email format');
+    const { passwordHash, accountId, emailVerified, emailVerifiedAt, ...profile } = doctorData;
+    profile.isApproved = false; // mark as pending
+    // Patients can add the doctor role to their existing account
+    const { account } = await authService.registerAccount('doctor', profile, password, { joinExisting: true });
+    if (!account.emailVerified) {
+      await emailVerificationService.sendVerification(account);
     }
-    if (!password || password.length < 6) {
-      throw new Error('Password must be at least 6 characters long');
-    }
-    const existing = await Doctor.findOne({ email: doctorData.email });
-    if (existing) {
-      throw new Error('User already exists');
-    }
-    const hashedPassword = await bcrypt.hash(password, 10);
-    doctorData.passwordHash = hashedPassword;
-    doctorData.isApproved = false; // mark as pending
-    const doctor = new Doctor(doctorData);
-    await doctor.save();
-  }
-
-  validateEmail(email) {
-    const emailRegex = /^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$/;
-    return emailRegex.test(email);
   }
 
   async listDoctors() {
@@ -37,7 +25,7 @@ class DoctorService {
   }
 
   async listPendingDoctors() {
-    return Doctor.find({ isApproved: false }).select('-passwordHash');
+    return Doctor.find({ isApproved: false });
   }
 
   async getDoctor(id) {
@@ -62,12 +50,15 @@ class DoctorService {
   async rejectDoctor(id) {
     const doctor = await Doctor.findById(id);
     if (!doctor) throw new Error('Doctor not found');
-    // Delete doctor on rejection
+    // Delete doctor on rejection; the account keeps any other roles
     await Doctor.findByIdAndDelete(id);
+    await authService.accountStore.removeRole('doctor', doctor._id);
   }
 
   async updateDoctor(id, updateData) {
-    return Doctor.findByIdAndUpdate(id, updateData, { new: true });
+    // Passwords only change through the password policy
+    const { passwordHash, accountId, ...changes } = updateData;
+    return Doctor.findByIdAndUpdate(id, changes, { new: true });
   }
 }
 
@@ -0,0 +1,89 @@
+const crypto = require('crypto');
+const EmailVerificationToken = require('../models/EmailVerificationToken');
+const authService = require('./authServiceInstance');
+const { normalizeEmail } = require('./accountStore');
+const cacheStore = require('../utils/cacheStore');
+const mailer = require('../utils/mailer');
+const env = require('../config/env');
+
+const RESEND_COOLDOWN_SECONDS = 60;
+const MAX_SENDS_PER_DAY = 5;
+
+const hashToken = (token) => crypto.createHash('sha256').update(token).digest('hex');
+
+class EmailVerificationService {
+  // Sends a fresh link; earlier links for the account stop working
+  async sendVerification(account) {
+    const accountId = account._id.toString();
+    await EmailVerificationToken.updateMany({ accountId, usedAt: null }, { usedAt: new Date() });
+    const token = crypto.randomBytes(32).toString('base64url');
+    await EmailVerificationToken.create({
+      tokenHash: hashToken(token),
+      accountId,
+      email: account.email,
+      expiresAt: new Date(Date.now() + env.EMAIL_VERIFICATION_TTL_HOURS * 60 * 60 * 1000),
+    });
+
+    const link = `${env.EMAIL_VERIFICATION_URL}?token=${encodeURIComponent(token)}`;
+    try {
+      await mailer.send({
+        to: account.email,
+        subject: 'Verify your email address',
+        text: [
+          'Please confirm that this is your email address by opening the link below:',
+          link,
+          '',
+          `The link expires in ${env.EMAIL_VERIFICATION_TTL_HOURS} hours.`,
+        ].join('\n'),
+      });
+    } catch (error) {
+      console.error(`Failed to send verification email: ${error.message}`);
+    }
+  }
+
+  // Like password reset, the response never reveals whether the account exists
+  async resend(email) {
+    const normalized = normalizeEmail(email);
+    if (!authService.validateEmail(normalized)) {
+      throw new Error('Invalid email format');
+    }
+    const account = await authService.accountStore.findByEmail(normalized);
+    if (!account || account.emailVerified) {
+      return;
+    }
+
+    // Both limits are claimed atomically so parallel requests cannot exceed them
+    const accountId = account._id.toString();
+    if (!await cacheStore.setIfAbsent(`email_verification_cooldown:${accountId}`, '1', RESEND_COOLDOWN_SECONDS)) {
+      return;
+    }
+    if (await cacheStore.incr(`email_verification_sent:${accountId}`, 24 * 60 * 60) > MAX_SENDS_PER_DAY) {
+      return;
+    }
+    await this.sendVerification(account);
+  }
+
+  async confirm(token) {
+    const record = token ? await EmailVerificationToken.findOne({ tokenHash: hashToken(token) }) : null;
+    if (!record || record.usedAt || record.expiresAt <= new Date()) {
+      throw new Error('Invalid or expired verification token');
+    }
+    const claimed = await EmailVerificationToken.findOneAndUpdate(
+      { _id: record._id, usedAt: null },
+      { usedAt: new Date() },
+    );
+    if (!claimed) {
+      throw new Error('Invalid or expired verification token');
+    }
+
+    const account = await authService.accountStore.findById(record.accountId);
+    if (!account || account.email !== record.email) {
+      throw new Error('Invalid or expired verification token');
+    }
+    account.emailVerified = true;
+    account.emailVerifiedAt = new Date();
+    await account.save();
+  }
+}
+
+module.exports = new EmailVerificationService();
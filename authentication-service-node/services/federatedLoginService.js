@@ -0,0 +1,296 @@
+const crypto = require('crypto');
+const { ObjectId } = require('mongoose').Types;
+const ExternalIdentity = require('../models/ExternalIdentity');
+const authService = require('./authServiceInstance');
+const identityProviderService = require('./identityProviderService');
+const oidcClient = require('../utils/oidcClient');
+const cacheStore = require('../utils/cacheStore');
+const env = require('../config/env');
+
+// The user has this long to finish signing in at the provider
+const AUTHORIZATION_STATE_TTL_SECONDS = 10 * 60;
+// The frontend redeems the callback code right after the redirect
+const CALLBACK_CODE_TTL_SECONDS = 2 * 60;
+const MAX_IDENTITIES_PER_ACCOUNT = 10;
+
+const hashValue = (value) => crypto.createHash('sha256').update(value).digest('hex');
+
+const randomToken = () => crypto.randomBytes(32).toString('base64url');
+
+// Errors worth showing the user on the frontend page the callback redirects to
+class FederationError extends Error {}
+
+// Signs users in through external OpenID Connect providers and links those
+// identities to accounts. An external identity only ever signs in to the
+// account it was linked to: a matching email address is not enough, since
+// that would hand the account to whoever controls the address at the provider.
+//
+// Each flow is bound to the browser that started it by a key the frontend
+// keeps and presents when redeeming the callback code. Without it, a callback
+// URL from someone else's flow could sign the user in to, or link their
+// identity to, that person's account.
+class FederatedLoginService {
+  // role and returnTo are echoed back to the frontend after the callback
+  async beginLogin(slug, { role = null, returnTo = null } = {}) {
+    const provider = await identityProviderService.getEnabledProvider(slug);
+    return this.startAuthorization(provider, { intent: 'login', role, returnTo });
+  }
+
+  // Linking adds a way into the account, so it needs the same assurance as
+  // registering a passkey
+  async beginLink(claims, slug) {
+    await authService.assertSecondFactorSession(claims);
+    const account = await this.accountFor(claims);
+    const provider = await identityProviderService.getEnabledProvider(slug);
+    if (await ExternalIdentity.countDocuments({ accountId: account._id }) >= MAX_IDENTITIES_PER_ACCOUNT) {
+      throw new Error(`At most ${MAX_IDENTITIES_PER_ACCOUNT} identities can be linked to an account`);
+    }
+    return this.startAuthorization(provider, { intent: 'link', accountId: account._id.toString() });
+  }
+
+  async startAuthorization(provider, flow) {
+    const metadata = await identityProviderService.metadataFor(provider);
+    const state = randomToken();
+    const nonce = randomToken();
+    const browserKey = randomToken();
+    const pkce = oidcClient.createPkce();
+    await cacheStore.set(`federation_state:${hashValue(state)}`, JSON.stringify({
+      ...flow,
+      provider: provider.slug,
+      nonce,
+      codeVerifier: pkce.verifier,
+      browserKeyHash: hashValue(browserKey),
+    }), AUTHORIZATION_STATE_TTL_SECONDS);
+
+    const url = new URL(metadata.authorization_endpoint);
+    url.searchParams.set('response_type', 'code');
+    url.searchParams.set('client_id', provider.clientId);
+    url.searchParams.set('redirect_uri', identityProviderService.callbackUrl(provider));
+    url.searchParams.set('scope', provider.scopes.join(' '));
+    url.searchParams.set('state', state);
+    url.searchParams.set('nonce', nonce);
+    url.searchParams.set('code_challenge', pkce.challenge);
+    url.searchParams.set('code_challenge_method', 'S256');
+    const responseMode = identityProviderService.responseMode(provider);
+    if (responseMode) {
+      url.searchParams.set('response_mode', responseMode);
+    }
+    return { authorizationUrl: url.toString(), browserKey };
+  }
+
+  // Handles the provider's redirect and returns where to send the user agent:
+  // the frontend page, with a single-use code for the verified identity or an
+  // error
+  async handleCallback(slug, params) {
+    let flow = null;
+    try {
+      const stored = params.state ? await cacheStore.take(`federation_state:${hashValue(params.state)}`) : null;
+      flow = stored ? JSON.parse(stored) : null;
+      if (!flow || flow.provider !== slug) {
+        throw new FederationError('The sign-in request has expired; please try again');
+      }
+      if (params.error) {
+        throw new FederationError(params.error === 'access_denied'
+          ? 'Sign-in was cancelled'
+          : 'The identity provider could not sign you in');
+      }
+      const provider = await identityProviderService.getEnabledProvider(slug);
+      const external = await this.verifyAuthorizationResponse(provider, params, flow);
+
+      const code = await this.issueCallbackCode({
+        protocol: 'oidc',
+        intent: flow.intent,
+        provider: slug,
+        role: flow.role,
+        accountId: flow.accountId,
+        browserKeyHash: flow.browserKeyHash,
+        external,
+      });
+      return this.frontendUrl({ code, intent: flow.intent, returnTo: flow.returnTo });
+    } catch (error) {
+      if (!(error instanceof FederationError)) {
+        console.error(`Federated sign-in through ${slug} failed:`, error.message);
+      }
+      return this.frontendUrl({
+        error: error instanceof FederationError ? error.message : 'Sign-in through the identity provider failed',
+        intent: flow && flow.intent,
+      });
+    }
+  }
+
+  frontendUrl(params) {
+    const url = new URL(env.FEDERATED_LOGIN_URL);
+    for (const [name, value] of Object.entries(params)) {
+      if (value) url.searchParams.set(name, value);
+    }
+    return url.toString();
+  }
+
+  async verifyAuthorizationResponse(provider, params, flow) {
+    if (!params.code) {
+      throw new FederationError('The identity provider did not return an authorization code');
+    }
+    const metadata = await identityProviderService.metadataFor(provider);
+    const tokens = await oidcClient.exchangeCode(metadata.token_endpoint, {
+      code: params.code,
+      redirectUri: identityProviderService.callbackUrl(provider),
+      codeVerifier: flow.codeVerifier,
+      clientId: provider.clientId,
+      clientSecret: identityProviderService.clientSecretFor(provider),
+      authMethod: provider.tokenEndpointAuthMethod,
+    });
+    const claims = await oidcClient.validateIdToken(tokens.id_token, {
+      issuer: metadata.issuer,
+      jwksUri: metadata.jwks_uri,
+      clientId: provider.clientId,
+      nonce: flow.nonce,
+    });
+    return {
+      subject: String(claims.sub),
+      email: claims.email ? claims.email.toLowerCase() : null,
+      // Apple sends "true"/"false" strings
+      emailVerified: claims.email_verified === true || claims.email_verified === 'true',
+      name: claims.name || this.appleName(params.user),
+    };
+  }
+
+  // Apple sends the user's name once, outside the ID token, on the first sign-in
+  appleName(user) {
+    try {
+      const { name } = JSON.parse(user || '{}');
+      return name ? [name.firstName, name.lastName].filter(Boolean).join(' ') : null;
+    } catch (error) {
+      return null;
+    }
+  }
+
+  // Hands a verified callback result to the frontend under a single-use code.
+  // result carries the protocol and intent it may be redeemed for, and the
+  // hash of the browser key of the flow.
+  async issueCallbackCode(result) {
+    const code = randomToken();
+    await cacheStore.set(`federation_callback:${hashValue(code)}`, JSON.stringify(result), CALLBACK_CODE_TTL_SECONDS);
+    return code;
+  }
+
+  async redeemCallback(code, browserKey, { protocol, intent }) {
+    const stored = code ? await cacheStore.take(`federation_callback:${hashValue(code)}`) : null;
+    const result = stored ? JSON.parse(stored) : null;
+    if (!result || result.protocol !== protocol || result.intent !== intent
+      || !browserKey || hashValue(browserKey) !== result.browserKeyHash) {
+      throw new Error('Invalid or expired sign-in code');
+    }
+    return result;
+  }
+
+  // Finishes a sign-in. The result is tokens or an MFA challenge, as for a
+  // password login.
+  async completeLogin(code, browserKey, context = {}) {
+    const { provider: slug, external, role } = await this.redeemCallback(code, browserKey, { protocol: 'oidc', intent: 'login' });
+    const provider = await identityProviderService.getEnabledProvider(slug);
+    let identity = await ExternalIdentity.findOne({ provider: provider.slug, subject: external.subject });
+    let account = identity ? await authService.accountStore.findById(identity.accountId) : null;
+    if (identity && !account) {
+      // The account was deleted; its links go with it
+      await ExternalIdentity.deleteOne({ _id: identity._id });
+      identity = null;
+    }
+    if (!identity) {
+      account = await this.provisionAccount(provider, external);
+      identity = await this.storeIdentity(account._id, provider, external);
+    }
+
+    const { user, role: selectedRole } = await authService.selectProfile(account, role, provider.allowedRoles);
+    await ExternalIdentity.updateOne(
+      { _id: identity._id },
+      { lastLoginAt: new Date(), email: external.email, emailVerified: external.emailVerified },
+    );
+    await authService.accountStore.recordLogin(account._id);
+    return authService.completePrimaryLogin(user, selectedRole, account, context, ['fed']);
+  }
+
+  async provisionAccount(provider, external) {
+    if (external.email && await authService.accountStore.findByEmail(external.email)) {
+      throw new Error(`An account already uses this email address. Sign in to it and link ${provider.displayName} from your account settings.`);
+    }
+    if (!provider.autoProvisionRole) {
+      throw new Error(`No account is linked to this ${provider.displayName} identity`);
+    }
+    if (!external.email || !external.emailVerified) {
+      throw new Error(`${provider.displayName} did not confirm an email address for this identity`);
+    }
+    const { account } = await authService.registerFederatedAccount(provider.autoProvisionRole, {
+      name: external.name || external.email.split('@')[0],
+      email: external.email,
+    });
+    return account;
+  }
+
+  // Links the identity from a callback to the signed-in user's account
+  async completeLink(claims, code, browserKey) {
+    const { provider: slug, external, accountId } = await this.redeemCallback(code, browserKey, { protocol: 'oidc', intent: 'link' });
+    const provider = await identityProviderService.getEnabledProvider(slug);
+    const account = await this.accountFor(claims);
+    if (account._id.toString() !== accountId) {
+      throw new Error('Invalid or expired sign-in code');
+    }
+    const existing = await ExternalIdentity.findOne({ provider: provider.slug, subject: external.subject });
+    if (existing && existing.accountId.toString() !== accountId) {
+      throw new Error(`This ${provider.displayName} identity is already linked to another account`);
+    }
+    return this.toPublic(existing || await this.storeIdentity(account._id, provider, external));
+  }
+
+  async storeIdentity(accountId, provider, external) {
+    return ExternalIdentity.create({
+      accountId,
+      provider: provider.slug,
+      subject: external.subject,
+      email: external.email,
+      emailVerified: external.emailVerified,
+    });
+  }
+
+  toPublic(identity) {
+    return {
+      id: identity._id,
+      provider: identity.provider,
+      email: identity.email,
+      linkedAt: identity.createdAt,
+      lastLoginAt: identity.lastLoginAt,
+    };
+  }
+
+  async accountFor(claims) {
+    const loaded = await authService.loadActiveProfile(claims.user_id, claims.role);
+    if (!loaded) {
+      throw new Error('User not found');
+    }
+    return loaded.account;
+  }
+
+  async listIdentities(claims) {
+    const account = await this.accountFor(claims);
+    const identities = await ExternalIdentity.find({ accountId: account._id }).sort({ createdAt: 1 });
+    return identities.map((identity) => this.toPublic(identity));
+  }
+
+  // The last way into an account cannot be removed
+  async unlinkIdentity(claims, identityId) {
+    const account = await this.accountFor(claims);
+    const identity = ObjectId.isValid(identityId)
+      ? await ExternalIdentity.findOne({ _id: identityId, accountId: account._id })
+      : null;
+    if (!identity) {
+      throw new Error('Linked identity not found');
+    }
+    const others = await ExternalIdentity.countDocuments({ accountId: account._id, _id: { $ne: identity._id } });
+    if (!account.passwordHash && others === 0) {
+      throw new Error('Set a password before unlinking your only sign-in method');
+    }
+    await ExternalIdentity.deleteOne({ _id: identity._id });
+  }
+}
+
+module.exports = new FederatedLoginService();
+module.exports.FederationError = FederationError;
@@ -0,0 +1,249 @@
+const crypto = require('crypto');
+const IdentityProvider = require('../models/IdentityProvider');
+const ExternalIdentity = require('../models/ExternalIdentity');
+const SecretBox = require('../utils/secretBox');
+const oidcClient = require('../utils/oidcClient');
+const env = require('../config/env');
+
+const ROLES = ['super_admin', 'admin', 'doctor', 'patient'];
+const AUTH_METHODS = ['none', 'client_secret_basic', 'client_secret_post'];
+const SLUG_PATTERN = /^[a-z0-9][a-z0-9-]{0,39}$/;
+
+// Settings each preset fills in; anything else speaking OpenID Connect uses
+// "oidc" with its issuer
+const PRESETS = {
+  oidc: {
+    scopes: ['openid', 'email', 'profile'],
+  },
+  google: {
+    displayName: 'Google',
+    issuer: () => 'https://accounts.google.com',
+    scopes: ['openid', 'email', 'profile'],
+  },
+  microsoft: {
+    displayName: 'Microsoft',
+    // "common" accepts work, school and personal accounts from any tenant
+    issuer: ({ tenant }) => `https://login.microsoftonline.com/${tenant || 'common'}/v2.0`,
+    scopes: ['openid', 'email', 'profile'],
+  },
+  apple: {
+    displayName: 'Apple',
+    issuer: () => 'https://appleid.apple.com',
+    scopes: ['openid', 'email', 'name'],
+    // Apple posts the callback when the name or email scope is requested
+    responseMode: 'form_post',
+  },
+};
+
+class IdentityProviderService {
+  constructor() {
+    this.secretBox = new SecretBox(env.JWT_SECRET);
+  }
+
+  validateProviderData(data, partial = false) {
+    const { slug, displayName, preset, issuer, clientId, allowedRoles, autoProvisionRole, tokenEndpointAuthMethod } = data;
+    if (!partial) {
+      if (!slug || !SLUG_PATTERN.test(slug)) {
+        throw new Error('Slug must be lowercase letters, digits and dashes');
+      }
+      if (!Object.prototype.hasOwnProperty.call(PRESETS, preset)) {
+        throw new Error(`Unknown preset: ${preset}`);
+      }
+      if (!issuer) throw new Error('Issuer is required');
+      if (!clientId) throw new Error('Client ID is required');
+    }
+    if (displayName !== undefined && !displayName) {
+      throw new Error('Display name is required');
+    }
+    if (issuer !== undefined) {
+      let parsed;
+      try {
+        parsed = new URL(issuer);
+      } catch (error) {
+        throw new Error(`Invalid issuer: ${issuer}`);
+      }
+      if (parsed.protocol !== 'https:' && parsed.hostname !== 'localhost') {
+        throw new Error('Issuer must use https');
+      }
+    }
+    if (allowedRoles !== undefined) {
+      if (!Array.isArray(allowedRoles) || allowedRoles.length === 0) {
+        throw new Error('At least one allowed role is required');
+      }
+      const unknown = allowedRoles.filter((role) => !ROLES.includes(role));
+      if (unknown.length > 0) throw new Error(`Unknown roles: ${unknown.join(', ')}`);
+    }
+    // Only patients self-register; other roles are created by staff
+    if (autoProvisionRole !== undefined && ![null, 'patient'].includes(autoProvisionRole)) {
+      throw new Error('Only patient accounts can be provisioned automatically');
+    }
+    if (tokenEndpointAuthMethod !== undefined && !AUTH_METHODS.includes(tokenEndpointAuthMethod)) {
+      throw new Error(`Unsupported token endpoint auth method: ${tokenEndpointAuthMethod}`);
+    }
+  }
+
+  // Turns an admin request into stored fields, encrypting any secrets
+  providerFields(data, preset) {
+    const fields = {};
+    for (const field of ['displayName', 'clientId', 'scopes', 'allowedRoles', 'autoProvisionRole', 'tokenEndpointAuthMethod', 'enabled', 'appleTeamId', 'appleKeyId']) {
+      if (data[field] !== undefined) fields[field] = data[field];
+    }
+    if (data.issuer !== undefined) fields.issuer = data.issuer.replace(/\/$/, '');
+    if (data.clientSecret !== undefined) {
+      fields.clientSecretEncrypted = data.clientSecret ? this.secretBox.encrypt(data.clientSecret) : undefined;
+    }
+    if (data.applePrivateKey !== undefined) {
+      if (preset !== 'apple') {
+        throw new Error('Only Apple providers take a private key');
+      }
+      let key;
+      try {
+        key = crypto.createPrivateKey(data.applePrivateKey);
+      } catch (error) {
+        throw new Error('Apple private key must be a PEM encoded key');
+      }
+      if (key.asymmetricKeyType !== 'ec') {
+        throw new Error('Apple private key must be an EC (P-256) key');
+      }
+      fields.applePrivateKeyEncrypted = this.secretBox.encrypt(data.applePrivateKey);
+    }
+    return fields;
+  }
+
+  async createProvider(creatorId, data) {
+    const preset = data.preset || 'oidc';
+    const defaults = PRESETS[preset] || {};
+    const providerData = {
+      ...data,
+      preset,
+      displayName: data.displayName || defaults.displayName,
+      issuer: data.issuer || (defaults.issuer && defaults.issuer(data)),
+    };
+    this.validateProviderData(providerData);
+    if (preset === 'apple' && !(data.appleTeamId && data.appleKeyId && data.applePrivateKey)) {
+      throw new Error('Apple providers need a team ID, key ID and private key');
+    }
+    if (await IdentityProvider.findOne({ slug: data.slug })) {
+      throw new Error('An identity provider with this slug already exists');
+    }
+
+    const fields = this.providerFields(providerData, preset);
+    const tokenEndpointAuthMethod = fields.tokenEndpointAuthMethod
+      || (preset === 'apple' || fields.clientSecretEncrypted ? 'client_secret_post' : 'none');
+    if (tokenEndpointAuthMethod !== 'none' && preset !== 'apple' && !fields.clientSecretEncrypted) {
+      throw new Error('A client secret is required for this token endpoint auth method');
+    }
+    const provider = await IdentityProvider.create({
+      ...fields,
+      slug: data.slug,
+      preset,
+      tokenEndpointAuthMethod,
+      scopes: fields.scopes && fields.scopes.length > 0 ? fields.scopes : defaults.scopes,
+      allowedRoles: fields.allowedRoles || ['doctor', 'patient'],
+      autoProvisionRole: fields.autoProvisionRole || null,
+      createdBy: creatorId,
+    });
+    return this.toPublic(provider);
+  }
+
+  toPublic(provider) {
+    return {
+      id: provider._id,
+      slug: provider.slug,
+      displayName: provider.displayName,
+      preset: provider.preset,
+      issuer: provider.issuer,
+      clientId: provider.clientId,
+      hasClientSecret: Boolean(provider.clientSecretEncrypted || provider.applePrivateKeyEncrypted),
+      tokenEndpointAuthMethod: provider.tokenEndpointAuthMethod,
+      appleTeamId: provider.appleTeamId,
+      appleKeyId: provider.appleKeyId,
+      scopes: provider.scopes,
+      allowedRoles: provider.allowedRoles,
+      autoProvisionRole: provider.autoProvisionRole,
+      enabled: provider.enabled,
+      callbackUrl: this.callbackUrl(provider),
+      createdAt: provider.createdAt,
+      updatedAt: provider.updatedAt,
+    };
+  }
+
+  // What the login page needs to offer a provider
+  async listEnabledProviders() {
+    const providers = await IdentityProvider.find({ enabled: true }).sort({ displayName: 1 });
+    return providers.map((provider) => ({
+      slug: provider.slug,
+      displayName: provider.displayName,
+      preset: provider.preset,
+    }));
+  }
+
+  async listProviders() {
+    const providers = await IdentityProvider.find().sort({ displayName: 1 });
+    return providers.map((provider) => this.toPublic(provider));
+  }
+
+  async getProvider(slug) {
+    return IdentityProvider.findOne({ slug });
+  }
+
+  async getEnabledProvider(slug) {
+    const provider = await IdentityProvider.findOne({ slug, enabled: true });
+    if (!provider) {
+      throw new Error('Unknown identity provider');
+    }
+    return provider;
+  }
+
+  async updateProvider(slug, data) {
+    const provider = await IdentityProvider.findOne({ slug });
+    if (!provider) throw new Error('Identity provider not found');
+    this.validateProviderData(data, true);
+    const fields = this.providerFields(data, provider.preset);
+    const authMethod = fields.tokenEndpointAuthMethod || provider.tokenEndpointAuthMethod;
+    const hasSecret = 'clientSecretEncrypted' in fields ? fields.clientSecretEncrypted : provider.clientSecretEncrypted;
+    if (authMethod !== 'none' && provider.preset !== 'apple' && !hasSecret) {
+      throw new Error('A client secret is required for this token endpoint auth method');
+    }
+    Object.assign(provider, fields);
+    await provider.save();
+    return this.toPublic(provider);
+  }
+
+  // Deleting a provider would strand accounts that only sign in through it;
+  // disable it instead, or unlink those identities first
+  async deleteProvider(slug) {
+    const provider = await IdentityProvider.findOne({ slug });
+    if (!provider) throw new Error('Identity provider not found');
+    if (await ExternalIdentity.countDocuments({ provider: slug }) > 0) {
+      throw new Error('Accounts are linked to this identity provider; disable it instead');
+    }
+    await IdentityProvider.deleteOne({ _id: provider._id });
+  }
+
+  callbackUrl(provider) {
+    return `${env.OIDC_ISSUER}/api/v1/auth/providers/${provider.slug}/callback`;
+  }
+
+  responseMode(provider) {
+    return (PRESETS[provider.preset] || {}).responseMode || null;
+  }
+
+  async metadataFor(provider) {
+    return oidcClient.discover(provider.issuer);
+  }
+
+  clientSecretFor(provider) {
+    if (provider.preset === 'apple') {
+      return oidcClient.appleClientSecret({
+        teamId: provider.appleTeamId,
+        keyId: provider.appleKeyId,
+        privateKey: this.secretBox.decrypt(provider.applePrivateKeyEncrypted),
+        clientId: provider.clientId,
+      });
+    }
+    return provider.clientSecretEncrypted ? this.secretBox.decrypt(provider.clientSecretEncrypted) : null;
+  }
+}
+
+module.exports = new IdentityProviderService();
@@ -0,0 +1,118 @@
+const crypto = require('crypto');
+const { ObjectId } = require('mongoose').Types;
+const authService = require('./authServiceInstance');
+const auditLog = require('./auditLog');
+
+// Shorter than access tokens, so the revocation markers written for those
+// always outlive an impersonation token as well
+const IMPERSONATION_LIFETIME_SECONDS = 10 * 60;
+const IMPERSONATABLE_ROLES = ['doctor', 'patient'];
+const MAX_REASON_LENGTH = 500;
+
+const actorOf = (claims) => ({ userId: claims.user_id, role: claims.role, email: claims.email });
+
+// Lets support staff see the app as a doctor or patient sees it without
+// knowing their password. The token is issued for the target user and
+// names the staff member in its act claim (RFC 8693); it cannot be
+// refreshed, and every request made with it is audited against the actor.
+class ImpersonationService {
+  validateReason(reason) {
+    const trimmed = typeof reason === 'string' ? reason.trim() : '';
+    if (!trimmed) {
+      throw new Error('A reason for impersonating is required');
+    }
+    if (trimmed.length > MAX_REASON_LENGTH) {
+      throw new Error(`Reason must be at most ${MAX_REASON_LENGTH} characters`);
+    }
+    return trimmed;
+  }
+
+  async start(claims, { userId, role, reason }, context = {}) {
+    if (claims.act) {
+      throw new Error('Stop impersonating before impersonating someone else');
+    }
+    if (!IMPERSONATABLE_ROLES.includes(role)) {
+      throw new Error('Only doctors and patients can be impersonated');
+    }
+    const justification = this.validateReason(reason);
+    await authService.assertSecondFactorSession(claims);
+
+    const loaded = ObjectId.isValid(userId) ? await authService.loadActiveProfile(userId, role) : null;
+    if (!loaded) {
+      throw new Error('User not found');
+    }
+    if (claims.account_id && loaded.account._id.toString() === claims.account_id) {
+      throw new Error('You cannot impersonate yourself');
+    }
+
+    const identity = authService.identityFor(loaded.user, role, loaded.account);
+    const sessionId = crypto.randomUUID();
+    const expiresAt = new Date(Date.now() + IMPERSONATION_LIFETIME_SECONDS * 1000);
+    // Listed among the target's sessions, so it can be ended like any other
+    await authService.sessionStore.create({
+      sessionId,
+      userId: identity.userId,
+      role,
+      amr: ['imp'],
+      expiresAt,
+      context,
+    });
+    const token = await authService.generateToken(
+      identity.userId,
+      identity.email,
+      identity.role,
+      identity.permissions,
+      identity.patientId,
+      sessionId,
+      {
+        email_verified: identity.emailVerified,
+        account_id: identity.accountId.toString(),
+        amr: ['imp'],
+        act: { sub: claims.user_id, role: claims.role, email: claims.email },
+      },
+      IMPERSONATION_LIFETIME_SECONDS,
+    );
+
+    await auditLog.record({
+      action: 'impersonation.start',
+      actor: actorOf(claims),
+      subject: { userId: identity.userId, role },
+      sessionId,
+      reason: justification,
+      context,
+    });
+    return { token, expiresIn: IMPERSONATION_LIFETIME_SECONDS, expiresAt, reason: justification };
+  }
+
+  // Called with the impersonation token itself
+  async end(claims, context = {}) {
+    if (!claims.act) {
+      throw new Error('This session is not an impersonation');
+    }
+    await authService.revokeTokenFamily(claims.sid);
+    await auditLog.record({
+      action: 'impersonation.end',
+      actor: { userId: claims.act.sub, role: claims.act.role, email: claims.act.email },
+      subject: { userId: claims.user_id, role: claims.role },
+      sessionId: claims.sid,
+      context,
+    });
+  }
+
+  // Attributes one request made with an impersonation token to the actor
+  async recordRequest(principal, { method, path, statusCode }, context = {}) {
+    const { act } = principal;
+    await auditLog.record({
+      action: 'impersonation.request',
+      actor: { userId: act.sub, role: act.role, email: act.email },
+      subject: { userId: principal.user_id, role: principal.role },
+      sessionId: principal.sid,
+      method,
+      path,
+      statusCode,
+      context,
+    });
+  }
+}
+
+module.exports = new ImpersonationService();
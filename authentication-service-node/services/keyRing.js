@@ -0,0 +1,152 @@
+const crypto = require('crypto');
+const { ALGORITHMS, isSupportedAlgorithm } = require('../utils/jwt');
+const SecretBox = require('../utils/secretBox');
+
+const CACHE_TTL_MS = 60 * 1000;
+// Unknown kids force a reload, but not more often than this
+const MIN_RELOAD_INTERVAL_MS = 5 * 1000;
+
+// Signing keys shared by all replicas through MongoDB. Each key is published
+// in the JWKS before it starts signing (publishLead), signs for one rotation
+// interval and stays published for verifyOverlap after it retires so tokens
+// it signed can still be verified. Private keys are stored encrypted with a
+// key derived from encryptionSecret.
+class KeyRing {
+  constructor({
+    signingKeyModel,
+    alg,
+    rotationIntervalSeconds,
+    publishLeadSeconds,
+    verifyOverlapSeconds,
+    encryptionSecret,
+  }) {
+    if (!isSupportedAlgorithm(alg)) {
+      throw new Error(`Unsupported signing algorithm: ${alg}`);
+    }
+    this.signingKeyModel = signingKeyModel;
+    this.alg = alg;
+    this.rotationIntervalMs = rotationIntervalSeconds * 1000;
+    this.publishLeadMs = publishLeadSeconds * 1000;
+    this.verifyOverlapMs = verifyOverlapSeconds * 1000;
+    this.secretBox = new SecretBox(encryptionSecret);
+    this.keys = [];
+    this.loadedAt = 0;
+  }
+
+  async load() {
+    const records = await this.signingKeyModel.find({ expiresAt: { $gt: new Date() } });
+    this.keys = records
+      .map((record) => ({
+        kid: record.kid,
+        generation: record.generation,
+        alg: record.alg,
+        publicJwk: record.publicJwk,
+        publicKey: crypto.createPublicKey({ key: record.publicJwk, format: 'jwk' }),
+        encryptedPrivateKey: record.encryptedPrivateKey,
+        activatesAt: new Date(record.activatesAt),
+        retiresAt: new Date(record.retiresAt),
+        expiresAt: new Date(record.expiresAt),
+      }))
+      .sort((a, b) => b.generation - a.generation);
+    this.loadedAt = Date.now();
+  }
+
+  async refresh({ force = false } = {}) {
+    if (force || Date.now() - this.loadedAt > CACHE_TTL_MS) {
+      await this.load();
+    }
+  }
+
+  async createKey(activatesAt) {
+    const { publicKey, privateKey } = crypto.generateKeyPairSync(
+      ALGORITHMS[this.alg].keyType,
+      ALGORITHMS[this.alg].generateOptions,
+    );
+    const latest = await this.signingKeyModel.findOne().sort({ generation: -1 });
+    const generation = latest ? latest.generation + 1 : 1;
+    const kid = `${generation}-${crypto.randomBytes(8).toString('hex')}`;
+    const retiresAt = new Date(activatesAt.getTime() + this.rotationIntervalMs);
+    try {
+      await this.signingKeyModel.create({
+        kid,
+        generation,
+        alg: this.alg,
+        publicJwk: { ...publicKey.export({ format: 'jwk' }), kid, alg: this.alg, use: 'sig' },
+        encryptedPrivateKey: this.secretBox.encrypt(privateKey.export({ format: 'pem', type: 'pkcs8' })),
+        activatesAt,
+        retiresAt,
+        expiresAt: new Date(retiresAt.getTime() + this.verifyOverlapMs),
+      });
+    } catch (error) {
+      // Another replica created this generation first; use theirs
+      if (error.code !== 11000) throw error;
+    }
+    await this.load();
+  }
+
+  // Creates the next key once the newest one is within publishLead of retiring
+  async rotateIfDue() {
+    await this.refresh({ force: true });
+    const now = Date.now();
+    const latest = this.keys[0];
+    if (!latest || latest.retiresAt.getTime() <= now) {
+      await this.createKey(new Date(now));
+      return;
+    }
+    if (latest.retiresAt.getTime() - now <= this.publishLeadMs) {
+      await this.createKey(latest.retiresAt);
+    }
+  }
+
+  // Immediately replaces the signing key; older keys keep verifying until they expire
+  async rotateNow() {
+    const now = new Date();
+    await this.signingKeyModel.updateMany(
+      { retiresAt: { $gt: now } },
+      { retiresAt: now, expiresAt: new Date(now.getTime() + this.verifyOverlapMs) },
+    );
+    await this.createKey(now);
+  }
+
+  activeKey() {
+    const now = Date.now();
+    return this.keys.find((key) => key.activatesAt.getTime() <= now && key.retiresAt.getTime() > now);
+  }
+
+  async getSigningKey() {
+    await this.refresh();
+    let key = this.activeKey();
+    if (!key) {
+      await this.rotateIfDue();
+      key = this.activeKey();
+    }
+    if (!key) {
+      throw new Error('No active signing key');
+    }
+    if (!key.privateKey) {
+      key.privateKey = crypto.createPrivateKey(this.secretBox.decrypt(key.encryptedPrivateKey));
+    }
+    return key;
+  }
+
+  async getVerificationKey(kid) {
+    await this.refresh();
+    let key = this.keys.find((candidate) => candidate.kid === kid);
+    if (!key && Date.now() - this.loadedAt > MIN_RELOAD_INTERVAL_MS) {
+      // Possibly created by another replica since the last load
+      await this.refresh({ force: true });
+      key = this.keys.find((candidate) => candidate.kid === kid);
+    }
+    if (!key || key.expiresAt.getTime() <= Date.now()) {
+      return null;
+    }
+    return key;
+  }
+
+  async jwks() {
+    await this.refresh();
+    return { keys: this.keys.map((key) => key.publicJwk) };
+  }
+}
+
+module.exports = KeyRing;
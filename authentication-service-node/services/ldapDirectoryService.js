@@ -0,0 +1,304 @@
+const crypto = require('crypto');
+const LdapDirectory = require('../models/LdapDirectory');
+const SecretBox = require('../utils/secretBox');
+const ldapClient = require('../utils/ldapClient');
+const permissions = require('../utils/permissions');
+const permissionRules = require('../utils/permissionRules');
+const env = require('../config/env');
+
+const { escapeFilterValue, LdapError, RESULT } = ldapClient;
+
+const SLUG_PATTERN = /^[a-z0-9][a-z0-9-]{0,39}$/;
+const DOMAIN_PATTERN = /^[a-z0-9-]+(\.[a-z0-9-]+)+$/;
+const KNOWN_PERMISSIONS = Object.values(permissions);
+const LOCAL_HOSTS = ['localhost', '127.0.0.1', '[::1]'];
+
+// DNs are compared without case and without spaces around separators
+const normalizeDn = (dn) => dn.split(',').map((part) => part.trim()).join(',').toLowerCase();
+
+const fillTemplate = (template, values) => template.replace(/\{(\w+)\}/g, (match, name) => (
+  name in values ? escapeFilterValue(values[name]) : match
+));
+
+const domainOf = (email) => email.slice(email.lastIndexOf('@') + 1).toLowerCase();
+
+// Staff directories (LDAP / Active Directory) that passwords for some email
+// domains are checked against instead of local password hashes. This service
+// only talks to the directory: it says who a user is there and which roles
+// and permissions their groups map to; the login and sync code act on that.
+class LdapDirectoryService {
+  constructor() {
+    this.secretBox = new SecretBox(env.JWT_SECRET);
+  }
+
+  async validateDirectoryData(data, existing = null) {
+    const { slug, displayName, url, startTls, bindDn, bindPassword, userBaseDn, userFilter, groupFilter, emailDomains, groupMappings, caCertificate } = data;
+    if (!existing) {
+      if (!slug || !SLUG_PATTERN.test(slug)) {
+        throw new Error('Slug must be lowercase letters, digits and dashes');
+      }
+      if (!url) throw new Error('Directory URL is required');
+      if (!bindDn || !bindPassword) throw new Error('A service account DN and password are required');
+      if (!userBaseDn) throw new Error('User base DN is required');
+    }
+    if (displayName !== undefined && !displayName) {
+      throw new Error('Display name is required');
+    }
+    if (url !== undefined || startTls !== undefined) {
+      let parsed;
+      try {
+        parsed = new URL(url !== undefined ? url : existing.url);
+      } catch (error) {
+        throw new Error(`Invalid directory URL: ${url}`);
+      }
+      if (!['ldap:', 'ldaps:'].includes(parsed.protocol)) {
+        throw new Error('Directory URL must be ldap:// or ldaps://');
+      }
+      const useStartTls = startTls !== undefined ? startTls : Boolean(existing && existing.startTls);
+      // Passwords are sent to the directory in the clear otherwise
+      if (parsed.protocol === 'ldap:' && !useStartTls && !LOCAL_HOSTS.includes(parsed.hostname)) {
+        throw new Error('Use ldaps:// or StartTLS for directories on other hosts');
+      }
+    }
+    if (caCertificate) {
+      try {
+        new crypto.X509Certificate(caCertificate);
+      } catch (error) {
+        throw new Error('CA certificate must be a PEM encoded X.509 certificate');
+      }
+    }
+    for (const [name, filter, placeholder] of [['User', userFilter, 'email'], ['Group', groupFilter, 'dn']]) {
+      if (filter === undefined) continue;
+      if (!filter.includes(`{${placeholder}}`)) {
+        throw new Error(`${name} filter must contain {${placeholder}}`);
+      }
+      try {
+        ldapClient.encodeFilter(fillTemplate(filter, { [placeholder]: 'x' }));
+      } catch (error) {
+        throw new Error(`${name} filter is not a valid LDAP filter`);
+      }
+    }
+    if (emailDomains !== undefined) {
+      if (!Array.isArray(emailDomains) || emailDomains.length === 0) {
+        throw new Error('At least one email domain is required');
+      }
+      const domains = emailDomains.map((domain) => String(domain).toLowerCase());
+      const invalid = domains.filter((domain) => !DOMAIN_PATTERN.test(domain));
+      if (invalid.length > 0) throw new Error(`Invalid domains: ${invalid.join(', ')}`);
+      const taken = await LdapDirectory.findOne({
+        emailDomains: { $in: domains },
+        ...(existing ? { _id: { $ne: existing._id } } : {}),
+      });
+      if (taken) {
+        throw new Error(`Another directory already handles ${domains.filter((domain) => taken.emailDomains.includes(domain)).join(', ')}`);
+      }
+    }
+    if (groupMappings !== undefined) {
+      if (!Array.isArray(groupMappings)
+        || groupMappings.some((mapping) => !mapping.group || !['admin', 'doctor'].includes(mapping.role))) {
+        throw new Error('Group mappings need a group DN and a role of admin or doctor');
+      }
+      const unknown = groupMappings.flatMap((mapping) => mapping.permissions || [])
+        .filter((permission) => !KNOWN_PERMISSIONS.includes(permission));
+      if (unknown.length > 0) throw new Error(`Unknown permissions: ${unknown.join(', ')}`);
+    }
+  }
+
+  directoryFields(data) {
+    const fields = {};
+    for (const field of ['displayName', 'url', 'startTls', 'caCertificate', 'bindDn', 'userBaseDn', 'userFilter', 'nameAttribute', 'groupBaseDn', 'groupFilter', 'enabled']) {
+      if (data[field] !== undefined) fields[field] = data[field];
+    }
+    if (data.bindPassword !== undefined) {
+      fields.bindPasswordEncrypted = this.secretBox.encrypt(data.bindPassword);
+    }
+    if (data.emailDomains !== undefined) {
+      fields.emailDomains = data.emailDomains.map((domain) => domain.toLowerCase());
+    }
+    if (data.groupMappings !== undefined) {
+      fields.groupMappings = data.groupMappings.map(({ group, role, permissions: granted }) => ({
+        group,
+        role,
+        permissions: role === 'admin' ? granted || [] : [],
+      }));
+    }
+    return fields;
+  }
+
+  // Groups can hand admins any permission in their mappings, so whoever sets
+  // the mappings must hold all of it. This is the check delegationService
+  // makes; that service cannot be used here as it loads the auth service,
+  // which loads this one.
+  assertCanMap(claims, groupMappings, existing = []) {
+    const granted = (mappings) => mappings.flatMap((mapping) => mapping.permissions || []);
+    permissionRules.assertCanGrant(claims.permissions, granted(groupMappings), granted(existing));
+  }
+
+  async createDirectory(claims, data) {
+    await this.validateDirectoryData({ emailDomains: [], ...data });
+    this.assertCanMap(claims, data.groupMappings || []);
+    if (await LdapDirectory.findOne({ slug: data.slug })) {
+      throw new Error('A directory with this slug already exists');
+    }
+    const directory = await LdapDirectory.create({
+      ...this.directoryFields(data),
+      slug: data.slug,
+      displayName: data.displayName || data.slug,
+      groupMappings: this.directoryFields({ groupMappings: data.groupMappings || [] }).groupMappings,
+      createdBy: claims.user_id,
+      grantedBy: claims.user_id,
+      grantedByRole: claims.role,
+    });
+    return this.toPublic(directory);
+  }
+
+  toPublic(directory) {
+    return {
+      id: directory._id,
+      slug: directory.slug,
+      displayName: directory.displayName,
+      url: directory.url,
+      startTls: directory.startTls,
+      hasCaCertificate: Boolean(directory.caCertificate),
+      bindDn: directory.bindDn,
+      userBaseDn: directory.userBaseDn,
+      userFilter: directory.userFilter,
+      nameAttribute: directory.nameAttribute,
+      groupBaseDn: directory.groupBaseDn,
+      groupFilter: directory.groupFilter,
+      emailDomains: directory.emailDomains,
+      groupMappings: directory.groupMappings,
+      enabled: directory.enabled,
+      lastSyncAt: directory.lastSyncAt,
+      lastSyncError: directory.lastSyncError,
+      createdAt: directory.createdAt,
+      updatedAt: directory.updatedAt,
+    };
+  }
+
+  async listDirectories() {
+    const directories = await LdapDirectory.find().sort({ displayName: 1 });
+    return directories.map((directory) => this.toPublic(directory));
+  }
+
+  async listEnabledDirectories() {
+    return LdapDirectory.find({ enabled: true });
+  }
+
+  async getDirectory(slug) {
+    return LdapDirectory.findOne({ slug });
+  }
+
+  async updateDirectory(claims, slug, data) {
+    const directory = await LdapDirectory.findOne({ slug });
+    if (!directory) throw new Error('Directory not found');
+    await this.validateDirectoryData(data, directory);
+    if (data.groupMappings !== undefined) {
+      this.assertCanMap(claims, data.groupMappings, directory.groupMappings);
+      directory.grantedBy = claims.user_id;
+      directory.grantedByRole = claims.role;
+    }
+    Object.assign(directory, this.directoryFields(data));
+    await directory.save();
+    return this.toPublic(directory);
+  }
+
+  async deleteDirectory(slug) {
+    const directory = await LdapDirectory.findOne({ slug });
+    if (!directory) throw new Error('Directory not found');
+    await LdapDirectory.deleteOne({ _id: directory._id });
+  }
+
+  // The enabled directory responsible for an address, or null
+  async directoryFor(email) {
+    return LdapDirectory.findOne({ enabled: true, emailDomains: domainOf(email) });
+  }
+
+  // Opens a connection bound as the service account
+  async connect(directory) {
+    const client = await ldapClient.connect(directory.url, {
+      startTls: directory.startTls,
+      ca: directory.caCertificate,
+    });
+    try {
+      await client.bind(directory.bindDn, this.secretBox.decrypt(directory.bindPasswordEncrypted));
+    } catch (error) {
+      client.close();
+      throw error;
+    }
+    return client;
+  }
+
+  // Checks a password against the directory. Returns the directory user, or
+  // null when the address is unknown there or the password is wrong; throws
+  // when the directory cannot be reached or misbehaves.
+  async authenticate(directory, email, password) {
+    if (!password) {
+      return null;
+    }
+    const client = await this.connect(directory);
+    try {
+      const entry = await this.findEntry(client, directory, email);
+      if (!entry) {
+        return null;
+      }
+      try {
+        await client.bind(entry.dn, password);
+      } catch (error) {
+        if (error instanceof LdapError && error.resultCode === RESULT.INVALID_CREDENTIALS) {
+          return null;
+        }
+        throw error;
+      }
+      // Users may not be allowed to read groups; go back to the service account
+      await client.bind(directory.bindDn, this.secretBox.decrypt(directory.bindPasswordEncrypted));
+      return this.toDirectoryUser(directory, entry, await this.groupsOf(client, directory, entry));
+    } finally {
+      client.close();
+    }
+  }
+
+  // The directory user for an address without checking a password, or null
+  // when it is not in the directory. client is bound as the service account.
+  async lookup(client, directory, email) {
+    const entry = await this.findEntry(client, directory, email);
+    return entry ? this.toDirectoryUser(directory, entry, await this.groupsOf(client, directory, entry)) : null;
+  }
+
+  // Ambiguous matches count as no match
+  async findEntry(client, directory, email) {
+    const entries = await client.search(directory.userBaseDn, {
+      filter: fillTemplate(directory.userFilter, { email }),
+      attributes: [directory.nameAttribute, 'memberOf'],
+      sizeLimit: 2,
+    });
+    return entries.length === 1 ? entries[0] : null;
+  }
+
+  async groupsOf(client, directory, entry) {
+    if (!directory.groupBaseDn) {
+      return (entry.attributes.memberof || []).map((value) => normalizeDn(value.toString('utf8')));
+    }
+    const groups = await client.search(directory.groupBaseDn, {
+      filter: fillTemplate(directory.groupFilter, { dn: entry.dn }),
+      attributes: ['1.1'],
+    });
+    return groups.map((group) => normalizeDn(group.dn));
+  }
+
+  toDirectoryUser(directory, entry, groups) {
+    const mappings = directory.groupMappings.filter((mapping) => groups.includes(normalizeDn(mapping.group)));
+    const name = entry.attributes[directory.nameAttribute.toLowerCase()];
+    return {
+      dn: entry.dn,
+      name: name && name.length > 0 ? name[0].toString('utf8') : null,
+      groups,
+      roles: ['admin', 'doctor'].filter((role) => mappings.some((mapping) => mapping.role === role)),
+      permissions: [...new Set(mappings
+        .filter((mapping) => mapping.role === 'admin')
+        .flatMap((mapping) => mapping.permissions))],
+    };
+  }
+}
+
+module.exports = new LdapDirectoryService();
@@ -0,0 +1,121 @@
+// Per-account and per-IP login throttling with exponential backoff. Counters
+// live in MongoDB and are only changed with atomic updates, so every replica
+// sees the same state and concurrent failures are all counted.
+
+class LoginThrottledError extends Error {
+  constructor(retryAfterSeconds) {
+    super(`Too many failed login attempts, try again in ${retryAfterSeconds} seconds`);
+    this.name = 'LoginThrottledError';
+    this.statusCode = 429;
+    this.retryAfterSeconds = retryAfterSeconds;
+  }
+}
+
+class LoginThrottle {
+  constructor({
+    model,
+    accountThreshold,
+    ipThreshold,
+    baseLockSeconds,
+    maxLockSeconds,
+    windowSeconds,
+  }) {
+    this.model = model;
+    this.thresholds = { account: accountThreshold, ip: ipThreshold };
+    this.baseLockSeconds = baseLockSeconds;
+    this.maxLockSeconds = maxLockSeconds;
+    this.windowSeconds = windowSeconds;
+  }
+
+  static accountSubject(email) {
+    return String(email || '').trim().toLowerCase();
+  }
+
+  // Each failure past the threshold doubles the lock, up to maxLockSeconds
+  lockSeconds(kind, failures) {
+    const excess = failures - this.thresholds[kind];
+    if (excess < 0) return 0;
+    return Math.min(this.baseLockSeconds * 2 ** excess, this.maxLockSeconds);
+  }
+
+  // Throws LoginThrottledError while either the account or the IP is locked
+  async assertAllowed(email, ip) {
+    const subjects = [{ kind: 'account', subject: LoginThrottle.accountSubject(email) }];
+    if (ip) subjects.push({ kind: 'ip', subject: ip });
+    const now = new Date();
+    const locked = await this.model.find({
+      $or: subjects,
+      lockedUntil: { $gt: now },
+    });
+    if (locked.length > 0) {
+      const until = Math.max(...locked.map((record) => record.lockedUntil.getTime()));
+      throw new LoginThrottledError(Math.ceil((until - now.getTime()) / 1000));
+    }
+  }
+
+  async recordFailure(email, ip) {
+    await this.increment('account', LoginThrottle.accountSubject(email));
+    if (ip) {
+      await this.increment('ip', ip);
+    }
+  }
+
+  async increment(kind, subject) {
+    const now = new Date();
+    // A counter whose quiet period has passed starts over, even before the TTL monitor runs
+    await this.model.deleteOne({ kind, subject, expiresAt: { $lte: now } });
+    const record = await this.model.findOneAndUpdate(
+      { kind, subject },
+      {
+        $inc: { failures: 1 },
+        $set: { lastFailureAt: now },
+        $max: { expiresAt: new Date(now.getTime() + this.windowSeconds * 1000) },
+      },
+      { upsert: true, new: true },
+    );
+
+    const lockSeconds = this.lockSeconds(kind, record.failures);
+    if (lockSeconds > 0) {
+      const lockedUntil = new Date(now.getTime() + lockSeconds * 1000);
+      // $max keeps the longest lock when failures race
+      await this.model.updateOne(
+        { _id: record._id },
+        { $max: { lockedUntil, expiresAt: new Date(lockedUntil.getTime() + this.windowSeconds * 1000) } },
+      );
+    }
+  }
+
+  // A successful login clears the account's counter. The IP counter is kept
+  // so one valid account cannot be used to reset a password-spraying source.
+  async recordSuccess(email) {
+    await this.model.deleteOne({ kind: 'account', subject: LoginThrottle.accountSubject(email) });
+  }
+
+  async clearAccount(email) {
+    await this.model.deleteOne({ kind: 'account', subject: LoginThrottle.accountSubject(email) });
+  }
+
+  async listLocked(kind) {
+    const filter = { lockedUntil: { $gt: new Date() } };
+    if (kind) filter.kind = kind;
+    const records = await this.model.find(filter).sort({ lockedUntil: -1 });
+    return records.map((record) => ({
+      id: record._id,
+      kind: record.kind,
+      subject: record.subject,
+      failures: record.failures,
+      lastFailureAt: record.lastFailureAt,
+      lockedUntil: record.lockedUntil,
+    }));
+  }
+
+  async clear(id) {
+    const deleted = await this.model.findOneAndDelete({ _id: id });
+    if (!deleted) {
+      throw new Error('Lockout not found');
+    }
+  }
+}
+
+module.exports = LoginThrottle;
+module.exports.LoginThrottledError = LoginThrottledError;
@@ -0,0 +1,182 @@
+const crypto = require('crypto');
+const MfaEnrollment = require('../models/MfaEnrollment');
+const authService = require('./authServiceInstance');
+const totp = require('../utils/totp');
+const SecretBox = require('../utils/secretBox');
+const env = require('../config/env');
+
+const RECOVERY_CODE_COUNT = 10;
+
+const hashRecoveryCode = (code) => crypto.createHash('sha256')
+  .update(code.replace(/[\s-]/g, '').toLowerCase())
+  .digest('hex');
+
+const generateRecoveryCode = () => {
+  const raw = totp.base32Encode(crypto.randomBytes(7)).slice(0, 10).toLowerCase();
+  return `${raw.slice(0, 5)}-${raw.slice(5)}`;
+};
+
+class MfaService {
+  constructor() {
+    this.secretBox = new SecretBox(env.JWT_SECRET);
+  }
+
+  async getStatus(userId, role) {
+    const enrollment = await MfaEnrollment.findOne({ userId: userId.toString(), role });
+    return {
+      enabled: Boolean(enrollment && enrollment.enabled),
+      required: authService.mfaRequiredRoles.includes(role),
+      recoveryCodesRemaining: enrollment && enrollment.enabled ? enrollment.recoveryCodeHashes.length : 0,
+      enabledAt: enrollment && enrollment.enabled ? enrollment.enabledAt : null,
+    };
+  }
+
+  // Starts (or restarts) enrollment; the factor is only active once confirmed
+  async beginEnrollment(userId, role, accountName) {
+    const secret = totp.generateSecret();
+    await MfaEnrollment.findOneAndUpdate(
+      { userId: userId.toString(), role },
+      { pendingTotpSecretEncrypted: this.secretBox.encrypt(secret) },
+      { upsert: true, new: true },
+    );
+    return {
+      secret,
+      provisioningUri: totp.provisioningUri(secret, accountName, env.MFA_ISSUER_NAME),
+    };
+  }
+
+  // Self-service enrollment; replacing an active factor needs the current one
+  async beginSelfEnrollment(claims, factor) {
+    await authService.assertSecondFactorSession(claims);
+    const enrollment = await MfaEnrollment.findOne({ userId: claims.user_id, role: claims.role, enabled: true });
+    if (enrollment && !await this.verifySecondFactor(enrollment, factor)) {
+      throw new Error('Invalid verification code');
+    }
+    return this.beginEnrollment(claims.user_id, claims.role, claims.email);
+  }
+
+  async confirmEnrollment(userId, role, code) {
+    const enrollment = await MfaEnrollment.findOne({ userId: userId.toString(), role });
+    if (!enrollment || !enrollment.pendingTotpSecretEncrypted) {
+      throw new Error('No MFA enrollment in progress');
+    }
+    const secret = this.secretBox.decrypt(enrollment.pendingTotpSecretEncrypted);
+    const step = totp.verifyCode(secret, code);
+    if (step === null) {
+      throw new Error('Invalid verification code');
+    }
+
+    const recoveryCodes = Array.from({ length: RECOVERY_CODE_COUNT }, generateRecoveryCode);
+    enrollment.totpSecretEncrypted = enrollment.pendingTotpSecretEncrypted;
+    enrollment.pendingTotpSecretEncrypted = undefined;
+    enrollment.enabled = true;
+    enrollment.enabledAt = new Date();
+    enrollment.lastUsedStep = step;
+    enrollment.recoveryCodeHashes = recoveryCodes.map(hashRecoveryCode);
+    await enrollment.save();
+    return recoveryCodes;
+  }
+
+  // Accepts either a current TOTP code or an unused recovery code
+  async verifySecondFactor(enrollment, { code, recoveryCode }) {
+    if (recoveryCode) {
+      const hash = hashRecoveryCode(recoveryCode);
+      const consumed = await MfaEnrollment.findOneAndUpdate(
+        { _id: enrollment._id, recoveryCodeHashes: hash },
+        { $pull: { recoveryCodeHashes: hash } },
+      );
+      return Boolean(consumed);
+    }
+
+    const step = totp.verifyCode(this.secretBox.decrypt(enrollment.totpSecretEncrypted), code);
+    if (step === null) {
+      return false;
+    }
+    const accepted = await MfaEnrollment.findOneAndUpdate(
+      { _id: enrollment._id, lastUsedStep: { $lt: step } },
+      { lastUsedStep: step },
+    );
+    return Boolean(accepted);
+  }
+
+  async requireEnabledEnrollment(userId, role) {
+    const enrollment = await MfaEnrollment.findOne({ userId: userId.toString(), role, enabled: true });
+    if (!enrollment) {
+      throw new Error('MFA is not enabled');
+    }
+    return enrollment;
+  }
+
+  async regenerateRecoveryCodes(userId, role, code) {
+    const enrollment = await this.requireEnabledEnrollment(userId, role);
+    if (!await this.verifySecondFactor(enrollment, { code })) {
+      throw new Error('Invalid verification code');
+    }
+    const recoveryCodes = Array.from({ length: RECOVERY_CODE_COUNT }, generateRecoveryCode);
+    await MfaEnrollment.findByIdAndUpdate(enrollment._id, { recoveryCodeHashes: recoveryCodes.map(hashRecoveryCode) });
+    return recoveryCodes;
+  }
+
+  async disable(userId, role, factor) {
+    if (authService.mfaRequiredRoles.includes(role)) {
+      throw new Error('MFA is required for this role');
+    }
+    const enrollment = await this.requireEnabledEnrollment(userId, role);
+    if (!await this.verifySecondFactor(enrollment, factor)) {
+      throw new Error('Invalid verification code');
+    }
+    await MfaEnrollment.findByIdAndDelete(enrollment._id);
+  }
+
+  // Admin recovery for users who lost every second factor and their recovery codes
+  async resetForUser(userId) {
+    await MfaEnrollment.deleteMany({ userId: userId.toString() });
+    await authService.webAuthnCredentialModel.deleteMany({ userId: userId.toString() });
+  }
+
+  // Second step of a login that returned an MFA challenge
+  async verifyChallenge(mfaToken, factor) {
+    const challenge = await authService.beginMfaAttempt(mfaToken);
+    const enrollment = await MfaEnrollment.findOne({ userId: challenge.userId, role: challenge.role, enabled: true });
+    if (!enrollment) {
+      throw new Error('No authenticator app enrolled');
+    }
+    if (!await this.verifySecondFactor(enrollment, factor)) {
+      await authService.recordMfaChallengeFailure(challenge);
+      throw new Error('Invalid verification code');
+    }
+    return authService.completeMfaChallenge(mfaToken, challenge);
+  }
+
+  // Users whose role requires MFA enroll during their first login. Anyone
+  // who already has a second factor must use it instead.
+  async assertNoSecondFactor(challenge) {
+    if (await authService.hasSecondFactor(challenge.userId, challenge.role)) {
+      throw new Error('A second factor is already set up');
+    }
+  }
+
+  async beginChallengeEnrollment(mfaToken) {
+    const challenge = await authService.getMfaChallenge(mfaToken);
+    await this.assertNoSecondFactor(challenge);
+    const model = authService.modelForRole(challenge.role);
+    const user = await model.findById(challenge.userId);
+    return this.beginEnrollment(challenge.userId, challenge.role, user.email);
+  }
+
+  async confirmChallengeEnrollment(mfaToken, code) {
+    const challenge = await authService.beginMfaAttempt(mfaToken);
+    await this.assertNoSecondFactor(challenge);
+    let recoveryCodes;
+    try {
+      recoveryCodes = await this.confirmEnrollment(challenge.userId, challenge.role, code);
+    } catch (error) {
+      await authService.recordMfaChallengeFailure(challenge);
+      throw error;
+    }
+    const tokens = await authService.completeMfaChallenge(mfaToken, challenge);
+    return { ...tokens, recoveryCodes };
+  }
+}
+
+module.exports = new MfaService();
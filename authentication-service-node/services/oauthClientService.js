@@ -0,0 +1,144 @@
+const crypto = require('crypto');
+const OAuthClient = require('../models/OAuthClient');
+const permissions = require('../utils/permissions');
+
+const IDENTITY_SCOPES = ['openid', 'profile', 'email', 'roles', 'offline_access'];
+// Permission names are scopes too: an access token issued to a client only
+// carries the permissions it was granted as scopes
+const SUPPORTED_SCOPES = [...IDENTITY_SCOPES, ...Object.values(permissions)];
+const ROLES = ['super_admin', 'admin', 'doctor', 'patient'];
+const AUTH_METHODS = ['none', 'client_secret_basic', 'client_secret_post'];
+
+const hashSecret = (secret) => crypto.createHash('sha256').update(secret).digest('hex');
+
+class OAuthClientService {
+  validateClientData({ name, redirectUris, allowedScopes, allowedRoles, tokenEndpointAuthMethod, canIntrospect }, partial = false) {
+    if (!partial || name !== undefined) {
+      if (!name) throw new Error('Client name is required');
+    }
+    if (!partial || redirectUris !== undefined) {
+      if (!Array.isArray(redirectUris) || redirectUris.length === 0) {
+        throw new Error('At least one redirect URI is required');
+      }
+      for (const uri of redirectUris) {
+        let parsed;
+        try {
+          parsed = new URL(uri);
+        } catch (error) {
+          throw new Error(`Invalid redirect URI: ${uri}`);
+        }
+        if (parsed.hash) {
+          throw new Error(`Redirect URI must not contain a fragment: ${uri}`);
+        }
+      }
+    }
+    if (allowedScopes !== undefined) {
+      const unknown = allowedScopes.filter((scope) => !SUPPORTED_SCOPES.includes(scope));
+      if (unknown.length > 0) throw new Error(`Unsupported scopes: ${unknown.join(', ')}`);
+    }
+    if (allowedRoles !== undefined) {
+      const unknown = allowedRoles.filter((role) => !ROLES.includes(role));
+      if (unknown.length > 0) throw new Error(`Unknown roles: ${unknown.join(', ')}`);
+    }
+    if (tokenEndpointAuthMethod !== undefined && !AUTH_METHODS.includes(tokenEndpointAuthMethod)) {
+      throw new Error(`Unsupported token endpoint auth method: ${tokenEndpointAuthMethod}`);
+    }
+    if (canIntrospect !== undefined && typeof canIntrospect !== 'boolean') {
+      throw new Error('canIntrospect must be true or false');
+    }
+    // Anyone can present a public client's ID
+    if (canIntrospect && tokenEndpointAuthMethod === 'none') {
+      throw new Error('Public clients cannot introspect tokens');
+    }
+  }
+
+  // Returns the client secret once; only its hash is stored
+  async createClient(creatorId, clientData) {
+    this.validateClientData(clientData);
+    const tokenEndpointAuthMethod = clientData.tokenEndpointAuthMethod || 'client_secret_basic';
+    const clientSecret = tokenEndpointAuthMethod === 'none' ? null : crypto.randomBytes(32).toString('base64url');
+    const client = new OAuthClient({
+      clientId: crypto.randomBytes(16).toString('hex'),
+      clientSecretHash: clientSecret ? hashSecret(clientSecret) : undefined,
+      name: clientData.name,
+      redirectUris: clientData.redirectUris,
+      allowedScopes: clientData.allowedScopes && clientData.allowedScopes.length > 0
+        ? clientData.allowedScopes
+        : ['openid', 'profile', 'email'],
+      allowedRoles: clientData.allowedRoles || [],
+      tokenEndpointAuthMethod,
+      canIntrospect: Boolean(clientData.canIntrospect),
+      createdBy: creatorId,
+    });
+    await client.save();
+    return { client: this.toPublic(client), clientSecret };
+  }
+
+  toPublic(client) {
+    return {
+      clientId: client.clientId,
+      name: client.name,
+      redirectUris: client.redirectUris,
+      allowedScopes: client.allowedScopes,
+      allowedRoles: client.allowedRoles,
+      tokenEndpointAuthMethod: client.tokenEndpointAuthMethod,
+      canIntrospect: Boolean(client.canIntrospect),
+      createdBy: client.createdBy,
+      createdAt: client.createdAt,
+      updatedAt: client.updatedAt,
+    };
+  }
+
+  async listClients() {
+    const clients = await OAuthClient.find();
+    return clients.map((client) => this.toPublic(client));
+  }
+
+  async getClient(clientId) {
+    return OAuthClient.findOne({ clientId });
+  }
+
+  async updateClient(clientId, updateData) {
+    const { name, redirectUris, allowedScopes, allowedRoles, canIntrospect } = updateData;
+    const update = {};
+    if (name !== undefined) update.name = name;
+    if (redirectUris !== undefined) update.redirectUris = redirectUris;
+    if (allowedScopes !== undefined) update.allowedScopes = allowedScopes;
+    if (allowedRoles !== undefined) update.allowedRoles = allowedRoles;
+    if (canIntrospect !== undefined) update.canIntrospect = canIntrospect;
+    const existing = canIntrospect ? await OAuthClient.findOne({ clientId }) : null;
+    this.validateClientData({
+      ...update,
+      tokenEndpointAuthMethod: existing ? existing.tokenEndpointAuthMethod : undefined,
+    }, true);
+    const client = await OAuthClient.findOneAndUpdate({ clientId }, update, { new: true });
+    if (!client) throw new Error('OAuth client not found');
+    return this.toPublic(client);
+  }
+
+  async rotateClientSecret(clientId) {
+    const client = await OAuthClient.findOne({ clientId });
+    if (!client) throw new Error('OAuth client not found');
+    if (client.tokenEndpointAuthMethod === 'none') {
+      throw new Error('Public clients have no secret');
+    }
+    const clientSecret = crypto.randomBytes(32).toString('base64url');
+    client.clientSecretHash = hashSecret(clientSecret);
+    await client.save();
+    return clientSecret;
+  }
+
+  async deleteClient(clientId) {
+    return OAuthClient.findOneAndDelete({ clientId });
+  }
+
+  verifyClientSecret(client, clientSecret) {
+    if (!client.clientSecretHash || !clientSecret) return false;
+    const expected = Buffer.from(client.clientSecretHash, 'hex');
+    const actual = Buffer.from(hashSecret(clientSecret), 'hex');
+    return crypto.timingSafeEqual(expected, actual);
+  }
+}
+
+module.exports = new OAuthClientService();
+module.exports.SUPPORTED_SCOPES = SUPPORTED_SCOPES;
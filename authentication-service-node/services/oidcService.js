@@ -0,0 +1,421 @@
+const crypto = require('crypto');
+const authService = require('./authServiceInstance');
+const oauthClientService = require('./oauthClientService');
+const serviceAccountService = require('./serviceAccountService');
+const OAuthConsent = require('../models/OAuthConsent');
+const cacheStore = require('../utils/cacheStore');
+const { CacheUnavailableError } = cacheStore;
+const jwt = require('../utils/jwt');
+const permissionRules = require('../utils/permissionRules');
+const env = require('../config/env');
+
+const AUTHORIZATION_CODE_TTL_SECONDS = 60;
+const ID_TOKEN_LIFETIME_SECONDS = 15 * 60;
+// Plain JWT as OpenID Connect expects; access tokens are at+jwt, so neither
+// verifies as the other
+const ID_TOKEN_TYPE = 'JWT';
+
+// Errors reported in the RFC 6749 error response format. Authorization
+// errors found once the redirect_uri is trusted go back to the client there.
+class OAuthError extends Error {
+  constructor(error, description, status = 400) {
+    super(description);
+    this.error = error;
+    this.status = status;
+    this.redirectUri = null;
+  }
+
+  redirectTo({ redirect_uri: redirectUri, state }) {
+    const redirect = new URL(redirectUri);
+    redirect.searchParams.set('error', this.error);
+    redirect.searchParams.set('error_description', this.message);
+    if (state) redirect.searchParams.set('state', state);
+    this.redirectUri = redirect.toString();
+    return this;
+  }
+}
+
+const hashCode = (code) => crypto.createHash('sha256').update(code).digest('hex');
+
+const base64UrlSha256 = (value) => crypto.createHash('sha256').update(value).digest('base64url');
+
+class OidcService {
+  get issuer() {
+    return env.OIDC_ISSUER;
+  }
+
+  discoveryDocument() {
+    const issuer = this.issuer;
+    return {
+      issuer,
+      authorization_endpoint: `${issuer}/oauth/authorize`,
+      token_endpoint: `${issuer}/oauth/token`,
+      userinfo_endpoint: `${issuer}/oauth/userinfo`,
+      jwks_uri: `${issuer}/.well-known/jwks.json`,
+      response_types_supported: ['code'],
+      grant_types_supported: ['authorization_code', 'refresh_token', 'client_credentials'],
+      subject_types_supported: ['public'],
+      id_token_signing_alg_values_supported: [env.JWT_SIGNING_ALG],
+      scopes_supported: oauthClientService.SUPPORTED_SCOPES,
+      token_endpoint_auth_methods_supported: ['client_secret_basic', 'client_secret_post', 'private_key_jwt', 'none'],
+      token_endpoint_auth_signing_alg_values_supported: Object.keys(jwt.ALGORITHMS),
+      introspection_endpoint: `${issuer}/oauth/introspect`,
+      introspection_endpoint_auth_methods_supported: ['client_secret_basic', 'client_secret_post', 'private_key_jwt'],
+      code_challenge_methods_supported: ['S256'],
+      claims_supported: ['sub', 'iss', 'aud', 'exp', 'iat', 'auth_time', 'nonce', 'email', 'email_verified', 'name', 'role', 'permissions'],
+    };
+  }
+
+  // Checks the parts of an authorization request that can be verified before
+  // the user signs in. Until the client and redirect_uri are known good,
+  // errors must not redirect back to the client; after that they do.
+  async validateAuthorizationRequest(params) {
+    const client = params.client_id ? await oauthClientService.getClient(params.client_id) : null;
+    if (!client) {
+      throw new OAuthError('invalid_client', 'Unknown client');
+    }
+    if (!params.redirect_uri || !client.redirectUris.includes(params.redirect_uri)) {
+      throw new OAuthError('invalid_request', 'redirect_uri is not registered for this client');
+    }
+    const fail = (error, description) => new OAuthError(error, description).redirectTo(params);
+    if (params.response_type !== 'code') {
+      throw fail('unsupported_response_type', 'Only the code response type is supported');
+    }
+    const scopes = (params.scope || '').split(' ').filter(Boolean);
+    if (!scopes.includes('openid')) {
+      throw fail('invalid_scope', 'The openid scope is required');
+    }
+    const unknown = scopes.filter((scope) => !client.allowedScopes.includes(scope));
+    if (unknown.length > 0) {
+      throw fail('invalid_scope', `Scopes not allowed for this client: ${unknown.join(', ')}`);
+    }
+    if (!params.code_challenge || params.code_challenge_method !== 'S256') {
+      throw fail('invalid_request', 'PKCE with code_challenge_method S256 is required');
+    }
+    return { client, scopes };
+  }
+
+  // Completes an authorization request for a signed-in user. The user first
+  // sees which scopes the client asks for, unless they agreed to all of them
+  // before; params.consent carries their answer ("approve" or "deny").
+  // Returns { redirectTo } for the user agent, or { consentRequired } with
+  // what the consent screen shows.
+  async authorize(params, claims, context = {}) {
+    const { client, scopes } = await this.validateAuthorizationRequest(params);
+    if (client.allowedRoles.length > 0 && !client.allowedRoles.includes(claims.role)) {
+      throw new OAuthError('access_denied', 'This account may not sign in to this client').redirectTo(params);
+    }
+    if (params.consent === 'deny') {
+      throw new OAuthError('access_denied', 'The user denied the request').redirectTo(params);
+    }
+
+    const consentKey = { userId: claims.user_id, role: claims.role, clientId: client.clientId };
+    const consent = await OAuthConsent.findOne(consentKey);
+    const prompts = (params.prompt || '').split(' ');
+    const consented = !prompts.includes('consent')
+      && Boolean(consent) && scopes.every((scope) => consent.scopes.includes(scope));
+    if (!consented && params.consent !== 'approve') {
+      if (prompts.includes('none')) {
+        throw new OAuthError('consent_required', 'The user has not approved this client').redirectTo(params);
+      }
+      return { consentRequired: true, client: { clientId: client.clientId, name: client.name }, scopes };
+    }
+    if (!consented) {
+      const granted = [...new Set([...(consent ? consent.scopes : []), ...scopes])];
+      await OAuthConsent.findOneAndUpdate(consentKey, { $set: { scopes: granted } }, { upsert: true });
+    }
+
+    const code = crypto.randomBytes(32).toString('base64url');
+    await cacheStore.set(`oauth_code:${hashCode(code)}`, JSON.stringify({
+      clientId: client.clientId,
+      redirectUri: params.redirect_uri,
+      userId: claims.user_id,
+      role: claims.role,
+      scope: scopes.join(' '),
+      nonce: params.nonce || null,
+      codeChallenge: params.code_challenge,
+      // When the user signed in, not when this access token was issued
+      authTime: claims.auth_time || null,
+      // The user's browser, recorded on the session the code starts
+      context,
+    }), AUTHORIZATION_CODE_TTL_SECONDS);
+
+    const redirect = new URL(params.redirect_uri);
+    redirect.searchParams.set('code', code);
+    if (params.state) redirect.searchParams.set('state', params.state);
+    return { redirectTo: redirect.toString() };
+  }
+
+  // Client credentials come from HTTP Basic auth or the form body
+  clientCredentials(authorizationHeader, body) {
+    let clientId = body.client_id;
+    let clientSecret = body.client_secret;
+    let method = clientSecret ? 'client_secret_post' : 'none';
+    if (authorizationHeader && authorizationHeader.startsWith('Basic ')) {
+      const decoded = Buffer.from(authorizationHeader.slice(6), 'base64').toString('utf8');
+      const separator = decoded.indexOf(':');
+      clientId = decodeURIComponent(decoded.slice(0, separator));
+      clientSecret = decodeURIComponent(decoded.slice(separator + 1));
+      method = 'client_secret_basic';
+    }
+    return { clientId, clientSecret, method };
+  }
+
+  // Whether a token or introspection request comes from a service account
+  // rather than an OAuth client
+  isServiceAccountRequest(authorizationHeader, body) {
+    return Boolean(body.client_assertion)
+      || serviceAccountService.isServiceClientId(this.clientCredentials(authorizationHeader, body).clientId);
+  }
+
+  async authenticateServiceAccount(authorizationHeader, body) {
+    let account;
+    if (body.client_assertion) {
+      const endpoints = [`${this.issuer}/oauth/token`, `${this.issuer}/oauth/introspect`, this.issuer];
+      account = await serviceAccountService.authenticateWithAssertion(body.client_assertion_type, body.client_assertion, endpoints);
+    } else {
+      const { clientId, clientSecret, method } = this.clientCredentials(authorizationHeader, body);
+      account = await serviceAccountService.authenticateWithSecret(clientId, clientSecret, method);
+    }
+    if (!account) {
+      throw new OAuthError('invalid_client', 'Client authentication failed', 401);
+    }
+    return account;
+  }
+
+  async authenticateClient(authorizationHeader, body) {
+    const { clientId, clientSecret, method } = this.clientCredentials(authorizationHeader, body);
+    const client = clientId ? await oauthClientService.getClient(clientId) : null;
+    if (!client || client.tokenEndpointAuthMethod !== method) {
+      throw new OAuthError('invalid_client', 'Client authentication failed', 401);
+    }
+    if (method !== 'none' && !oauthClientService.verifyClientSecret(client, clientSecret)) {
+      throw new OAuthError('invalid_client', 'Client authentication failed', 401);
+    }
+    return client;
+  }
+
+  async exchangeToken(authorizationHeader, body) {
+    if (body.grant_type === 'client_credentials') {
+      return this.exchangeClientCredentials(authorizationHeader, body);
+    }
+    const client = await this.authenticateClient(authorizationHeader, body);
+    switch (body.grant_type) {
+      case 'authorization_code':
+        return this.exchangeAuthorizationCode(client, body);
+      case 'refresh_token':
+        return this.exchangeRefreshToken(client, body);
+      default:
+        throw new OAuthError('unsupported_grant_type', `Unsupported grant type: ${body.grant_type}`);
+    }
+  }
+
+  // Service accounts only; scope narrows the token to some of the account's
+  // permissions. No refresh token: the service asks again when it expires.
+  async exchangeClientCredentials(authorizationHeader, body) {
+    if (!this.isServiceAccountRequest(authorizationHeader, body)) {
+      throw new OAuthError('unauthorized_client', 'Only service accounts may use the client_credentials grant');
+    }
+    const account = await this.authenticateServiceAccount(authorizationHeader, body);
+    let issued;
+    try {
+      issued = await serviceAccountService.issueToken(account, (body.scope || '').split(' ').filter(Boolean));
+    } catch (error) {
+      throw new OAuthError('invalid_scope', error.message);
+    }
+    return {
+      access_token: issued.token,
+      token_type: 'Bearer',
+      expires_in: issued.expiresIn,
+      scope: issued.permissions.join(' '),
+    };
+  }
+
+  async exchangeAuthorizationCode(client, body) {
+    const stored = body.code ? await cacheStore.take(`oauth_code:${hashCode(body.code)}`) : null;
+    if (!stored) {
+      throw new OAuthError('invalid_grant', 'Invalid or expired authorization code');
+    }
+    const grant = JSON.parse(stored);
+    if (grant.clientId !== client.clientId || grant.redirectUri !== body.redirect_uri) {
+      throw new OAuthError('invalid_grant', 'Authorization code was not issued to this client');
+    }
+    if (!body.code_verifier || base64UrlSha256(body.code_verifier) !== grant.codeChallenge) {
+      throw new OAuthError('invalid_grant', 'PKCE verification failed');
+    }
+
+    const { user, identity } = await this.loadUser(grant.role, grant.userId);
+    const scopes = grant.scope.split(' ');
+    const tokens = await authService.issueTokens(identity, {
+      clientId: client.clientId,
+      scope: grant.scope,
+      authTime: grant.authTime,
+      context: grant.context,
+    });
+    const idToken = await this.signIdToken(user, identity, client.clientId, scopes, {
+      nonce: grant.nonce,
+      authTime: grant.authTime,
+    });
+    return this.tokenResponse(tokens, grant.scope, idToken, scopes.includes('offline_access'));
+  }
+
+  async exchangeRefreshToken(client, body) {
+    let tokens;
+    try {
+      tokens = await authService.refreshToken(body.refresh_token, client.clientId);
+    } catch (error) {
+      throw new OAuthError('invalid_grant', error.message);
+    }
+    const claims = jwt.decode(tokens.token);
+    const { user, identity } = await this.loadUser(claims.role, claims.user_id);
+    const scopes = (claims.scope || '').split(' ');
+    const idToken = await this.signIdToken(user, identity, client.clientId, scopes, {});
+    return this.tokenResponse(tokens, claims.scope, idToken, true);
+  }
+
+  // RFC 7662. Only access tokens are described; anything else, and any token
+  // that was revoked or whose user may no longer sign in, is inactive.
+  async introspect(authorizationHeader, body) {
+    await this.authenticateIntrospectionCaller(authorizationHeader, body);
+    if (!body.token) {
+      throw new OAuthError('invalid_request', 'token is required');
+    }
+    const claims = await this.activeClaims(body.token);
+    if (!claims) {
+      return { active: false };
+    }
+    const response = {
+      active: true,
+      sub: claims.user_id,
+      username: claims.email,
+      token_type: 'Bearer',
+      iss: this.issuer,
+      iat: claims.iat,
+      exp: claims.exp,
+      aud: claims.aud,
+      jti: claims.jti,
+      role: claims.role,
+      // Spelled out, so resource servers need not know the wildcard rules
+      permissions: permissionRules.expand(claims.permissions),
+      email_verified: claims.email_verified,
+    };
+    for (const name of ['client_id', 'scope', 'patientId', 'sid', 'amr', 'act']) {
+      if (claims[name] !== undefined) response[name] = claims[name];
+    }
+    return response;
+  }
+
+  // OAuth clients allowed to introspect, or service accounts holding token:introspect
+  async authenticateIntrospectionCaller(authorizationHeader, body) {
+    if (this.isServiceAccountRequest(authorizationHeader, body)) {
+      const account = await this.authenticateServiceAccount(authorizationHeader, body);
+      if (!account.permissions.includes('token:introspect')) {
+        throw new OAuthError('unauthorized_client', 'This service account may not introspect tokens', 403);
+      }
+      return;
+    }
+    const client = await this.authenticateClient(authorizationHeader, body);
+    if (!client.canIntrospect) {
+      throw new OAuthError('unauthorized_client', 'This client may not introspect tokens', 403);
+    }
+  }
+
+  async activeClaims(token) {
+    try {
+      const claims = await authService.validateToken(token);
+      if (claims.role === 'service') {
+        return await serviceAccountService.isActive(claims.user_id) ? claims : null;
+      }
+      return await authService.loadActiveProfile(claims.user_id, claims.role) ? claims : null;
+    } catch (error) {
+      // Not "inactive": callers would cache that answer for a valid token
+      if (error instanceof CacheUnavailableError) throw error;
+      return null;
+    }
+  }
+
+  // Seconds a resource server may reuse an introspection response; never
+  // past the token's own expiry
+  introspectionMaxAge(response) {
+    if (!response.active) {
+      return env.INTROSPECTION_CACHE_SECONDS;
+    }
+    const remaining = response.exp - Math.floor(Date.now() / 1000);
+    return Math.max(0, Math.min(env.INTROSPECTION_CACHE_SECONDS, remaining));
+  }
+
+  tokenResponse(tokens, scope, idToken, includeRefreshToken) {
+    const response = {
+      access_token: tokens.token,
+      token_type: 'Bearer',
+      expires_in: tokens.expiresIn,
+      id_token: idToken,
+      scope,
+    };
+    if (includeRefreshToken) {
+      response.refresh_token = tokens.refreshToken;
+    }
+    return response;
+  }
+
+  // The profile plus the claims it is issued
+  async loadUser(role, userId) {
+    let loaded;
+    try {
+      loaded = await authService.loadActiveProfile(userId, role);
+    } catch (error) {
+      throw new OAuthError('invalid_grant', error.message);
+    }
+    if (!loaded) {
+      throw new OAuthError('invalid_grant', 'User no longer exists');
+    }
+    return { user: loaded.user, identity: authService.identityFor(loaded.user, role, loaded.account) };
+  }
+
+  // Claims released for the granted scopes, shared by ID tokens and userinfo
+  scopedClaims(user, identity, scopes) {
+    const claims = { sub: identity.userId.toString() };
+    if (scopes.includes('email')) {
+      claims.email = identity.email;
+      claims.email_verified = identity.emailVerified;
+    }
+    if (scopes.includes('profile')) {
+      claims.name = user.name || user.username;
+    }
+    if (scopes.includes('roles')) {
+      claims.role = identity.role;
+      claims.permissions = identity.permissions;
+    }
+    return claims;
+  }
+
+  async signIdToken(user, identity, clientId, scopes, { nonce, authTime }) {
+    const payload = {
+      ...this.scopedClaims(user, identity, scopes),
+      iss: this.issuer,
+      aud: clientId,
+    };
+    if (nonce) payload.nonce = nonce;
+    if (authTime) payload.auth_time = authTime;
+
+    const key = await authService.keyRing.getSigningKey();
+    return jwt.sign(payload, {
+      privateKey: key.privateKey,
+      alg: key.alg,
+      kid: key.kid,
+      expiresIn: ID_TOKEN_LIFETIME_SECONDS,
+      jwtid: crypto.randomUUID(),
+      typ: ID_TOKEN_TYPE,
+    });
+  }
+
+  async userInfo(claims) {
+    const { user, identity } = await this.loadUser(claims.role, claims.user_id);
+    // Tokens from the password login carry no scope and get the full profile
+    const scopes = claims.scope ? claims.scope.split(' ') : ['openid', 'profile', 'email', 'roles'];
+    return this.scopedClaims(user, identity, scopes);
+  }
+}
+
+module.exports = new OidcService();
+module.exports.OAuthError = OAuthError;
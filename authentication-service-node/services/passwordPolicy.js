@@ -0,0 +1,153 @@
+const bcrypt = require('bcryptjs');
+const PasswordHistory = require('../models/PasswordHistory');
+const BreachedPasswordList = require('../utils/breachedPasswords');
+const passwordStrength = require('../utils/passwordStrength');
+const env = require('../config/env');
+
+const CHARACTER_CLASSES = {
+  lower: { regex: /[a-z]/, message: 'a lowercase letter' },
+  upper: { regex: /[A-Z]/, message: 'an uppercase letter' },
+  digit: { regex: /\d/, message: 'a digit' },
+  symbol: { regex: /[^A-Za-z0-9]/, message: 'a symbol' },
+};
+
+// Carries every rule a password broke so clients can show them all at once
+class PasswordPolicyError extends Error {
+  constructor(reasons) {
+    super(reasons.map((reason) => reason.message).join('; '));
+    this.name = 'PasswordPolicyError';
+    this.statusCode = 400;
+    this.reasons = reasons;
+  }
+}
+
+class PasswordPolicy {
+  constructor({
+    minLength,
+    maxLength,
+    requiredClasses,
+    minStrength,
+    historySize,
+    breachedPasswords,
+  }) {
+    this.minLength = minLength;
+    this.maxLength = maxLength;
+    this.requiredClasses = requiredClasses;
+    this.minStrength = minStrength;
+    this.historySize = historySize;
+    this.breachedPasswords = breachedPasswords;
+  }
+
+  // Public description of the rules, e.g. for signup forms
+  describe() {
+    return {
+      minLength: this.minLength,
+      maxLength: this.maxLength,
+      requiredClasses: this.requiredClasses,
+      minStrength: this.minStrength,
+      historySize: this.historySize,
+    };
+  }
+
+  // Returns the list of broken rules; empty means the password is acceptable.
+  // userInputs are the account's own details (email, name) that make weak passwords;
+  // userId and role enable the history check for existing accounts.
+  async check(password, { userInputs = [], userId = null, role = null, currentPasswordHash = null } = {}) {
+    if (typeof password !== 'string' || password.length === 0) {
+      return [{ code: 'required', message: 'Password is required' }];
+    }
+
+    const reasons = [];
+    if (password.length < this.minLength) {
+      reasons.push({ code: 'too_short', message: `Password must be at least ${this.minLength} characters long` });
+    }
+    // bcrypt only looks at the first 72 bytes
+    if (password.length > this.maxLength || Buffer.byteLength(password) > 72) {
+      reasons.push({ code: 'too_long', message: `Password must be at most ${this.maxLength} characters long` });
+    }
+    for (const name of this.requiredClasses) {
+      if (!CHARACTER_CLASSES[name].regex.test(password)) {
+        reasons.push({ code: `missing_${name}`, message: `Password must contain ${CHARACTER_CLASSES[name].message}` });
+      }
+    }
+
+    const strength = passwordStrength.estimate(password, userInputs);
+    if (strength.score < this.minStrength) {
+      reasons.push({
+        code: 'too_weak',
+        message: 'Password is too easy to guess',
+        score: strength.score,
+        patterns: strength.patterns,
+      });
+    }
+
+    if (await this.breachedPasswords.contains(password)) {
+      reasons.push({ code: 'breached', message: 'Password has appeared in a data breach' });
+    }
+
+    if (userId && await this.isReused(password, userId, role, currentPasswordHash)) {
+      reasons.push({
+        code: 'reused',
+        message: `Password must differ from your last ${this.historySize} passwords`,
+      });
+    }
+    return reasons;
+  }
+
+  async assertValid(password, context) {
+    const reasons = await this.check(password, context);
+    if (reasons.length > 0) {
+      throw new PasswordPolicyError(reasons);
+    }
+  }
+
+  // Validates and hashes a new password
+  async hash(password, context) {
+    await this.assertValid(password, context);
+    return bcrypt.hash(password, 10);
+  }
+
+  async isReused(password, userId, role, currentPasswordHash) {
+    if (currentPasswordHash && await bcrypt.compare(password, currentPasswordHash)) {
+      return true;
+    }
+    if (this.historySize <= 0) {
+      return false;
+    }
+    const history = await PasswordHistory.find({ userId: userId.toString(), role })
+      .sort({ createdAt: -1 })
+      .limit(this.historySize);
+    for (const entry of history) {
+      if (await bcrypt.compare(password, entry.passwordHash)) {
+        return true;
+      }
+    }
+    return false;
+  }
+
+  // Called whenever an account's password is set; keeps the newest entries only
+  async remember(userId, role, passwordHash) {
+    if (this.historySize <= 0) {
+      return;
+    }
+    await PasswordHistory.create({ userId: userId.toString(), role, passwordHash });
+    const stale = await PasswordHistory.find({ userId: userId.toString(), role })
+      .sort({ createdAt: -1 })
+      .skip(this.historySize);
+    if (stale.length > 0) {
+      await PasswordHistory.deleteMany({ _id: { $in: stale.map((entry) => entry._id) } });
+    }
+  }
+}
+
+module.exports = new PasswordPolicy({
+  minLength: env.PASSWORD_MIN_LENGTH,
+  maxLength: env.PASSWORD_MAX_LENGTH,
+  requiredClasses: env.PASSWORD_REQUIRED_CLASSES,
+  minStrength: env.PASSWORD_MIN_STRENGTH,
+  historySize: env.PASSWORD_HISTORY_SIZE,
+  breachedPasswords: new BreachedPasswordList(env.BREACHED_PASSWORDS_PATH),
+});
+module.exports.PasswordPolicyError = PasswordPolicyError;
+module.exports.CHARACTER_CLASSES = CHARACTER_CLASSES;
+module.exports.PasswordPolicy = PasswordPolicy;
@@ -0,0 +1,92 @@
+const crypto = require('crypto');
+const PasswordResetToken = require('../models/PasswordResetToken');
+const authService = require('./authServiceInstance');
+const { normalizeEmail } = require('./accountStore');
+const cacheStore = require('../utils/cacheStore');
+const mailer = require('../utils/mailer');
+const env = require('../config/env');
+
+// At most one reset email per address in this window
+const REQUEST_COOLDOWN_SECONDS = 60;
+
+const hashToken = (token) => crypto.createHash('sha256').update(token).digest('hex');
+
+class PasswordResetService {
+  // Always succeeds from the caller's point of view so the endpoint cannot be
+  // used to find out which addresses have accounts
+  async requestReset(email, requestedIp) {
+    const normalized = normalizeEmail(email);
+    if (!authService.validateEmail(normalized)) {
+      throw new Error('Invalid email format');
+    }
+    if (await cacheStore.get(`password_reset_sent:${normalized}`)) {
+      return;
+    }
+    await cacheStore.set(`password_reset_sent:${normalized}`, '1', REQUEST_COOLDOWN_SECONDS);
+
+    const account = await authService.accountStore.findByEmail(normalized);
+    if (!account) {
+      return;
+    }
+    const accountId = account._id.toString();
+
+    // Only the newest link works
+    await PasswordResetToken.updateMany({ accountId, usedAt: null }, { usedAt: new Date() });
+    const token = crypto.randomBytes(32).toString('base64url');
+    await PasswordResetToken.create({
+      tokenHash: hashToken(token),
+      accountId,
+      expiresAt: new Date(Date.now() + env.PASSWORD_RESET_TTL_MINUTES * 60 * 1000),
+      requestedIp,
+    });
+
+    const link = `${env.PASSWORD_RESET_URL}?token=${encodeURIComponent(token)}`;
+    try {
+      await mailer.send({
+        to: account.email,
+        subject: 'Reset your password',
+        text: [
+          'We received a request to reset the password for your account.',
+          '',
+          `Open this link within ${env.PASSWORD_RESET_TTL_MINUTES} minutes to choose a new password:`,
+          link,
+          '',
+          'If you did not ask for this, you can ignore this email; your password will not change.',
+        ].join('\n'),
+      });
+    } catch (error) {
+      console.error(`Failed to send password reset email: ${error.message}`);
+    }
+  }
+
+  async resetPassword(token, newPassword) {
+    const record = token ? await PasswordResetToken.findOne({ tokenHash: hashToken(token) }) : null;
+    if (!record || record.usedAt || record.expiresAt <= new Date()) {
+      throw new Error('Invalid or expired reset token');
+    }
+    const account = await authService.accountStore.findById(record.accountId);
+    if (!account) {
+      throw new Error('Invalid or expired reset token');
+    }
+    // A rejected password leaves the link usable for another attempt
+    const passwordHash = await authService.hashNewPassword(account, newPassword);
+
+    // Claim the token before changing anything so it cannot be used twice
+    const claimed = await PasswordResetToken.findOneAndUpdate(
+      { _id: record._id, usedAt: null },
+      { usedAt: new Date() },
+    );
+    if (!claimed) {
+      throw new Error('Invalid or expired reset token');
+    }
+
+    // Following the emailed link proves ownership of the address
+    if (!account.emailVerified) {
+      account.emailVerified = true;
+      account.emailVerifiedAt = new Date();
+    }
+    await authService.replacePassword(account, passwordHash);
+  }
+}
+
+module.exports = new PasswordResetService();
@@ -1,31 +1,30 @@
 const Patient = require('../models/Patient');
-const bcrypt = require('bcryptjs');
+const authService = require('./authServiceInstance');
+const emailVerificationService = require('./emailVerificationService');
+const careRelationshipService = require('./careRelationshipService');
 
 class PatientService {
+  // Someone who already has an account, e.g. as a doctor, adds the patient
+  // role by registering with that account's password
   async registerPatient(patientData, password) {
-    if (!this.validateEmail(patientData.email)) {
-      throw new Error('Invalid email format');
+    // Credentials and verification belong to the account, never the profile
+    const { passwordHash, accountId, emailVerified, emailVerifiedAt, ...profile } = patientData;
+    const { account } = await authService.registerAccount('patient', profile, password, { joinExisting: true });
+    if (!account.emailVerified) {
+      await emailVerificationService.sendVerification(account);
     }
-    if (!password || password.length < 6) {
-      throw new Error('Password must be at least 6 characters long');
-    }
-    const existing = await Patient.findOne({ email: patientData.email });
-    if (existing) {
-      throw new Error('User already exists');
-    }
-    const hashedPassword = await bcrypt.hash(password, 10);
-    patientData.passwordHash = hashedPassword;
-    const patient = new Patient(patientData);
-    await patient.save();
   }
 
-  validateEmail(email) {
-    const emailRegex = /^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$/;
-    return emailRegex.test(email);
+  // Given a doctor, only the patients they have a care relationship with
+  async listPatients(doctorId = null) {
+    if (!doctorId) {
+      return Patient.find();
+    }
+    return Patient.find({ _id: { $in: await careRelationshipService.patientIdsFor(doctorId) } });
   }
 
-  async listPatients() {
-    return Patient.find();
+  async listCareTeam(patientId) {
+    return careRelationshipService.listForPatient(patientId);
   }
 
   async getPatient(id) {
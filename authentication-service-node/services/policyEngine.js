@@ -0,0 +1,172 @@
+const { ObjectId } = require('mongoose').Types;
+const authService = require('./authServiceInstance');
+const careRelationshipService = require('./careRelationshipService');
+const breakGlassService = require('./breakGlassService');
+const Principal = require('../utils/principal');
+const {
+  PermissionPatientList,
+  PermissionPatientView,
+  PermissionPatientHistory,
+} = require('../utils/permissions');
+
+// Roles that act for the organisation rather than for one patient; they are
+// limited by their permissions only
+const STAFF_ROLES = ['super_admin', 'admin', 'service'];
+
+// A rule's test resolves to allow(reason) or deny(reason); the reasons make
+// up the explanation of a decision
+const allow = (reason, details = {}) => ({ matched: true, reason, ...details });
+const deny = (reason) => ({ matched: false, reason });
+
+const staffWith = (permission) => ({
+  name: 'staff',
+  description: `Staff and services holding ${permission}`,
+  test: ({ subject }) => {
+    if (!STAFF_ROLES.includes(subject.role)) return deny(`role ${subject.role} is not a staff role`);
+    if (!subject.can(permission)) return deny(`lacks ${permission}`);
+    return allow(`${subject.role} holding ${permission}`);
+  },
+});
+
+const patientSelf = (permission) => ({
+  name: 'self',
+  description: 'The patient the record belongs to',
+  test: ({ subject, resource }) => {
+    if (subject.role !== 'patient') return deny('not a patient');
+    if (!subject.can(permission)) return deny(`lacks ${permission}`);
+    if (!subject.patientId || subject.patientId !== resource.id) return deny('not their own record');
+    return allow('own record');
+  },
+});
+
+const treatingDoctor = (permission) => ({
+  name: 'treating-doctor',
+  description: 'A doctor with an appointment or care-team link to the patient',
+  test: async ({ subject, resource }) => {
+    if (subject.role !== 'doctor') return deny('not a doctor');
+    if (!subject.can(permission)) return deny(`lacks ${permission}`);
+    const link = await careRelationshipService.findActive(subject.user_id, resource.id);
+    if (!link) return deny('no appointment or care-team link with this patient');
+    return allow(`${link.source} link`, { relationship: careRelationshipService.toPublic(link) });
+  },
+});
+
+// Emergency access the doctor asked for themselves; a support user
+// impersonating them does not inherit it
+const breakGlass = (permission) => ({
+  name: 'break-glass',
+  description: 'A doctor with an active break-glass grant for the patient',
+  test: async ({ subject, resource }) => {
+    if (subject.role !== 'doctor') return deny('not a doctor');
+    if (subject.impersonating) return deny('break-glass access is not available while impersonating');
+    if (!subject.can(permission)) return deny(`lacks ${permission}`);
+    const grant = await breakGlassService.findActive(subject.user_id, resource.id);
+    if (!grant) return deny('no active break-glass grant for this patient');
+    return allow(`break-glass grant until ${grant.expiresAt.toISOString()}`, { breakGlassGrantId: grant._id.toString() });
+  },
+});
+
+// Lists cannot be checked record by record, so a rule may allow the
+// request on condition that the handler narrows what it returns
+const doctorOwnPatients = {
+  name: 'treating-doctor',
+  description: 'A doctor, limited to patients they have a link with',
+  constraint: 'own_patients',
+  test: ({ subject }) => {
+    if (subject.role !== 'doctor') return deny('not a doctor');
+    if (!subject.can(PermissionPatientList)) return deny(`lacks ${PermissionPatientList}`);
+    return allow('doctor, own patients only');
+  },
+};
+
+// Each policy allows a request when any of its rules does, checked in order
+const POLICIES = {
+  'patient.list': {
+    description: 'List patient records',
+    resource: 'patient',
+    rules: [staffWith(PermissionPatientList), doctorOwnPatients],
+  },
+  'patient.read': {
+    description: "Read a patient's profile",
+    resource: 'patient',
+    rules: [patientSelf(PermissionPatientView), treatingDoctor(PermissionPatientView), staffWith(PermissionPatientView), breakGlass(PermissionPatientView)],
+  },
+  'patient.history.read': {
+    description: "Read a patient's medical history",
+    resource: 'patient',
+    rules: [
+      patientSelf(PermissionPatientView),
+      treatingDoctor(PermissionPatientHistory),
+      staffWith(PermissionPatientHistory),
+      breakGlass(PermissionPatientHistory),
+    ],
+  },
+  'patient.history.write': {
+    description: "Add to a patient's medical history",
+    resource: 'patient',
+    rules: [treatingDoctor(PermissionPatientHistory), staffWith(PermissionPatientHistory)],
+  },
+};
+
+// Decides whether a subject (a Principal) may act on a resource, taking the
+// subject's role and permissions and its relationships with the resource
+// into account. Every decision carries the trace of the rules it tried.
+class PolicyEngine {
+  has(name) {
+    return Object.prototype.hasOwnProperty.call(POLICIES, name);
+  }
+
+  describe() {
+    return Object.entries(POLICIES).map(([name, policy]) => ({
+      name,
+      description: policy.description,
+      resource: policy.resource,
+      rules: policy.rules.map((rule) => ({
+        name: rule.name,
+        description: rule.description,
+        constraint: rule.constraint,
+      })),
+    }));
+  }
+
+  async evaluate(name, subject, resource = {}) {
+    if (!this.has(name)) {
+      throw new Error(`Unknown policy: ${name}`);
+    }
+    const policy = POLICIES[name];
+    const target = { type: policy.resource, ...resource };
+    const trace = [];
+    for (const rule of policy.rules) {
+      const outcome = await rule.test({ subject, resource: target });
+      trace.push({ rule: rule.name, ...outcome });
+      if (outcome.matched) {
+        return { policy: name, allowed: true, rule: rule.name, constraint: rule.constraint || null, resource: target, trace };
+      }
+    }
+    return { policy: name, allowed: false, rule: null, constraint: null, resource: target, trace };
+  }
+
+  // How a policy decides for some user signed in with their own role and
+  // permissions, for answering "why can't this doctor open this record?"
+  async explainFor({ policy, userId, role, resourceId } = {}) {
+    if (!this.has(policy)) {
+      throw new Error(`Unknown policy: ${policy}`);
+    }
+    const loaded = ObjectId.isValid(userId) ? await authService.loadActiveProfile(userId, role) : null;
+    if (!loaded) {
+      throw new Error('User not found');
+    }
+    const identity = authService.identityFor(loaded.user, role, loaded.account);
+    const subject = new Principal({
+      user_id: identity.userId.toString(),
+      email: identity.email,
+      role,
+      permissions: identity.permissions,
+      patientId: identity.patientId ? identity.patientId.toString() : undefined,
+      auth_method: Principal.AUTH_METHODS.ACCESS_TOKEN,
+    });
+    return this.evaluate(policy, subject, { id: resourceId });
+  }
+}
+
+module.exports = new PolicyEngine();
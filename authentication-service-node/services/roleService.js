@@ -0,0 +1,198 @@
+const { ObjectId } = require('mongoose').Types;
+const Role = require('../models/Role');
+const Admin = require('../models/Admin');
+const authService = require('./authServiceInstance');
+const delegationService = require('./delegationService');
+const permissions = require('../utils/permissions');
+const permissionRules = require('../utils/permissionRules');
+
+const KNOWN_PERMISSIONS = Object.values(permissions);
+// Held by service accounts only; people never need them
+const SERVICE_ONLY_PERMISSIONS = [permissions.PermissionTokenIntrospect, permissions.PermissionCareRelationshipSync];
+// Held by doctors through their role
+const CLINICIAN_ONLY_PERMISSIONS = [permissions.PermissionBreakGlassRequest];
+const ROLE_NAME_PATTERN = /^[a-z][a-z0-9_-]{1,49}$/;
+// Given to admins created without explicit roles or permissions
+const DEFAULT_ADMIN_ROLE = 'admin';
+
+// Named permission sets for admins. An admin holds the union of their roles'
+// permissions and any granted directly; the union is stored on the admin
+// (rolePermissions) and rewritten whenever a role or assignment changes,
+// and tokens pick it up on the next request. Nobody can put a permission
+// into a role or onto an admin without holding it themselves.
+class RoleService {
+  assignablePermissions() {
+    return KNOWN_PERMISSIONS
+      .filter((permission) => !SERVICE_ONLY_PERMISSIONS.includes(permission))
+      .filter((permission) => !CLINICIAN_ONLY_PERMISSIONS.includes(permission));
+  }
+
+  validatePermissions(list) {
+    if (!Array.isArray(list) || list.some((permission) => typeof permission !== 'string')) {
+      throw new Error('permissions must be a list of permission names');
+    }
+    const assignable = this.assignablePermissions();
+    const unknown = list.filter((permission) => !permissionRules.isValidGrant(permission, assignable));
+    if (unknown.length > 0) {
+      throw new Error(`Unknown permissions: ${unknown.join(', ')}`);
+    }
+    return [...new Set(list)];
+  }
+
+  // Built-in roles are recreated if missing, never overwritten
+  async ensureBuiltInRoles() {
+    if (!await Role.findOne({ name: DEFAULT_ADMIN_ROLE })) {
+      await Role.create({
+        name: DEFAULT_ADMIN_ROLE,
+        description: 'Default permissions for administrators',
+        permissions: authService.defaultAdminPermissions(),
+        builtIn: true,
+      });
+    }
+  }
+
+  toPublic(role) {
+    return {
+      name: role.name,
+      description: role.description,
+      permissions: role.permissions,
+      builtIn: role.builtIn,
+      createdBy: role.createdBy,
+      createdAt: role.createdAt,
+      updatedAt: role.updatedAt,
+    };
+  }
+
+  async listRoles() {
+    const roles = await Role.find().sort({ name: 1 });
+    return roles.map((role) => this.toPublic(role));
+  }
+
+  async getRole(name) {
+    return Role.findOne({ name });
+  }
+
+  async createRole(claims, { name, description, permissions: granted } = {}) {
+    if (typeof name !== 'string' || !ROLE_NAME_PATTERN.test(name)) {
+      throw new Error('Role name must be 2-50 lowercase letters, digits, "-" or "_", starting with a letter');
+    }
+    if (await Role.findOne({ name })) {
+      throw new Error('A role with this name already exists');
+    }
+    const list = this.validatePermissions(granted);
+    delegationService.assertCanGrant(claims, list);
+    const role = await Role.create({
+      name,
+      description,
+      permissions: list,
+      createdBy: claims.user_id.toString(),
+    });
+    return this.toPublic(role);
+  }
+
+  async updateRole(claims, name, { description, permissions: granted } = {}, context = {}) {
+    const role = await Role.findOne({ name });
+    if (!role) throw new Error('Role not found');
+    if (granted !== undefined) {
+      const list = this.validatePermissions(granted);
+      delegationService.assertCanGrant(claims, list, role.permissions);
+      role.permissions = list;
+    }
+    if (description !== undefined) role.description = description;
+    await role.save();
+    if (granted !== undefined) {
+      for (const admin of await Admin.find({ roles: name })) {
+        await this.refreshRolePermissions(admin);
+        await delegationService.afterChange(claims, admin, context);
+      }
+    }
+    return this.toPublic(role);
+  }
+
+  // Roles still assigned to someone have to be unassigned first
+  async deleteRole(name) {
+    const role = await Role.findOne({ name });
+    if (!role) throw new Error('Role not found');
+    if (role.builtIn) throw new Error('Built-in roles cannot be deleted');
+    const holders = await Admin.countDocuments({ roles: name });
+    if (holders > 0) throw new Error(`Role is assigned to ${holders} admin(s)`);
+    await Role.deleteOne({ _id: role._id });
+  }
+
+  async loadAdmin(adminId) {
+    const admin = ObjectId.isValid(adminId) ? await Admin.findById(adminId) : null;
+    if (!admin) throw new Error('Admin not found');
+    return admin;
+  }
+
+  async findRoles(roleNames) {
+    if (!Array.isArray(roleNames) || roleNames.some((name) => typeof name !== 'string')) {
+      throw new Error('roles must be a list of role names');
+    }
+    const names = [...new Set(roleNames)];
+    const found = await Role.find({ name: { $in: names } });
+    const missing = names.filter((name) => !found.some((role) => role.name === name));
+    if (missing.length > 0) {
+      throw new Error(`Unknown roles: ${missing.join(', ')}`);
+    }
+    return found;
+  }
+
+  async assignRoles(claims, adminId, roleNames, context = {}) {
+    const found = await this.findRoles(roleNames);
+    const admin = await this.loadAdmin(adminId);
+    delegationService.assertCanGrant(claims, found.flatMap((role) => role.permissions), admin.rolePermissions);
+    admin.roles = found.map((role) => role.name);
+    const result = await this.refreshRolePermissions(admin, found);
+    await delegationService.afterChange(claims, admin, context);
+    return result;
+  }
+
+  // Replaces the permissions granted directly, outside any role
+  async setDirectPermissions(claims, adminId, granted, context = {}) {
+    const list = this.validatePermissions(granted);
+    const admin = await this.loadAdmin(adminId);
+    delegationService.assertCanGrant(claims, list, admin.permissions);
+    admin.permissions = list;
+    await admin.save();
+    await delegationService.afterChange(claims, admin, context);
+    return this.effectivePermissions(admin);
+  }
+
+  async refreshRolePermissions(admin, roles = null) {
+    const assigned = roles || await Role.find({ name: { $in: admin.roles || [] } });
+    admin.rolePermissions = [...new Set(assigned.flatMap((role) => role.permissions))];
+    await admin.save();
+    return this.effectivePermissions(admin);
+  }
+
+  effectivePermissions(admin) {
+    return {
+      adminId: admin._id,
+      roles: admin.roles || [],
+      permissions: admin.permissions || [],
+      effectivePermissions: permissionRules.expand(authService.adminPermissions(admin)),
+    };
+  }
+
+  // What any user holds: as granted, every permission that satisfies, and
+  // the compressed form their tokens carry
+  async userPermissions(userId, role) {
+    const model = authService.modelForRole(role);
+    const user = model && ObjectId.isValid(userId) ? await model.findById(userId) : null;
+    const account = user ? await authService.accountStore.forProfile(user) : null;
+    if (!user || !account) throw new Error('User not found');
+    const granted = authService.identityFor(user, role, account).permissions;
+    return {
+      userId: user._id,
+      role,
+      roles: role === 'admin' ? user.roles || [] : [],
+      granted,
+      effective: permissionRules.expand(granted),
+      token: permissionRules.compress(granted),
+    };
+  }
+}
+
+module.exports = new RoleService();
+module.exports.DEFAULT_ADMIN_ROLE = DEFAULT_ADMIN_ROLE;
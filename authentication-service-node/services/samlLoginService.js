@@ -0,0 +1,419 @@
+const crypto = require('crypto');
+const fs = require('fs');
+const zlib = require('zlib');
+const ExternalIdentity = require('../models/ExternalIdentity');
+const authService = require('./authServiceInstance');
+const federatedLoginService = require('./federatedLoginService');
+const samlProviderService = require('./samlProviderService');
+const { identityNamespace } = require('./samlProviderService');
+const { parse, escapeXml } = require('../utils/xml');
+const { findSignature, verifyEnvelopedSignature } = require('../utils/xmlSignature');
+const cacheStore = require('../utils/cacheStore');
+const env = require('../config/env');
+
+const { FederationError } = federatedLoginService;
+
+const PROTOCOL_NAMESPACE = 'urn:oasis:names:tc:SAML:2.0:protocol';
+const ASSERTION_NAMESPACE = 'urn:oasis:names:tc:SAML:2.0:assertion';
+const METADATA_NAMESPACE = 'urn:oasis:names:tc:SAML:2.0:metadata';
+const HTTP_POST_BINDING = 'urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST';
+const BEARER = 'urn:oasis:names:tc:SAML:2.0:cm:bearer';
+const STATUS_SUCCESS = 'urn:oasis:names:tc:SAML:2.0:status:Success';
+const NAMEID_EMAIL = 'urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress';
+const NAMEID_PERSISTENT = 'urn:oasis:names:tc:SAML:2.0:nameid-format:persistent';
+const NAMEID_UNSPECIFIED = 'urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified';
+const RSA_SHA256 = 'http://www.w3.org/2001/04/xmldsig-more#rsa-sha256';
+
+// The user has this long to sign in at the IdP
+const REQUEST_TTL_SECONDS = 10 * 60;
+// Allowed clock difference with the IdP
+const CLOCK_SKEW_SECONDS = 60;
+const DOCTOR_FIELDS = ['name', 'specialization', 'phone', 'address', 'licenseNumber', 'experience'];
+
+const hashValue = (value) => crypto.createHash('sha256').update(value).digest('hex');
+
+const randomToken = () => crypto.randomBytes(32).toString('base64url');
+
+const pemBody = (pem) => pem.replace(/-----(BEGIN|END) CERTIFICATE-----/g, '').replace(/\s+/g, '');
+
+const parseInstant = (value, name) => {
+  const time = value ? Date.parse(value) : NaN;
+  if (Number.isNaN(time)) {
+    throw new Error(`Invalid ${name}`);
+  }
+  return time;
+};
+
+// SAML 2.0 Web Browser SSO for hospital staff. This service is the SP: it
+// sends signed AuthnRequests over the HTTP-Redirect binding and accepts
+// signed assertions over HTTP-POST. Only SP-initiated sign-in is supported;
+// an unsolicited response has no request to be bound to and is refused.
+//
+// The hospital IdP is trusted for the email domains configured on it, so its
+// staff are linked to existing accounts in those domains by address, and
+// admin and doctor profiles are created for them on first sign-in.
+class SamlLoginService {
+  // The SP key and certificate, read once from the configured files
+  spConfig() {
+    if (!this.sp) {
+      if (!env.SAML_SP_PRIVATE_KEY_PATH || !env.SAML_SP_CERTIFICATE_PATH) {
+        throw new Error('SAML is not configured');
+      }
+      const privateKey = crypto.createPrivateKey(fs.readFileSync(env.SAML_SP_PRIVATE_KEY_PATH));
+      if (privateKey.asymmetricKeyType !== 'rsa') {
+        throw new Error('The SAML SP key must be an RSA key');
+      }
+      const certificate = new crypto.X509Certificate(fs.readFileSync(env.SAML_SP_CERTIFICATE_PATH));
+      if (!certificate.checkPrivateKey(privateKey)) {
+        throw new Error('The SAML SP certificate does not match its key');
+      }
+      this.sp = {
+        privateKey,
+        certificate: pemBody(certificate.toString()),
+        entityId: env.SAML_SP_ENTITY_ID || `${env.OIDC_ISSUER}/api/v1/auth/saml/metadata`,
+        acsUrl: `${env.OIDC_ISSUER}/api/v1/auth/saml/acs`,
+      };
+    }
+    return this.sp;
+  }
+
+  // SP metadata for configuring the service at the IdP
+  metadata() {
+    const { entityId, acsUrl, certificate } = this.spConfig();
+    return '<?xml version="1.0" encoding="UTF-8"?>\n'
+      + `<md:EntityDescriptor xmlns:md="${METADATA_NAMESPACE}" entityID="${escapeXml(entityId)}">`
+      + `<md:SPSSODescriptor AuthnRequestsSigned="true" WantAssertionsSigned="true" protocolSupportEnumeration="${PROTOCOL_NAMESPACE}">`
+      + '<md:KeyDescriptor use="signing"><ds:KeyInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#">'
+      + `<ds:X509Data><ds:X509Certificate>${certificate}</ds:X509Certificate></ds:X509Data>`
+      + '</ds:KeyInfo></md:KeyDescriptor>'
+      + `<md:NameIDFormat>${NAMEID_PERSISTENT}</md:NameIDFormat>`
+      + `<md:NameIDFormat>${NAMEID_EMAIL}</md:NameIDFormat>`
+      + `<md:AssertionConsumerService Binding="${HTTP_POST_BINDING}" Location="${escapeXml(acsUrl)}" index="0" isDefault="true"/>`
+      + '</md:SPSSODescriptor>'
+      + '</md:EntityDescriptor>\n';
+  }
+
+  // Returns the IdP URL to send the user to and the browser key the frontend
+  // presents when redeeming the result, as for OpenID Connect providers
+  async beginLogin(slug, { returnTo = null } = {}) {
+    const sp = this.spConfig();
+    const provider = await samlProviderService.getEnabledProvider(slug);
+    const requestId = `_${crypto.randomBytes(20).toString('hex')}`;
+    const relayState = randomToken();
+    const browserKey = randomToken();
+    await cacheStore.set(`saml_request:${hashValue(relayState)}`, JSON.stringify({
+      provider: provider.slug,
+      requestId,
+      browserKeyHash: hashValue(browserKey),
+      returnTo,
+    }), REQUEST_TTL_SECONDS);
+
+    const request = `<samlp:AuthnRequest xmlns:samlp="${PROTOCOL_NAMESPACE}" xmlns:saml="${ASSERTION_NAMESPACE}"`
+      + ` ID="${requestId}" Version="2.0" IssueInstant="${new Date().toISOString()}"`
+      + ` Destination="${escapeXml(provider.ssoUrl)}" AssertionConsumerServiceURL="${escapeXml(sp.acsUrl)}"`
+      + ` ProtocolBinding="${HTTP_POST_BINDING}">`
+      + `<saml:Issuer>${escapeXml(sp.entityId)}</saml:Issuer>`
+      + `<samlp:NameIDPolicy Format="${NAMEID_UNSPECIFIED}" AllowCreate="true"/>`
+      + '</samlp:AuthnRequest>';
+
+    // HTTP-Redirect binding: the signature covers the encoded query parameters
+    // in this order
+    const query = [
+      `SAMLRequest=${encodeURIComponent(zlib.deflateRawSync(request).toString('base64'))}`,
+      `RelayState=${encodeURIComponent(relayState)}`,
+      `SigAlg=${encodeURIComponent(RSA_SHA256)}`,
+    ].join('&');
+    const signature = crypto.sign('sha256', Buffer.from(query), sp.privateKey).toString('base64');
+    const url = new URL(provider.ssoUrl);
+    const authorizationUrl = `${url.toString()}${url.search ? '&' : '?'}${query}&Signature=${encodeURIComponent(signature)}`;
+    return { authorizationUrl, browserKey };
+  }
+
+  // Handles a response posted to the ACS and returns where to send the user
+  // agent: the frontend page, with a single-use code or an error
+  async handleAcs({ SAMLResponse: samlResponse, RelayState: relayState }) {
+    let flow = null;
+    try {
+      const stored = relayState ? await cacheStore.take(`saml_request:${hashValue(relayState)}`) : null;
+      flow = stored ? JSON.parse(stored) : null;
+      if (!flow) {
+        throw new FederationError('The sign-in request has expired; please try again');
+      }
+      if (!samlResponse) {
+        throw new FederationError('The identity provider did not return a SAML response');
+      }
+      const provider = await samlProviderService.getEnabledProvider(flow.provider);
+      const external = await this.validateResponse(provider, samlResponse, flow.requestId);
+      const code = await federatedLoginService.issueCallbackCode({
+        protocol: 'saml',
+        intent: 'login',
+        provider: provider.slug,
+        browserKeyHash: flow.browserKeyHash,
+        external,
+      });
+      return federatedLoginService.frontendUrl({ code, intent: 'login', protocol: 'saml', returnTo: flow.returnTo });
+    } catch (error) {
+      if (!(error instanceof FederationError)) {
+        console.error(`SAML sign-in${flow ? ` through ${flow.provider}` : ''} failed:`, error.message);
+      }
+      return federatedLoginService.frontendUrl({
+        error: error instanceof FederationError ? error.message : 'Sign-in through the identity provider failed',
+        intent: 'login',
+        protocol: 'saml',
+      });
+    }
+  }
+
+  // Checks a base64 encoded Response against the request it answers and
+  // returns the NameID and attributes of its assertion. Everything is read
+  // from the one assertion whose signature was verified.
+  async validateResponse(provider, samlResponse, requestId) {
+    const { entityId, acsUrl } = this.spConfig();
+    const now = Date.now();
+    const skew = CLOCK_SKEW_SECONDS * 1000;
+    const response = parse(Buffer.from(samlResponse, 'base64').toString('utf8'));
+
+    if (response.namespaceURI !== PROTOCOL_NAMESPACE || response.localName !== 'Response') {
+      throw new Error('Not a SAML response');
+    }
+    if (response.getAttribute('Version') !== '2.0') {
+      throw new Error('Unsupported SAML version');
+    }
+    // Signatures reference elements by ID; duplicates are a sign of a
+    // signature wrapping attempt
+    const ids = [response, ...response.descendants()]
+      .map((element) => element.getAttribute('ID'))
+      .filter(Boolean);
+    if (new Set(ids).size !== ids.length) {
+      throw new Error('Duplicate IDs in SAML response');
+    }
+    if (response.getAttribute('InResponseTo') !== requestId) {
+      throw new Error('SAML response does not answer our request');
+    }
+    const destination = response.getAttribute('Destination');
+    if (destination && destination !== acsUrl) {
+      throw new Error('SAML response is for another destination');
+    }
+    const responseIssuer = response.child(ASSERTION_NAMESPACE, 'Issuer');
+    if (responseIssuer && responseIssuer.textContent().trim() !== provider.entityId) {
+      throw new Error('SAML response is from another issuer');
+    }
+
+    const status = response.child(PROTOCOL_NAMESPACE, 'Status');
+    const statusCode = status && status.child(PROTOCOL_NAMESPACE, 'StatusCode');
+    if (!statusCode || statusCode.getAttribute('Value') !== STATUS_SUCCESS) {
+      throw new FederationError(`${provider.displayName} could not sign you in`);
+    }
+
+    const publicKeys = samlProviderService.publicKeysFor(provider);
+    const responseSignature = findSignature(response);
+    if (responseSignature) {
+      verifyEnvelopedSignature(response, responseSignature, publicKeys);
+    }
+    if (response.childrenNamed(ASSERTION_NAMESPACE, 'EncryptedAssertion').length > 0) {
+      throw new Error('Encrypted assertions are not supported');
+    }
+    const assertions = response.childrenNamed(ASSERTION_NAMESPACE, 'Assertion');
+    if (assertions.length !== 1) {
+      throw new Error('SAML response must contain exactly one assertion');
+    }
+    const assertion = assertions[0];
+    const assertionSignature = findSignature(assertion);
+    if (!assertionSignature) {
+      throw new Error('SAML assertion is not signed');
+    }
+    verifyEnvelopedSignature(assertion, assertionSignature, publicKeys);
+
+    const issuer = assertion.child(ASSERTION_NAMESPACE, 'Issuer');
+    if (!issuer || issuer.textContent().trim() !== provider.entityId) {
+      throw new Error('SAML assertion is from another issuer');
+    }
+
+    const subject = assertion.child(ASSERTION_NAMESPACE, 'Subject');
+    const nameId = subject && subject.child(ASSERTION_NAMESPACE, 'NameID');
+    if (!nameId || !nameId.textContent().trim()) {
+      throw new Error('SAML assertion has no NameID');
+    }
+    const confirmed = subject.childrenNamed(ASSERTION_NAMESPACE, 'SubjectConfirmation').some((confirmation) => {
+      const data = confirmation.child(ASSERTION_NAMESPACE, 'SubjectConfirmationData');
+      return confirmation.getAttribute('Method') === BEARER
+        && data
+        && data.getAttribute('Recipient') === acsUrl
+        && data.getAttribute('InResponseTo') === requestId
+        && !data.getAttribute('NotBefore')
+        && parseInstant(data.getAttribute('NotOnOrAfter'), 'NotOnOrAfter') > now - skew;
+    });
+    if (!confirmed) {
+      throw new Error('SAML assertion has no valid bearer subject confirmation');
+    }
+
+    const conditions = assertion.child(ASSERTION_NAMESPACE, 'Conditions');
+    if (!conditions) {
+      throw new Error('SAML assertion has no conditions');
+    }
+    if (conditions.getAttribute('NotBefore') && parseInstant(conditions.getAttribute('NotBefore'), 'NotBefore') > now + skew) {
+      throw new Error('SAML assertion is not yet valid');
+    }
+    const notOnOrAfter = parseInstant(conditions.getAttribute('NotOnOrAfter'), 'NotOnOrAfter');
+    if (notOnOrAfter <= now - skew) {
+      throw new Error('SAML assertion has expired');
+    }
+    const restrictions = conditions.childrenNamed(ASSERTION_NAMESPACE, 'AudienceRestriction');
+    if (restrictions.length === 0 || !restrictions.every((restriction) => restriction
+      .childrenNamed(ASSERTION_NAMESPACE, 'Audience')
+      .some((audience) => audience.textContent().trim() === entityId))) {
+      throw new Error('SAML assertion is for another audience');
+    }
+
+    // A bearer assertion is accepted once
+    const fresh = await cacheStore.setIfAbsent(
+      `saml_assertion:${hashValue(`${provider.entityId} ${assertion.getAttribute('ID')}`)}`,
+      '1',
+      (notOnOrAfter + skew - now) / 1000,
+    );
+    if (!fresh) {
+      throw new Error('SAML assertion has already been used');
+    }
+
+    const attributes = {};
+    for (const statement of assertion.childrenNamed(ASSERTION_NAMESPACE, 'AttributeStatement')) {
+      for (const attribute of statement.childrenNamed(ASSERTION_NAMESPACE, 'Attribute')) {
+        const name = attribute.getAttribute('Name');
+        const values = attribute.childrenNamed(ASSERTION_NAMESPACE, 'AttributeValue')
+          .map((value) => value.textContent().trim())
+          .filter(Boolean);
+        attributes[name] = [...(attributes[name] || []), ...values];
+      }
+    }
+    return {
+      subject: nameId.textContent().trim(),
+      nameIdFormat: nameId.getAttribute('Format'),
+      attributes,
+    };
+  }
+
+  // Profile fields, roles and permissions from the assertion, as configured
+  // on the provider
+  mapAttributes(provider, external) {
+    const mapping = provider.attributeMapping || {};
+    const values = (field) => (mapping[field] && external.attributes[mapping[field]]) || [];
+    const first = (field) => values(field)[0];
+
+    let email = first('email');
+    if (!email && external.nameIdFormat === NAMEID_EMAIL) {
+      email = external.subject;
+    }
+    const roleValues = values('role');
+    const roles = [...new Set(provider.roleMapping
+      .filter((entry) => roleValues.includes(entry.value))
+      .map((entry) => entry.role))];
+    const profile = {};
+    for (const field of DOCTOR_FIELDS) {
+      if (first(field) !== undefined) profile[field] = first(field);
+    }
+    return {
+      email: email ? email.toLowerCase() : null,
+      roles,
+      // null when the IdP does not manage permissions
+      permissions: mapping.permissions
+        ? values('permissions').filter((permission) => provider.grantablePermissions.includes(permission))
+        : null,
+      profile,
+    };
+  }
+
+  // Finishes a sign-in. The result is tokens or an MFA challenge, as for a
+  // password login.
+  async completeLogin(code, browserKey, context = {}) {
+    const { provider: slug, external } = await federatedLoginService.redeemCallback(code, browserKey, { protocol: 'saml', intent: 'login' });
+    const provider = await samlProviderService.getEnabledProvider(slug);
+    const mapped = this.mapAttributes(provider, external);
+    if (mapped.roles.length === 0) {
+      throw new Error(`${provider.displayName} did not assign you a role in this service`);
+    }
+
+    const namespace = identityNamespace(provider.slug);
+    let identity = await ExternalIdentity.findOne({ provider: namespace, subject: external.subject });
+    let account = identity ? await authService.accountStore.findById(identity.accountId) : null;
+    if (identity && !account) {
+      // The account was deleted; its links go with it
+      await ExternalIdentity.deleteOne({ _id: identity._id });
+      identity = null;
+    }
+    if (!identity) {
+      account = await this.provisionAccount(provider, mapped);
+      identity = await ExternalIdentity.create({
+        accountId: account._id,
+        provider: namespace,
+        subject: external.subject,
+        email: mapped.email,
+        emailVerified: true,
+      });
+    }
+    await this.provisionRoles(provider, account, mapped);
+
+    // Roles the IdP no longer asserts cannot be signed in to this way
+    const { user, role } = await authService.selectProfile(account, null, mapped.roles);
+    await ExternalIdentity.updateOne({ _id: identity._id }, { lastLoginAt: new Date(), email: mapped.email });
+    await authService.accountStore.recordLogin(account._id);
+    return authService.completePrimaryLogin(user, role, account, context, ['fed']);
+  }
+
+  // The account for a first sign-in: the one with the asserted address, or a
+  // new one with the first mapped role
+  async provisionAccount(provider, mapped) {
+    const domain = mapped.email ? mapped.email.slice(mapped.email.lastIndexOf('@') + 1) : null;
+    if (!domain || !provider.allowedDomains.includes(domain)) {
+      throw new Error(`${provider.displayName} did not assert an email address in its domains`);
+    }
+    const existing = await authService.accountStore.findByEmail(mapped.email);
+    if (existing) {
+      return existing;
+    }
+    const [role] = mapped.roles;
+    const { account } = await authService.registerFederatedAccount(role, this.profileData(provider, role, mapped));
+    return account;
+  }
+
+  // Creates the profiles the IdP grants that the account lacks, and keeps
+  // admin permissions in step with the IdP when it manages them
+  async provisionRoles(provider, account, mapped) {
+    for (const role of mapped.roles) {
+      const profileId = authService.accountStore.profileIdFor(account, role);
+      if (!profileId) {
+        await authService.addFederatedRole(account, role, this.profileData(provider, role, mapped));
+      } else if (role === 'admin' && mapped.permissions) {
+        await authService.modelForRole('admin').updateOne({ _id: profileId }, { permissions: mapped.permissions });
+      }
+    }
+  }
+
+  profileData(provider, role, mapped) {
+    if (role === 'admin') {
+      return {
+        email: mapped.email,
+        username: mapped.profile.name || mapped.email.split('@')[0],
+        permissions: mapped.permissions || provider.grantablePermissions,
+        // Delegation review treats whoever set grantablePermissions as the creator
+        createdBy: provider.grantedBy,
+        createdByRole: provider.grantedByRole,
+      };
+    }
+    const missing = DOCTOR_FIELDS.filter((field) => !mapped.profile[field]);
+    if (missing.length > 0) {
+      throw new Error(`${provider.displayName} did not send ${missing.join(', ')} needed for a doctor profile`);
+    }
+    const experience = Number(mapped.profile.experience);
+    if (!Number.isFinite(experience) || experience < 0) {
+      throw new Error(`${provider.displayName} sent an invalid experience value`);
+    }
+    return {
+      ...mapped.profile,
+      email: mapped.email,
+      experience,
+      isApproved: Boolean(provider.autoApproveDoctors),
+    };
+  }
+}
+
+module.exports = new SamlLoginService();
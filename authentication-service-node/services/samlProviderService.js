@@ -0,0 +1,192 @@
+const crypto = require('crypto');
+const SamlProvider = require('../models/SamlProvider');
+const ExternalIdentity = require('../models/ExternalIdentity');
+const authService = require('./authServiceInstance');
+const delegationService = require('./delegationService');
+const permissions = require('../utils/permissions');
+
+const SLUG_PATTERN = /^[a-z0-9][a-z0-9-]{0,39}$/;
+const DOMAIN_PATTERN = /^[a-z0-9-]+(\.[a-z0-9-]+)+$/;
+const KNOWN_PERMISSIONS = Object.values(permissions);
+const MAPPED_FIELDS = ['email', 'name', 'role', 'permissions', 'specialization', 'phone', 'address', 'licenseNumber', 'experience'];
+
+// SAML identities share the external identity collection with OpenID Connect
+// ones, under a prefix so slugs cannot collide
+const identityNamespace = (slug) => `saml:${slug}`;
+
+class SamlProviderService {
+  // Certificates are stored as given but must parse, and must not have expired
+  normalizeCertificates(certificates) {
+    if (!Array.isArray(certificates) || certificates.length === 0) {
+      throw new Error('At least one IdP signing certificate is required');
+    }
+    return certificates.map((pem) => {
+      let certificate;
+      try {
+        certificate = new crypto.X509Certificate(pem);
+      } catch (error) {
+        throw new Error('IdP certificates must be PEM encoded X.509 certificates');
+      }
+      if (new Date(certificate.validTo) <= new Date()) {
+        throw new Error(`IdP certificate ${certificate.subject} has expired`);
+      }
+      return certificate.toString();
+    });
+  }
+
+  validateProviderData(data, partial = false) {
+    const { slug, displayName, entityId, ssoUrl, allowedDomains, attributeMapping, roleMapping, grantablePermissions } = data;
+    if (!partial) {
+      if (!slug || !SLUG_PATTERN.test(slug)) {
+        throw new Error('Slug must be lowercase letters, digits and dashes');
+      }
+      if (!entityId) throw new Error('IdP entity ID is required');
+      if (!ssoUrl) throw new Error('IdP single sign-on URL is required');
+    }
+    if (displayName !== undefined && !displayName) {
+      throw new Error('Display name is required');
+    }
+    if (ssoUrl !== undefined) {
+      let parsed;
+      try {
+        parsed = new URL(ssoUrl);
+      } catch (error) {
+        throw new Error(`Invalid single sign-on URL: ${ssoUrl}`);
+      }
+      if (parsed.protocol !== 'https:' && parsed.hostname !== 'localhost') {
+        throw new Error('Single sign-on URL must use https');
+      }
+    }
+    if (allowedDomains !== undefined) {
+      const invalid = allowedDomains.filter((domain) => !DOMAIN_PATTERN.test(String(domain).toLowerCase()));
+      if (invalid.length > 0) throw new Error(`Invalid domains: ${invalid.join(', ')}`);
+    }
+    if (attributeMapping !== undefined) {
+      const unknown = Object.keys(attributeMapping).filter((field) => !MAPPED_FIELDS.includes(field));
+      if (unknown.length > 0) throw new Error(`Unknown mapped fields: ${unknown.join(', ')}`);
+    }
+    if (roleMapping !== undefined) {
+      if (!Array.isArray(roleMapping)
+        || roleMapping.some((entry) => !entry.value || !['admin', 'doctor'].includes(entry.role))) {
+        throw new Error('Role mappings need a value and a role of admin or doctor');
+      }
+    }
+    if (grantablePermissions !== undefined) {
+      const unknown = grantablePermissions.filter((permission) => !KNOWN_PERMISSIONS.includes(permission));
+      if (unknown.length > 0) throw new Error(`Unknown permissions: ${unknown.join(', ')}`);
+    }
+  }
+
+  providerFields(data) {
+    const fields = {};
+    for (const field of ['displayName', 'entityId', 'ssoUrl', 'attributeMapping', 'roleMapping', 'grantablePermissions', 'autoApproveDoctors', 'enabled']) {
+      if (data[field] !== undefined) fields[field] = data[field];
+    }
+    if (data.allowedDomains !== undefined) {
+      fields.allowedDomains = data.allowedDomains.map((domain) => domain.toLowerCase());
+    }
+    if (data.certificates !== undefined) {
+      fields.certificates = this.normalizeCertificates(data.certificates);
+    }
+    return fields;
+  }
+
+  // The IdP can hand admins anything in grantablePermissions, so whoever sets
+  // them must hold all of it
+  async createProvider(claims, data) {
+    this.validateProviderData(data);
+    const fields = this.providerFields({ ...data, certificates: data.certificates || [] });
+    const grantablePermissions = fields.grantablePermissions || authService.defaultAdminPermissions();
+    delegationService.assertCanGrant(claims, grantablePermissions);
+    if (await SamlProvider.findOne({ slug: data.slug })) {
+      throw new Error('A SAML provider with this slug already exists');
+    }
+    const provider = await SamlProvider.create({
+      ...fields,
+      slug: data.slug,
+      displayName: fields.displayName || data.slug,
+      allowedDomains: fields.allowedDomains || [],
+      attributeMapping: fields.attributeMapping || {},
+      roleMapping: fields.roleMapping || [],
+      grantablePermissions,
+      createdBy: claims.user_id,
+      grantedBy: claims.user_id,
+      grantedByRole: claims.role,
+    });
+    return this.toPublic(provider);
+  }
+
+  toPublic(provider) {
+    return {
+      id: provider._id,
+      slug: provider.slug,
+      displayName: provider.displayName,
+      entityId: provider.entityId,
+      ssoUrl: provider.ssoUrl,
+      certificates: provider.certificates.map((pem) => {
+        const certificate = new crypto.X509Certificate(pem);
+        return { subject: certificate.subject, validTo: certificate.validTo, fingerprint256: certificate.fingerprint256 };
+      }),
+      allowedDomains: provider.allowedDomains,
+      attributeMapping: provider.attributeMapping,
+      roleMapping: provider.roleMapping,
+      grantablePermissions: provider.grantablePermissions,
+      autoApproveDoctors: provider.autoApproveDoctors,
+      enabled: provider.enabled,
+      createdAt: provider.createdAt,
+      updatedAt: provider.updatedAt,
+    };
+  }
+
+  async listEnabledProviders() {
+    const providers = await SamlProvider.find({ enabled: true }).sort({ displayName: 1 });
+    return providers.map((provider) => ({ slug: provider.slug, displayName: provider.displayName }));
+  }
+
+  async listProviders() {
+    const providers = await SamlProvider.find().sort({ displayName: 1 });
+    return providers.map((provider) => this.toPublic(provider));
+  }
+
+  async getProvider(slug) {
+    return SamlProvider.findOne({ slug });
+  }
+
+  async getEnabledProvider(slug) {
+    const provider = await SamlProvider.findOne({ slug, enabled: true });
+    if (!provider) {
+      throw new Error('Unknown SAML provider');
+    }
+    return provider;
+  }
+
+  async updateProvider(claims, slug, data) {
+    const provider = await SamlProvider.findOne({ slug });
+    if (!provider) throw new Error('SAML provider not found');
+    this.validateProviderData(data, true);
+    if (data.grantablePermissions !== undefined) {
+      delegationService.assertCanGrant(claims, data.grantablePermissions, provider.grantablePermissions);
+      provider.grantedBy = claims.user_id;
+      provider.grantedByRole = claims.role;
+    }
+    Object.assign(provider, this.providerFields(data));
+    await provider.save();
+    return this.toPublic(provider);
+  }
+
+  async deleteProvider(slug) {
+    const provider = await SamlProvider.findOne({ slug });
+    if (!provider) throw new Error('SAML provider not found');
+    if (await ExternalIdentity.countDocuments({ provider: identityNamespace(slug) }) > 0) {
+      throw new Error('Accounts are linked to this SAML provider; disable it instead');
+    }
+    await SamlProvider.deleteOne({ _id: provider._id });
+  }
+
+  publicKeysFor(provider) {
+    return provider.certificates.map((pem) => new crypto.X509Certificate(pem).publicKey);
+  }
+}
+
+module.exports = new SamlProviderService();
+module.exports.identityNamespace = identityNamespace;
@@ -0,0 +1,266 @@
+const crypto = require('crypto');
+const ServiceAccount = require('../models/ServiceAccount');
+const authService = require('./authServiceInstance');
+const delegationService = require('./delegationService');
+const oauthClientService = require('./oauthClientService');
+const cacheStore = require('../utils/cacheStore');
+const jwt = require('../utils/jwt');
+
+// Service account client IDs never collide with OAuth client IDs (plain hex)
+const CLIENT_ID_PREFIX = 'svc_';
+const AUTH_METHODS = ['client_secret_basic', 'client_secret_post', 'private_key_jwt'];
+const CLIENT_ASSERTION_TYPE = 'urn:ietf:params:oauth:client-assertion-type:jwt-bearer';
+// Assertions must be short-lived so their jti only has to be remembered briefly
+const MAX_ASSERTION_LIFETIME_SECONDS = 5 * 60;
+const SERVICE_TOKEN_LIFETIME_SECONDS = 15 * 60;
+
+const hashSecret = (secret) => crypto.createHash('sha256').update(secret).digest('hex');
+
+// The algorithm a JWK is used with, when the key does not say
+const algorithmFor = (jwk) => {
+  if (jwk.alg) return jwk.alg;
+  if (jwk.kty === 'RSA') return 'RS256';
+  if (jwk.kty === 'EC' && jwk.crv === 'P-256') return 'ES256';
+  if (jwk.kty === 'OKP' && jwk.crv === 'Ed25519') return 'EdDSA';
+  return null;
+};
+
+// Backend services calling the API as themselves. They authenticate at the
+// token endpoint with a client secret or a signed client assertion (RFC 7523)
+// and receive short-lived access tokens; there are no refresh tokens.
+class ServiceAccountService {
+  isServiceClientId(clientId) {
+    return typeof clientId === 'string' && clientId.startsWith(CLIENT_ID_PREFIX);
+  }
+
+  validateAccountData(data, existing = null) {
+    const { name, tokenEndpointAuthMethod, permissions, jwks, enabled } = data;
+    if (!existing || name !== undefined) {
+      if (typeof name !== 'string' || !name.trim()) throw new Error('Service account name is required');
+    }
+    if (tokenEndpointAuthMethod !== undefined && !AUTH_METHODS.includes(tokenEndpointAuthMethod)) {
+      throw new Error(`Unsupported token endpoint auth method: ${tokenEndpointAuthMethod}`);
+    }
+    if (!existing || permissions !== undefined) {
+      if (!Array.isArray(permissions) || permissions.length === 0) {
+        throw new Error('At least one permission is required');
+      }
+      const allowed = authService.servicePermissions();
+      const invalid = permissions.filter((permission) => !allowed.includes(permission));
+      if (invalid.length > 0) {
+        throw new Error(`Permissions not available to service accounts: ${invalid.join(', ')}`);
+      }
+    }
+    const method = tokenEndpointAuthMethod || (existing ? existing.tokenEndpointAuthMethod : 'client_secret_basic');
+    if (method === 'private_key_jwt' && (jwks !== undefined || !existing)) {
+      this.validateJwks(jwks);
+    }
+    if (enabled !== undefined && typeof enabled !== 'boolean') {
+      throw new Error('enabled must be true or false');
+    }
+  }
+
+  validateJwks(jwks) {
+    if (!jwks || !Array.isArray(jwks.keys) || jwks.keys.length === 0) {
+      throw new Error('private_key_jwt needs a JWK set with at least one public key');
+    }
+    for (const jwk of jwks.keys) {
+      if (jwk.d) {
+        throw new Error('The JWK set must only contain public keys');
+      }
+      if (!jwt.isSupportedAlgorithm(algorithmFor(jwk))) {
+        throw new Error(`Unsupported key: ${jwk.kid || jwk.kty}`);
+      }
+      try {
+        crypto.createPublicKey({ key: jwk, format: 'jwk' });
+      } catch (error) {
+        throw new Error(`Invalid public key: ${jwk.kid || jwk.kty}`);
+      }
+    }
+    if (jwks.keys.length > 1 && jwks.keys.some((jwk) => !jwk.kid)) {
+      throw new Error('Every key needs a kid when there are several');
+    }
+  }
+
+  // Returns the client secret once, for the secret-based methods
+  async createAccount(claims, data) {
+    this.validateAccountData(data);
+    delegationService.assertCanGrant(claims, data.permissions);
+    const tokenEndpointAuthMethod = data.tokenEndpointAuthMethod || 'client_secret_basic';
+    const clientSecret = tokenEndpointAuthMethod === 'private_key_jwt' ? null : crypto.randomBytes(32).toString('base64url');
+    const account = await ServiceAccount.create({
+      clientId: `${CLIENT_ID_PREFIX}${crypto.randomBytes(12).toString('hex')}`,
+      name: data.name.trim(),
+      description: data.description,
+      tokenEndpointAuthMethod,
+      clientSecretHash: clientSecret ? hashSecret(clientSecret) : undefined,
+      jwks: tokenEndpointAuthMethod === 'private_key_jwt' ? { keys: data.jwks.keys } : undefined,
+      permissions: [...new Set(data.permissions)],
+      createdBy: claims.user_id,
+      grantedBy: claims.user_id,
+      grantedByRole: claims.role,
+    });
+    return { serviceAccount: this.toPublic(account), clientSecret };
+  }
+
+  toPublic(account) {
+    return {
+      clientId: account.clientId,
+      name: account.name,
+      description: account.description,
+      tokenEndpointAuthMethod: account.tokenEndpointAuthMethod,
+      jwks: account.jwks,
+      permissions: account.permissions,
+      enabled: account.enabled,
+      lastUsedAt: account.lastUsedAt,
+      createdBy: account.createdBy,
+      grantedBy: account.grantedBy,
+      createdAt: account.createdAt,
+      updatedAt: account.updatedAt,
+    };
+  }
+
+  async listAccounts() {
+    const accounts = await ServiceAccount.find().sort({ name: 1 });
+    return accounts.map((account) => this.toPublic(account));
+  }
+
+  async getAccount(clientId) {
+    return ServiceAccount.findOne({ clientId });
+  }
+
+  // Tokens already issued end when the account is disabled or loses a
+  // permission; the service simply requests a new one
+  async updateAccount(claims, clientId, data) {
+    const account = await ServiceAccount.findOne({ clientId });
+    if (!account) throw new Error('Service account not found');
+    this.validateAccountData(data, account);
+    if (data.permissions !== undefined) {
+      delegationService.assertCanGrant(claims, data.permissions, account.permissions);
+    }
+    if (data.tokenEndpointAuthMethod !== undefined && data.tokenEndpointAuthMethod !== account.tokenEndpointAuthMethod) {
+      throw new Error('The token endpoint auth method cannot be changed');
+    }
+    const narrowed = (data.permissions !== undefined
+      && account.permissions.some((permission) => !data.permissions.includes(permission)))
+      || data.enabled === false;
+    if (data.name !== undefined) account.name = data.name.trim();
+    if (data.description !== undefined) account.description = data.description;
+    if (data.permissions !== undefined) {
+      account.permissions = [...new Set(data.permissions)];
+      account.grantedBy = claims.user_id;
+      account.grantedByRole = claims.role;
+    }
+    if (data.jwks !== undefined && account.tokenEndpointAuthMethod === 'private_key_jwt') {
+      account.jwks = { keys: data.jwks.keys };
+    }
+    if (data.enabled !== undefined) account.enabled = data.enabled;
+    await account.save();
+    if (narrowed) {
+      await authService.revokeAllTokensForUser(account._id);
+    }
+    return this.toPublic(account);
+  }
+
+  async rotateSecret(clientId) {
+    const account = await ServiceAccount.findOne({ clientId });
+    if (!account) throw new Error('Service account not found');
+    if (account.tokenEndpointAuthMethod === 'private_key_jwt') {
+      throw new Error('This service account signs in with a key, not a secret');
+    }
+    const clientSecret = crypto.randomBytes(32).toString('base64url');
+    account.clientSecretHash = hashSecret(clientSecret);
+    await account.save();
+    return clientSecret;
+  }
+
+  async deleteAccount(clientId) {
+    const account = await ServiceAccount.findOne({ clientId });
+    if (!account) throw new Error('Service account not found');
+    await ServiceAccount.deleteOne({ _id: account._id });
+    await authService.revokeAllTokensForUser(account._id);
+  }
+
+  // The enabled account for a secret presented with the given method, or null
+  async authenticateWithSecret(clientId, clientSecret, method) {
+    const account = this.isServiceClientId(clientId) ? await ServiceAccount.findOne({ clientId, enabled: true }) : null;
+    if (!account || account.tokenEndpointAuthMethod !== method) {
+      return null;
+    }
+    return oauthClientService.verifyClientSecret(account, clientSecret) ? account : null;
+  }
+
+  // The enabled account a client assertion was signed by, or null. audiences
+  // are the URLs the assertion may be addressed to. Each assertion is
+  // accepted once.
+  async authenticateWithAssertion(assertionType, assertion, audiences) {
+    if (assertionType !== CLIENT_ASSERTION_TYPE || typeof assertion !== 'string') {
+      return null;
+    }
+    const unverified = jwt.decode(assertion);
+    const clientId = unverified && unverified.iss;
+    const account = this.isServiceClientId(clientId) ? await ServiceAccount.findOne({ clientId, enabled: true }) : null;
+    if (!account || account.tokenEndpointAuthMethod !== 'private_key_jwt') {
+      return null;
+    }
+
+    let claims;
+    try {
+      claims = await jwt.verify(assertion, (header) => this.assertionKey(account, header));
+    } catch (error) {
+      return null;
+    }
+    const now = Math.floor(Date.now() / 1000);
+    const audience = [].concat(claims.aud || []);
+    if (claims.sub !== clientId
+      || !audience.some((value) => audiences.includes(value))
+      || !claims.jti
+      || !claims.exp
+      || claims.exp - now > MAX_ASSERTION_LIFETIME_SECONDS) {
+      return null;
+    }
+    if (!await cacheStore.setIfAbsent(`client_assertion:${clientId}:${claims.jti}`, '1', claims.exp - now + 60)) {
+      return null;
+    }
+    return account;
+  }
+
+  assertionKey(account, header) {
+    const keys = (account.jwks && account.jwks.keys) || [];
+    const jwk = header.kid ? keys.find((key) => key.kid === header.kid) : keys.length === 1 && keys[0];
+    if (!jwk) {
+      return null;
+    }
+    return { alg: algorithmFor(jwk), publicKey: crypto.createPublicKey({ key: jwk, format: 'jwk' }) };
+  }
+
+  // Requested permissions must be a subset of the account's; none requested
+  // means all of them
+  async issueToken(account, requestedPermissions = []) {
+    const excess = requestedPermissions.filter((permission) => !account.permissions.includes(permission));
+    if (excess.length > 0) {
+      throw new Error(`Scopes not granted to this service account: ${excess.join(', ')}`);
+    }
+    const permissions = requestedPermissions.length > 0 ? [...new Set(requestedPermissions)] : account.permissions;
+    const token = await authService.generateToken(
+      account._id,
+      undefined,
+      'service',
+      permissions,
+      null,
+      null,
+      { client_id: account.clientId, scope: permissions.join(' ') },
+      SERVICE_TOKEN_LIFETIME_SECONDS,
+    );
+    await ServiceAccount.updateOne({ _id: account._id }, { lastUsedAt: new Date() });
+    return { token, expiresIn: SERVICE_TOKEN_LIFETIME_SECONDS, permissions };
+  }
+
+  // Whether a service token's account still exists and is enabled
+  async isActive(accountId) {
+    return Boolean(await ServiceAccount.findOne({ _id: accountId, enabled: true }));
+  }
+}
+
+module.exports = new ServiceAccountService();
+module.exports.CLIENT_ASSERTION_TYPE = CLIENT_ASSERTION_TYPE;
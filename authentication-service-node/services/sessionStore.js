@@ -0,0 +1,106 @@
+const { describeDevice } = require('../utils/requestContext');
+
+// Active-use writes are batched: a session is marked seen at most once a minute
+const LAST_SEEN_RESOLUTION_SECONDS = 60;
+
+class SessionStore {
+  constructor({ model, cacheStore, idleTimeoutSeconds }) {
+    this.model = model;
+    this.cacheStore = cacheStore;
+    this.idleTimeoutSeconds = idleTimeoutSeconds;
+  }
+
+  async create({ sessionId, userId, role, clientId, amr, expiresAt, context = {} }) {
+    const now = new Date();
+    await this.model.create({
+      sessionId,
+      userId: userId.toString(),
+      role,
+      clientId,
+      amr: amr || [],
+      device: describeDevice(context.userAgent),
+      userAgent: context.userAgent,
+      ip: context.ip,
+      lastSeenAt: now,
+      lastSeenIp: context.ip,
+      expiresAt,
+    });
+    await this.cacheStore.set(`session_seen:${sessionId}`, '1', LAST_SEEN_RESOLUTION_SECONDS);
+  }
+
+  // Records activity and reports whether the session may still be used: not
+  // revoked, not past its absolute expiry and not idle for too long. A
+  // session without a record is not usable either; expired records are
+  // removed by the TTL index, and tokens from before session records existed
+  // have to sign in again.
+  async touch(sessionId, context = {}, { force = false } = {}) {
+    const seenKey = `session_seen:${sessionId}`;
+    if (!force && await this.cacheStore.get(seenKey)) {
+      return true;
+    }
+    const now = new Date();
+    const update = { lastSeenAt: now };
+    if (context.ip) update.lastSeenIp = context.ip;
+    const active = await this.model.findOneAndUpdate(
+      {
+        sessionId,
+        revokedAt: null,
+        expiresAt: { $gt: now },
+        lastSeenAt: { $gt: new Date(now.getTime() - this.idleTimeoutSeconds * 1000) },
+      },
+      update,
+    );
+    if (!active) {
+      return false;
+    }
+    await this.cacheStore.set(seenKey, '1', LAST_SEEN_RESOLUTION_SECONDS);
+    return true;
+  }
+
+  async markRevoked(filter) {
+    const sessions = await this.model.find({ ...filter, revokedAt: null });
+    await this.model.updateMany({ ...filter, revokedAt: null }, { revokedAt: new Date() });
+    for (const session of sessions) {
+      await this.cacheStore.del(`session_seen:${session.sessionId}`);
+    }
+    return sessions.map((session) => session.sessionId);
+  }
+
+  async listActive(userId, role = null) {
+    const now = new Date();
+    const filter = {
+      userId: userId.toString(),
+      revokedAt: null,
+      expiresAt: { $gt: now },
+      lastSeenAt: { $gt: new Date(now.getTime() - this.idleTimeoutSeconds * 1000) },
+    };
+    if (role) filter.role = role;
+    return this.model.find(filter).sort({ lastSeenAt: -1 });
+  }
+
+  async find(userId, sessionId) {
+    return this.model.findOne({ userId: userId.toString(), sessionId });
+  }
+
+  // Public shape of a session; currentSessionId marks the caller's own
+  describe(session, currentSessionId = null) {
+    return {
+      id: session.sessionId,
+      role: session.role,
+      clientId: session.clientId,
+      device: session.device,
+      userAgent: session.userAgent,
+      ip: session.ip,
+      lastSeenIp: session.lastSeenIp,
+      amr: session.amr,
+      createdAt: session.createdAt,
+      lastSeenAt: session.lastSeenAt,
+      idleExpiresAt: new Date(session.lastSeenAt.getTime() + this.idleTimeoutSeconds * 1000),
+      expiresAt: session.expiresAt,
+      revokedAt: session.revokedAt,
+      current: session.sessionId === currentSessionId,
+    };
+  }
+}
+
+module.exports = SessionStore;
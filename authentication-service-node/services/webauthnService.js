@@ -0,0 +1,220 @@
+const crypto = require('crypto');
+const WebAuthnCredential = require('../models/WebAuthnCredential');
+const authService = require('./authServiceInstance');
+const cacheStore = require('../utils/cacheStore');
+const webauthn = require('../utils/webauthn');
+const env = require('../config/env');
+
+const CEREMONY_TTL_SECONDS = 5 * 60;
+
+class WebAuthnService {
+  get rp() {
+    return { id: env.WEBAUTHN_RP_ID, name: env.WEBAUTHN_RP_NAME };
+  }
+
+  async listCredentials(userId, role) {
+    const credentials = await WebAuthnCredential.find({ userId: userId.toString(), role });
+    return credentials.map((credential) => ({
+      id: credential._id,
+      credentialId: credential.credentialId,
+      name: credential.name,
+      transports: credential.transports,
+      createdAt: credential.createdAt,
+      lastUsedAt: credential.lastUsedAt,
+    }));
+  }
+
+  async deleteCredential(userId, role, id) {
+    const deleted = await WebAuthnCredential.findOneAndDelete({ _id: id, userId: userId.toString(), role });
+    if (!deleted) {
+      throw new Error('Credential not found');
+    }
+  }
+
+  async hasCredentials(userId, role) {
+    return (await WebAuthnCredential.countDocuments({ userId: userId.toString(), role })) > 0;
+  }
+
+  async registrationOptions(claims) {
+    await authService.assertSecondFactorSession(claims);
+    const challenge = crypto.randomBytes(32).toString('base64url');
+    await cacheStore.set(`webauthn_registration:${claims.user_id}:${claims.role}`, challenge, CEREMONY_TTL_SECONDS);
+
+    const existing = await WebAuthnCredential.find({ userId: claims.user_id, role: claims.role });
+    return {
+      challenge,
+      rp: this.rp,
+      user: {
+        id: Buffer.from(`${claims.role}:${claims.user_id}`).toString('base64url'),
+        name: claims.email,
+        displayName: claims.email,
+      },
+      pubKeyCredParams: Object.values(webauthn.COSE_ALGORITHMS).map((alg) => ({ type: 'public-key', alg })),
+      timeout: CEREMONY_TTL_SECONDS * 1000,
+      attestation: 'none',
+      excludeCredentials: existing.map((credential) => ({
+        type: 'public-key',
+        id: credential.credentialId,
+        transports: credential.transports,
+      })),
+      authenticatorSelection: { residentKey: 'preferred', userVerification: 'preferred' },
+    };
+  }
+
+  async verifyRegistration(claims, credential, name) {
+    await authService.assertSecondFactorSession(claims);
+    const challenge = await cacheStore.take(`webauthn_registration:${claims.user_id}:${claims.role}`);
+    if (!challenge) {
+      throw new Error('No passkey registration in progress');
+    }
+    const verified = webauthn.verifyRegistration(credential, {
+      expectedChallenge: challenge,
+      expectedOrigins: env.WEBAUTHN_ORIGINS,
+      rpId: this.rp.id,
+    });
+    if (await WebAuthnCredential.findOne({ credentialId: verified.credentialId })) {
+      throw new Error('Credential already registered');
+    }
+    const saved = await WebAuthnCredential.create({
+      userId: claims.user_id,
+      role: claims.role,
+      credentialId: verified.credentialId,
+      publicKeyJwk: verified.publicKeyJwk,
+      coseAlg: verified.coseAlg,
+      signCount: verified.signCount,
+      transports: verified.transports,
+      aaguid: verified.aaguid,
+      name: name || 'Passkey',
+    });
+    return { id: saved._id, credentialId: saved.credentialId, name: saved.name };
+  }
+
+  // Assertion options for a login ceremony. Without an email the browser
+  // offers any discoverable passkey for this site. Addresses without
+  // passkeys get a made-up credential that is stable per address, so the
+  // response does not tell whether an account exists.
+  async loginOptions(email) {
+    let allowCredentials = [];
+    if (email) {
+      const account = await authService.accountStore.findByEmail(email);
+      if (account && account.roles.length > 0) {
+        // Passkeys are registered per role; offer those of every role the account holds
+        const credentials = await WebAuthnCredential.find({
+          $or: account.roles.map((entry) => ({ userId: entry.profileId.toString(), role: entry.role })),
+        });
+        allowCredentials = credentials.map((credential) => ({
+          type: 'public-key',
+          id: credential.credentialId,
+          transports: credential.transports,
+        }));
+      }
+      if (allowCredentials.length === 0) {
+        allowCredentials = [this.decoyCredential(email)];
+      }
+    }
+    return this.createAssertionCeremony({ purpose: 'login' }, allowCredentials, 'required');
+  }
+
+  decoyCredential(email) {
+    const id = crypto.createHmac('sha256', env.JWT_SECRET)
+      .update(`webauthn-decoy:${String(email).trim().toLowerCase()}`)
+      .digest()
+      .toString('base64url');
+    return { type: 'public-key', id, transports: ['internal', 'hybrid'] };
+  }
+
+  async createAssertionCeremony(context, allowCredentials, userVerification) {
+    const challenge = crypto.randomBytes(32).toString('base64url');
+    const ceremonyId = crypto.randomBytes(16).toString('base64url');
+    await cacheStore.set(`webauthn_assertion:${ceremonyId}`, JSON.stringify({ ...context, challenge }), CEREMONY_TTL_SECONDS);
+    return {
+      ceremonyId,
+      publicKey: {
+        challenge,
+        rpId: this.rp.id,
+        timeout: CEREMONY_TTL_SECONDS * 1000,
+        allowCredentials,
+        userVerification,
+      },
+    };
+  }
+
+  // Checks the assertion itself; callers confirm the ceremony's purpose and
+  // the credential's owner, then record the use with recordUse
+  async verifyAssertion(ceremonyId, credential, requireUserVerification) {
+    const stored = ceremonyId ? await cacheStore.take(`webauthn_assertion:${ceremonyId}`) : null;
+    if (!stored) {
+      throw new Error('Invalid or expired passkey ceremony');
+    }
+    const ceremony = JSON.parse(stored);
+    const record = credential && credential.id
+      ? await WebAuthnCredential.findOne({ credentialId: credential.id })
+      : null;
+    if (!record) {
+      throw new Error('Unknown credential');
+    }
+
+    const result = webauthn.verifyAssertion(credential, {
+      expectedChallenge: ceremony.challenge,
+      expectedOrigins: env.WEBAUTHN_ORIGINS,
+      rpId: this.rp.id,
+      publicKeyJwk: record.publicKeyJwk,
+      coseAlg: record.coseAlg,
+      storedSignCount: record.signCount,
+      requireUserVerification,
+    });
+    return { ceremony, record, signCount: result.signCount };
+  }
+
+  async recordUse(record, signCount) {
+    await WebAuthnCredential.findByIdAndUpdate(record._id, { signCount, lastUsedAt: new Date() });
+  }
+
+  // Passwordless login; user verification makes the passkey a full second factor
+  async login(ceremonyId, credential, context = {}) {
+    const { ceremony, record, signCount } = await this.verifyAssertion(ceremonyId, credential, true);
+    if (ceremony.purpose !== 'login') {
+      throw new Error('Invalid or expired passkey ceremony');
+    }
+    const handle = credential.response.userHandle;
+    if (handle && Buffer.from(handle, 'base64url').toString('utf8') !== `${record.role}:${record.userId}`) {
+      throw new Error('Credential does not belong to this user');
+    }
+    await this.recordUse(record, signCount);
+    return authService.loginWithVerifiedCredential(record.userId, record.role, ['hwk', 'user'], context);
+  }
+
+  async mfaOptions(mfaToken) {
+    const challenge = await authService.getMfaChallenge(mfaToken);
+    const credentials = await WebAuthnCredential.find({ userId: challenge.userId, role: challenge.role });
+    if (credentials.length === 0) {
+      throw new Error('No passkeys registered');
+    }
+    return this.createAssertionCeremony(
+      { purpose: 'mfa', userId: challenge.userId, role: challenge.role },
+      credentials.map((credential) => ({ type: 'public-key', id: credential.credentialId, transports: credential.transports })),
+      'preferred',
+    );
+  }
+
+  async verifyMfa(mfaToken, ceremonyId, credential) {
+    const challenge = await authService.beginMfaAttempt(mfaToken);
+    let verified;
+    try {
+      verified = await this.verifyAssertion(ceremonyId, credential, false);
+    } catch (error) {
+      await authService.recordMfaChallengeFailure(challenge);
+      throw error;
+    }
+    const { ceremony, record, signCount } = verified;
+    if (ceremony.purpose !== 'mfa' || ceremony.userId !== challenge.userId || ceremony.role !== challenge.role
+      || record.userId !== challenge.userId || record.role !== challenge.role) {
+      await authService.recordMfaChallengeFailure(challenge);
+      throw new Error('Credential does not belong to this user');
+    }
+    await this.recordUse(record, signCount);
+    return authService.completeMfaChallenge(mfaToken, challenge, 'hwk');
+  }
+}
+
+module.exports = new WebAuthnService();
@@ -0,0 +1,96 @@
+const { test } = require('node:test');
+const assert = require('node:assert/strict');
+const bcrypt = require('bcryptjs');
+const { app, models, PASSWORD } = require('./support/app');
+
+// Loads every model the migration touches
+app('services/authServiceInstance');
+const AccountStore = app('services/accountStore');
+
+
+const accountStore = new AccountStore({
+  accountModel: models.Account,
+  profileModels: {
+    super_admin: models.SuperAdmin,
+    admin: models.Admin,
+    doctor: models.Doctor,
+    patient: models.Patient,
+  },
+  passwordHistoryModel: models.PasswordHistory,
+});
+
+const hash = (password = PASSWORD) => bcrypt.hash(password, 4);
+
+const accountFor = (email) => models.Account.docs.find((doc) => doc.email === email);
+
+test('profiles move their credentials and password history to an account for their email', async () => {
+  const doctor = await models.Doctor.create({ name: 'Doc', email: ' Solo@Hospital.test ', passwordHash: await hash(), emailVerified: true });
+  const old = await models.PasswordHistory.create({ userId: doctor._id.toString(), role: 'doctor', passwordHash: await hash('Old-password-1') });
+
+  const report = await accountStore.migrateLegacyProfiles();
+  assert.equal(report.accountsCreated, 1);
+  assert.equal(report.profilesLinked, 1);
+  assert.deepEqual(report.conflicts, []);
+
+  const account = accountFor('solo@hospital.test');
+  assert.deepEqual(account.roles, [{ role: 'doctor', profileId: doctor._id }]);
+  assert.equal(await bcrypt.compare(PASSWORD, account.passwordHash), true);
+  assert.equal(account.emailVerified, true);
+  assert.equal(doctor.accountId, account._id);
+  assert.equal(doctor.email, 'solo@hospital.test');
+  assert.equal(doctor.passwordHash, undefined);
+  assert.equal(old.userId, account._id.toString());
+  assert.equal(old.role, 'account');
+
+  // Running again finds nothing left to do
+  assert.deepEqual(await accountStore.migrateLegacyProfiles(), { accountsCreated: 0, profilesLinked: 0, conflicts: [] });
+});
+
+test('a profile with a password of its own is reported, not merged, whatever the password', async () => {
+  // Salted separately, so nothing tells these hashes of one password apart from different passwords
+  const adminHash = await hash();
+  await models.Admin.create({ username: 'dana', email: 'dana@hospital.test', passwordHash: adminHash });
+  const patient = await models.Patient.create({ name: 'Dana', email: 'DANA@hospital.test', passwordHash: await hash() });
+  // Federated profiles have no password to weigh up
+  const doctor = await models.Doctor.create({ name: 'Dana', email: 'dana@hospital.test' });
+
+  const report = await accountStore.migrateLegacyProfiles();
+  assert.deepEqual(report.conflicts, [{ email: 'dana@hospital.test', role: 'patient', profileId: patient._id, reason: 'separate password' }]);
+
+  const account = accountFor('dana@hospital.test');
+  assert.deepEqual(accountStore.rolesOf(account), ['admin', 'doctor']);
+  assert.equal(account.passwordHash, adminHash);
+  // Staff accounts count as verified
+  assert.equal(account.emailVerified, true);
+  assert.equal(doctor.accountId, account._id);
+  assert.equal(patient.accountId, undefined);
+  assert.ok(patient.passwordHash);
+  assert.equal(await accountStore.countUnlinkedProfiles(), 1);
+});
+
+test('a copied hash joins the account and a second profile for the same role is reported', async () => {
+  const passwordHash = await hash();
+  const first = await models.Doctor.create({ name: 'Lee', email: 'lee@hospital.test', passwordHash });
+  const patient = await models.Patient.create({ name: 'Lee', email: 'lee@hospital.test', passwordHash });
+  const second = await models.Doctor.create({ name: 'Lee', email: ' LEE@hospital.test', passwordHash });
+
+  // Dana's patient profile from above is still waiting for an operator
+  const report = await accountStore.migrateLegacyProfiles();
+  assert.deepEqual(report.conflicts.filter((conflict) => conflict.email === 'lee@hospital.test'), [{ email: 'lee@hospital.test', role: 'doctor', profileId: second._id, reason: 'duplicate role' }]);
+  const account = accountFor('lee@hospital.test');
+  assert.deepEqual(accountStore.rolesOf(account), ['doctor', 'patient']);
+  assert.equal(accountStore.profileIdFor(account, 'doctor'), first._id);
+  assert.equal(patient.accountId, account._id);
+  assert.equal(second.accountId, undefined);
+});
+
+test('one account per normalized email', async () => {
+  const profileId = (await models.Patient.create({ name: 'Kim', email: 'kim@hospital.test' }))._id;
+  await accountStore.create({ email: 'kim@hospital.test', passwordHash: await hash(), role: 'patient', profileId });
+  await assert.rejects(
+    accountStore.create({ email: '  KIM@Hospital.test', passwordHash: await hash(), role: 'doctor', profileId }),
+    { code: 11000 },
+  );
+  assert.equal(await accountStore.findByEmail('Kim@hospital.test ').then((account) => account.roles.length), 1);
+  assert.equal(models.Account.docs.filter((doc) => doc.email === 'kim@hospital.test').length, 1);
+});
@@ -0,0 +1,113 @@
+const { test } = require('node:test');
+const assert = require('node:assert/strict');
+const { app, models, PASSWORD, uniqueEmail } = require('./support/app');
+
+const authService = app('services/authServiceInstance');
+const apiKeyService = app('services/apiKeyService');
+const apiKeyFormat = app('utils/apiKeyFormat');
+
+// Registered directly, so the admin holds exactly these permissions
+const registerAdmin = async (permissions) => {
+  const email = uniqueEmail('keys');
+  const { profile } = await authService.registerAccount('admin', { username: email.split('@')[0], email, permissions }, PASSWORD);
+  return { profile, claims: { user_id: profile._id.toString(), role: 'admin', email } };
+};
+
+const storedKey = (id) => models.ApiKey.docs.find((doc) => doc._id.toString() === id.toString());
+
+test('a key is shown once and resolves to a principal with its scopes', async () => {
+  const { claims } = await registerAdmin(['doctor:list', 'doctor:view']);
+  const { apiKey, key } = await apiKeyService.createKey(claims, { name: 'Reporting', scopes: ['doctor:list'] });
+
+  assert.ok(apiKeyFormat.isWellFormed(key));
+  assert.equal(apiKey.prefix, key.slice(0, 10));
+  assert.equal(storedKey(apiKey.id).keyHash, apiKeyFormat.hash(key));
+  assert.ok(models.ApiKey.docs.every((doc) => !Object.values(doc).includes(key)));
+
+  const principal = await apiKeyService.authenticate(key, { ip: '198.51.100.30' });
+  assert.equal(principal.user_id, claims.user_id);
+  assert.equal(principal.auth_method, 'api_key');
+  assert.equal(principal.can('doctor:list'), true);
+  assert.equal(principal.can('doctor:view'), false);
+  assert.equal(principal.interactive, false);
+  assert.equal(storedKey(apiKey.id).lastUsedIp, '198.51.100.30');
+});
+
+test('refuses scopes the owner does not hold or that do not exist', async () => {
+  const { claims } = await registerAdmin(['doctor:list']);
+  await assert.rejects(apiKeyService.createKey(claims, { name: 'Too much', scopes: ['admin:create'] }), /exceed your permissions/);
+  await assert.rejects(apiKeyService.createKey(claims, { name: 'Typo', scopes: ['doctor:lsit'] }), /Unknown scopes/);
+  await assert.rejects(apiKeyService.createKey(claims, { name: 'None', scopes: [] }), /At least one scope/);
+  await assert.rejects(
+    apiKeyService.createKey(claims, { name: 'Forever', scopes: ['doctor:list'], expiresInDays: 100000 }),
+    /Key lifetime must be between/,
+  );
+});
+
+test('refuses malformed, revoked and expired keys', async () => {
+  const { claims } = await registerAdmin(['doctor:list']);
+  const { key } = await apiKeyService.createKey(claims, { name: 'Short lived', scopes: ['doctor:list'] });
+
+  // A changed character breaks the checksum
+  const tampered = `${key.slice(0, 8)}${key[8] === 'a' ? 'b' : 'a'}${key.slice(9)}`;
+  await assert.rejects(apiKeyService.authenticate(tampered), /Malformed API key/);
+  await assert.rejects(apiKeyService.authenticate(apiKeyFormat.generate()), /Invalid or expired API key/);
+
+  const expiring = await apiKeyService.createKey(claims, { name: 'Expiring', scopes: ['doctor:list'] });
+  storedKey(expiring.apiKey.id).expiresAt = new Date(Date.now() - 1000);
+  await assert.rejects(apiKeyService.authenticate(expiring.key), /Invalid or expired API key/);
+
+  const revoked = await apiKeyService.createKey(claims, { name: 'Revoked', scopes: ['doctor:list'] });
+  await apiKeyService.revokeKey(claims.user_id, revoked.apiKey.id);
+  await assert.rejects(apiKeyService.authenticate(revoked.key), /Invalid or expired API key/);
+});
+
+test('a key loses the scopes its owner loses', async () => {
+  const { profile, claims } = await registerAdmin(['doctor:list', 'doctor:view']);
+  const { apiKey, key } = await apiKeyService.createKey(claims, { name: 'Both', scopes: ['doctor:list', 'doctor:view'] });
+
+  profile.permissions = ['doctor:list'];
+  const principal = await apiKeyService.authenticate(key);
+  assert.deepEqual(principal.permissions, ['doctor:list']);
+
+  // Rotating drops the lost scope for good
+  const rotated = await apiKeyService.rotateKey(claims, apiKey.id);
+  assert.deepEqual(rotated.apiKey.scopes, ['doctor:list']);
+});
+
+test('rotation issues a new key and retires the old one after the grace period', async () => {
+  const { claims } = await registerAdmin(['doctor:list']);
+  const original = await apiKeyService.createKey(claims, { name: 'Deploy', scopes: ['doctor:list'], expiresInDays: 30 });
+
+  const rotated = await apiKeyService.rotateKey(claims, original.apiKey.id, { gracePeriodMinutes: 10 });
+  assert.notEqual(rotated.key, original.key);
+  assert.equal(rotated.apiKey.rotatedFrom, original.apiKey.id);
+  await apiKeyService.authenticate(original.key);
+  await apiKeyService.authenticate(rotated.key);
+  assert.ok(storedKey(original.apiKey.id).expiresAt <= new Date(Date.now() + 10 * 60 * 1000));
+
+  const immediate = await apiKeyService.rotateKey(claims, rotated.apiKey.id);
+  await assert.rejects(apiKeyService.authenticate(rotated.key), /Invalid or expired API key/);
+  await apiKeyService.authenticate(immediate.key);
+  await assert.rejects(apiKeyService.rotateKey(claims, rotated.apiKey.id), /Only active API keys can be rotated/);
+});
+
+test('keys belong to their owner alone', async () => {
+  const owner = await registerAdmin(['doctor:list']);
+  const other = await registerAdmin(['doctor:list']);
+  const { apiKey } = await apiKeyService.createKey(owner.claims, { name: 'Mine', scopes: ['doctor:list'] });
+
+  await assert.rejects(apiKeyService.getKey(other.claims.user_id, apiKey.id), /API key not found/);
+  await assert.rejects(apiKeyService.revokeKey(other.claims.user_id, apiKey.id), /API key not found/);
+  await assert.rejects(apiKeyService.rotateKey(other.claims, apiKey.id), /API key not found/);
+  assert.deepEqual(await apiKeyService.listKeys(other.claims.user_id), []);
+  assert.equal((await apiKeyService.listKeys(owner.claims.user_id)).length, 1);
+});
+
+test('limits how many active keys a user may hold', async () => {
+  const { claims } = await registerAdmin(['doctor:list']);
+  for (let index = 0; index < 20; index += 1) {
+    await apiKeyService.createKey(claims, { name: `Key ${index}`, scopes: ['doctor:list'] });
+  }
+  await assert.rejects(apiKeyService.createKey(claims, { name: 'One more', scopes: ['doctor:list'] }), /At most 20/);
+});
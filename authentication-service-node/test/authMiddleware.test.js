@@ -0,0 +1,97 @@
+const { test, before } = require('node:test');
+const assert = require('node:assert/strict');
+const { app, models, PASSWORD } = require('./support/app');
+
+const authService = app('services/authServiceInstance');
+const apiKeyService = app('services/apiKeyService');
+const serviceAccountService = app('services/serviceAccountService');
+const {
+  validateToken,
+  requireInteractive,
+  requireRole,
+  requirePolicy,
+} = app('middleware/authMiddleware');
+
+const SUPER_ADMIN_EMAIL = 'root@hospital.test';
+
+// One credential of each kind, all but the service account's for a super admin
+const credentials = {};
+let patientId;
+
+// Runs the middlewares in order like express and answers with the status of
+// the first refusal, or 'next' when every one passed the request on
+const run = async (middlewares, headers) => {
+  const req = { headers, params: { id: patientId }, method: 'GET', originalUrl: '/test', get: (name) => headers[name.toLowerCase()] };
+  let status = 200;
+  const res = { status: (code) => { status = code; return res; }, on: () => {}, set: () => {} };
+  for (const middleware of middlewares) {
+    const error = await new Promise((resolve) => {
+      try {
+        middleware(req, res, resolve);
+      } catch (thrown) {
+        resolve(thrown);
+      }
+    });
+    if (error) return status;
+  }
+  return 'next';
+};
+
+before(async () => {
+  const { profile } = await authService.registerAccount('super_admin', {
+    username: 'root',
+    email: SUPER_ADMIN_EMAIL,
+    permissions: authService.defaultSuperAdminPermissions(),
+  }, PASSWORD);
+  const claims = { user_id: profile._id.toString(), role: 'super_admin', email: SUPER_ADMIN_EMAIL, permissions: ['all_permissions'] };
+  patientId = (await models.Patient.create({ name: 'Pat', isApproved: true }))._id.toString();
+
+  const accessToken = await authService.generateToken(profile._id, SUPER_ADMIN_EMAIL, 'super_admin', ['all_permissions']);
+  credentials.accessToken = { authorization: `Bearer ${accessToken}` };
+
+  const oauthToken = await authService.generateToken(profile._id, SUPER_ADMIN_EMAIL, 'super_admin', ['patient:view'], null, null, {
+    client_id: 'reporting-app',
+    scope: 'patient:view',
+  });
+  credentials.oauthClient = { authorization: `Bearer ${oauthToken}` };
+
+  const { key } = await apiKeyService.createKey(claims, { name: 'Reporting', scopes: ['patient:view'] });
+  credentials.apiKey = { 'x-api-key': key };
+
+  const { serviceAccount } = await serviceAccountService.createAccount(claims, { name: 'Billing', permissions: ['patient:view'] });
+  const account = models.ServiceAccount.docs.find((doc) => doc.clientId === serviceAccount.clientId);
+  account.enabled = true;
+  credentials.clientCredentials = { authorization: `Bearer ${(await serviceAccountService.issueToken(account)).token}` };
+});
+
+test('a role gate checks the role whichever credential carries it', async () => {
+  const gate = [validateToken, requireRole('super_admin')];
+  assert.equal(await run(gate, credentials.accessToken), 'next');
+  assert.equal(await run(gate, credentials.apiKey), 'next');
+  assert.equal(await run(gate, credentials.oauthClient), 'next');
+  assert.equal(await run(gate, credentials.clientCredentials), 403);
+  assert.equal(await run(gate, {}), 401);
+});
+
+test('only a signed-in session passes requireInteractive, also in front of a role gate', async () => {
+  const gate = [validateToken, requireInteractive, requireRole('super_admin')];
+  assert.equal(await run(gate, credentials.accessToken), 'next');
+  assert.equal(await run(gate, credentials.apiKey), 403);
+  assert.equal(await run(gate, credentials.oauthClient), 403);
+  assert.equal(await run(gate, credentials.clientCredentials), 403);
+});
+
+test('policies judge every credential by its permissions', async () => {
+  const read = [validateToken, requirePolicy('patient.read')];
+  assert.equal(await run(read, credentials.accessToken), 'next');
+  assert.equal(await run(read, credentials.apiKey), 'next');
+  assert.equal(await run(read, credentials.oauthClient), 'next');
+  assert.equal(await run(read, credentials.clientCredentials), 'next');
+
+  // None of the delegated credentials was granted the history
+  const history = [validateToken, requirePolicy('patient.history.read')];
+  assert.equal(await run(history, credentials.accessToken), 'next');
+  assert.equal(await run(history, credentials.apiKey), 403);
+  assert.equal(await run(history, credentials.oauthClient), 403);
+  assert.equal(await run(history, credentials.clientCredentials), 403);
+});
@@ -0,0 +1,115 @@
+const { test, before } = require('node:test');
+const assert = require('node:assert/strict');
+const { app, models, sentMail } = require('./support/app');
+
+const breakGlassService = app('services/breakGlassService');
+const policyEngine = app('services/policyEngine');
+const Principal = app('utils/principal');
+
+const JUSTIFICATION = 'Unconscious patient in the emergency department';
+const REVIEWER = { user_id: '64b0000000000000000000aa', role: 'admin', email: 'compliance@hospital.test' };
+
+let doctors = 0;
+const newDoctor = async () => {
+  doctors += 1;
+  const doctor = await models.Doctor.create({ email: `er${doctors}@hospital.test`, isApproved: true });
+  return {
+    user_id: doctor._id.toString(),
+    role: 'doctor',
+    email: doctor.email,
+    sid: `session-${doctors}`,
+    amr: ['pwd'],
+    permissions: ['patient:view', 'patient:history', 'break_glass:request'],
+  };
+};
+const newPatient = async () => (await models.Patient.create({ name: 'Pat' }))._id.toString();
+
+const read = async (claims, patientId) => policyEngine.evaluate('patient.read', new Principal(claims), { id: patientId });
+
+const storedGrant = (id) => models.BreakGlassGrant.docs.find((doc) => doc._id.toString() === id.toString());
+
+before(async () => {
+  await models.SuperAdmin.create({ email: 'root@hospital.test' });
+});
+
+test('a grant opens one patient to the doctor who asked, is audited and reported', async () => {
+  const [doctor, patientId] = [await newDoctor(), await newPatient()];
+  assert.equal((await read(doctor, patientId)).allowed, false);
+
+  const grant = await breakGlassService.request(doctor, patientId, { justification: JUSTIFICATION, durationMinutes: 30 });
+  assert.equal(grant.active, true);
+  assert.equal(grant.review.status, 'pending');
+  assert.ok(grant.expiresAt <= new Date(Date.now() + 30 * 60 * 1000));
+
+  const decision = await read(doctor, patientId);
+  assert.equal(decision.rule, 'break-glass');
+  assert.equal((await read(doctor, await newPatient())).allowed, false);
+  assert.equal((await read(await newDoctor(), patientId)).allowed, false);
+  // A support user acting as the doctor does not inherit it
+  assert.equal((await read({ ...doctor, act: { sub: 'support-1' } }, patientId)).allowed, false);
+
+  await breakGlassService.recordAccess(new Principal(doctor), decision.trace.at(-1).breakGlassGrantId, { method: 'GET', path: `/patients/${patientId}` });
+  assert.equal(storedGrant(grant.id).accessCount, 1);
+  const actions = models.AuditEvent.docs.filter((event) => event.actor.userId === doctor.user_id).map((event) => event.action);
+  assert.deepEqual(actions, ['break_glass.grant', 'break_glass.access']);
+
+  const mail = sentMail().find((entry) => entry.text.includes(grant.id.toString()));
+  assert.deepEqual(mail.to, ['root@hospital.test']);
+  assert.match(mail.text, new RegExp(JUSTIFICATION));
+});
+
+test('requests need a clinician, a reason, a sensible duration and a patient', async () => {
+  const [doctor, patientId] = [await newDoctor(), await newPatient()];
+  const admin = { ...doctor, role: 'admin' };
+  await assert.rejects(breakGlassService.request(admin, patientId, { justification: JUSTIFICATION }), /Only clinicians/);
+  await assert.rejects(breakGlassService.request(doctor, patientId, { justification: 'emergency' }), /at least 20 characters/);
+  await assert.rejects(breakGlassService.request(doctor, patientId, { justification: JUSTIFICATION, durationMinutes: 24 * 60 }), /between 1 and 240 minutes/);
+  await assert.rejects(breakGlassService.request(doctor, '64b0000000000000000000ff', { justification: JUSTIFICATION }), /Patient not found/);
+});
+
+test('one open grant per doctor and patient, even when requests race', async () => {
+  const [doctor, patientId] = [await newDoctor(), await newPatient()];
+  const results = await Promise.allSettled(Array.from({ length: 3 }, () => (
+    breakGlassService.request(doctor, patientId, { justification: JUSTIFICATION })
+  )));
+  assert.equal(results.filter((result) => result.status === 'fulfilled').length, 1);
+  for (const result of results.filter((outcome) => outcome.status === 'rejected')) {
+    assert.match(result.reason.message, /already have break-glass access/);
+  }
+  assert.equal(models.BreakGlassGrant.docs.filter((doc) => doc.userId === doctor.user_id).length, 1);
+});
+
+test('ending or lapsing closes a grant and a new one can be requested', async () => {
+  const [doctor, patientId] = [await newDoctor(), await newPatient()];
+  const first = await breakGlassService.request(doctor, patientId, { justification: JUSTIFICATION });
+  await breakGlassService.end(doctor, patientId);
+  assert.equal((await read(doctor, patientId)).allowed, false);
+  await assert.rejects(breakGlassService.end(doctor, patientId), /No active break-glass access/);
+
+  const second = await breakGlassService.request(doctor, patientId, { justification: JUSTIFICATION });
+  const lapsedAt = new Date(Date.now() - 1000);
+  storedGrant(second.id).expiresAt = lapsedAt;
+  assert.equal((await read(doctor, patientId)).allowed, false);
+
+  const third = await breakGlassService.request(doctor, patientId, { justification: JUSTIFICATION });
+  assert.deepEqual(storedGrant(second.id).endedAt, lapsedAt);
+  assert.equal(storedGrant(third.id).endedAt, null);
+  assert.ok(storedGrant(first.id).endedAt < storedGrant(third.id).createdAt);
+});
+
+test('compliance reviews grants, never their own', async () => {
+  const [doctor, patientId] = [await newDoctor(), await newPatient()];
+  const grant = await breakGlassService.request(doctor, patientId, { justification: JUSTIFICATION });
+  assert.ok((await breakGlassService.listGrants()).some((pending) => pending.id === grant.id));
+
+  await assert.rejects(breakGlassService.review(doctor, grant.id, { decision: 'accepted' }), /cannot review your own/);
+  await assert.rejects(breakGlassService.review(REVIEWER, grant.id, { decision: 'ignored' }), /decision must be one of/);
+  const reviewed = await breakGlassService.review(REVIEWER, grant.id, { decision: 'flagged', note: ' No emergency on record ' });
+  assert.equal(reviewed.review.status, 'flagged');
+  assert.equal(reviewed.review.note, 'No emergency on record');
+
+  assert.ok(!(await breakGlassService.listGrants()).some((pending) => pending.id === grant.id));
+  assert.deepEqual((await breakGlassService.listGrants({ status: 'flagged', userId: doctor.user_id })).map((flagged) => flagged.id), [grant.id]);
+  const detail = await breakGlassService.getGrant(grant.id.toString());
+  assert.deepEqual(detail.events.map((event) => event.action), ['break_glass.grant']);
+});
@@ -0,0 +1,107 @@
+const { test, before } = require('node:test');
+const assert = require('node:assert/strict');
+const { app, models, createAdmin } = require('./support/app');
+
+const adminService = app('services/adminService');
+const roleService = app('services/roleService');
+const delegationService = app('services/delegationService');
+const { GrantDeniedError } = app('utils/permissionRules');
+const env = app('config/env');
+
+let superAdmin;
+
+const claimsOf = (admin) => ({
+  user_id: admin._id.toString(),
+  role: 'admin',
+  email: admin.email,
+  permissions: [...new Set([...(admin.permissions || []), ...(admin.rolePermissions || [])])],
+});
+
+const reload = (admin) => models.Admin.docs.find((doc) => doc._id === admin._id);
+
+const withRevocationMode = async (mode, action) => {
+  const previous = env.DELEGATION_REVOCATION_MODE;
+  env.DELEGATION_REVOCATION_MODE = mode;
+  try {
+    return await action();
+  } finally {
+    env.DELEGATION_REVOCATION_MODE = previous;
+  }
+};
+
+before(async () => {
+  await roleService.ensureBuiltInRoles();
+  const created = await models.SuperAdmin.create({ email: 'root@hospital.test' });
+  superAdmin = { user_id: created._id.toString(), role: 'super_admin', email: created.email, permissions: ['all_permissions'] };
+});
+
+test('an admin creates admins with at most what they hold', async () => {
+  const lead = await createAdmin(superAdmin, ['doctor:list', 'doctor:view', 'patient:list']);
+  assert.equal(lead.createdBy, superAdmin.user_id);
+  assert.equal(lead.createdByRole, 'super_admin');
+
+  const delegate = await createAdmin(claimsOf(lead), ['doctor:list']);
+  assert.equal(delegate.createdBy, lead._id.toString());
+  await assert.rejects(createAdmin(claimsOf(lead), ['doctor:*']), GrantDeniedError);
+  // The default role holds more than the lead does
+  await assert.rejects(createAdmin(claimsOf(lead), []), GrantDeniedError);
+  await assert.rejects(roleService.setDirectPermissions(claimsOf(lead), delegate._id, ['admin:create']), GrantDeniedError);
+});
+
+test('the chain up to the super admin and the delegates below can be queried', async () => {
+  const lead = await createAdmin(superAdmin, ['doctor:list', 'patient:list']);
+  const middle = await createAdmin(claimsOf(lead), ['doctor:list', 'patient:list']);
+  const bottom = await createAdmin(claimsOf(middle), ['doctor:list']);
+
+  const described = await delegationService.describe(bottom._id.toString());
+  assert.deepEqual(described.createdBy.map((link) => [link.id.toString(), link.role]), [
+    [middle._id.toString(), 'admin'],
+    [lead._id.toString(), 'admin'],
+    [superAdmin.user_id, 'super_admin'],
+  ]);
+
+  const tree = await delegationService.describe(lead._id.toString());
+  assert.deepEqual(tree.delegates.map((delegate) => delegate.id), [middle._id]);
+  assert.deepEqual(tree.delegates[0].delegates.map((delegate) => delegate.id), [bottom._id]);
+  assert.ok((await delegationService.describe(superAdmin.user_id)).delegates.some((delegate) => delegate.id === lead._id));
+
+  // A deleted creator ends the chain
+  await adminService.deleteAdmin(middle._id);
+  assert.deepEqual((await delegationService.describe(bottom._id.toString())).createdBy, [
+    { id: middle._id.toString(), role: 'admin', deleted: true },
+  ]);
+  await assert.rejects(delegationService.describe('64b0000000000000000000ff'), /Admin not found/);
+});
+
+test('in flag mode, admins left holding more than their creator are flagged for review', async () => {
+  const lead = await createAdmin(superAdmin, ['doctor:list', 'patient:list']);
+  const delegate = await createAdmin(claimsOf(lead), ['doctor:list', 'patient:list']);
+
+  await withRevocationMode('flag', () => roleService.setDirectPermissions(superAdmin, lead._id, ['doctor:list']));
+  assert.deepEqual(reload(delegate).permissions, ['doctor:list', 'patient:list']);
+  assert.deepEqual(delegationService.flagOf(reload(delegate)).excessPermissions, ['patient:list']);
+  assert.ok((await delegationService.listFlagged()).some((flagged) => flagged.id === delegate._id));
+  assert.ok(models.AuditEvent.docs.some((event) => event.action === 'delegation.flag'
+    && event.subject.userId.toString() === delegate._id.toString()));
+
+  // Giving the creator the permission back clears the flag
+  await withRevocationMode('flag', () => roleService.setDirectPermissions(superAdmin, lead._id, ['doctor:list', 'patient:list']));
+  assert.equal(delegationService.flagOf(reload(delegate)), null);
+});
+
+test('in cascade mode, what the creator lost is taken away all the way down', async () => {
+  await roleService.createRole(superAdmin, { name: 'directory', permissions: ['doctor:list', 'patient:list'] });
+  const lead = await createAdmin(superAdmin, ['doctor:list', 'patient:list', 'system:logs']);
+  const middle = await createAdmin(claimsOf(lead), ['system:logs'], ['directory']);
+  const bottom = await createAdmin(claimsOf(middle), ['patient:list', 'system:logs']);
+
+  await withRevocationMode('cascade', () => roleService.setDirectPermissions(superAdmin, lead._id, ['doctor:list', 'system:logs']));
+
+  // The role grants more than the lead now holds, so it is unassigned and
+  // what the lead still holds of it stays as a direct grant
+  assert.deepEqual(reload(middle).roles, []);
+  assert.deepEqual(reload(middle).permissions.sort(), ['doctor:list', 'system:logs']);
+  assert.deepEqual(reload(bottom).permissions, ['system:logs']);
+  assert.equal(delegationService.flagOf(reload(bottom)), null);
+  assert.equal(models.AuditEvent.docs.filter((event) => event.action === 'delegation.cascade').length, 2);
+});
@@ -0,0 +1,113 @@
+const { test } = require('node:test');
+const assert = require('node:assert/strict');
+const { app, models, sentMail, linkToken, PASSWORD, uniqueEmail } = require('./support/app');
+
+const authService = app('services/authServiceInstance');
+const patientService = app('services/patientService');
+const emailVerificationService = app('services/emailVerificationService');
+const cacheStore = app('utils/cacheStore');
+const { requireVerifiedEmail } = app('middleware/authMiddleware');
+
+// Registers through the patient API, which sends the verification email
+const register = async () => {
+  const email = uniqueEmail('verify');
+  await patientService.registerPatient({ email, name: 'Patient', isApproved: true }, PASSWORD);
+  return email;
+};
+
+const account = (email) => models.Account.docs.find((doc) => doc.email === email);
+
+const claimsFor = async (email) => authService.validateToken((await authService.login(email, PASSWORD)).token);
+
+// Lets the next resend through the cooldown
+const skipCooldown = (email) => cacheStore.del(`email_verification_cooldown:${account(email)._id}`);
+
+test('registering sends a link that verifies the address', async () => {
+  const email = await register();
+  assert.equal((await claimsFor(email)).email_verified, false);
+
+  await emailVerificationService.confirm(linkToken(email));
+  assert.equal(account(email).emailVerified, true);
+  assert.equal((await claimsFor(email)).email_verified, true);
+});
+
+test('a verification link works only once', async () => {
+  const email = await register();
+  const token = linkToken(email);
+
+  await emailVerificationService.confirm(token);
+  await assert.rejects(emailVerificationService.confirm(token), /Invalid or expired verification token/);
+});
+
+test('a resent link replaces the previous one', async () => {
+  const email = await register();
+  const first = linkToken(email);
+  await emailVerificationService.resend(email);
+  const second = linkToken(email);
+
+  assert.notEqual(first, second);
+  await assert.rejects(emailVerificationService.confirm(first), /Invalid or expired verification token/);
+  await emailVerificationService.confirm(second);
+});
+
+test('an expired link, or one for a previous address, is refused', async () => {
+  const expired = await register();
+  models.EmailVerificationToken.docs.forEach((doc) => { doc.expiresAt = new Date(Date.now() - 1000); });
+  await assert.rejects(emailVerificationService.confirm(linkToken(expired)), /Invalid or expired/);
+
+  const changed = await register();
+  const token = linkToken(changed);
+  account(changed).email = `new-${changed}`;
+  await assert.rejects(emailVerificationService.confirm(token), /Invalid or expired verification token/);
+  assert.equal(account(`new-${changed}`).emailVerified, false);
+});
+
+test('resending skips unknown and verified addresses', async () => {
+  const before = sentMail().length;
+  await emailVerificationService.resend('nobody@hospital.test');
+
+  const email = await register();
+  await emailVerificationService.confirm(linkToken(email));
+  await skipCooldown(email);
+  await emailVerificationService.resend(email);
+  // Only the registration email
+  assert.equal(sentMail().length, before + 1);
+});
+
+test('resending is limited by a cooldown and a daily cap, even in parallel', async () => {
+  const email = await register();
+  await Promise.all([1, 2, 3].map(() => emailVerificationService.resend(email)));
+  // The registration email plus one resend
+  assert.equal(sentMail().filter((entry) => entry.to === email).length, 2);
+
+  for (let attempt = 0; attempt < 10; attempt += 1) {
+    await skipCooldown(email);
+    await emailVerificationService.resend(email);
+  }
+  assert.equal(sentMail().filter((entry) => entry.to === email).length, 6);
+});
+
+test('the phi policy keeps unverified accounts away from patient data', async () => {
+  const email = await register();
+  const claims = await claimsFor(email);
+  const run = (req) => {
+    const res = { status(code) { this.statusCode = code; return this; } };
+    let passed = false;
+    try {
+      requireVerifiedEmail(req, res, () => { passed = true; });
+    } catch (error) {
+      return { passed, status: res.statusCode };
+    }
+    return { passed };
+  };
+
+  assert.equal(run({ user: claims }).passed, true);
+  authService.emailVerificationPolicy = 'phi';
+  try {
+    assert.deepEqual(run({ user: claims }), { passed: false, status: 403 });
+    await emailVerificationService.confirm(linkToken(email));
+    assert.equal(run({ user: await claimsFor(email) }).passed, true);
+  } finally {
+    authService.emailVerificationPolicy = 'off';
+  }
+});
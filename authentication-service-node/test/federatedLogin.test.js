@@ -0,0 +1,180 @@
+const { test, before, after } = require('node:test');
+const assert = require('node:assert/strict');
+const crypto = require('crypto');
+const { app, models } = require('./support/app');
+const oidcProvider = require('./support/oidcProvider');
+
+const authService = app('services/authServiceInstance');
+const federatedLoginService = app('services/federatedLoginService');
+const identityProviderService = app('services/identityProviderService');
+const oidcClient = app('utils/oidcClient');
+
+const CLIENT_ID = 'hospital-portal';
+const CLIENT_SECRET = 'provider-secret';
+
+const idp = oidcProvider({ clientId: CLIENT_ID, clientSecret: CLIENT_SECRET });
+
+const identity = (overrides = {}) => {
+  const subject = crypto.randomBytes(6).toString('hex');
+  return { sub: subject, email: `${subject}@mail.test`, email_verified: true, name: 'Fed User', ...overrides };
+};
+
+// Runs the browser's part of a sign-in: the provider signs the user in with
+// the given ID token claims and redirects back to the service
+const signInAtProvider = async (claims) => {
+  const { authorizationUrl, browserKey } = await federatedLoginService.beginLogin('mock');
+  const { code, state } = idp.authorize(authorizationUrl, claims);
+  const redirect = new URL(await federatedLoginService.handleCallback('mock', { code, state }));
+  return {
+    authorizationUrl: new URL(authorizationUrl),
+    state,
+    code: redirect.searchParams.get('code'),
+    error: redirect.searchParams.get('error'),
+    browserKey,
+  };
+};
+
+const quietly = async (action) => {
+  const { error } = console;
+  console.error = () => {};
+  try {
+    return await action();
+  } finally {
+    console.error = error;
+  }
+};
+
+before(async () => {
+  // Fake models do not apply schema defaults such as Patient.isApproved
+  const registerFederatedAccount = authService.registerFederatedAccount.bind(authService);
+  authService.registerFederatedAccount = (role, profile) => registerFederatedAccount(role, { isApproved: true, ...profile });
+
+  await idp.listen();
+  await identityProviderService.createProvider(null, {
+    slug: 'mock',
+    displayName: 'Mock IdP',
+    preset: 'oidc',
+    issuer: idp.issuer,
+    clientId: CLIENT_ID,
+    clientSecret: CLIENT_SECRET,
+    allowedRoles: ['patient'],
+    autoProvisionRole: 'patient',
+    enabled: true,
+  });
+});
+
+after(() => idp.close());
+
+test('signs in with state, nonce and PKCE bound to the flow', async () => {
+  const claims = identity();
+  const flow = await signInAtProvider(claims);
+
+  const request = flow.authorizationUrl.searchParams;
+  assert.equal(request.get('response_type'), 'code');
+  assert.equal(request.get('code_challenge_method'), 'S256');
+  assert.ok(request.get('nonce') && request.get('state'));
+  // The verifier sent to the token endpoint is the one behind the challenge
+  const verifier = idp.tokenRequests.at(-1).get('code_verifier');
+  assert.equal(crypto.createHash('sha256').update(verifier).digest('base64url'), request.get('code_challenge'));
+  assert.equal(flow.error, null);
+
+  const tokens = await federatedLoginService.completeLogin(flow.code, flow.browserKey);
+  const session = await authService.validateToken(tokens.token);
+  assert.equal(session.email, claims.email);
+  assert.deepEqual(session.amr, ['fed']);
+  const linked = models.ExternalIdentity.docs.find((doc) => doc.subject === claims.sub);
+  assert.equal(linked.accountId.toString(), session.account_id);
+
+  // The same identity signs in to the same account next time
+  const again = await signInAtProvider(claims);
+  const second = await authService.validateToken((await federatedLoginService.completeLogin(again.code, again.browserKey)).token);
+  assert.equal(second.account_id, session.account_id);
+});
+
+test('a state is used once and a callback code only in the browser that started the flow', async () => {
+  const flow = await signInAtProvider(identity());
+  const replay = new URL(await federatedLoginService.handleCallback('mock', { code: 'anything', state: flow.state }));
+  assert.match(replay.searchParams.get('error'), /expired/);
+
+  await assert.rejects(
+    federatedLoginService.completeLogin(flow.code, crypto.randomBytes(32).toString('base64url')),
+    /Invalid or expired sign-in code/,
+  );
+});
+
+test('refuses ID tokens with the wrong nonce, issuer or audience', async () => {
+  const linked = models.ExternalIdentity.docs.length;
+  for (const claims of [{ nonce: 'another-nonce' }, { iss: 'https://evil.test' }, { aud: 'another-client' }]) {
+    const flow = await quietly(() => signInAtProvider(identity(claims)));
+    assert.equal(flow.code, null);
+    assert.equal(flow.error, 'Sign-in through the identity provider failed');
+  }
+  assert.equal(models.ExternalIdentity.docs.length, linked);
+});
+
+test('validates each ID token claim', async () => {
+  const metadata = await oidcClient.discover(idp.issuer);
+  const validate = (idToken, nonce = 'expected-nonce') => oidcClient.validateIdToken(idToken, {
+    issuer: metadata.issuer,
+    jwksUri: metadata.jwks_uri,
+    clientId: CLIENT_ID,
+    nonce,
+  });
+  // An ID token for the given claims, fetched the way the service would
+  const token = async (claims) => {
+    const { authorizationUrl } = await federatedLoginService.beginLogin('mock');
+    const url = new URL(authorizationUrl);
+    const pkce = oidcClient.createPkce();
+    url.searchParams.set('code_challenge', pkce.challenge);
+    url.searchParams.set('nonce', 'expected-nonce');
+    const { code } = idp.authorize(url.toString(), claims);
+    const tokens = await oidcClient.exchangeCode(metadata.token_endpoint, {
+      code,
+      redirectUri: url.searchParams.get('redirect_uri'),
+      codeVerifier: pkce.verifier,
+      clientId: CLIENT_ID,
+      clientSecret: CLIENT_SECRET,
+      authMethod: 'client_secret_post',
+    });
+    return tokens.id_token;
+  };
+
+  await validate(await token({ sub: 'abc' }));
+  await assert.rejects(validate(await token({ sub: 'abc' }), 'other-nonce'), /nonce does not match/);
+  await assert.rejects(validate(await token({ sub: 'abc', iss: 'https://evil.test' })), /another issuer/);
+  await assert.rejects(validate(await token({ sub: 'abc', aud: 'another-client' })), /another client/);
+  await assert.rejects(validate(await token({ sub: 'abc', aud: [CLIENT_ID, 'other'], azp: 'other' })), /another client/);
+  await assert.rejects(validate(await token({ sub: 'abc', exp: Math.floor(Date.now() / 1000) - 3600 })), /expired/);
+  await assert.rejects(validate(await token({})), /no subject/);
+});
+
+test('the token endpoint refuses a code without the right PKCE verifier', async () => {
+  const metadata = await oidcClient.discover(idp.issuer);
+  const { authorizationUrl } = await federatedLoginService.beginLogin('mock');
+  const { code } = idp.authorize(authorizationUrl, identity());
+  await assert.rejects(
+    oidcClient.exchangeCode(metadata.token_endpoint, {
+      code,
+      redirectUri: new URL(authorizationUrl).searchParams.get('redirect_uri'),
+      codeVerifier: oidcClient.createPkce().verifier,
+      clientId: CLIENT_ID,
+      clientSecret: CLIENT_SECRET,
+      authMethod: 'client_secret_post',
+    }),
+    /invalid_grant/,
+  );
+});
+
+test('does not provision an account for an unverified email address', async () => {
+  const claims = identity({ email_verified: false });
+  const flow = await signInAtProvider(claims);
+  await assert.rejects(federatedLoginService.completeLogin(flow.code, flow.browserKey), /did not confirm an email address/);
+  assert.equal(await authService.accountStore.findByEmail(claims.email), null);
+});
+
+test('does not sign in to an existing account just because the email matches', async () => {
+  const email = 'existing-fed@hospital.test';
+  await authService.registerAccount('patient', { email, name: 'Ex', isApproved: true }, 'Lantern-orbit-meadow-42');
+  const flow = await signInAtProvider(identity({ email }));
+  await assert.rejects(federatedLoginService.completeLogin(flow.code, flow.browserKey), /already uses this email address/);
+});
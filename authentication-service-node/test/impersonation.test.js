@@ -0,0 +1,111 @@
+const { test, before } = require('node:test');
+const assert = require('node:assert/strict');
+const { app, models, PASSWORD, createAdmin, registerPatient } = require('./support/app');
+
+const authService = app('services/authServiceInstance');
+const impersonationService = app('services/impersonationService');
+const roleService = app('services/roleService');
+const cacheStore = app('utils/cacheStore');
+const loginResponse = app('utils/loginResponse');
+const Principal = app('utils/principal');
+const { validateToken, requireInteractive } = app('middleware/authMiddleware');
+
+const SUPER_ADMIN = { user_id: '64b000000000000000000001', role: 'super_admin', permissions: ['all_permissions'] };
+const REASON = 'Patient reports the booking page is blank';
+
+let support;
+
+const auditEvents = (action) => models.AuditEvent.docs.filter((doc) => doc.action === action);
+
+// Support staff acting as a new patient; the claims of the impersonation token
+const impersonate = async (reason = REASON) => {
+  const { userId } = await registerPatient('target');
+  const result = await impersonationService.start(support, { userId, role: 'patient', reason }, { ip: '198.51.100.50' });
+  return { userId, result, claims: await authService.validateToken(result.token) };
+};
+
+before(async () => {
+  await roleService.ensureBuiltInRoles();
+  const admin = await createAdmin(SUPER_ADMIN, ['user:impersonate'], [], 'support');
+  const { token } = await authService.login(admin.email, PASSWORD);
+  support = await authService.validateToken(token);
+});
+
+test('the token acts as the user and names the support user in its act claim', async () => {
+  const { userId, result, claims } = await impersonate();
+  assert.equal(claims.user_id, userId);
+  assert.equal(claims.role, 'patient');
+  assert.deepEqual(claims.act, { sub: support.user_id, role: 'admin', email: support.email });
+  assert.deepEqual(claims.amr, ['imp']);
+  assert.equal(claims.exp - claims.iat, result.expiresIn);
+  assert.equal(result.reason, REASON);
+  assert.deepEqual(loginResponse({ token: result.token }).user.impersonatedBy, { id: support.user_id, email: support.email, role: 'admin' });
+
+  const [started] = auditEvents('impersonation.start').filter((doc) => doc.sessionId === claims.sid);
+  assert.equal(started.actor.userId, support.user_id);
+  assert.equal(started.subject.userId, userId);
+  assert.equal(started.reason, REASON);
+
+  // One impersonation at a time, and never without a reason
+  await assert.rejects(impersonationService.start(claims, { userId, role: 'patient', reason: REASON }), /Stop impersonating/);
+  await assert.rejects(impersonate('   '), /reason for impersonating is required/);
+});
+
+test('an impersonation cannot be refreshed or used to manage the account', async () => {
+  const { result, claims } = await impersonate();
+  assert.equal(result.refreshToken, undefined);
+  assert.equal(models.RefreshToken.docs.filter((doc) => doc.familyId === claims.sid).length, 0);
+
+  const res = { status: () => res };
+  assert.throws(() => requireInteractive({ user: Principal.fromAccessToken(claims) }, res, () => {}), /not available while impersonating/);
+
+  // Once its session lapses the token is done
+  models.Session.docs.find((doc) => doc.sessionId === claims.sid).expiresAt = new Date(Date.now() - 1000);
+  await cacheStore.del(`session_seen:${claims.sid}`);
+  await assert.rejects(authService.validateToken(result.token), /Session has expired/);
+});
+
+test('every request made while impersonating is audited against the support user', async () => {
+  const { userId, result, claims } = await impersonate();
+  const finished = [];
+  const req = {
+    headers: { authorization: `Bearer ${result.token}` },
+    method: 'GET',
+    originalUrl: '/api/v1/patients/me',
+    get: () => undefined,
+  };
+  const res = { statusCode: 200, status: () => res, on: (event, listener) => finished.push(listener) };
+  await new Promise((resolve) => { validateToken(req, res, resolve); });
+  assert.equal(req.user.impersonating, true);
+
+  finished.forEach((listener) => listener());
+  await new Promise((resolve) => { setImmediate(resolve); });
+  const [request] = auditEvents('impersonation.request').filter((doc) => doc.sessionId === claims.sid);
+  assert.equal(request.actor.userId, support.user_id);
+  assert.equal(request.subject.userId, userId);
+  assert.equal(request.method, 'GET');
+  assert.equal(request.path, '/api/v1/patients/me');
+  assert.equal(request.statusCode, 200);
+});
+
+test('ending an impersonation revokes its token, as does signing the support user out', async () => {
+  const ended = await impersonate();
+  await impersonationService.end(ended.claims);
+  await assert.rejects(authService.validateToken(ended.result.token), /revoked/);
+  assert.equal(auditEvents('impersonation.end').filter((doc) => doc.sessionId === ended.claims.sid)[0].actor.userId, support.user_id);
+  await assert.rejects(impersonationService.end(support), /not an impersonation/);
+
+  const other = await impersonate();
+  await authService.revokeAllTokensForUser(support.user_id);
+  await assert.rejects(authService.validateToken(other.result.token), /revoked/);
+});
+
+test('only doctors and patients can be impersonated, never staff', async () => {
+  const root = await models.SuperAdmin.create({ email: 'root@hospital.test' });
+  const admin = await createAdmin(SUPER_ADMIN, ['doctor:list'], []);
+  const start = (userId, role) => impersonationService.start(support, { userId, role, reason: REASON });
+  await assert.rejects(start(root._id.toString(), 'super_admin'), /Only doctors and patients/);
+  await assert.rejects(start(admin._id.toString(), 'admin'), /Only doctors and patients/);
+  await assert.rejects(start('64b0000000000000000000ff', 'patient'), /User not found/);
+  assert.equal(auditEvents('impersonation.start').some((doc) => [root._id.toString(), admin._id.toString()].includes(doc.subject.userId)), false);
+});
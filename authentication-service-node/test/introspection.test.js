@@ -0,0 +1,122 @@
+const { test, before } = require('node:test');
+const assert = require('node:assert/strict');
+const { app, models, PASSWORD, signIn } = require('./support/app');
+
+const authService = app('services/authServiceInstance');
+const oidcService = app('services/oidcService');
+const oauthClientService = app('services/oauthClientService');
+const serviceAccountService = app('services/serviceAccountService');
+const oidcController = app('controllers/oidcController');
+const env = app('config/env');
+
+const { OAuthError } = oidcService;
+
+const SUPER_ADMIN = { user_id: 'super-admin-1', role: 'super_admin', permissions: ['all_permissions'] };
+
+const basic = (clientId, clientSecret) => `Basic ${Buffer.from(`${clientId}:${clientSecret}`).toString('base64')}`;
+
+// Authorization headers of each kind of caller
+const callers = {};
+let clientId;
+
+const createClient = async (canIntrospect) => {
+  const { client, clientSecret } = await oauthClientService.createClient(null, {
+    name: 'Resource server',
+    redirectUris: ['https://rs.test/callback'],
+    canIntrospect,
+  });
+  clientId = clientId || client.clientId;
+  return basic(client.clientId, clientSecret);
+};
+
+// Fake models do not apply schema defaults such as ServiceAccount.enabled
+const createAccount = async (permissions) => {
+  const { serviceAccount, clientSecret } = await serviceAccountService.createAccount(SUPER_ADMIN, { name: 'Appointments', permissions });
+  models.ServiceAccount.docs.find((doc) => doc.clientId === serviceAccount.clientId).enabled = true;
+  return basic(serviceAccount.clientId, clientSecret);
+};
+
+const introspect = (token, authorization = callers.client) => oidcService.introspect(authorization, { token });
+
+// The controller's answer: status, Cache-Control and body
+const respond = (authorization, body) => new Promise((resolve, reject) => {
+  const answer = { status: 200, headers: {} };
+  const res = {
+    set: (name, value) => { answer.headers[name] = value; return res; },
+    status: (code) => { answer.status = code; return res; },
+    json: (json) => resolve({ ...answer, body: json }),
+  };
+  oidcController.introspect({ headers: { authorization }, body }, res, reject);
+});
+
+before(async () => {
+  callers.client = await createClient(true);
+  callers.plainClient = await createClient(false);
+  callers.service = await createAccount(['token:introspect']);
+  callers.plainService = await createAccount(['patient:view']);
+});
+
+test('only clients and service accounts allowed to introspect may ask', async () => {
+  const { token } = await signIn();
+  const refused = (status) => (error) => error instanceof OAuthError && error.status === status;
+
+  await assert.rejects(oidcService.introspect(undefined, { token }), refused(401));
+  await assert.rejects(introspect(token, basic('unknown-client', 'secret')), refused(401));
+  await assert.rejects(introspect(token, basic(clientId, 'wrong-secret')), refused(401));
+  await assert.rejects(introspect(token, callers.plainClient), refused(403));
+  await assert.rejects(introspect(token, callers.plainService), refused(403));
+  await assert.rejects(oidcService.introspect(callers.client, {}), /token is required/);
+
+  assert.equal((await introspect(token, callers.client)).active, true);
+  assert.equal((await introspect(token, callers.service)).active, true);
+
+  // Refusals are never cached
+  const answer = await respond(undefined, { token });
+  assert.equal(answer.status, 401);
+  assert.equal(answer.headers['Cache-Control'], 'no-store');
+});
+
+test('describes an active token with its permissions spelled out', async () => {
+  const { token } = await signIn();
+  const claims = await authService.validateToken(token);
+  const response = await introspect(token);
+  assert.equal(response.sub, claims.user_id);
+  assert.equal(response.role, 'patient');
+  assert.equal(response.sid, claims.sid);
+  assert.equal(response.jti, claims.jti);
+  assert.ok(response.permissions.includes('patient:self'));
+  assert.equal(response.act, undefined);
+});
+
+test('revoked, disabled, expired and foreign tokens are inactive', async () => {
+  const revoked = await signIn();
+  await authService.revokeToken(revoked.token);
+  assert.deepEqual(await introspect(revoked.token), { active: false });
+
+  const disabled = await signIn();
+  models.Account.docs.find((doc) => doc.email === disabled.email).status = 'disabled';
+  assert.deepEqual(await introspect(disabled.token), { active: false });
+
+  const { email } = await signIn();
+  const claims = await authService.validateToken((await authService.login(email, PASSWORD)).token);
+  const expired = await authService.generateToken(claims.user_id, email, 'patient', ['patient:self'], claims.patientId, null, {}, -10);
+  assert.deepEqual(await introspect(expired), { active: false });
+
+  assert.deepEqual(await introspect('not-a-token'), { active: false });
+});
+
+test('answers may be cached for a short while, never past the token expiry', async () => {
+  const { token } = await signIn();
+  const active = await respond(callers.client, { token });
+  assert.equal(active.body.active, true);
+  assert.equal(active.headers['Cache-Control'], `private, max-age=${env.INTROSPECTION_CACHE_SECONDS}`);
+
+  const claims = await authService.validateToken(token);
+  const ending = await authService.generateToken(claims.user_id, claims.email, 'patient', ['patient:self'], claims.patientId, null, {}, 5);
+  const maxAge = oidcService.introspectionMaxAge(await introspect(ending));
+  assert.ok(maxAge <= 5 && maxAge >= 4, `max-age ${maxAge}`);
+
+  const inactive = await respond(callers.client, { token: 'not-a-token' });
+  assert.deepEqual(inactive.body, { active: false });
+  assert.equal(inactive.headers['Cache-Control'], `private, max-age=${env.INTROSPECTION_CACHE_SECONDS}`);
+});
@@ -0,0 +1,142 @@
+const { test } = require('node:test');
+const assert = require('node:assert/strict');
+const crypto = require('crypto');
+const { app } = require('./support/app');
+const fakeModel = require('./support/fakeModel');
+
+const jwt = app('utils/jwt');
+const KeyRing = app('services/keyRing');
+
+const keyPair = (alg) => crypto.generateKeyPairSync(jwt.ALGORITHMS[alg].keyType, jwt.ALGORITHMS[alg].generateOptions);
+
+const segment = (value) => Buffer.from(JSON.stringify(value)).toString('base64url');
+
+const resolverFor = (publicKey, alg) => async () => ({ publicKey, alg });
+
+for (const alg of Object.keys(jwt.ALGORITHMS)) {
+  test(`${alg} tokens verify with the public key and its JWK`, async () => {
+    const { publicKey, privateKey } = keyPair(alg);
+    const token = jwt.sign({ sub: 'user-1' }, { privateKey, alg, kid: 'k1', expiresIn: 60, jwtid: 'j1' });
+
+    const claims = await jwt.verify(token, resolverFor(publicKey, alg));
+    assert.equal(claims.sub, 'user-1');
+    assert.equal(claims.jti, 'j1');
+    assert.equal(claims.exp - claims.iat, 60);
+
+    const fromJwk = crypto.createPublicKey({ key: publicKey.export({ format: 'jwk' }), format: 'jwk' });
+    assert.equal((await jwt.verify(token, resolverFor(fromJwk, alg))).sub, 'user-1');
+  });
+}
+
+const { publicKey, privateKey } = keyPair('RS256');
+const resolve = resolverFor(publicKey, 'RS256');
+const signed = (payload = { sub: 'user-1' }, options = {}) => jwt.sign(payload, {
+  privateKey, alg: 'RS256', kid: 'k1', expiresIn: 60, ...options,
+});
+
+test('unsigned tokens are rejected', async () => {
+  const payload = segment({ sub: 'admin', exp: Math.floor(Date.now() / 1000) + 60 });
+  for (const alg of ['none', 'None', 'NONE']) {
+    await assert.rejects(jwt.verify(`${segment({ alg, typ: 'JWT', kid: 'k1' })}.${payload}.`, resolve), /invalid algorithm/);
+  }
+});
+
+test('HMAC tokens keyed with the public key are rejected', async () => {
+  const pem = publicKey.export({ format: 'pem', type: 'spki' });
+  const payload = segment({ sub: 'admin', exp: Math.floor(Date.now() / 1000) + 60 });
+  for (const alg of ['HS256', 'HS384', 'HS512']) {
+    const input = `${segment({ alg, typ: 'JWT', kid: 'k1' })}.${payload}`;
+    const mac = crypto.createHmac(`sha${alg.slice(2)}`, pem).update(input).digest('base64url');
+    await assert.rejects(jwt.verify(`${input}.${mac}`, resolve), /invalid algorithm/);
+  }
+});
+
+test('the key decides the algorithm, not the header', async () => {
+  const ec = keyPair('ES256');
+  const token = jwt.sign({ sub: 'admin' }, { privateKey: ec.privateKey, alg: 'ES256', kid: 'k1' });
+  await assert.rejects(jwt.verify(token, resolve), /invalid algorithm/);
+  await assert.rejects(jwt.verify(token, resolverFor(ec.publicKey, 'RS256')), /invalid algorithm/);
+});
+
+test('tampered payloads and signatures from other keys are rejected', async () => {
+  const [header, , signature] = signed().split('.');
+  await assert.rejects(jwt.verify(`${header}.${segment({ sub: 'admin' })}.${signature}`, resolve), /invalid signature/);
+
+  const other = keyPair('RS256');
+  const forged = jwt.sign({ sub: 'user-1' }, { privateKey: other.privateKey, alg: 'RS256', kid: 'k1' });
+  await assert.rejects(jwt.verify(forged, resolve), /invalid signature/);
+});
+
+test('unknown keys, malformed tokens and unsupported algorithms are rejected', async () => {
+  await assert.rejects(jwt.verify(signed(), async () => null), /unknown signing key/);
+  for (const token of [undefined, '', 'a.b', 'a.b.c.d', 'not.json.here']) {
+    await assert.rejects(jwt.verify(token, resolve), /jwt malformed/);
+  }
+  assert.throws(() => jwt.sign({}, { privateKey, alg: 'HS256' }), /Unsupported/);
+});
+
+test('expiry, not-before and the token type are enforced', async () => {
+  const now = Math.floor(Date.now() / 1000);
+  const expired = signed({ sub: 'user-1', exp: now - 1 }, { expiresIn: undefined });
+  await assert.rejects(jwt.verify(expired, resolve), /jwt expired/);
+  assert.equal((await jwt.verify(expired, resolve, { ignoreExpiration: true })).sub, 'user-1');
+
+  await assert.rejects(jwt.verify(signed({ nbf: now + 60 }), resolve), /not active/);
+
+  const accessToken = signed({ sub: 'user-1' }, { typ: 'at+jwt' });
+  assert.equal((await jwt.verify(accessToken, resolve, { typ: 'application/at+jwt' })).sub, 'user-1');
+  await assert.rejects(jwt.verify(signed(), resolve, { typ: 'at+jwt' }), /invalid token type/);
+});
+
+const keyRing = (options = {}) => new KeyRing({
+  signingKeyModel: fakeModel(),
+  alg: 'ES256',
+  rotationIntervalSeconds: 3600,
+  publishLeadSeconds: 600,
+  verifyOverlapSeconds: 7200,
+  encryptionSecret: 'test-secret',
+  ...options,
+});
+
+const signWith = async (ring) => {
+  const key = await ring.getSigningKey();
+  return jwt.sign({ sub: 'user-1' }, { privateKey: key.privateKey, alg: key.alg, kid: key.kid, expiresIn: 60 });
+};
+
+const verifyWith = (ring, token) => jwt.verify(token, (header) => ring.getVerificationKey(header.kid));
+
+test('the key ring keeps verifying tokens of a key it rotated away from', async () => {
+  const ring = keyRing();
+  const before = await signWith(ring);
+  await ring.rotateNow();
+  const after = await signWith(ring);
+
+  assert.notEqual(jwt.decode(before, { complete: true }).header.kid, jwt.decode(after, { complete: true }).header.kid);
+  await verifyWith(ring, before);
+  await verifyWith(ring, after);
+
+  const { keys } = await ring.jwks();
+  assert.equal(keys.length, 2);
+  for (const key of keys) {
+    assert.equal(key.d, undefined);
+    assert.equal(key.alg, 'ES256');
+  }
+});
+
+test('the key ring publishes the next key ahead of its use', async () => {
+  const ring = keyRing({ rotationIntervalSeconds: 300 });
+  await ring.getSigningKey();
+  await ring.rotateIfDue();
+
+  const { keys } = await ring.jwks();
+  assert.equal(keys.length, 2);
+  assert.equal((await ring.getSigningKey()).generation, 1);
+});
+
+test('keys past their overlap no longer verify', async () => {
+  const ring = keyRing({ verifyOverlapSeconds: 0 });
+  const token = await signWith(ring);
+  await ring.rotateNow();
+
+  await assert.rejects(verifyWith(ring, token), /unknown signing key/);
+});
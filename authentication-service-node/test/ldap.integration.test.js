@@ -0,0 +1,81 @@
+const { test } = require('node:test');
+const assert = require('node:assert/strict');
+const path = require('path');
+const { app } = require('./support/app');
+
+// Runs against a real OpenLDAP server seeded with fixtures/ldap/hospital.ldif,
+// and is skipped unless LDAP_TEST_URL is set:
+//
+//   docker run --rm -p 1389:1389 -e LDAP_ROOT=dc=hospital,dc=test \
+//     -e LDAP_ADMIN_PASSWORD=adminpassword \
+//     -v "$PWD/test/fixtures/ldap:/ldifs:ro" bitnami/openldap:2.6
+//   LDAP_TEST_URL=ldap://localhost:1389 npm test
+//
+// LDAP_TEST_BIND_DN and LDAP_TEST_BIND_PASSWORD default to that admin.
+
+const ldapDirectoryService = app('services/ldapDirectoryService');
+const ldapClient = app('utils/ldapClient');
+
+const BASE = 'dc=hospital,dc=test';
+const url = process.env.LDAP_TEST_URL;
+const bindDn = process.env.LDAP_TEST_BIND_DN || `cn=admin,${BASE}`;
+const bindPassword = process.env.LDAP_TEST_BIND_PASSWORD || 'adminpassword';
+const options = { skip: url ? false : `LDAP_TEST_URL is not set; see ${path.basename(__filename)}` };
+
+const directory = (overrides = {}) => ({
+  url,
+  startTls: false,
+  bindDn,
+  bindPasswordEncrypted: ldapDirectoryService.secretBox.encrypt(bindPassword),
+  userBaseDn: `ou=people,${BASE}`,
+  userFilter: '(&(objectClass=person)(mail={email}))',
+  nameAttribute: 'cn',
+  groupBaseDn: `ou=groups,${BASE}`,
+  groupFilter: '(|(member={dn})(uniqueMember={dn}))',
+  groupMappings: [
+    { group: `cn=admins,ou=groups,${BASE}`, role: 'admin', permissions: ['doctor:list'] },
+    { group: `cn=ward-8,ou=groups,${BASE}`, role: 'doctor', permissions: [] },
+  ],
+  ...overrides,
+});
+
+test('signs in a directory user', options, async () => {
+  const user = await ldapDirectoryService.authenticate(directory(), 'alice@hospital.test', 'alice-pw');
+  assert.equal(user.dn, `uid=alice,ou=people,${BASE}`);
+  assert.equal(user.name, 'Alice Moreau');
+  assert.equal(user.groups.length, 9);
+  assert.deepEqual(user.roles, ['admin', 'doctor']);
+});
+
+test('treats a failed user bind as no match', options, async () => {
+  assert.equal(await ldapDirectoryService.authenticate(directory(), 'alice@hospital.test', 'wrong'), null);
+  assert.equal(await ldapDirectoryService.authenticate(directory(), 'nobody@hospital.test', 'alice-pw'), null);
+});
+
+test('fails when the service account bind fails', options, async () => {
+  const broken = directory({ bindPasswordEncrypted: ldapDirectoryService.secretBox.encrypt('wrong') });
+  await assert.rejects(
+    ldapDirectoryService.authenticate(broken, 'alice@hospital.test', 'alice-pw'),
+    (error) => error.resultCode === ldapClient.RESULT.INVALID_CREDENTIALS,
+  );
+});
+
+test('skips continuation references and fails on referrals', options, async () => {
+  const user = await ldapDirectoryService.authenticate(directory({ userBaseDn: BASE }), 'bob@hospital.test', 'bob-pw');
+  assert.equal(user.dn, `uid=bob,ou=people,${BASE}`);
+  await assert.rejects(
+    ldapDirectoryService.authenticate(directory({ userBaseDn: `ou=partners,${BASE}` }), 'bob@hospital.test', 'bob-pw'),
+    (error) => error.resultCode === ldapClient.RESULT.REFERRAL,
+  );
+});
+
+test('follows paged results cookies to the last page', options, async () => {
+  const client = await ldapClient.connect(url);
+  try {
+    await client.bind(bindDn, bindPassword);
+    const groups = await client.search(`ou=groups,${BASE}`, { filter: '(objectClass=groupOfNames)', attributes: ['1.1'], pageSize: 2 });
+    assert.equal(groups.length, 9);
+  } finally {
+    client.close();
+  }
+});
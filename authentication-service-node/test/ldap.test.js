@@ -0,0 +1,155 @@
+const { test, before, after } = require('node:test');
+const assert = require('node:assert/strict');
+const { app } = require('./support/app');
+const ldapServer = require('./support/ldapServer');
+
+const ldapDirectoryService = app('services/ldapDirectoryService');
+const ldapClient = app('utils/ldapClient');
+
+const BASE = 'dc=hospital,dc=test';
+const SERVICE_DN = `cn=sync,ou=services,${BASE}`;
+const ALICE_DN = `uid=alice,ou=people,${BASE}`;
+const WARDS = Array.from({ length: 8 }, (_, index) => `cn=ward-${index + 1},ou=groups,${BASE}`);
+
+const entries = [
+  { dn: SERVICE_DN, password: 'sync-secret', attributes: { objectClass: ['applicationProcess'] } },
+  {
+    dn: ALICE_DN,
+    password: 'alice-pw',
+    attributes: { objectClass: ['person'], mail: ['alice@hospital.test'], cn: ['Alice Moreau'] },
+  },
+  {
+    dn: `uid=carol,ou=people,${BASE}`,
+    password: 'carol-pw',
+    attributes: { objectClass: ['person'], mail: ['carol@hospital.test'], cn: ['Carol Diaz'] },
+  },
+  { dn: `cn=admins,ou=groups,${BASE}`, attributes: { objectClass: ['groupOfNames'], member: [ALICE_DN] } },
+  ...WARDS.map((dn) => ({ dn, attributes: { objectClass: ['groupOfNames'], member: [ALICE_DN] } })),
+];
+const referrals = { [`ou=partners,${BASE}`]: 'ldap://partners.hospital.test' };
+
+const servers = [];
+const start = async (options = {}) => {
+  const server = ldapServer({ entries, referrals, ...options });
+  servers.push(server);
+  return { server, url: await server.listen() };
+};
+
+const directory = (url, overrides = {}) => ({
+  url,
+  startTls: false,
+  bindDn: SERVICE_DN,
+  bindPasswordEncrypted: ldapDirectoryService.secretBox.encrypt('sync-secret'),
+  userBaseDn: `ou=people,${BASE}`,
+  userFilter: '(&(objectClass=person)(mail={email}))',
+  nameAttribute: 'cn',
+  groupBaseDn: `ou=groups,${BASE}`,
+  groupFilter: '(|(member={dn})(uniqueMember={dn}))',
+  groupMappings: [
+    { group: `CN=Admins, OU=Groups, ${BASE}`, role: 'admin', permissions: ['doctor:list'] },
+    { group: WARDS[7], role: 'doctor', permissions: [] },
+  ],
+  ...overrides,
+});
+
+let url;
+before(async () => {
+  ({ url } = await start());
+});
+
+after(async () => {
+  await Promise.all(servers.map((server) => server.close()));
+});
+
+test('binds as the user to check their password', async () => {
+  const user = await ldapDirectoryService.authenticate(directory(url), 'alice@hospital.test', 'alice-pw');
+  assert.equal(user.dn, ALICE_DN);
+  assert.equal(user.name, 'Alice Moreau');
+  assert.deepEqual(user.roles, ['admin', 'doctor']);
+  assert.deepEqual(user.permissions, ['doctor:list']);
+});
+
+test('treats a wrong password or unknown address as no match', async () => {
+  assert.equal(await ldapDirectoryService.authenticate(directory(url), 'alice@hospital.test', 'wrong'), null);
+  assert.equal(await ldapDirectoryService.authenticate(directory(url), 'nobody@hospital.test', 'alice-pw'), null);
+});
+
+test('never sends an empty password', async () => {
+  const { server, url: emptyUrl } = await start();
+  assert.equal(await ldapDirectoryService.authenticate(directory(emptyUrl), 'alice@hospital.test', ''), null);
+  assert.equal(server.requests.length, 0);
+});
+
+test('fails when the directory refuses a bind for another reason', async () => {
+  const { url: refusingUrl } = await start({ bindResults: { [`uid=carol,ou=people,${BASE}`]: [53, 'password must be changed'] } });
+  await assert.rejects(
+    ldapDirectoryService.authenticate(directory(refusingUrl), 'carol@hospital.test', 'carol-pw'),
+    (error) => error instanceof ldapClient.LdapError && error.resultCode === 53,
+  );
+});
+
+test('fails when the service account cannot bind', async () => {
+  const broken = directory(url, { bindPasswordEncrypted: ldapDirectoryService.secretBox.encrypt('expired') });
+  await assert.rejects(
+    ldapDirectoryService.authenticate(broken, 'alice@hospital.test', 'alice-pw'),
+    (error) => error instanceof ldapClient.LdapError && error.resultCode === ldapClient.RESULT.INVALID_CREDENTIALS,
+  );
+});
+
+test('skips continuation references in search results', async () => {
+  const user = await ldapDirectoryService.authenticate(directory(url, { userBaseDn: BASE }), 'alice@hospital.test', 'alice-pw');
+  assert.equal(user.dn, ALICE_DN);
+});
+
+test('fails on a referral instead of following it', async () => {
+  await assert.rejects(
+    ldapDirectoryService.authenticate(directory(url, { userBaseDn: `ou=partners,${BASE}` }), 'alice@hospital.test', 'alice-pw'),
+    (error) => error.resultCode === ldapClient.RESULT.REFERRAL,
+  );
+});
+
+test('reads every page of a paged search', async () => {
+  const { server, url: pagedUrl } = await start({ pageLimit: 3 });
+  const user = await ldapDirectoryService.authenticate(directory(pagedUrl), 'alice@hospital.test', 'alice-pw');
+  assert.equal(user.groups.length, 9);
+  assert.deepEqual(user.roles, ['admin', 'doctor']);
+  // One user search with a size limit, then the group search in 3 pages
+  const searches = server.requests.filter((request) => request.op === 0x63);
+  assert.equal(searches.length, 4);
+  assert.deepEqual(searches.map((request) => Object.keys(request.controls).length), [0, 1, 1, 1]);
+});
+
+test('refuses results truncated by a server that does not page', async () => {
+  const { url: limitedUrl } = await start({ pageLimit: 3, paging: false });
+  await assert.rejects(
+    ldapDirectoryService.authenticate(directory(limitedUrl), 'alice@hospital.test', 'alice-pw'),
+    (error) => error.resultCode === ldapClient.RESULT.SIZE_LIMIT_EXCEEDED,
+  );
+});
+
+test('reads responses split across packets', async () => {
+  const { url: chunkedUrl } = await start({ chunked: true });
+  const user = await ldapDirectoryService.authenticate(directory(chunkedUrl), 'alice@hospital.test', 'alice-pw');
+  assert.equal(user.groups.length, 9);
+});
+
+test('encodes requests as RFC 4511 and RFC 2696 lay them out', async () => {
+  const { server, url: wireUrl } = await start();
+  const client = await ldapClient.connect(wireUrl);
+  try {
+    await client.bind(SERVICE_DN, 'sync-secret');
+    await client.search(`ou=groups,${BASE}`, { filter: '(member=*)', attributes: ['1.1'] });
+  } finally {
+    client.close();
+  }
+  const [bind, search] = server.requests;
+  const dn = Buffer.from(SERVICE_DN);
+  const password = Buffer.from('sync-secret');
+  const bindRequest = Buffer.concat([
+    Buffer.from([0x60, 3 + 2 + dn.length + 2 + password.length, 0x02, 0x01, 0x03, 0x04, dn.length]), dn,
+    Buffer.from([0x80, password.length]), password,
+  ]);
+  assert.deepEqual(bind.message, Buffer.concat([Buffer.from([0x02, 0x01, 0x01]), bindRequest]));
+  // realSearchControlValue: page size 500, empty cookie
+  assert.equal(search.controls['1.2.840.113556.1.4.319'].toString('hex'), '3006020201f40400');
+});
@@ -0,0 +1,128 @@
+const { test, beforeEach } = require('node:test');
+const assert = require('node:assert/strict');
+const { app, models, PASSWORD, registerPatient } = require('./support/app');
+
+const authService = app('services/authServiceInstance');
+const mfaService = app('services/mfaService');
+const LoginThrottle = app('services/loginThrottle');
+const totp = app('utils/totp');
+
+const { LoginThrottledError } = LoginThrottle;
+
+const IP = '203.0.113.7';
+
+const throttle = (overrides = {}) => new LoginThrottle({
+  model: models.LoginThrottle,
+  accountThreshold: 3,
+  ipThreshold: 5,
+  baseLockSeconds: 60,
+  maxLockSeconds: 200,
+  windowSeconds: 15 * 60,
+  ...overrides,
+});
+
+const record = (kind, subject) => models.LoginThrottle.docs.find((doc) => doc.kind === kind && doc.subject === subject);
+
+const lockedFor = (kind, subject) => {
+  const found = record(kind, subject);
+  return found && found.lockedUntil ? Math.round((found.lockedUntil.getTime() - Date.now()) / 1000) : 0;
+};
+
+const register = () => registerPatient('throttle');
+
+beforeEach(() => {
+  models.LoginThrottle.docs.length = 0;
+});
+
+test('locks an account once it reaches the threshold and doubles the lock', async () => {
+  const limiter = throttle();
+  await limiter.recordFailure('Pat@Hospital.test');
+  await limiter.recordFailure('pat@hospital.test');
+  await limiter.assertAllowed('pat@hospital.test');
+
+  await limiter.recordFailure('pat@hospital.test');
+  assert.equal(lockedFor('account', 'pat@hospital.test'), 60);
+  await assert.rejects(
+    limiter.assertAllowed('PAT@hospital.test'),
+    (error) => error instanceof LoginThrottledError && error.statusCode === 429 && error.retryAfterSeconds === 60,
+  );
+
+  await limiter.recordFailure('pat@hospital.test');
+  assert.equal(lockedFor('account', 'pat@hospital.test'), 120);
+  await limiter.recordFailure('pat@hospital.test');
+  assert.equal(lockedFor('account', 'pat@hospital.test'), 200);
+});
+
+test('locks an address spraying many accounts without locking the accounts', async () => {
+  const limiter = throttle();
+  for (let index = 0; index < 5; index += 1) {
+    await limiter.recordFailure(`user${index}@hospital.test`, IP);
+  }
+  await assert.rejects(limiter.assertAllowed('someone@hospital.test', IP), LoginThrottledError);
+  await limiter.assertAllowed('user0@hospital.test', '203.0.113.8');
+  assert.equal(lockedFor('account', 'user0@hospital.test'), 0);
+});
+
+test('counts every one of many concurrent failures', async () => {
+  const limiter = throttle({ accountThreshold: 100, ipThreshold: 100 });
+  await Promise.all(Array.from({ length: 25 }, () => limiter.recordFailure('pat@hospital.test', IP)));
+  assert.equal(record('account', 'pat@hospital.test').failures, 25);
+  assert.equal(record('ip', IP).failures, 25);
+});
+
+test('starts counting over once the quiet period has passed', async () => {
+  const limiter = throttle();
+  await limiter.recordFailure('pat@hospital.test');
+  await limiter.recordFailure('pat@hospital.test');
+  record('account', 'pat@hospital.test').expiresAt = new Date(Date.now() - 1000);
+
+  await limiter.recordFailure('pat@hospital.test');
+  assert.equal(record('account', 'pat@hospital.test').failures, 1);
+  await limiter.assertAllowed('pat@hospital.test');
+});
+
+test('a locked account refuses even the right password', async () => {
+  const { email } = await register();
+  for (let attempt = 0; attempt < 5; attempt += 1) {
+    await assert.rejects(authService.login(email, 'wrong-password', { ip: IP }), /Invalid email or password/);
+  }
+  await assert.rejects(authService.login(email, PASSWORD, { ip: IP }), LoginThrottledError);
+});
+
+test('a completed login clears the account counter but not the address', async () => {
+  const { email } = await register();
+  await assert.rejects(authService.login(email, 'wrong-password', { ip: IP }));
+  await assert.rejects(authService.login(email, 'wrong-password', { ip: IP }));
+
+  await authService.login(email, PASSWORD, { ip: IP });
+  assert.equal(record('account', email), undefined);
+  assert.equal(record('ip', IP).failures, 2);
+});
+
+test('the right password alone does not clear the counter while a second factor is pending', async () => {
+  const { email, profile } = await register();
+  const { secret } = await mfaService.beginEnrollment(profile._id, 'patient', email);
+  await mfaService.confirmEnrollment(profile._id, 'patient', totp.generateCode(secret, totp.currentStep()));
+  models.MfaEnrollment.docs.forEach((enrollment) => { enrollment.lastUsedStep = 0; });
+
+  await assert.rejects(authService.login(email, 'wrong-password', { ip: IP }));
+  const { mfaToken } = await authService.login(email, PASSWORD, { ip: IP });
+  assert.equal(record('account', email).failures, 1);
+
+  await mfaService.verifyChallenge(mfaToken, { code: totp.generateCode(secret, totp.currentStep()) });
+  assert.equal(record('account', email), undefined);
+});
+
+test('admins can list and lift locks', async () => {
+  const limiter = throttle();
+  for (let attempt = 0; attempt < 3; attempt += 1) {
+    await limiter.recordFailure('pat@hospital.test', IP);
+  }
+  const locked = await limiter.listLocked('account');
+  assert.deepEqual(locked.map((entry) => entry.subject), ['pat@hospital.test']);
+  assert.deepEqual(await limiter.listLocked('ip'), []);
+
+  await limiter.clear(locked[0].id);
+  await limiter.assertAllowed('pat@hospital.test', IP);
+  await assert.rejects(limiter.clear(locked[0].id), /Lockout not found/);
+});
@@ -0,0 +1,95 @@
+const { test, beforeEach } = require('node:test');
+const assert = require('node:assert/strict');
+const { app, models } = require('./support/app');
+
+const authService = app('services/authServiceInstance');
+const mfaService = app('services/mfaService');
+const totp = app('utils/totp');
+
+const PASSWORD = 'Lantern-orbit-meadow-42';
+const EMAIL = 'mfa@hospital.test';
+const IP = '198.51.100.9';
+
+let secret;
+
+// Every test signs in within the same 30-second step; forget the last used
+// step so the current code is not rejected as a replay
+const currentCode = () => {
+  models.MfaEnrollment.docs.forEach((enrollment) => { enrollment.lastUsedStep = 0; });
+  return totp.generateCode(secret, totp.currentStep());
+};
+
+// A code outside the accepted window, so never right by chance
+const wrongCode = () => {
+  const accepted = [-1, 0, 1].map((offset) => totp.generateCode(secret, totp.currentStep() + offset));
+  for (let candidate = 0; ; candidate += 1) {
+    const code = String(candidate).padStart(6, '0');
+    if (!accepted.includes(code)) return code;
+  }
+};
+
+const startChallenge = async () => {
+  const result = await authService.login(EMAIL, PASSWORD, { ip: IP });
+  assert.equal(result.mfaRequired, true);
+  return result.mfaToken;
+};
+
+const accountFailures = () => {
+  const record = models.LoginThrottle.docs.find((doc) => doc.kind === 'account' && doc.subject === EMAIL);
+  return record ? record.failures : 0;
+};
+
+beforeEach(async () => {
+  models.LoginThrottle.docs.length = 0;
+  if (!secret) {
+    const { profile } = await authService.registerAccount('patient', { email: EMAIL, name: 'Mo', isApproved: true }, PASSWORD);
+    ({ secret } = await mfaService.beginEnrollment(profile._id, 'patient', EMAIL));
+    await mfaService.confirmEnrollment(profile._id, 'patient', currentCode());
+  }
+});
+
+test('a valid code completes the login once', async () => {
+  const mfaToken = await startChallenge();
+  const tokens = await mfaService.verifyChallenge(mfaToken, { code: currentCode() });
+
+  const claims = await authService.validateToken(tokens.token);
+  assert.deepEqual(claims.amr, ['pwd', 'otp']);
+  await assert.rejects(mfaService.verifyChallenge(mfaToken, { code: currentCode() }), /Invalid or expired MFA challenge/);
+});
+
+test('a challenge allows five attempts, even in parallel', async () => {
+  const mfaToken = await startChallenge();
+  const results = await Promise.allSettled(
+    Array.from({ length: 20 }, () => mfaService.verifyChallenge(mfaToken, { code: wrongCode() })),
+  );
+
+  assert.ok(results.every((result) => result.status === 'rejected'));
+  assert.equal(results.filter((result) => /Invalid verification code/.test(result.reason.message)).length, 5);
+  assert.equal(accountFailures(), 5);
+  await assert.rejects(mfaService.verifyChallenge(mfaToken, { code: currentCode() }), /Invalid or expired MFA challenge|Too many/);
+});
+
+test('failed codes lock the account across new challenges', async () => {
+  for (let attempt = 0; attempt < 4; attempt += 1) {
+    const mfaToken = await startChallenge();
+    await assert.rejects(mfaService.verifyChallenge(mfaToken, { code: wrongCode() }), /Invalid verification code/);
+  }
+  // The fifth failure locks the account, even for a challenge already started
+  const mfaToken = await startChallenge();
+  await assert.rejects(mfaService.verifyChallenge(mfaToken, { code: wrongCode() }), /Invalid verification code/);
+
+  await assert.rejects(mfaService.verifyChallenge(mfaToken, { code: currentCode() }), { statusCode: 429 });
+  await assert.rejects(authService.login(EMAIL, PASSWORD, { ip: IP }), { statusCode: 429 });
+});
+
+test('the password alone does not clear failed second factors', async () => {
+  const first = await startChallenge();
+  await assert.rejects(mfaService.verifyChallenge(first, { code: wrongCode() }));
+  await assert.rejects(mfaService.verifyChallenge(first, { code: wrongCode() }));
+
+  const second = await startChallenge();
+  assert.equal(accountFailures(), 2);
+
+  await mfaService.verifyChallenge(second, { code: currentCode() });
+  assert.equal(accountFailures(), 0);
+});
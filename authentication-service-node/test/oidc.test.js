@@ -0,0 +1,157 @@
+const { test, before, mock } = require('node:test');
+const assert = require('node:assert/strict');
+const crypto = require('crypto');
+const { app } = require('./support/app');
+
+const authService = app('services/authServiceInstance');
+const oidcService = app('services/oidcService');
+const oauthClientService = app('services/oauthClientService');
+const jwt = app('utils/jwt');
+
+const PASSWORD = 'Lantern-orbit-meadow-42';
+const REDIRECT_URI = 'https://client.test/callback';
+const verifier = crypto.randomBytes(32).toString('base64url');
+const challenge = crypto.createHash('sha256').update(verifier).digest('base64url');
+
+let client;
+let clientSecret;
+let user;
+let session;
+
+const request = (overrides = {}) => ({
+  client_id: client.clientId,
+  redirect_uri: REDIRECT_URI,
+  response_type: 'code',
+  scope: 'openid email',
+  state: 'state-1',
+  nonce: 'nonce-1',
+  code_challenge: challenge,
+  code_challenge_method: 'S256',
+  ...overrides,
+});
+
+const redeem = (redirectTo) => oidcService.exchangeToken(undefined, {
+  grant_type: 'authorization_code',
+  code: new URL(redirectTo).searchParams.get('code'),
+  redirect_uri: REDIRECT_URI,
+  code_verifier: verifier,
+  client_id: client.clientId,
+  client_secret: clientSecret,
+});
+
+const verifyIdToken = (idToken) => jwt.verify(idToken, (header) => authService.keyRing.getVerificationKey(header.kid), { typ: 'JWT' });
+
+const errorOf = (redirectUri) => Object.fromEntries(new URL(redirectUri).searchParams);
+
+before(async () => {
+  ({ client, clientSecret } = await oauthClientService.createClient(null, {
+    name: 'Lab portal',
+    redirectUris: [REDIRECT_URI],
+    allowedScopes: ['openid', 'email', 'profile', 'offline_access'],
+    tokenEndpointAuthMethod: 'client_secret_post',
+  }));
+  await authService.registerAccount('patient', { email: 'pat@hospital.test', name: 'Pat', isApproved: true }, PASSWORD);
+  session = await authService.login('pat@hospital.test', PASSWORD);
+  user = await authService.validateToken(session.token);
+});
+
+test('the user approves the scopes once, then is sent straight back', async () => {
+  const first = await oidcService.authorize(request(), user);
+  assert.deepEqual(first, {
+    consentRequired: true,
+    client: { clientId: client.clientId, name: 'Lab portal' },
+    scopes: ['openid', 'email'],
+  });
+
+  const approved = await oidcService.authorize(request({ consent: 'approve' }), user);
+  assert.equal(new URL(approved.redirectTo).searchParams.get('state'), 'state-1');
+
+  const again = await oidcService.authorize(request(), user);
+  assert.ok(new URL(again.redirectTo).searchParams.get('code'));
+  assert.equal((await oidcService.authorize(request({ prompt: 'consent' }), user)).consentRequired, true);
+  assert.equal((await oidcService.authorize(request({ scope: 'openid email profile' }), user)).consentRequired, true);
+});
+
+test('ID tokens carry the time the user signed in, not when the code was issued', async () => {
+  const later = Date.now() + 10 * 60 * 1000;
+  mock.method(Date, 'now', () => later);
+  try {
+    const refreshed = await authService.refreshToken(session.refreshToken);
+    session = refreshed;
+    const current = await authService.validateToken(refreshed.token);
+    assert.equal(current.auth_time, user.auth_time);
+    assert.ok(current.iat > user.auth_time);
+
+    const { redirectTo } = await oidcService.authorize(request(), current);
+    const response = await redeem(redirectTo);
+    const idToken = await verifyIdToken(response.id_token);
+    assert.equal(idToken.auth_time, user.auth_time);
+    assert.equal(idToken.nonce, 'nonce-1');
+    assert.equal(idToken.aud, client.clientId);
+    assert.equal((await authService.validateToken(response.access_token)).auth_time, user.auth_time);
+  } finally {
+    mock.restoreAll();
+  }
+});
+
+test('ID tokens are not accepted as access tokens', async () => {
+  const { redirectTo } = await oidcService.authorize(request(), user);
+  const response = await redeem(redirectTo);
+
+  await assert.rejects(authService.validateToken(response.id_token), /Invalid or expired token/);
+});
+
+test('denying consent sends an error back to the client', async () => {
+  const result = await oidcService.authorize(request({ consent: 'deny' }), user).catch((error) => error);
+  assert.deepEqual(errorOf(result.redirectUri), {
+    error: 'access_denied',
+    error_description: 'The user denied the request',
+    state: 'state-1',
+  });
+});
+
+test('prompt=none without consent fails at the client', async () => {
+  const result = await oidcService.authorize(request({ scope: 'openid profile', prompt: 'none' }), user).catch((error) => error);
+  assert.equal(errorOf(result.redirectUri).error, 'consent_required');
+});
+
+test('request errors go back to a registered redirect_uri only', async () => {
+  const cases = [
+    [{ response_type: 'token' }, 'unsupported_response_type'],
+    [{ scope: 'email' }, 'invalid_scope'],
+    [{ scope: 'openid roles' }, 'invalid_scope'],
+    [{ code_challenge: undefined }, 'invalid_request'],
+  ];
+  for (const [overrides, error] of cases) {
+    const result = await oidcService.validateAuthorizationRequest(request(overrides)).catch((caught) => caught);
+    const params = errorOf(result.redirectUri);
+    assert.equal(params.error, error);
+    assert.equal(params.state, 'state-1');
+    assert.ok(result.redirectUri.startsWith(REDIRECT_URI));
+  }
+
+  for (const overrides of [{ redirect_uri: 'https://evil.test/callback' }, { client_id: 'unknown' }]) {
+    const result = await oidcService.validateAuthorizationRequest(request(overrides)).catch((caught) => caught);
+    assert.ok(result instanceof oidcService.OAuthError);
+    assert.equal(result.redirectUri, null);
+  }
+});
+
+test('a code is redeemed once, by its client, with the PKCE verifier', async () => {
+  const { redirectTo } = await oidcService.authorize(request(), user);
+  const code = new URL(redirectTo).searchParams.get('code');
+  const exchange = (overrides) => oidcService.exchangeToken(undefined, {
+    grant_type: 'authorization_code',
+    code,
+    redirect_uri: REDIRECT_URI,
+    code_verifier: verifier,
+    client_id: client.clientId,
+    client_secret: clientSecret,
+    ...overrides,
+  });
+
+  await assert.rejects(exchange({ code_verifier: 'wrong' }), /PKCE verification failed/);
+  const second = await oidcService.authorize(request(), user);
+  await redeem(second.redirectTo);
+  await assert.rejects(redeem(second.redirectTo), /Invalid or expired authorization code/);
+});
@@ -0,0 +1,110 @@
+const { test } = require('node:test');
+const assert = require('node:assert/strict');
+const crypto = require('crypto');
+const fs = require('fs');
+const os = require('os');
+const path = require('path');
+const { app } = require('./support/app');
+
+const authService = app('services/authServiceInstance');
+const passwordPolicy = app('services/passwordPolicy');
+const BreachedPasswordList = app('utils/breachedPasswords');
+
+const { PasswordPolicy, PasswordPolicyError } = passwordPolicy;
+
+const PASSWORD = 'Lantern-orbit-meadow-42';
+
+const policy = (overrides = {}) => new PasswordPolicy({
+  minLength: 8,
+  maxLength: 64,
+  requiredClasses: [],
+  minStrength: 2,
+  historySize: 5,
+  breachedPasswords: new BreachedPasswordList(null),
+  ...overrides,
+});
+
+const codes = async (target, password, context) => (await target.check(password, context)).map((reason) => reason.code);
+
+const sha1 = (value) => crypto.createHash('sha1').update(value).digest('hex').toUpperCase();
+
+const tempDir = () => fs.mkdtempSync(path.join(os.tmpdir(), 'password-policy-'));
+
+test('reports every broken rule at once', async () => {
+  const strict = policy({ minLength: 12, requiredClasses: ['upper', 'digit', 'symbol'] });
+  assert.deepEqual(await codes(strict, 'aaaa'), ['too_short', 'missing_upper', 'missing_digit', 'missing_symbol', 'too_weak']);
+  assert.deepEqual(await codes(strict, ''), ['required']);
+  assert.deepEqual(await codes(strict, 'Zephyr-Quokka-7'), []);
+
+  const error = await strict.assertValid('aaaa').catch((caught) => caught);
+  assert.ok(error instanceof PasswordPolicyError);
+  assert.equal(error.statusCode, 400);
+  assert.equal(error.reasons.length, 5);
+});
+
+test('refuses passwords bcrypt would truncate', async () => {
+  // 35 characters, but 85 bytes
+  assert.deepEqual(await codes(policy(), `${'€'.repeat(25)}Zephyr-Quo`), ['too_long']);
+  assert.deepEqual(await codes(policy({ maxLength: 16 }), 'Zephyr-Quokka-Lantern'), ['too_long']);
+});
+
+test('rates passwords built from common words or the user\'s details as weak', async () => {
+  assert.deepEqual(await codes(policy(), 'password123'), ['too_weak']);
+  assert.deepEqual(await codes(policy(), 'MilaJones1990!'), []);
+  assert.deepEqual(
+    await codes(policy(), 'MilaJones1990!', { userInputs: ['mila.jones@hospital.test', 'Mila Jones'] }),
+    ['too_weak'],
+  );
+});
+
+test('checks the breached list from a single file or from range files', async () => {
+  const dir = tempDir();
+  const breached = 'Zephyr-Quokka-7';
+  const hash = sha1(breached);
+
+  const file = path.join(dir, 'breached.txt');
+  fs.writeFileSync(file, `# comment\n${hash}:42\n`);
+  assert.deepEqual(await codes(policy({ breachedPasswords: new BreachedPasswordList(file) }), breached), ['breached']);
+
+  const ranges = path.join(dir, 'ranges');
+  fs.mkdirSync(ranges);
+  fs.writeFileSync(path.join(ranges, `${hash.slice(0, 5)}.txt`), `${hash.slice(5)}:42\r\n`);
+  const fromRanges = policy({ breachedPasswords: new BreachedPasswordList(ranges) });
+  assert.deepEqual(await codes(fromRanges, breached), ['breached']);
+  assert.deepEqual(await codes(fromRanges, PASSWORD), []);
+
+  // The shipped list covers the most common breached passwords
+  assert.equal(await passwordPolicy.breachedPasswords.contains('P@ssw0rd'), true);
+  fs.rmSync(dir, { recursive: true, force: true });
+});
+
+test('remembers only the newest passwords', async () => {
+  const short = policy({ historySize: 2 });
+  const userId = crypto.randomBytes(12).toString('hex');
+  for (const password of ['First-lantern-81', 'Second-meadow-82', 'Third-harbor-83']) {
+    await short.remember(userId, 'account', await short.hash(password));
+  }
+  assert.deepEqual(await codes(short, 'Third-harbor-83', { userId, role: 'account' }), ['reused']);
+  assert.deepEqual(await codes(short, 'Second-meadow-82', { userId, role: 'account' }), ['reused']);
+  assert.deepEqual(await codes(short, 'First-lantern-81', { userId, role: 'account' }), []);
+});
+
+test('a password change refuses the current and recent passwords', async () => {
+  const { profile } = await authService.registerAccount(
+    'patient',
+    { email: 'policy@hospital.test', name: 'Pol', isApproved: true },
+    PASSWORD,
+  );
+  const claims = { user_id: profile._id.toString(), role: 'patient' };
+
+  await assert.rejects(authService.changePassword(claims, PASSWORD, PASSWORD), /differ from your last/);
+  await authService.changePassword(claims, PASSWORD, 'Harbor-violet-compass-77');
+  await assert.rejects(
+    authService.changePassword(claims, 'Harbor-violet-compass-77', PASSWORD),
+    (error) => error instanceof PasswordPolicyError && error.reasons[0].code === 'reused',
+  );
+  await assert.rejects(
+    authService.changePassword(claims, 'Harbor-violet-compass-77', 'policy@hospital.test'),
+    (error) => error instanceof PasswordPolicyError && error.reasons.some((reason) => reason.code === 'too_weak'),
+  );
+});
@@ -0,0 +1,86 @@
+const { test } = require('node:test');
+const assert = require('node:assert/strict');
+const { app, models, sentMail, linkToken, PASSWORD, registerPatient } = require('./support/app');
+
+const authService = app('services/authServiceInstance');
+const passwordResetService = app('services/passwordResetService');
+const cacheStore = app('utils/cacheStore');
+
+const NEW_PASSWORD = 'Harbor-violet-compass-77';
+
+const register = async () => (await registerPatient('reset')).email;
+
+const requestAgain = async (email) => {
+  await cacheStore.del(`password_reset_sent:${email}`);
+  await passwordResetService.requestReset(email, '198.51.100.4');
+};
+
+test('a reset link sets a new password and ends existing sessions', async () => {
+  const email = await register();
+  const session = await authService.login(email, PASSWORD);
+  await passwordResetService.requestReset(email, '198.51.100.4');
+
+  const token = linkToken(email);
+  assert.ok(token);
+  // Only a hash of the token is stored
+  assert.ok(models.PasswordResetToken.docs.every((doc) => doc.tokenHash !== token));
+
+  await passwordResetService.resetPassword(token, NEW_PASSWORD);
+  await assert.rejects(authService.login(email, PASSWORD), /Invalid email or password/);
+  assert.ok((await authService.login(email, NEW_PASSWORD)).token);
+  await assert.rejects(authService.refreshToken(session.refreshToken));
+});
+
+test('a reset link works only once', async () => {
+  const email = await register();
+  await passwordResetService.requestReset(email, '198.51.100.4');
+  const token = linkToken(email);
+
+  const results = await Promise.allSettled([
+    passwordResetService.resetPassword(token, NEW_PASSWORD),
+    passwordResetService.resetPassword(token, `${NEW_PASSWORD}-again`),
+  ]);
+  assert.equal(results.filter((result) => result.status === 'fulfilled').length, 1);
+  await assert.rejects(passwordResetService.resetPassword(token, NEW_PASSWORD), /Invalid or expired reset token/);
+});
+
+test('a newer request replaces the previous link', async () => {
+  const email = await register();
+  await passwordResetService.requestReset(email, '198.51.100.4');
+  const first = linkToken(email);
+  await requestAgain(email);
+  const second = linkToken(email);
+
+  assert.notEqual(first, second);
+  await assert.rejects(passwordResetService.resetPassword(first, NEW_PASSWORD), /Invalid or expired reset token/);
+  await passwordResetService.resetPassword(second, NEW_PASSWORD);
+});
+
+test('an expired link is refused', async () => {
+  const email = await register();
+  await passwordResetService.requestReset(email, '198.51.100.4');
+  const token = linkToken(email);
+  models.PasswordResetToken.docs.forEach((doc) => { doc.expiresAt = new Date(Date.now() - 1000); });
+
+  await assert.rejects(passwordResetService.resetPassword(token, NEW_PASSWORD), /Invalid or expired reset token/);
+});
+
+test('a password the policy rejects leaves the link usable', async () => {
+  const email = await register();
+  await passwordResetService.requestReset(email, '198.51.100.4');
+  const token = linkToken(email);
+
+  await assert.rejects(passwordResetService.resetPassword(token, 'short'));
+  await passwordResetService.resetPassword(token, NEW_PASSWORD);
+});
+
+test('unknown addresses and repeated requests send nothing', async () => {
+  const before = sentMail().length;
+  await passwordResetService.requestReset('nobody@hospital.test', '198.51.100.4');
+  assert.equal(sentMail().length, before);
+
+  const email = await register();
+  await passwordResetService.requestReset(email, '198.51.100.4');
+  await passwordResetService.requestReset(email, '198.51.100.4');
+  assert.equal(sentMail().filter((entry) => entry.to === email).length, 1);
+});
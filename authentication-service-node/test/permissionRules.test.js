@@ -0,0 +1,84 @@
+const { test } = require('node:test');
+const assert = require('node:assert/strict');
+const { app } = require('./support/app');
+
+const authService = app('services/authServiceInstance');
+const permissionRules = app('utils/permissionRules');
+const permissions = app('utils/permissions');
+
+const CATALOG = Object.values(permissions);
+const DOCTOR_PERMISSIONS = CATALOG.filter((permission) => permission.startsWith('doctor:'));
+
+test('wildcards cover their resource and implications are followed', () => {
+  const { holds } = permissionRules;
+  assert.equal(holds(['doctor:*'], 'doctor:delete'), true);
+  assert.equal(holds(['doctor:*'], 'patient:view'), false);
+  assert.equal(holds(['patient:history'], 'patient:view'), true);
+  assert.equal(holds(['patient:view'], 'patient:history'), false);
+  // approve implies view and list
+  assert.equal(holds(['doctor:approve'], 'doctor:list'), true);
+  assert.equal(holds(['all_permissions'], 'system:logs'), true);
+  assert.equal(holds([], 'doctor:list'), false);
+  assert.equal(holds(undefined, 'doctor:list'), false);
+});
+
+test('only catalog permissions and wildcards over known resources are valid grants', () => {
+  assert.equal(permissionRules.isValidGrant('doctor:*'), true);
+  assert.equal(permissionRules.isValidGrant('billing:*'), false);
+  assert.equal(permissionRules.isValidGrant('doctor:lsit'), false);
+  assert.equal(permissionRules.isValidGrant('doctor:list', ['patient:list']), false);
+  // A wildcard is only as valid as everything it covers
+  assert.equal(permissionRules.isValidGrant('doctor:*', ['doctor:list', 'doctor:view']), false);
+  assert.equal(permissionRules.isValidGrant('doctor:*', DOCTOR_PERMISSIONS), true);
+});
+
+test('token payloads carry the shortest equivalent list', () => {
+  const { compress, expand } = permissionRules;
+  assert.deepEqual(compress(DOCTOR_PERMISSIONS), ['doctor:*']);
+  assert.deepEqual(compress(['patient:history', 'patient:view']), ['patient:history']);
+  assert.deepEqual(compress(['doctor:approve', 'doctor:view', 'doctor:list']), ['doctor:approve']);
+  assert.deepEqual(compress(['system:config', 'all_permissions']), ['all_permissions']);
+  // Grants outside the catalog only ever match themselves, so they stay
+  assert.deepEqual(compress(['patient:self', 'patient:view']), ['patient:view', 'patient:self']);
+  assert.deepEqual(expand(['doctor:*']), DOCTOR_PERMISSIONS);
+});
+
+test('compressing never changes what a list of grants satisfies', () => {
+  const { compress, expand } = permissionRules;
+  // A fixed spread of subsets so failures reproduce
+  for (let seed = 1; seed < 400; seed += 1) {
+    const grants = CATALOG.filter((permission, index) => ((seed * 2654435761) >>> (index % 29)) & 1);
+    assert.deepEqual(expand(compress(grants)), expand(grants), `grants: ${grants.join(' ')}`);
+  }
+  const doctor = authService.identityFor({ _id: 'd1', email: 'd@hospital.test' }, 'doctor', {}).permissions;
+  assert.deepEqual(expand(compress(doctor)), expand(doctor));
+});
+
+test('the published rules describe the catalog and its implications', () => {
+  const published = permissionRules.document();
+  assert.equal(published.all_permissions, 'all_permissions');
+  assert.equal(published.wildcard_suffix, ':*');
+  assert.deepEqual(published.permissions, CATALOG);
+  assert.deepEqual(published.implies['patient:history'], ['patient:view']);
+  assert.deepEqual(JSON.parse(JSON.stringify(published)), published);
+});
+
+test('granting more than one holds fails with a typed error', () => {
+  const { assertCanGrant, isGrantDenied, GrantDeniedError } = permissionRules;
+  assertCanGrant(['doctor:*'], ['doctor:list', 'doctor:delete']);
+  // Narrowing someone who already holds more is allowed
+  assertCanGrant(['doctor:list'], ['doctor:list', 'admin:create'], ['admin:create']);
+
+  let denied;
+  try {
+    assertCanGrant(['doctor:list'], ['doctor:*']);
+  } catch (error) {
+    denied = error;
+  }
+  assert.ok(denied instanceof GrantDeniedError);
+  assert.equal(denied.statusCode, 403);
+  assert.ok(denied.excessPermissions.includes('doctor:delete'));
+  assert.equal(isGrantDenied(denied), true);
+  // Other errors are not mistaken for it, whatever they say
+  assert.equal(isGrantDenied(new Error(denied.message)), false);
+});
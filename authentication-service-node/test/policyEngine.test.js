@@ -0,0 +1,116 @@
+const { test } = require('node:test');
+const assert = require('node:assert/strict');
+const { app, models } = require('./support/app');
+
+const policyEngine = app('services/policyEngine');
+const careRelationshipService = app('services/careRelationshipService');
+const Principal = app('utils/principal');
+
+const DAY_MS = 24 * 60 * 60 * 1000;
+const SYNC_SERVICE = 'appointment-service-account';
+
+const newPatient = async () => (await models.Patient.create({ name: 'Pat' }))._id.toString();
+const newDoctor = async (isApproved = true) => (await models.Doctor.create({ name: 'Doc', isApproved }))._id.toString();
+
+const doctor = (userId, permissions = ['patient:view', 'patient:history', 'patient:list']) => new Principal({ user_id: userId, role: 'doctor', permissions });
+
+const link = (appointmentId) => models.CareRelationship.docs.find((doc) => doc.appointmentId === appointmentId);
+
+const syncAppointment = (doctorId, patientId, appointmentId, status, endTime = new Date(Date.now() + DAY_MS)) => (
+  careRelationshipService.syncAppointment(SYNC_SERVICE, patientId, appointmentId, { doctorId, status, endTime })
+);
+
+test('a doctor reads a patient only with a care-team link', async () => {
+  const [patientId, doctorId] = [await newPatient(), await newDoctor()];
+  const denied = await policyEngine.evaluate('patient.read', doctor(doctorId), { id: patientId });
+  assert.equal(denied.allowed, false);
+  assert.deepEqual(denied.trace.map((step) => step.rule), ['self', 'treating-doctor', 'staff', 'break-glass']);
+  assert.match(denied.trace[1].reason, /no appointment or care-team link/);
+
+  await careRelationshipService.addCareTeamMember('admin-1', patientId, { doctorId, note: 'Cardiology' });
+  const allowed = await policyEngine.evaluate('patient.read', doctor(doctorId), { id: patientId });
+  assert.equal(allowed.allowed, true);
+  assert.equal(allowed.rule, 'treating-doctor');
+  assert.equal(allowed.trace.at(-1).relationship.source, 'care_team');
+  // The link opens this patient only
+  assert.equal((await policyEngine.evaluate('patient.read', doctor(doctorId), { id: await newPatient() })).allowed, false);
+
+  await careRelationshipService.removeCareTeamMember(patientId, doctorId);
+  assert.equal((await policyEngine.evaluate('patient.read', doctor(doctorId), { id: patientId })).allowed, false);
+  await assert.rejects(careRelationshipService.removeCareTeamMember(patientId, doctorId), /Care team link not found/);
+});
+
+test('a link still needs the permission the policy checks', async () => {
+  const [patientId, doctorId] = [await newPatient(), await newDoctor()];
+  await careRelationshipService.addCareTeamMember('admin-1', patientId, { doctorId });
+  const viewer = doctor(doctorId, ['patient:view']);
+  assert.equal((await policyEngine.evaluate('patient.read', viewer, { id: patientId })).allowed, true);
+  const history = await policyEngine.evaluate('patient.history.read', viewer, { id: patientId });
+  assert.equal(history.allowed, false);
+  assert.equal(history.trace[1].reason, 'lacks patient:history');
+});
+
+test('confirmed and completed appointments link, pending and cancelled ones unlink', async () => {
+  const [patientId, doctorId] = [await newPatient(), await newDoctor()];
+  const read = async () => (await policyEngine.evaluate('patient.read', doctor(doctorId), { id: patientId })).allowed;
+
+  await syncAppointment(doctorId, patientId, 'appt-1', 'pending');
+  assert.equal(await read(), false);
+  await syncAppointment(doctorId, patientId, 'appt-1', 'confirmed');
+  assert.equal(await read(), true);
+  await syncAppointment(doctorId, patientId, 'appt-1', 'completed');
+  assert.equal(models.CareRelationship.docs.filter((doc) => doc.appointmentId === 'appt-1').length, 1);
+  await syncAppointment(doctorId, patientId, 'appt-1', 'cancelled');
+  assert.equal(await read(), false);
+
+  await assert.rejects(syncAppointment(doctorId, patientId, 'appt-1', 'rescheduled'), /status must be one of/);
+  await assert.rejects(syncAppointment(await newDoctor(false), patientId, 'appt-2', 'confirmed'), /Doctor not found/);
+  await assert.rejects(syncAppointment(doctorId, patientId, 'appt-2', 'confirmed', 'soon'), /endTime must be a date/);
+});
+
+test('an appointment link expires some days after the appointment, never far in the future', async () => {
+  const [patientId, doctorId] = [await newPatient(), await newDoctor()];
+  const endTime = new Date(Date.now() + 2 * DAY_MS);
+  await syncAppointment(doctorId, patientId, 'appt-3', 'confirmed', endTime);
+  assert.equal(link('appt-3').expiresAt.getTime(), endTime.getTime() + 90 * DAY_MS);
+
+  await syncAppointment(doctorId, patientId, 'appt-4', 'confirmed', new Date(Date.now() + 5000 * DAY_MS));
+  assert.ok(link('appt-4').expiresAt.getTime() <= Date.now() + 180 * DAY_MS);
+
+  link('appt-3').expiresAt = new Date(Date.now() - 1000);
+  link('appt-4').expiresAt = new Date(Date.now() - 1000);
+  assert.equal((await policyEngine.evaluate('patient.read', doctor(doctorId), { id: patientId })).allowed, false);
+  assert.deepEqual(await careRelationshipService.patientIdsFor(doctorId), []);
+});
+
+test('patients read their own record and staff need the permission', async () => {
+  const patientId = await newPatient();
+  const patient = new Principal({ user_id: 'account-1', role: 'patient', patientId, permissions: ['patient:view'] });
+  assert.equal((await policyEngine.evaluate('patient.read', patient, { id: patientId })).rule, 'self');
+  assert.equal((await policyEngine.evaluate('patient.read', patient, { id: await newPatient() })).allowed, false);
+  assert.equal((await policyEngine.evaluate('patient.history.write', patient, { id: patientId })).allowed, false);
+
+  const admin = (permissions) => new Principal({ user_id: 'admin-2', role: 'admin', permissions });
+  assert.equal((await policyEngine.evaluate('patient.read', admin(['patient:view']), { id: patientId })).rule, 'staff');
+  assert.equal((await policyEngine.evaluate('patient.read', admin(['doctor:view']), { id: patientId })).allowed, false);
+  assert.equal((await policyEngine.evaluate('patient.history.read', admin(['patient:*']), { id: patientId })).allowed, true);
+});
+
+test('doctors list only their own patients', async () => {
+  const [patientId, doctorId] = [await newPatient(), await newDoctor()];
+  await careRelationshipService.addCareTeamMember('admin-1', patientId, { doctorId });
+
+  const decision = await policyEngine.evaluate('patient.list', doctor(doctorId));
+  assert.equal(decision.allowed, true);
+  assert.equal(decision.constraint, 'own_patients');
+  assert.deepEqual(await careRelationshipService.patientIdsFor(doctorId), [patientId]);
+
+  const staff = await policyEngine.evaluate('patient.list', new Principal({ role: 'admin', permissions: ['patient:list'] }));
+  assert.equal(staff.constraint, null);
+});
+
+test('explains a decision for a user and refuses unknown policies', async () => {
+  await assert.rejects(policyEngine.evaluate('patient.delete', doctor('x')), /Unknown policy/);
+  await assert.rejects(policyEngine.explainFor({ policy: 'patient.read', userId: 'not-an-id', role: 'doctor' }), /User not found/);
+  assert.ok(policyEngine.describe().some((policy) => policy.name === 'patient.list' && policy.rules[1].constraint === 'own_patients'));
+});
@@ -0,0 +1,45 @@
+const { test } = require('node:test');
+const assert = require('node:assert/strict');
+const { app, signIn } = require('./support/app');
+
+const authService = app('services/authServiceInstance');
+
+test('a refresh token is exchanged for a new pair in the same session', async () => {
+  const first = await signIn();
+  const second = await authService.refreshToken(first.refreshToken);
+
+  assert.notEqual(second.refreshToken, first.refreshToken);
+  const before = await authService.validateToken(first.token);
+  const after = await authService.validateToken(second.token);
+  assert.equal(after.sid, before.sid);
+});
+
+test('reusing a rotated refresh token revokes the whole session', async () => {
+  const first = await signIn();
+  const second = await authService.refreshToken(first.refreshToken);
+
+  await assert.rejects(authService.refreshToken(first.refreshToken), /reuse detected/);
+  await assert.rejects(authService.refreshToken(second.refreshToken), /Invalid refresh token/);
+  await assert.rejects(authService.validateToken(second.token), /revoked/);
+});
+
+test('concurrent refreshes with one token succeed only once', async () => {
+  const { refreshToken } = await signIn();
+  const results = await Promise.allSettled([
+    authService.refreshToken(refreshToken),
+    authService.refreshToken(refreshToken),
+  ]);
+
+  assert.equal(results.filter((result) => result.status === 'fulfilled').length, 1);
+});
+
+test('a refresh token only works for the client it was issued to', async () => {
+  const { refreshToken } = await signIn();
+
+  await assert.rejects(authService.refreshToken(refreshToken, 'some-client'), /Invalid refresh token/);
+  await authService.refreshToken(refreshToken);
+});
+
+test('an unknown refresh token is rejected', async () => {
+  await assert.rejects(authService.refreshToken('not-a-token'), /Invalid refresh token/);
+});
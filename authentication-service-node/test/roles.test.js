@@ -0,0 +1,107 @@
+const { test, before } = require('node:test');
+const assert = require('node:assert/strict');
+const { app, models, PASSWORD, createAdmin } = require('./support/app');
+
+const authService = app('services/authServiceInstance');
+const adminService = app('services/adminService');
+const roleService = app('services/roleService');
+const delegationService = app('services/delegationService');
+
+const SUPER_ADMIN = { user_id: '64b000000000000000000001', role: 'super_admin', permissions: ['all_permissions'] };
+
+// Creates an admin through the admin API and signs them in
+const signedInAdmin = async ({ permissions, roles, creator = SUPER_ADMIN } = {}) => {
+  const admin = await createAdmin(creator, permissions, roles, 'roles');
+  const { token } = await authService.login(admin.email, PASSWORD, { ip: '198.51.100.40' });
+  const claims = await authService.validateToken(token);
+  return { admin, token, claims };
+};
+
+before(() => roleService.ensureBuiltInRoles());
+
+test('the built-in admin role is created once and cannot be deleted', async () => {
+  const builtIn = await roleService.getRole('admin');
+  assert.deepEqual(builtIn.permissions, authService.defaultAdminPermissions());
+  builtIn.description = 'Edited';
+  await roleService.ensureBuiltInRoles();
+  assert.equal(models.Role.docs.filter((doc) => doc.name === 'admin').length, 1);
+  assert.equal((await roleService.getRole('admin')).description, 'Edited');
+  await assert.rejects(roleService.deleteRole('admin'), /Built-in roles cannot be deleted/);
+
+  // Admins created without roles or permissions hold it
+  const { admin, claims } = await signedInAdmin();
+  assert.deepEqual(admin.roles, ['admin']);
+  assert.deepEqual(claims.permissions, authService.defaultAdminPermissions());
+});
+
+test('roles hold known, assignable permissions under a valid name', async () => {
+  await assert.rejects(roleService.createRole(SUPER_ADMIN, { name: 'Billing Team', permissions: ['doctor:list'] }), /Role name must be/);
+  await assert.rejects(roleService.createRole(SUPER_ADMIN, { name: 'billing', permissions: ['doctor:lsit'] }), /Unknown permissions: doctor:lsit/);
+  await assert.rejects(roleService.createRole(SUPER_ADMIN, { name: 'billing', permissions: ['token:introspect'] }), /Unknown permissions/);
+  // Wildcards over resources with service-only or clinician-only permissions
+  await assert.rejects(roleService.createRole(SUPER_ADMIN, { name: 'billing', permissions: ['token:*'] }), /Unknown permissions: token:\*/);
+  await assert.rejects(roleService.createRole(SUPER_ADMIN, { name: 'billing', permissions: ['break_glass:*'] }), /Unknown permissions: break_glass:\*/);
+  await assert.rejects(adminService.createAdmin(SUPER_ADMIN, 'wild@hospital.test', PASSWORD, ['care_relationship:*']), /Unknown permissions/);
+  await assert.rejects(roleService.createRole(SUPER_ADMIN, { name: 'billing', permissions: 'doctor:list' }), /must be a list/);
+
+  const role = await roleService.createRole(SUPER_ADMIN, { name: 'billing', permissions: ['doctor:*', 'doctor:list', 'doctor:list'] });
+  assert.deepEqual(role.permissions, ['doctor:*', 'doctor:list']);
+  await assert.rejects(roleService.createRole(SUPER_ADMIN, { name: 'billing', permissions: ['doctor:list'] }), /already exists/);
+});
+
+test('a role change reaches signed-in admins on their next request', async () => {
+  await roleService.createRole(SUPER_ADMIN, { name: 'records', permissions: ['patient:list', 'patient:view'] });
+  const { token, claims } = await signedInAdmin({ roles: ['records'] });
+  assert.deepEqual(claims.permissions, ['patient:list', 'patient:view']);
+
+  await roleService.updateRole(SUPER_ADMIN, 'records', { permissions: ['patient:list'] });
+  assert.deepEqual((await authService.validateToken(token)).permissions, ['patient:list']);
+  await roleService.updateRole(SUPER_ADMIN, 'records', { permissions: ['patient:list', 'doctor:list'] });
+  assert.deepEqual((await authService.validateToken(token)).permissions, ['patient:list', 'doctor:list']);
+});
+
+test('admins hold their roles and direct grants together', async () => {
+  await roleService.createRole(SUPER_ADMIN, { name: 'directory', permissions: ['doctor:list'] });
+  await roleService.createRole(SUPER_ADMIN, { name: 'approvals', permissions: ['doctor:approve'] });
+  const { admin, token } = await signedInAdmin({ permissions: ['patient:list'], roles: ['directory'] });
+
+  await assert.rejects(roleService.assignRoles(SUPER_ADMIN, admin._id, ['directory', 'nope']), /Unknown roles: nope/);
+  const assigned = await roleService.assignRoles(SUPER_ADMIN, admin._id, ['approvals']);
+  assert.deepEqual(assigned.roles, ['approvals']);
+  assert.deepEqual(assigned.effectivePermissions.sort(), ['doctor:approve', 'doctor:list', 'doctor:view', 'patient:list'].sort());
+  assert.deepEqual((await authService.validateToken(token)).permissions.sort(), ['doctor:approve', 'patient:list']);
+
+  await adminService.updateAdminPermissions(SUPER_ADMIN, admin._id, []);
+  assert.deepEqual((await authService.validateToken(token)).permissions, ['doctor:approve']);
+
+  const described = await roleService.userPermissions(admin._id.toString(), 'admin');
+  assert.deepEqual(described.roles, ['approvals']);
+  assert.deepEqual(described.granted, ['doctor:approve']);
+  assert.deepEqual(described.effective.sort(), ['doctor:approve', 'doctor:list', 'doctor:view']);
+  await assert.rejects(roleService.userPermissions('64b0000000000000000000ff', 'admin'), /User not found/);
+});
+
+test('nobody puts into a role more than they hold', async () => {
+  const { claims } = await signedInAdmin({ permissions: ['doctor:list', 'patient:list'] });
+  await roleService.createRole(claims, { name: 'lookups', permissions: ['doctor:list'] });
+
+  const denied = await roleService.createRole(claims, { name: 'escalate', permissions: ['admin:create'] }).catch((error) => error);
+  assert.ok(delegationService.isGrantDenied(denied));
+  await assert.rejects(roleService.updateRole(claims, 'lookups', { permissions: ['doctor:*'] }), /cannot grant permissions you do not hold: .*doctor:delete/);
+
+  // Taking away what the role holds beyond the editor is still allowed
+  await roleService.createRole(SUPER_ADMIN, { name: 'wide', permissions: ['doctor:list', 'admin:create'] });
+  await roleService.updateRole(claims, 'wide', { permissions: ['doctor:list'] });
+  assert.deepEqual((await roleService.getRole('wide')).permissions, ['doctor:list']);
+});
+
+test('a role in use cannot be deleted', async () => {
+  await roleService.createRole(SUPER_ADMIN, { name: 'temporary', permissions: ['doctor:list'] });
+  const { admin } = await signedInAdmin({ roles: ['temporary'] });
+  await assert.rejects(roleService.deleteRole('temporary'), /assigned to 1 admin/);
+
+  await roleService.assignRoles(SUPER_ADMIN, admin._id, []);
+  await roleService.deleteRole('temporary');
+  assert.equal(await roleService.getRole('temporary'), null);
+  await assert.rejects(roleService.deleteRole('temporary'), /Role not found/);
+});
@@ -0,0 +1,154 @@
+const { test, mock } = require('node:test');
+const assert = require('node:assert/strict');
+const fs = require('fs');
+const path = require('path');
+
+// The fixtures are responses in the shapes Okta and Microsoft Entra ID send,
+// signed ahead of time from hand-written exclusive canonical forms, so a
+// canonicalization bug shows up as a digest mismatch here rather than being
+// reproduced by the signer. They answer REQUEST_ID and are valid at NOW.
+const FIXTURES = path.join(__dirname, 'fixtures', 'saml');
+process.env.OIDC_ISSUER = 'https://auth.hospital.test';
+process.env.SAML_SP_PRIVATE_KEY_PATH = path.join(FIXTURES, 'sp.key');
+process.env.SAML_SP_CERTIFICATE_PATH = path.join(FIXTURES, 'sp.crt');
+
+const { app } = require('./support/app');
+
+const samlLoginService = app('services/samlLoginService');
+
+const REQUEST_ID = '_4f1c0a3d9e8b7a6c5d4e3f2a1b0c9d8e';
+const NOW = Date.parse('2025-03-04T10:15:31Z');
+
+const fixture = (name) => fs.readFileSync(path.join(FIXTURES, name), 'utf8');
+
+const okta = {
+  entityId: 'http://www.okta.com/exk1fcia6d6EMsf7P0h8',
+  displayName: 'Okta',
+  certificates: [fixture('idp.crt')],
+};
+const entra = {
+  entityId: 'https://sts.windows.net/2f1c7a9e-5d3b-4c8f-9a6e-1b0d4e7c3a52/',
+  displayName: 'Entra ID',
+  certificates: [fixture('idp.crt')],
+};
+
+const validate = (provider, xml) => samlLoginService.validateResponse(
+  provider,
+  Buffer.from(xml).toString('base64'),
+  REQUEST_ID,
+);
+
+const ASSERTION_END = '</saml2:Assertion>';
+const SIGNATURE = /<ds:Signature[\s\S]*?<\/ds:Signature>/;
+
+// Pieces of the Okta response for building wrapping attacks
+const signedResponse = fixture('okta-response.xml');
+const signedAssertion = signedResponse.slice(
+  signedResponse.indexOf('<saml2:Assertion'),
+  signedResponse.indexOf(ASSERTION_END) + ASSERTION_END.length,
+);
+const signatureOf = (xml) => xml.match(SIGNATURE)[0];
+const assertionId = signedAssertion.match(/ ID="([^"]+)"/)[1];
+const forge = (assertion, id = 'id-forged') => assertion
+  .replace(SIGNATURE, '')
+  .replace(`ID="${assertionId}"`, `ID="${id}"`)
+  .replace('alice.moreau@hospital.test</saml2:NameID>', 'admin@hospital.test</saml2:NameID>');
+// Puts replacement where the signed assertion was and hidden in an extension
+const wrap = (replacement, hidden = signedAssertion) => signedResponse
+  .replace(signedAssertion, replacement)
+  .replace('    <saml2p:Status>', `    <saml2p:Extensions>${hidden}</saml2p:Extensions>\n    <saml2p:Status>`);
+
+mock.method(Date, 'now', () => NOW);
+
+test('accepts an Okta response once', async () => {
+  const result = await validate(okta, signedResponse);
+  assert.equal(result.subject, 'alice.moreau@hospital.test');
+  assert.equal(result.nameIdFormat, 'urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress');
+  assert.deepEqual(result.attributes, {
+    email: ['alice.moreau@hospital.test'],
+    displayName: ["Alice O'Brien-Moreau"],
+    groups: ['physicians', 'Cardiology & Vascular'],
+  });
+
+  await assert.rejects(validate(okta, signedResponse), /already been used/);
+});
+
+test('accepts an Entra ID response with default namespaces and CDATA', async () => {
+  const result = await validate(entra, fixture('entra-response.xml'));
+  assert.equal(result.subject, 'kX3vJ9dQp2R8bT5mW1nY7cZ4hL6sA0eF');
+  assert.deepEqual(result.attributes['http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name'], ['Dr. R. Ng <Cardiology>']);
+});
+
+test('reads the whole NameID when a comment splits it', async () => {
+  const result = await validate(okta, fixture('okta-comment-injection.xml'));
+  assert.equal(result.subject, 'admin@hospital.test.evil.test');
+});
+
+test('refuses a response signed with a key the provider is not configured with', async () => {
+  // The signature carries its own certificate in KeyInfo; it must be ignored
+  await assert.rejects(validate(okta, fixture('okta-untrusted-key.xml')), /Invalid signature/);
+});
+
+test('refuses an unsigned assertion', async () => {
+  await assert.rejects(validate(okta, signedResponse.replace(SIGNATURE, '')), /SAML assertion is not signed/);
+});
+
+test('refuses an unsigned assertion inside a signed response', async () => {
+  await assert.rejects(validate(okta, fixture('okta-signed-response-only.xml')), /SAML assertion is not signed/);
+});
+
+test('refuses modified content', async () => {
+  await assert.rejects(
+    validate(okta, signedResponse.replace('>physicians<', '>it-admins<')),
+    /Signed content has been modified/,
+  );
+  await assert.rejects(
+    validate(okta, signedResponse.replace('alice.moreau@hospital.test</saml2:NameID>', 'admin@hospital.test</saml2:NameID>')),
+    /Signed content has been modified/,
+  );
+});
+
+test('refuses signature wrapping', async (t) => {
+  await t.test('signed assertion hidden in an extension', async () => {
+    await assert.rejects(validate(okta, wrap(forge(signedAssertion))), /SAML assertion is not signed/);
+  });
+
+  await t.test('forged assertion reusing the signed ID', async () => {
+    await assert.rejects(validate(okta, wrap(forge(signedAssertion, assertionId))), /Duplicate IDs/);
+  });
+
+  await t.test('signature moved onto the forged assertion', async () => {
+    const forged = forge(signedAssertion).replace('</saml2:Issuer>', `</saml2:Issuer>${signatureOf(signedAssertion)}`);
+    await assert.rejects(validate(okta, wrap(forged)), /Signature does not reference the signed element/);
+  });
+
+  await t.test('signed assertion inside the signature object', async () => {
+    const signature = signatureOf(signedAssertion)
+      .replace('</ds:Signature>', `<ds:Object>${signedAssertion}</ds:Object></ds:Signature>`);
+    const forged = forge(signedAssertion).replace('</saml2:Issuer>', `</saml2:Issuer>${signature}`);
+    await assert.rejects(validate(okta, signedResponse.replace(signedAssertion, forged)), /Signature does not reference the signed element/);
+  });
+
+  await t.test('forged assertion with the signature copied over', async () => {
+    const forged = forge(signedAssertion, assertionId).replace('</saml2:Issuer>', `</saml2:Issuer>${signatureOf(signedAssertion)}`);
+    await assert.rejects(validate(okta, signedResponse.replace(signedAssertion, forged)), /Signed content has been modified/);
+  });
+
+  await t.test('second assertion next to the signed one', async () => {
+    const doubled = signedResponse.replace(ASSERTION_END, `${ASSERTION_END}\n    ${forge(signedAssertion)}`);
+    await assert.rejects(validate(okta, doubled), /exactly one assertion/);
+  });
+});
+
+test('refuses SHA-1 signatures', async () => {
+  const downgraded = signedResponse.replace('xmldsig-more#rsa-sha256', 'xmldsig#rsa-sha1');
+  await assert.rejects(validate(okta, downgraded), /Unsupported signature algorithm/);
+});
+
+test('refuses document type declarations', async () => {
+  const withDoctype = signedResponse.replace(
+    '<saml2p:Response',
+    '<!DOCTYPE saml2p:Response [<!ENTITY name "admin@hospital.test">]>\n<saml2p:Response',
+  );
+  await assert.rejects(validate(okta, withDoctype), /document type declarations are not allowed/);
+});
@@ -0,0 +1,156 @@
+const { test } = require('node:test');
+const assert = require('node:assert/strict');
+const crypto = require('crypto');
+const { app, models } = require('./support/app');
+
+const authService = app('services/authServiceInstance');
+const serviceAccountService = app('services/serviceAccountService');
+const oidcService = app('services/oidcService');
+const jwt = app('utils/jwt');
+
+const { CLIENT_ASSERTION_TYPE } = serviceAccountService;
+const { OAuthError } = oidcService;
+
+const SUPER_ADMIN = { user_id: 'super-admin-1', role: 'super_admin', permissions: ['all_permissions'] };
+const TOKEN_ENDPOINT = `${oidcService.issuer}/oauth/token`;
+
+const storedAccount = (clientId) => models.ServiceAccount.docs.find((doc) => doc.clientId === clientId);
+
+// Fake models do not apply schema defaults such as ServiceAccount.enabled
+const createAccount = async (data, claims = SUPER_ADMIN) => {
+  const created = await serviceAccountService.createAccount(claims, { name: 'Billing', permissions: ['patient:view'], ...data });
+  storedAccount(created.serviceAccount.clientId).enabled = true;
+  return created;
+};
+
+const basic = (clientId, clientSecret) => `Basic ${Buffer.from(`${clientId}:${clientSecret}`).toString('base64')}`;
+
+const keyPair = () => {
+  const { privateKey, publicKey } = crypto.generateKeyPairSync('ec', { namedCurve: 'P-256' });
+  const kid = crypto.randomBytes(4).toString('hex');
+  return { privateKey, kid, jwks: { keys: [{ ...publicKey.export({ format: 'jwk' }), kid }] } };
+};
+
+const assertion = (clientId, { privateKey, kid }, overrides = {}, expiresIn = 60) => jwt.sign(
+  { iss: clientId, sub: clientId, aud: TOKEN_ENDPOINT, jti: crypto.randomUUID(), ...overrides },
+  { privateKey, alg: 'ES256', kid, expiresIn },
+);
+
+test('a secret account gets tokens at the token endpoint with its own method only', async () => {
+  const { serviceAccount, clientSecret } = await createAccount({ permissions: ['patient:view', 'doctor:list'] });
+  assert.match(serviceAccount.clientId, /^svc_/);
+  assert.ok(!Object.values(storedAccount(serviceAccount.clientId)).includes(clientSecret));
+
+  const response = await oidcService.exchangeToken(basic(serviceAccount.clientId, clientSecret), {
+    grant_type: 'client_credentials',
+    scope: 'patient:view',
+  });
+  assert.equal(response.token_type, 'Bearer');
+  assert.equal(response.scope, 'patient:view');
+  assert.equal(response.refresh_token, undefined);
+  const claims = await authService.validateToken(response.access_token);
+  assert.equal(claims.role, 'service');
+  assert.equal(claims.client_id, serviceAccount.clientId);
+  assert.deepEqual(claims.permissions, ['patient:view']);
+
+  // Registered for Basic auth, so the same secret in the body is refused
+  await assert.rejects(
+    oidcService.exchangeToken(undefined, { grant_type: 'client_credentials', client_id: serviceAccount.clientId, client_secret: clientSecret }),
+    (error) => error instanceof OAuthError && error.error === 'invalid_client',
+  );
+  assert.equal(await serviceAccountService.authenticateWithSecret(serviceAccount.clientId, 'wrong', 'client_secret_basic'), null);
+});
+
+test('a token asks for some of the account permissions, never more', async () => {
+  const { serviceAccount, clientSecret } = await createAccount({ permissions: ['patient:view', 'doctor:list'] });
+  const account = await serviceAccountService.authenticateWithSecret(serviceAccount.clientId, clientSecret, 'client_secret_basic');
+
+  assert.deepEqual((await serviceAccountService.issueToken(account)).permissions, ['patient:view', 'doctor:list']);
+  await assert.rejects(serviceAccountService.issueToken(account, ['patient:view', 'patient:delete']), /Scopes not granted to this service account: patient:delete/);
+  await assert.rejects(
+    oidcService.exchangeToken(basic(serviceAccount.clientId, clientSecret), { grant_type: 'client_credentials', scope: 'doctor:view' }),
+    (error) => error instanceof OAuthError && error.error === 'invalid_scope',
+  );
+});
+
+test('OAuth clients cannot use the client_credentials grant', async () => {
+  await assert.rejects(
+    oidcService.exchangeToken(basic('0123abcd', 'secret'), { grant_type: 'client_credentials' }),
+    (error) => error instanceof OAuthError && error.error === 'unauthorized_client',
+  );
+});
+
+test('a signed client assertion is accepted once, for this server and for a short time', async () => {
+  const key = keyPair();
+  const { serviceAccount, clientSecret } = await createAccount({ tokenEndpointAuthMethod: 'private_key_jwt', jwks: key.jwks });
+  assert.equal(clientSecret, null);
+  const { clientId } = serviceAccount;
+  const authenticate = (signed) => serviceAccountService.authenticateWithAssertion(CLIENT_ASSERTION_TYPE, signed, [TOKEN_ENDPOINT]);
+
+  const signed = assertion(clientId, key);
+  const response = await oidcService.exchangeToken(undefined, {
+    grant_type: 'client_credentials',
+    client_assertion_type: CLIENT_ASSERTION_TYPE,
+    client_assertion: signed,
+  });
+  assert.equal((await authService.validateToken(response.access_token)).client_id, clientId);
+
+  assert.equal(await authenticate(signed), null);
+  assert.equal(await authenticate(assertion(clientId, key, { aud: 'https://elsewhere.test/token' })), null);
+  assert.equal(await authenticate(assertion(clientId, key, {}, 60 * 60)), null);
+  assert.equal(await authenticate(assertion(clientId, key, { sub: 'svc_someone_else' })), null);
+  assert.equal(await authenticate(assertion(clientId, keyPair())), null);
+  assert.equal(await serviceAccountService.authenticateWithAssertion('urn:other', assertion(clientId, key), [TOKEN_ENDPOINT]), null);
+  assert.ok(await authenticate(assertion(clientId, key)));
+});
+
+test('refuses private keys and missing keys in the JWK set', async () => {
+  const { privateKey } = keyPair();
+  await assert.rejects(
+    createAccount({ tokenEndpointAuthMethod: 'private_key_jwt', jwks: { keys: [privateKey.export({ format: 'jwk' })] } }),
+    /only contain public keys/,
+  );
+  await assert.rejects(createAccount({ tokenEndpointAuthMethod: 'private_key_jwt' }), /needs a JWK set/);
+});
+
+test('narrowing or disabling an account ends its tokens', async () => {
+  const { serviceAccount, clientSecret } = await createAccount({ permissions: ['patient:view', 'doctor:list'] });
+  const { clientId } = serviceAccount;
+  const token = async () => {
+    const account = await serviceAccountService.authenticateWithSecret(clientId, clientSecret, 'client_secret_basic');
+    return account && (await serviceAccountService.issueToken(account)).token;
+  };
+
+  // Changes that take nothing away keep tokens
+  const kept = await token();
+  await serviceAccountService.updateAccount(SUPER_ADMIN, clientId, { name: 'Billing v2' });
+  await authService.validateToken(kept);
+
+  await serviceAccountService.updateAccount(SUPER_ADMIN, clientId, { permissions: ['patient:view'] });
+  await assert.rejects(authService.validateToken(kept), /revoked/);
+
+  const later = await token();
+  await serviceAccountService.updateAccount(SUPER_ADMIN, clientId, { enabled: false });
+  await assert.rejects(authService.validateToken(later), /revoked/);
+  assert.equal(await token(), null);
+  assert.equal(await serviceAccountService.isActive(storedAccount(clientId)._id), false);
+});
+
+test('rotating the secret retires the old one', async () => {
+  const { serviceAccount, clientSecret } = await createAccount({});
+  const rotated = await serviceAccountService.rotateSecret(serviceAccount.clientId);
+  assert.notEqual(rotated, clientSecret);
+  assert.equal(await serviceAccountService.authenticateWithSecret(serviceAccount.clientId, clientSecret, 'client_secret_basic'), null);
+  assert.ok(await serviceAccountService.authenticateWithSecret(serviceAccount.clientId, rotated, 'client_secret_basic'));
+
+  const keyed = await createAccount({ tokenEndpointAuthMethod: 'private_key_jwt', jwks: keyPair().jwks });
+  await assert.rejects(serviceAccountService.rotateSecret(keyed.serviceAccount.clientId), /signs in with a key/);
+});
+
+test('grants only service permissions the creator holds', async () => {
+  const admin = { user_id: 'admin-1', role: 'admin', permissions: ['patient:view', 'service_account:create'] };
+  await createAccount({ permissions: ['patient:view'] }, admin);
+  await assert.rejects(createAccount({ permissions: ['doctor:list'] }, admin), /cannot grant permissions you do not hold/);
+  await assert.rejects(createAccount({ permissions: ['admin:create'] }), /not available to service accounts/);
+  await assert.rejects(createAccount({ permissions: [] }), /At least one permission/);
+});
@@ -0,0 +1,88 @@
+const { test } = require('node:test');
+const assert = require('node:assert/strict');
+const { app, models, PASSWORD, registerPatient } = require('./support/app');
+
+const authService = app('services/authServiceInstance');
+const cacheStore = app('utils/cacheStore');
+
+const FIREFOX = 'Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0';
+
+const register = () => registerPatient('session');
+
+// Another sign-in to an existing account, from Firefox unless context says otherwise
+const signIn = async (email, context = {}) => {
+  const tokens = await authService.login(email, PASSWORD, { ip: '198.51.100.20', userAgent: FIREFOX, ...context });
+  return { ...tokens, claims: await authService.validateToken(tokens.token) };
+};
+
+const sessionRecord = (sessionId) => models.Session.docs.find((doc) => doc.sessionId === sessionId);
+
+// Makes the next request look the session up instead of trusting the
+// once-a-minute activity cache
+const forgetActivity = (sessionId) => cacheStore.del(`session_seen:${sessionId}`);
+
+test('lists each sign-in as a session and marks the current one', async () => {
+  const { email, userId } = await register();
+  const laptop = await signIn(email);
+  const phone = await signIn(email, { userAgent: 'Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)' });
+
+  const sessions = await authService.listSessions(userId, phone.claims.sid);
+  assert.deepEqual(sessions.map((session) => session.id).sort(), [laptop.claims.sid, phone.claims.sid].sort());
+  assert.deepEqual(sessions.filter((session) => session.current).map((session) => session.id), [phone.claims.sid]);
+  assert.ok(sessions.every((session) => session.device && session.ip === '198.51.100.20'));
+});
+
+test('ending a session stops its access and refresh tokens only', async () => {
+  const { email, userId } = await register();
+  const ended = await signIn(email);
+  const kept = await signIn(email);
+
+  await authService.revokeSession(userId, ended.claims.sid);
+  await assert.rejects(authService.validateToken(ended.token), /Session has expired|revoked/);
+  await assert.rejects(authService.refreshToken(ended.refreshToken));
+  await authService.validateToken(kept.token);
+  await authService.refreshToken(kept.refreshToken);
+});
+
+test('signing out elsewhere keeps the current session', async () => {
+  const { email, userId } = await register();
+  const others = [await signIn(email), await signIn(email)];
+  const current = await signIn(email);
+
+  await authService.revokeOtherSessions(userId, current.claims.sid);
+  for (const other of others) {
+    await assert.rejects(authService.validateToken(other.token));
+  }
+  assert.deepEqual((await authService.listSessions(userId)).map((session) => session.id), [current.claims.sid]);
+});
+
+test('an idle session expires', async () => {
+  const { email } = await register();
+  const idle = await signIn(email);
+  sessionRecord(idle.claims.sid).lastSeenAt = new Date(Date.now() - 24 * 60 * 60 * 1000);
+  await forgetActivity(idle.claims.sid);
+
+  await assert.rejects(authService.validateToken(idle.token), /Session has expired/);
+  await assert.rejects(authService.refreshToken(idle.refreshToken), /Session has expired/);
+});
+
+test('a token whose session record is gone is refused', async () => {
+  const { email } = await register();
+  const removed = await signIn(email);
+  // As the TTL index does once a session is past its expiry
+  models.Session.docs.splice(models.Session.docs.indexOf(sessionRecord(removed.claims.sid)), 1);
+  await forgetActivity(removed.claims.sid);
+
+  await assert.rejects(authService.validateToken(removed.token), /Session has expired/);
+  await assert.rejects(authService.refreshToken(removed.refreshToken), /Session has expired/);
+});
+
+test('a session can only be seen and ended by its own user', async () => {
+  const owner = await register();
+  const other = await register();
+  const { claims } = await signIn(owner.email);
+
+  await assert.rejects(authService.getSession(other.userId, claims.sid), /Session not found/);
+  await assert.rejects(authService.revokeSession(other.userId, claims.sid), /Session not found/);
+  assert.equal((await authService.getSession(owner.userId, claims.sid)).id, claims.sid);
+});
@@ -0,0 +1,72 @@
+const { test, after } = require('node:test');
+const assert = require('node:assert/strict');
+const net = require('net');
+const { app } = require('./support/app');
+
+const smtp = app('utils/smtp');
+
+// A plain-text SMTP server that answers each command from a script and
+// records what the client sent
+const servers = [];
+const start = async (replies) => {
+  const received = [];
+  const server = net.createServer((socket) => {
+    socket.write('220 mail.hospital.test ESMTP\r\n');
+    let buffer = '';
+    socket.on('data', (chunk) => {
+      buffer += chunk.toString('utf8');
+      let index;
+      while ((index = buffer.indexOf('\r\n')) !== -1) {
+        const line = buffer.slice(0, index);
+        buffer = buffer.slice(index + 2);
+        received.push(line);
+        const verb = line.split(' ')[0].toUpperCase();
+        const reply = replies[verb] || '250 OK';
+        socket.write(`${reply}\r\n`);
+      }
+    });
+    socket.on('error', () => {});
+  });
+  servers.push(server);
+  await new Promise((resolve) => server.listen(0, '127.0.0.1', resolve));
+  return { port: server.address().port, received };
+};
+
+after(() => servers.forEach((server) => server.close()));
+
+const mail = { from: 'no-reply@hospital.test', to: 'pat@hospital.test', message: 'Subject: hi\r\n\r\nhello' };
+
+test('refuses to send credentials when the server does not offer STARTTLS', async () => {
+  const { port, received } = await start({ EHLO: '250-mail.hospital.test\r\n250 AUTH PLAIN LOGIN' });
+  await assert.rejects(
+    smtp.sendMail({ host: '127.0.0.1', port, username: 'mailer', password: 'secret' }, mail),
+    /does not offer STARTTLS/,
+  );
+  assert.ok(received.every((line) => !line.startsWith('AUTH')));
+});
+
+test('refuses plain text delivery when TLS is required', async () => {
+  const { port, received } = await start({ EHLO: '250 mail.hospital.test' });
+  await assert.rejects(smtp.sendMail({ host: '127.0.0.1', port, requireTls: true }, mail), /does not offer STARTTLS/);
+  assert.ok(received.every((line) => !line.startsWith('MAIL FROM')));
+});
+
+test('refuses replies injected before the TLS handshake', async () => {
+  const { port } = await start({
+    EHLO: '250-mail.hospital.test\r\n250 STARTTLS',
+    // The second reply would be read as the answer to the first command after the upgrade
+    STARTTLS: '220 Ready to start TLS\r\n250 injected',
+  });
+  await assert.rejects(
+    smtp.sendMail({ host: '127.0.0.1', port, username: 'mailer', password: 'secret' }, mail),
+    /sent data before the TLS handshake/,
+  );
+});
+
+test('delivers over plain text only when no TLS is asked for', async () => {
+  const { port, received } = await start({ EHLO: '250 mail.hospital.test', DATA: '354 Go ahead', QUIT: '221 Bye' });
+  await smtp.sendMail({ host: '127.0.0.1', port }, { ...mail, message: 'Subject: hi\r\n\r\n.hidden' });
+  assert.deepEqual(received.slice(1, 4), ['MAIL FROM:<no-reply@hospital.test>', 'RCPT TO:<pat@hospital.test>', 'DATA']);
+  // Lines starting with a dot are escaped
+  assert.ok(received.includes('..hidden'));
+});
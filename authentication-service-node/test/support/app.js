@@ -0,0 +1,122 @@
+const fs = require('fs');
+const Module = require('module');
+const os = require('os');
+const path = require('path');
+const fakeModel = require('./fakeModel');
+
+// Loads the service's modules with in-memory models and no Redis, so tests
+// run without MongoDB or Redis. Require this before anything from the app;
+// each test file runs in its own process, so each gets a fresh store.
+
+const ROOT = path.join(__dirname, '..', '..');
+const MODELS = path.join(ROOT, 'models') + path.sep;
+const REDIS_CLIENT = path.join(ROOT, 'redisClient.js');
+
+// A test file may set other variables before requiring this
+const defaults = {
+  MONGO_URI: 'mongodb://unused',
+  JWT_SECRET: 'test-secret',
+  MAIL_TRANSPORT: 'log',
+  MAIL_LOG_FILE: path.join(os.tmpdir(), `auth-service-test-mail-${process.pid}.log`),
+  MFA_REQUIRED_ROLES: 'super_admin',
+};
+for (const [name, value] of Object.entries(defaults)) {
+  if (process.env[name] === undefined) process.env[name] = value;
+}
+
+const models = {};
+
+// Enough of mongoose for the model files to declare their schemas; the
+// unique indexes they declare are handed to the fake models
+class Schema {
+  constructor(definition = {}) {
+    this.uniqueIndexes = Object.entries(definition)
+      .filter(([, options]) => options && options.unique)
+      .map(([field]) => ({ fields: [field] }));
+  }
+
+  index(spec, { unique, partialFilterExpression } = {}) {
+    if (unique) this.uniqueIndexes.push({ fields: Object.keys(spec), partialFilterExpression });
+  }
+
+  pre() {}
+}
+Schema.Types = { ObjectId: String, Mixed: Object };
+const fakeMongoose = {
+  Schema,
+  model: (name, schema) => {
+    if (!models[name]) models[name] = fakeModel(schema.uniqueIndexes);
+    return models[name];
+  },
+};
+
+const load = Module._load;
+Module._load = function loadWithFakes(request, parent, isMain) {
+  if (request === 'mongoose' && parent && parent.filename.startsWith(MODELS)) {
+    return fakeMongoose;
+  }
+  const filename = parent ? Module._resolveFilename(request, parent, isMain) : request;
+  if (filename === REDIS_CLIENT) {
+    // Redis is not configured, so the cache store keeps entries in memory
+    return null;
+  }
+  return load.apply(this, arguments);
+};
+
+const app = (relative) => require(path.join(ROOT, relative));
+
+// Messages the log mailer has written so far, oldest first
+const sentMail = () => {
+  if (!fs.existsSync(process.env.MAIL_LOG_FILE)) return [];
+  return fs.readFileSync(process.env.MAIL_LOG_FILE, 'utf8').split('\n').filter(Boolean).map((line) => JSON.parse(line));
+};
+process.on('exit', () => fs.rmSync(process.env.MAIL_LOG_FILE, { force: true }));
+
+// The token in the link of the newest email sent to the address
+const linkToken = (email) => {
+  const message = sentMail().filter((entry) => entry.to === email).pop();
+  return message && new URL(message.text.match(/https?:\/\/\S+/)[0]).searchParams.get('token');
+};
+
+// Accounts the helpers below create sign in with this password
+const PASSWORD = 'Lantern-orbit-meadow-42';
+
+let accounts = 0;
+// A new address each call within a test file, e.g. reset3@hospital.test
+const uniqueEmail = (prefix) => {
+  accounts += 1;
+  return `${prefix}${accounts}@hospital.test`;
+};
+
+// An approved patient account, registered without sending any email
+const registerPatient = async (prefix = 'patient') => {
+  const email = uniqueEmail(prefix);
+  const { profile } = await app('services/authServiceInstance')
+    .registerAccount('patient', { email, name: `Patient ${accounts}`, isApproved: true }, PASSWORD);
+  return { email, profile, userId: profile._id.toString() };
+};
+
+// Registers a patient and signs them in; the login result and the address
+const signIn = async (context = {}) => {
+  const { email } = await registerPatient();
+  return { email, ...(await app('services/authServiceInstance').login(email, PASSWORD, context)) };
+};
+
+// An admin created by creator (claims) through the admin API, as stored
+const createAdmin = async (creator, permissions, roles, prefix = 'admin') => {
+  const email = uniqueEmail(prefix);
+  await app('services/adminService').createAdmin(creator, email, PASSWORD, permissions, roles);
+  return models.Admin.docs.find((doc) => doc.email === email);
+};
+
+module.exports = {
+  app,
+  models,
+  sentMail,
+  linkToken,
+  PASSWORD,
+  uniqueEmail,
+  registerPatient,
+  signIn,
+  createAdmin,
+};
@@ -0,0 +1,204 @@
+const crypto = require('crypto');
+
+// In-memory stand-in for a mongoose model, covering the queries the services
+// make. Documents are plain objects with a save() method. Unique indexes,
+// partial ones included, are enforced like MongoDB does: a write that would
+// duplicate a key fails with code 11000 and changes nothing.
+
+const valueAt = (doc, path) => path.split('.').reduce((value, key) => {
+  if (value == null) return undefined;
+  return Array.isArray(value) ? value.map((item) => item && item[key]) : value[key];
+}, doc);
+
+const same = (a, b) => String(a) === String(b);
+
+const isOperator = (condition) => condition && typeof condition === 'object'
+  && !(condition instanceof Date) && !Array.isArray(condition)
+  && Object.keys(condition).some((key) => key.startsWith('$'));
+
+const matchesCondition = (value, condition) => {
+  if (isOperator(condition)) {
+    return Object.entries(condition).every(([operator, operand]) => {
+      switch (operator) {
+        case '$in': return operand.some((item) => (Array.isArray(value) ? value.some((v) => same(v, item)) : same(value, item)));
+        case '$ne': return Array.isArray(value) ? !value.some((v) => same(v, operand)) : !same(value, operand);
+        case '$lt': return value < operand;
+        case '$lte': return value <= operand;
+        case '$gt': return value > operand;
+        case '$gte': return value >= operand;
+        case '$exists': return (value !== undefined && value !== null) === operand;
+        case '$size': return Array.isArray(value) && value.length === operand;
+        case '$type': return operand === 'null' ? value === null : typeof value === operand;
+        default: throw new Error(`fakeModel does not support ${operator}`);
+      }
+    });
+  }
+  if (condition === null) return value == null;
+  if (Array.isArray(value) && !Array.isArray(condition)) return value.some((item) => same(item, condition));
+  return same(value, condition);
+};
+
+const matches = (doc, filter = {}) => Object.entries(filter).every(([key, condition]) => {
+  if (key === '$or') return condition.some((sub) => matches(doc, sub));
+  if (key === '$and') return condition.every((sub) => matches(doc, sub));
+  return matchesCondition(valueAt(doc, key), condition);
+});
+
+const applyUpdate = (doc, update) => {
+  const operators = Object.keys(update).some((key) => key.startsWith('$'));
+  Object.assign(doc, operators ? update.$set || {} : update);
+  for (const [key, value] of Object.entries(update.$inc || {})) doc[key] = (doc[key] || 0) + value;
+  for (const [key, value] of Object.entries(update.$max || {})) if (doc[key] == null || value > doc[key]) doc[key] = value;
+  for (const [key, value] of Object.entries(update.$push || {})) doc[key] = [...(doc[key] || []), value];
+  for (const key of Object.keys(update.$unset || {})) delete doc[key];
+  for (const [key, value] of Object.entries(update.$pull || {})) {
+    doc[key] = (doc[key] || []).filter((item) => (value && typeof value === 'object' ? !matches(item, value) : !same(item, value)));
+  }
+};
+
+// Chainable like a mongoose query and awaitable
+const query = (docs, single) => {
+  let results = docs.slice();
+  const chain = {
+    select: () => chain,
+    lean: () => chain,
+    populate: () => chain,
+    sort: (spec) => {
+      const keys = Object.entries(spec);
+      results.sort((a, b) => {
+        for (const [key, direction] of keys) {
+          const x = valueAt(a, key);
+          const y = valueAt(b, key);
+          if (x > y) return direction;
+          if (x < y) return -direction;
+        }
+        return 0;
+      });
+      return chain;
+    },
+    skip: (count) => { results = results.slice(count); return chain; },
+    limit: (count) => { results = results.slice(0, count); return chain; },
+    then: (resolve, reject) => Promise.resolve(single ? results[0] || null : results).then(resolve, reject),
+  };
+  return chain;
+};
+
+// Fake documents skip required fields, so one missing every field of an
+// index is left out of it rather than colliding with the next such document
+const keyOf = (doc, { fields, partialFilterExpression }) => {
+  if (partialFilterExpression && !matches(doc, partialFilterExpression)) return null;
+  const values = fields.map((field) => valueAt(doc, field));
+  if (values.every((value) => value === undefined)) return null;
+  return JSON.stringify(values.map((value) => (value == null ? null : String(value))));
+};
+
+const duplicateKey = (index) => Object.assign(
+  new Error(`E11000 duplicate key error index: ${index.fields.join('_')}`),
+  { code: 11000 },
+);
+
+let clock = 0;
+
+// indexes: the unique indexes, each { fields, partialFilterExpression }
+function fakeModel(indexes = []) {
+  const docs = [];
+
+  // Throws as MongoDB would if doc, stored in place of current, broke an index
+  const assertUnique = (doc, current) => {
+    for (const index of indexes) {
+      const key = keyOf(doc, index);
+      if (key !== null && docs.some((other) => other !== current && keyOf(other, index) === key)) {
+        throw duplicateKey(index);
+      }
+    }
+  };
+
+  // Applies an update to a copy first, so a rejected one changes nothing
+  const update = (doc, changes) => {
+    const updated = { ...doc };
+    applyUpdate(updated, changes);
+    assertUnique(updated, doc);
+    applyUpdate(doc, changes);
+  };
+
+  return class Model {
+    constructor(fields = {}) {
+      Object.assign(this, fields);
+      if (!this._id) this._id = crypto.randomBytes(12).toString('hex');
+      // Strictly increasing so sorting on createdAt is stable
+      if (!this.createdAt) this.createdAt = new Date(Date.now() + (clock += 1));
+    }
+
+    static get docs() { return docs; }
+
+    async save() {
+      assertUnique(this, this);
+      this.updatedAt = new Date();
+      if (!docs.includes(this)) docs.push(this);
+      return this;
+    }
+
+    static async create(fields) { return new Model(fields).save(); }
+
+    static find(filter) { return query(docs.filter((doc) => matches(doc, filter)), false); }
+
+    static findOne(filter) { return query(docs.filter((doc) => matches(doc, filter)), true); }
+
+    static findById(id) { return query(docs.filter((doc) => same(doc._id, id)), true); }
+
+    static async countDocuments(filter) { return docs.filter((doc) => matches(doc, filter)).length; }
+
+    static async findOneAndUpdate(filter, changes, options = {}) {
+      let doc = docs.find((candidate) => matches(candidate, filter));
+      if (!doc && options.upsert) {
+        const inserted = new Model(Object.fromEntries(Object.entries(filter).filter(([, value]) => typeof value !== 'object')));
+        applyUpdate(inserted, changes);
+        assertUnique(inserted, inserted);
+        docs.push(inserted);
+        return options.new ? inserted : null;
+      }
+      if (!doc) return null;
+      const before = { ...doc };
+      update(doc, changes);
+      return options.new ? doc : before;
+    }
+
+    static async findByIdAndUpdate(id, update, options) {
+      return Model.findOneAndUpdate({ _id: id }, update, options);
+    }
+
+    static async updateOne(filter, changes) {
+      const doc = docs.find((candidate) => matches(candidate, filter));
+      if (doc) update(doc, changes);
+      return { modifiedCount: doc ? 1 : 0 };
+    }
+
+    static async updateMany(filter, changes) {
+      const found = docs.filter((doc) => matches(doc, filter));
+      found.forEach((doc) => update(doc, changes));
+      return { modifiedCount: found.length };
+    }
+
+    static async findOneAndDelete(filter) {
+      const index = docs.findIndex((doc) => matches(doc, filter));
+      return index === -1 ? null : docs.splice(index, 1)[0];
+    }
+
+    static async findByIdAndDelete(id) {
+      return Model.findOneAndDelete({ _id: id });
+    }
+
+    static async deleteOne(filter) {
+      const removed = await Model.findOneAndDelete(filter);
+      return { deletedCount: removed ? 1 : 0 };
+    }
+
+    static async deleteMany(filter) {
+      const found = docs.filter((doc) => matches(doc, filter));
+      found.forEach((doc) => docs.splice(docs.indexOf(doc), 1));
+      return { deletedCount: found.length };
+    }
+  };
+}
+
+module.exports = fakeModel;
@@ -0,0 +1,185 @@
+const net = require('net');
+const { app } = require('./app');
+
+const ber = app('utils/ber');
+
+// A small LDAP server for tests, enough to stand in for a directory during
+// sign-in and sync: simple bind, search (equality, presence, substring,
+// and/or/not filters), simple paged results and referrals. Responses can be
+// delivered a byte at a time to exercise message framing.
+//
+// entries: [{ dn, password, attributes: { name: [values] } }]
+// referrals: { dn: url } subtrees held by another server
+// pageLimit: the most entries one search returns, like MaxPageSize in Active
+//   Directory; searches over it end in sizeLimitExceeded unless paged
+// paging: false for a server that ignores the paged results control
+// bindResults: { dn: [resultCode, message] } to fail binds in other ways
+
+const PAGED_RESULTS_OID = '1.2.840.113556.1.4.319';
+
+const lower = (value) => String(value).toLowerCase();
+const isUnder = (dn, base) => lower(dn) === lower(base) || lower(dn).endsWith(`,${lower(base)}`);
+
+const result = (tag, code, message = '', extra = []) => ber.sequence([
+  ber.enumerated(code),
+  ber.octetString(''),
+  ber.octetString(message),
+  ...extra,
+], tag);
+
+function matches(filter, entry) {
+  const parts = () => ber.children(filter.value);
+  const values = (name) => {
+    const key = Object.keys(entry.attributes).find((candidate) => lower(candidate) === lower(name));
+    return key ? entry.attributes[key].map(lower) : [];
+  };
+  switch (filter.tag) {
+    case 0xa0: return parts().every((part) => matches(part, entry));
+    case 0xa1: return parts().some((part) => matches(part, entry));
+    case 0xa2: return !matches(parts()[0], entry);
+    case 0x87: return values(filter.value.toString('utf8')).length > 0;
+    case 0xa3: {
+      const [name, value] = parts();
+      return values(name.value.toString('utf8')).includes(lower(value.value.toString('utf8')));
+    }
+    case 0xa4: {
+      const [name, substrings] = parts();
+      const pattern = ber.children(substrings.value).map((piece) => {
+        const text = lower(piece.value.toString('utf8')).replace(/[.*+?^${}()|[\]\\]/g, '\\$&');
+        if (piece.tag === 0x80) return `^${text}.*`;
+        if (piece.tag === 0x82) return `.*${text}$`;
+        return `.*${text}.*`;
+      }).join('');
+      return values(name.value.toString('utf8')).some((value) => new RegExp(pattern).test(value));
+    }
+    default:
+      throw new Error(`ldapServer does not support filter tag ${filter.tag.toString(16)}`);
+  }
+}
+
+function ldapServer({ entries = [], referrals = {}, pageLimit = Infinity, paging = true, bindResults = {}, chunked = false } = {}) {
+  // Requests as received: { op (tag), message (the LDAPMessage's content), controls }
+  const requests = [];
+
+  const server = net.createServer((socket) => {
+    let buffer = Buffer.alloc(0);
+    let boundDn = null;
+
+    const reply = (id, op, controls) => {
+      const parts = [ber.integer(id), op];
+      if (controls) parts.push(ber.sequence(controls, 0xa0));
+      const message = ber.sequence(parts);
+      if (!chunked) {
+        socket.write(message);
+        return;
+      }
+      for (const byte of message) socket.write(Buffer.from([byte]));
+    };
+
+    const bind = (id, op) => {
+      const [, name, password] = ber.children(op.value);
+      const dn = name.value.toString('utf8');
+      if (bindResults[dn]) {
+        boundDn = null;
+        reply(id, result(0x61, ...bindResults[dn]));
+        return;
+      }
+      const entry = entries.find((candidate) => lower(candidate.dn) === lower(dn));
+      const ok = entry && entry.password && entry.password === password.value.toString('utf8');
+      boundDn = ok ? entry.dn : null;
+      reply(id, ok ? result(0x61, 0) : result(0x61, 49, '80090308: LdapErr: DSID-0C090447, comment: AcceptSecurityContext error, data 52e, v3839'));
+    };
+
+    const search = (id, op, controls) => {
+      const [base, , , sizeLimit, , , filter, attributes] = ber.children(op.value);
+      const baseDn = base.value.toString('utf8');
+      if (!boundDn) {
+        reply(id, result(0x65, 50, 'bind required'));
+        return;
+      }
+      const referral = Object.keys(referrals).find((dn) => isUnder(baseDn, dn));
+      if (referral) {
+        // The base is held elsewhere
+        reply(id, result(0x65, 10, 'referral', [ber.sequence([ber.octetString(referrals[referral])], 0xa3)]));
+        return;
+      }
+      const wanted = ber.children(attributes.value).map((attribute) => lower(attribute.value.toString('utf8')));
+      const found = entries.filter((entry) => isUnder(entry.dn, baseDn) && lower(entry.dn) !== lower(baseDn) && matches(filter, entry));
+
+      const paged = paging && controls[PAGED_RESULTS_OID];
+      let page = found;
+      let code = 0;
+      let nextCookie = null;
+      if (paged) {
+        const [size, cookie] = ber.children(ber.read(paged).value);
+        const offset = cookie.value.length ? parseInt(cookie.value.toString('utf8'), 10) : 0;
+        const pageSize = Math.min(ber.toInteger(size.value), pageLimit);
+        page = found.slice(offset, offset + pageSize);
+        nextCookie = offset + pageSize < found.length ? String(offset + pageSize) : '';
+      } else {
+        const limit = Math.min(ber.toInteger(sizeLimit.value) || Infinity, pageLimit);
+        if (found.length > limit) {
+          page = found.slice(0, limit);
+          code = 4;
+        }
+      }
+
+      for (const entry of page) {
+        const returned = Object.entries(entry.attributes).filter(([name]) => wanted.includes(lower(name)));
+        reply(id, ber.sequence([
+          ber.octetString(entry.dn),
+          ber.sequence(returned.map(([name, values]) => ber.sequence([
+            ber.octetString(name),
+            ber.sequence(values.map((value) => ber.octetString(value)), ber.TAG.SET),
+          ]))),
+        ], 0x64));
+      }
+      // Continuation references for subtrees held elsewhere
+      for (const [dn, url] of Object.entries(referrals)) {
+        if (isUnder(dn, baseDn) && (!paged || nextCookie === '')) {
+          reply(id, ber.sequence([ber.octetString(`${url}/${dn}`)], 0x73));
+        }
+      }
+      const done = result(0x65, code);
+      if (paged) {
+        const value = ber.sequence([ber.integer(0), ber.octetString(nextCookie)]);
+        reply(id, done, [ber.sequence([ber.octetString(PAGED_RESULTS_OID), ber.element(ber.TAG.OCTET_STRING, value)])]);
+      } else {
+        reply(id, done);
+      }
+    };
+
+    socket.on('data', (chunk) => {
+      buffer = Buffer.concat([buffer, chunk]);
+      let message;
+      while ((message = ber.read(buffer))) {
+        buffer = buffer.subarray(message.end);
+        const [idElement, op, controlList] = ber.children(message.value);
+        const id = ber.toInteger(idElement.value);
+        const controls = {};
+        if (controlList) {
+          for (const control of ber.children(controlList.value)) {
+            const [type, ...rest] = ber.children(control.value);
+            const value = rest.find((part) => part.tag === ber.TAG.OCTET_STRING);
+            controls[type.value.toString('utf8')] = value ? value.value : Buffer.alloc(0);
+          }
+        }
+        requests.push({ op: op.tag, message: Buffer.from(message.value), controls });
+        if (op.tag === 0x60) bind(id, op);
+        else if (op.tag === 0x63) search(id, op, controls);
+        else if (op.tag === 0x42) socket.end();
+      }
+    });
+    socket.on('error', () => {});
+  });
+
+  return {
+    requests,
+    listen: () => new Promise((resolve) => {
+      server.listen(0, '127.0.0.1', () => resolve(`ldap://127.0.0.1:${server.address().port}`));
+    }),
+    close: () => new Promise((resolve) => server.close(resolve)),
+  };
+}
+
+module.exports = ldapServer;
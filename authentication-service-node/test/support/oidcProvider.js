@@ -0,0 +1,100 @@
+const crypto = require('crypto');
+const http = require('http');
+const { app } = require('./app');
+
+const jwt = app('utils/jwt');
+
+// A small OpenID Connect provider for tests: discovery, a JWKS and a token
+// endpoint that checks the client, the redirect URI and the PKCE verifier.
+// Tests play the browser: they read the authorization request the service
+// built and call authorize() for the code the provider would redirect with.
+//
+// clientId, clientSecret: the only client the token endpoint accepts
+
+function oidcProvider({ clientId, clientSecret }) {
+  const { privateKey, publicKey } = crypto.generateKeyPairSync('ec', { namedCurve: 'P-256' });
+  const kid = crypto.randomBytes(8).toString('hex');
+  const codes = new Map();
+  const tokenRequests = [];
+  let issuer = null;
+
+  const send = (res, status, body) => {
+    res.writeHead(status, { 'Content-Type': 'application/json' });
+    res.end(JSON.stringify(body));
+  };
+
+  const token = (params, res) => {
+    tokenRequests.push(params);
+    const grant = codes.get(params.get('code'));
+    codes.delete(params.get('code'));
+    if (!grant || params.get('grant_type') !== 'authorization_code') {
+      return send(res, 400, { error: 'invalid_grant' });
+    }
+    if (params.get('client_id') !== clientId || params.get('client_secret') !== clientSecret) {
+      return send(res, 401, { error: 'invalid_client' });
+    }
+    const verifier = params.get('code_verifier') || '';
+    if (params.get('redirect_uri') !== grant.redirectUri
+      || crypto.createHash('sha256').update(verifier).digest('base64url') !== grant.codeChallenge) {
+      return send(res, 400, { error: 'invalid_grant' });
+    }
+    const idToken = jwt.sign(
+      { iss: issuer, aud: clientId, nonce: grant.nonce, ...grant.claims },
+      { privateKey, alg: 'ES256', kid, expiresIn: grant.claims.exp ? undefined : 5 * 60 },
+    );
+    return send(res, 200, { access_token: 'unused', token_type: 'Bearer', id_token: idToken });
+  };
+
+  const server = http.createServer((req, res) => {
+    const url = new URL(req.url, issuer);
+    if (req.method === 'GET' && url.pathname === '/.well-known/openid-configuration') {
+      return send(res, 200, {
+        issuer,
+        authorization_endpoint: `${issuer}/authorize`,
+        token_endpoint: `${issuer}/token`,
+        jwks_uri: `${issuer}/jwks`,
+      });
+    }
+    if (req.method === 'GET' && url.pathname === '/jwks') {
+      return send(res, 200, { keys: [{ ...publicKey.export({ format: 'jwk' }), kid, use: 'sig', alg: 'ES256' }] });
+    }
+    if (req.method === 'POST' && url.pathname === '/token') {
+      let body = '';
+      req.on('data', (chunk) => { body += chunk; });
+      req.on('end', () => token(new URLSearchParams(body), res));
+      return undefined;
+    }
+    return send(res, 404, { error: 'not_found' });
+  });
+
+  return {
+    get issuer() { return issuer; },
+    tokenRequests,
+    // Signs the user in for an authorization URL the service built. claims
+    // go into the ID token and may override iss, aud and nonce.
+    authorize(authorizationUrl, claims) {
+      const request = new URL(authorizationUrl).searchParams;
+      const code = crypto.randomBytes(16).toString('base64url');
+      codes.set(code, {
+        redirectUri: request.get('redirect_uri'),
+        codeChallenge: request.get('code_challenge'),
+        nonce: request.get('nonce'),
+        claims,
+      });
+      return { code, state: request.get('state') };
+    },
+    listen: () => new Promise((resolve) => {
+      server.listen(0, 'localhost', () => {
+        issuer = `http://localhost:${server.address().port}`;
+        resolve(issuer);
+      });
+    }),
+    close: () => new Promise((resolve) => {
+      server.close(resolve);
+      // fetch keeps connections open
+      server.closeAllConnections();
+    }),
+  };
+}
+
+module.exports = oidcProvider;
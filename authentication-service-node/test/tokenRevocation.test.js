@@ -0,0 +1,97 @@
+const { test, afterEach } = require('node:test');
+const assert = require('node:assert/strict');
+const { app, PASSWORD, signIn } = require('./support/app');
+
+const authService = app('services/authServiceInstance');
+const cacheStore = app('utils/cacheStore');
+
+const { CacheStore, CacheUnavailableError } = cacheStore;
+
+afterEach(() => {
+  cacheStore.client = null;
+});
+
+test('a revoked access token is rejected by its jti', async () => {
+  const { token } = await signIn();
+  await authService.validateToken(token);
+
+  await authService.revokeToken(token);
+  await assert.rejects(authService.validateToken(token), /revoked/);
+});
+
+test('revoking a session rejects every access token of its family', async () => {
+  const { token, refreshToken } = await signIn();
+  const rotated = await authService.refreshToken(refreshToken);
+
+  await authService.revokeRefreshToken(rotated.refreshToken);
+  await assert.rejects(authService.validateToken(token), /revoked/);
+  await assert.rejects(authService.validateToken(rotated.token), /revoked/);
+});
+
+test('signing a user out everywhere rejects tokens issued before it', async () => {
+  const first = await signIn();
+  const claims = await authService.validateToken(first.token);
+  const second = await authService.login(claims.email, PASSWORD);
+
+  await authService.revokeAllTokensForUser(claims.user_id);
+  await assert.rejects(authService.validateToken(first.token), /revoked/);
+  await assert.rejects(authService.validateToken(second.token), /revoked/);
+
+  // Signing in again within the same second works
+  const after = await authService.login(claims.email, PASSWORD);
+  assert.equal((await authService.validateToken(after.token)).user_id, claims.user_id);
+});
+
+test('revocation fails closed while a configured Redis is down', async () => {
+  const { token } = await signIn();
+  cacheStore.client = { isReady: false };
+
+  await assert.rejects(authService.validateToken(token), CacheUnavailableError);
+});
+
+test('only an unconfigured cache keeps entries in memory', async () => {
+  const memory = new CacheStore(null);
+  await memory.set('key', 'value', 60);
+  assert.equal(await memory.get('key'), 'value');
+
+  const down = new CacheStore({ isReady: false });
+  await assert.rejects(down.get('key'), CacheUnavailableError);
+  await assert.rejects(down.set('key', 'value', 60), CacheUnavailableError);
+});
+
+test('counters on Redis get their expiry in the same transaction as the first increment', async () => {
+  // Just enough of a Redis client to run one MULTI at a time
+  const entries = new Map();
+  const transactions = [];
+  const client = {
+    isReady: true,
+    multi() {
+      const commands = [];
+      const transaction = {
+        set: (key, value, options) => { commands.push(['set', key, value, options]); return transaction; },
+        incr: (key) => { commands.push(['incr', key]); return transaction; },
+        exec: async () => {
+          transactions.push(commands.map(([name]) => name));
+          return commands.map(([name, key, value, options]) => {
+            if (name === 'set') {
+              if (options.NX && entries.has(key)) return null;
+              entries.set(key, { value: Number(value), ttl: options.EX });
+              return 'OK';
+            }
+            entries.get(key).value += 1;
+            return entries.get(key).value;
+          });
+        },
+      };
+      return transaction;
+    },
+  };
+  const redis = new CacheStore(client);
+
+  assert.equal(await redis.incr('attempts', 60), 1);
+  entries.get('attempts').ttl = 30;
+  assert.equal(await redis.incr('attempts', 60), 2);
+  // The window keeps running from the first increment
+  assert.equal(entries.get('attempts').ttl, 30);
+  assert.deepEqual(transactions, [['set', 'incr'], ['set', 'incr']]);
+});
@@ -0,0 +1,229 @@
+const { test, before } = require('node:test');
+const assert = require('node:assert/strict');
+const crypto = require('crypto');
+const { app, models } = require('./support/app');
+
+const authService = app('services/authServiceInstance');
+const webauthnService = app('services/webauthnService');
+const webauthn = app('utils/webauthn');
+const cbor = app('utils/cbor');
+
+const PASSWORD = 'Lantern-orbit-meadow-42';
+const ORIGIN = 'http://localhost:3000';
+const RP_ID = 'localhost';
+
+const sha256 = (data) => crypto.createHash('sha256').update(data).digest();
+
+// Encoder for the CBOR an authenticator produces: integers, byte and text
+// strings and maps
+const encodeHead = (majorType, length) => {
+  if (length < 24) return Buffer.from([(majorType << 5) | length]);
+  if (length < 0x100) return Buffer.from([(majorType << 5) | 24, length]);
+  const head = Buffer.alloc(3);
+  head[0] = (majorType << 5) | 25;
+  head.writeUInt16BE(length, 1);
+  return head;
+};
+const encode = (value) => {
+  if (typeof value === 'number') return value >= 0 ? encodeHead(0, value) : encodeHead(1, -1 - value);
+  if (Buffer.isBuffer(value)) return Buffer.concat([encodeHead(2, value.length), value]);
+  if (typeof value === 'string') return Buffer.concat([encodeHead(3, Buffer.byteLength(value)), Buffer.from(value)]);
+  const entries = value instanceof Map ? [...value] : Object.entries(value);
+  return Buffer.concat([encodeHead(5, entries.length), ...entries.flatMap(([key, item]) => [encode(key), encode(item)])]);
+};
+
+// A software authenticator holding one ES256 passkey
+const authenticator = (userHandle) => {
+  const { privateKey, publicKey } = crypto.generateKeyPairSync('ec', { namedCurve: 'P-256' });
+  const jwk = publicKey.export({ format: 'jwk' });
+  const credentialId = crypto.randomBytes(32);
+  let counter = 0;
+
+  const clientData = (type, challenge, origin = ORIGIN) => Buffer.from(JSON.stringify({ type, challenge, origin }));
+  const authData = (flags, extra = Buffer.alloc(0)) => {
+    const count = Buffer.alloc(4);
+    count.writeUInt32BE(counter);
+    return Buffer.concat([sha256(RP_ID), Buffer.from([flags]), count, extra]);
+  };
+
+  return {
+    id: credentialId.toString('base64url'),
+    get counter() { return counter; },
+    set counter(value) { counter = value; },
+
+    create(challenge, { origin } = {}) {
+      const coseKey = new Map([[1, 2], [3, -7], [-1, 1], [-2, Buffer.from(jwk.x, 'base64url')], [-3, Buffer.from(jwk.y, 'base64url')]]);
+      const idLength = Buffer.alloc(2);
+      idLength.writeUInt16BE(credentialId.length);
+      const attested = Buffer.concat([Buffer.alloc(16), idLength, credentialId, encode(coseKey)]);
+      return {
+        id: credentialId.toString('base64url'),
+        type: 'public-key',
+        response: {
+          clientDataJSON: clientData('webauthn.create', challenge, origin).toString('base64url'),
+          attestationObject: encode({ fmt: 'none', attStmt: {}, authData: authData(0x45, attested) }).toString('base64url'),
+          transports: ['internal'],
+        },
+      };
+    },
+
+    get(challenge, { userVerified = true } = {}) {
+      counter += 1;
+      const data = authData(userVerified ? 0x05 : 0x01);
+      const json = clientData('webauthn.get', challenge);
+      return {
+        id: credentialId.toString('base64url'),
+        type: 'public-key',
+        response: {
+          clientDataJSON: json.toString('base64url'),
+          authenticatorData: data.toString('base64url'),
+          signature: crypto.sign('sha256', Buffer.concat([data, sha256(json)]), privateKey).toString('base64url'),
+          userHandle: Buffer.from(userHandle).toString('base64url'),
+        },
+      };
+    },
+  };
+};
+
+const register = async (email) => {
+  const { profile } = await authService.registerAccount('patient', { email, name: 'Pat', isApproved: true }, PASSWORD);
+  const claims = { user_id: profile._id.toString(), role: 'patient', email, amr: ['pwd'] };
+  const key = authenticator(`patient:${claims.user_id}`);
+  const options = await webauthnService.registrationOptions(claims);
+  await webauthnService.verifyRegistration(claims, key.create(options.challenge), 'Laptop');
+  return { claims, key };
+};
+
+const storedCounter = (key) => models.WebAuthnCredential.docs.find((doc) => doc.credentialId === key.id).signCount;
+
+let patient;
+before(async () => {
+  patient = await register('passkey@hospital.test');
+});
+
+test('registers a passkey and signs in with it', async () => {
+  const options = await webauthnService.loginOptions('passkey@hospital.test');
+  assert.deepEqual(options.publicKey.allowCredentials.map((entry) => entry.id), [patient.key.id]);
+
+  const tokens = await webauthnService.login(options.ceremonyId, patient.key.get(options.publicKey.challenge));
+  const claims = await authService.validateToken(tokens.token);
+  assert.equal(claims.user_id, patient.claims.user_id);
+  assert.deepEqual(claims.amr, ['hwk', 'user']);
+  assert.equal(storedCounter(patient.key), patient.key.counter);
+});
+
+test('refuses a registration for another origin or challenge', async () => {
+  // Adding a second passkey needs a session that used the first
+  const claims = { ...patient.claims, amr: ['hwk', 'user'] };
+  const key = authenticator(`patient:${claims.user_id}`);
+  let options = await webauthnService.registrationOptions(claims);
+  await assert.rejects(
+    webauthnService.verifyRegistration(claims, key.create(options.challenge, { origin: 'https://evil.test' })),
+    /Unexpected origin/,
+  );
+  options = await webauthnService.registrationOptions(claims);
+  await assert.rejects(
+    webauthnService.verifyRegistration(claims, key.create(crypto.randomBytes(32).toString('base64url'))),
+    /Challenge mismatch/,
+  );
+  await assert.rejects(webauthnService.registrationOptions(patient.claims), /second factor first/);
+});
+
+test('refuses a counter that does not move forward', async () => {
+  const before = storedCounter(patient.key);
+  patient.key.counter = before - 1;
+  const options = await webauthnService.loginOptions('passkey@hospital.test');
+  await assert.rejects(
+    webauthnService.login(options.ceremonyId, patient.key.get(options.publicKey.challenge)),
+    /Signature counter did not increase/,
+  );
+  patient.key.counter = before;
+});
+
+test('requires user verification for a passwordless login', async () => {
+  const options = await webauthnService.loginOptions('passkey@hospital.test');
+  await assert.rejects(
+    webauthnService.login(options.ceremonyId, patient.key.get(options.publicKey.challenge, { userVerified: false })),
+    /User verification required/,
+  );
+});
+
+test('keeps the stored counter when the ceremony was for another purpose', async () => {
+  const before = storedCounter(patient.key);
+  const mfaCeremony = await webauthnService.createAssertionCeremony(
+    { purpose: 'mfa', userId: patient.claims.user_id, role: 'patient' },
+    [],
+    'preferred',
+  );
+  await assert.rejects(
+    webauthnService.login(mfaCeremony.ceremonyId, patient.key.get(mfaCeremony.publicKey.challenge)),
+    /Invalid or expired passkey ceremony/,
+  );
+  assert.equal(storedCounter(patient.key), before);
+});
+
+test('keeps the stored counter when the passkey belongs to someone else', async () => {
+  const before = storedCounter(patient.key);
+  const other = await register('other-passkey@hospital.test');
+  const options = await webauthnService.loginOptions('passkey@hospital.test');
+  const assertion = patient.key.get(options.publicKey.challenge);
+  assertion.response.userHandle = Buffer.from(`patient:${other.claims.user_id}`).toString('base64url');
+  await assert.rejects(webauthnService.login(options.ceremonyId, assertion), /does not belong to this user/);
+  assert.equal(storedCounter(patient.key), before);
+});
+
+test('answers unknown addresses like accounts without passkeys', async () => {
+  await authService.registerAccount('patient', { email: 'nopasskey@hospital.test', name: 'Nia', isApproved: true }, PASSWORD);
+  const unknown = await webauthnService.loginOptions('nobody@hospital.test');
+  const withoutPasskeys = await webauthnService.loginOptions('nopasskey@hospital.test');
+
+  assert.equal(unknown.publicKey.allowCredentials.length, 1);
+  assert.equal(withoutPasskeys.publicKey.allowCredentials.length, 1);
+  assert.deepEqual(Object.keys(unknown.publicKey.allowCredentials[0]), Object.keys(withoutPasskeys.publicKey.allowCredentials[0]));
+  assert.equal(Buffer.from(unknown.publicKey.allowCredentials[0].id, 'base64url').length, 32);
+  // The same address always gets the same made-up credential
+  const again = await webauthnService.loginOptions('Nobody@hospital.test');
+  assert.deepEqual(again.publicKey.allowCredentials, unknown.publicKey.allowCredentials);
+});
+
+test('decodes the CBOR items WebAuthn uses', () => {
+  assert.equal(cbor.decode(Buffer.from('1903e8', 'hex')), 1000);
+  assert.equal(cbor.decode(Buffer.from('3863', 'hex')), -100);
+  assert.deepEqual(cbor.decode(Buffer.from('43010203', 'hex')), Buffer.from([1, 2, 3]));
+  assert.equal(cbor.decode(Buffer.from('6449455446', 'hex')), 'IETF');
+  assert.deepEqual(cbor.decode(Buffer.from('83010203', 'hex')), [1, 2, 3]);
+  assert.deepEqual(cbor.decode(Buffer.from('a201020304', 'hex')), new Map([[1, 2], [3, 4]]));
+  assert.equal(cbor.decode(Buffer.from('f93c00', 'hex')), 1);
+  assert.equal(cbor.decode(Buffer.from('f5', 'hex')), true);
+  assert.deepEqual(cbor.decodeFirst(Buffer.from('0102', 'hex')), { value: 1, length: 1 });
+});
+
+test('rejects truncated, trailing and unsupported CBOR', () => {
+  assert.throws(() => cbor.decode(Buffer.from('4301', 'hex')), /Unexpected end/);
+  assert.throws(() => cbor.decode(Buffer.from('a201', 'hex')), /Unexpected end/);
+  assert.throws(() => cbor.decode(Buffer.from('0102', 'hex')), /Trailing bytes/);
+  assert.throws(() => cbor.decode(Buffer.from('5f', 'hex')), /Unsupported CBOR length/);
+  assert.throws(() => cbor.decode(Buffer.from('c0', 'hex')), /Unsupported CBOR major type/);
+  assert.throws(() => cbor.decode(Buffer.from('1bffffffffffffffff', 'hex')), /too large/);
+});
+
+test('converts COSE keys to JWKs', () => {
+  const { publicKey } = crypto.generateKeyPairSync('ed25519');
+  const x = Buffer.from(publicKey.export({ format: 'jwk' }).x, 'base64url');
+  const converted = webauthn.coseToJwk(new Map([[1, 1], [3, -8], [-1, 6], [-2, x]]));
+  assert.equal(converted.alg, webauthn.COSE_ALGORITHMS.EdDSA);
+  assert.deepEqual(converted.jwk, { kty: 'OKP', crv: 'Ed25519', x: x.toString('base64url') });
+
+  // ES256 on the wrong curve, and ES384, are refused
+  assert.throws(() => webauthn.coseToJwk(new Map([[1, 2], [3, -7], [-1, 2]])), /Unsupported credential public key/);
+  assert.throws(() => webauthn.coseToJwk(new Map([[1, 2], [3, -35], [-1, 2]])), /Unsupported credential public key/);
+});
+
+test('rejects malformed authenticator data', () => {
+  const data = Buffer.concat([sha256(RP_ID), Buffer.from([0x01]), Buffer.alloc(4)]);
+  assert.equal(webauthn.parseAuthenticatorData(data).userPresent, true);
+  assert.throws(() => webauthn.parseAuthenticatorData(data.subarray(0, 36)), /too short/);
+  assert.throws(() => webauthn.parseAuthenticatorData(Buffer.concat([data, Buffer.from([0])])), /Trailing bytes/);
+  const attested = Buffer.concat([sha256(RP_ID), Buffer.from([0x41]), Buffer.alloc(4), Buffer.alloc(10)]);
+  assert.throws(() => webauthn.parseAuthenticatorData(attested), /Attested credential data too short/);
+});
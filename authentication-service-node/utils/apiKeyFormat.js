@@ -0,0 +1,72 @@
+const crypto = require('crypto');
+
+// API keys look like "hck_<30 random base62 chars><6 char checksum>". The
+// prefix makes leaked keys easy to spot in code and logs, and the CRC32
+// checksum lets malformed keys be rejected without a database lookup.
+
+const PREFIX = 'hck_';
+const BODY_LENGTH = 30;
+const CHECKSUM_LENGTH = 6;
+const ALPHABET = '0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz';
+
+const CRC_TABLE = Array.from({ length: 256 }, (_, n) => {
+  let c = n;
+  for (let k = 0; k < 8; k += 1) {
+    c = c & 1 ? 0xedb88320 ^ (c >>> 1) : c >>> 1;
+  }
+  return c >>> 0;
+});
+
+const crc32 = (value) => {
+  let crc = 0xffffffff;
+  for (const byte of Buffer.from(value)) {
+    crc = CRC_TABLE[(crc ^ byte) & 0xff] ^ (crc >>> 8);
+  }
+  return (crc ^ 0xffffffff) >>> 0;
+};
+
+const base62 = (number, length) => {
+  let value = number;
+  let out = '';
+  for (let i = 0; i < length; i += 1) {
+    out = ALPHABET[value % 62] + out;
+    value = Math.floor(value / 62);
+  }
+  return out;
+};
+
+const randomBase62 = (length) => {
+  let out = '';
+  while (out.length < length) {
+    for (const byte of crypto.randomBytes(length)) {
+      // Rejection sampling keeps every character equally likely
+      if (byte < 248 && out.length < length) out += ALPHABET[byte % 62];
+    }
+  }
+  return out;
+};
+
+const generate = () => {
+  const body = randomBase62(BODY_LENGTH);
+  return `${PREFIX}${body}${base62(crc32(body), CHECKSUM_LENGTH)}`;
+};
+
+const isWellFormed = (key) => {
+  if (typeof key !== 'string' || key.length !== PREFIX.length + BODY_LENGTH + CHECKSUM_LENGTH || !key.startsWith(PREFIX)) {
+    return false;
+  }
+  const body = key.slice(PREFIX.length, PREFIX.length + BODY_LENGTH);
+  const checksum = key.slice(PREFIX.length + BODY_LENGTH);
+  return /^[0-9A-Za-z]+$/.test(body) && base62(crc32(body), CHECKSUM_LENGTH) === checksum;
+};
+
+const hash = (key) => crypto.createHash('sha256').update(key).digest('hex');
+
+const displayPrefix = (key) => key.slice(0, PREFIX.length + 6);
+
+module.exports = {
+  generate,
+  isWellFormed,
+  hash,
+  displayPrefix,
+};
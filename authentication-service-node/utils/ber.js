@@ -0,0 +1,114 @@
+// The subset of ASN.1 BER used by LDAP (RFC 4511 section 5.1): definite
+// lengths only, single-byte tags.
+
+const TAG = {
+  BOOLEAN: 0x01,
+  INTEGER: 0x02,
+  OCTET_STRING: 0x04,
+  NULL: 0x05,
+  ENUMERATED: 0x0a,
+  SEQUENCE: 0x30,
+  SET: 0x31,
+};
+
+const encodeLength = (length) => {
+  if (length < 0x80) {
+    return Buffer.from([length]);
+  }
+  const bytes = [];
+  for (let remaining = length; remaining > 0; remaining = Math.floor(remaining / 256)) {
+    bytes.unshift(remaining % 256);
+  }
+  return Buffer.from([0x80 | bytes.length, ...bytes]);
+};
+
+// An element with the given tag around content (a Buffer, or an array of
+// encoded elements)
+const element = (tag, content) => {
+  const value = Array.isArray(content) ? Buffer.concat(content) : content;
+  return Buffer.concat([Buffer.from([tag]), encodeLength(value.length), value]);
+};
+
+const integer = (value, tag = TAG.INTEGER) => {
+  const bytes = [];
+  let remaining = value;
+  do {
+    bytes.unshift(remaining & 0xff);
+    remaining >>= 8;
+  } while (remaining !== 0 && remaining !== -1);
+  // Keep the sign bit right
+  if (value >= 0 && bytes[0] & 0x80) bytes.unshift(0);
+  if (value < 0 && !(bytes[0] & 0x80)) bytes.unshift(0xff);
+  return element(tag, Buffer.from(bytes));
+};
+
+const octetString = (value, tag = TAG.OCTET_STRING) => element(tag, Buffer.from(value, 'utf8'));
+
+const boolean = (value) => element(TAG.BOOLEAN, Buffer.from([value ? 0xff : 0x00]));
+
+const enumerated = (value) => integer(value, TAG.ENUMERATED);
+
+const sequence = (children, tag = TAG.SEQUENCE) => element(tag, children);
+
+// Reads the element at offset. Returns null when buffer does not hold all of
+// it yet.
+function read(buffer, offset = 0) {
+  if (buffer.length < offset + 2) return null;
+  const tag = buffer[offset];
+  let length = buffer[offset + 1];
+  let headerLength = 2;
+  if (length & 0x80) {
+    const count = length & 0x7f;
+    if (count === 0 || count > 4) {
+      throw new Error('Unsupported BER length');
+    }
+    if (buffer.length < offset + 2 + count) return null;
+    length = 0;
+    for (let i = 0; i < count; i += 1) {
+      length = length * 256 + buffer[offset + 2 + i];
+    }
+    headerLength += count;
+  }
+  const start = offset + headerLength;
+  if (buffer.length < start + length) return null;
+  return { tag, value: buffer.subarray(start, start + length), end: start + length };
+}
+
+// The elements inside a constructed element's value
+function children(value) {
+  const result = [];
+  let offset = 0;
+  while (offset < value.length) {
+    const child = read(value, offset);
+    if (!child) {
+      throw new Error('Truncated BER element');
+    }
+    result.push(child);
+    offset = child.end;
+  }
+  return result;
+}
+
+const toInteger = (value) => {
+  if (value.length === 0 || value.length > 6) {
+    throw new Error('Unsupported BER integer');
+  }
+  let result = 0;
+  for (const byte of value) {
+    result = result * 256 + byte;
+  }
+  return value[0] & 0x80 ? result - 256 ** value.length : result;
+};
+
+module.exports = {
+  TAG,
+  element,
+  integer,
+  octetString,
+  boolean,
+  enumerated,
+  sequence,
+  read,
+  children,
+  toInteger,
+};
@@ -0,0 +1,60 @@
+const crypto = require('crypto');
+const fs = require('fs');
+const path = require('path');
+
+// Looks passwords up in a local copy of a breached-password corpus using
+// SHA-1 hash prefixes (the k-anonymity layout of Pwned Passwords). The source
+// is either a single file of "HASH[:COUNT]" lines, indexed by prefix on first
+// use, or a directory of "<PREFIX>.txt" range files holding "SUFFIX[:COUNT]"
+// lines, of which only the one needed is read.
+
+const PREFIX_LENGTH = 5;
+
+const parseLines = (content) => content
+  .split(/\r?\n/)
+  .map((line) => line.trim())
+  .filter((line) => line && !line.startsWith('#'))
+  .map((line) => line.split(':')[0].toUpperCase());
+
+class BreachedPasswordList {
+  constructor(source) {
+    this.source = source;
+    this.index = null;
+  }
+
+  async loadIndex() {
+    const index = new Map();
+    for (const hash of parseLines(await fs.promises.readFile(this.source, 'utf8'))) {
+      const prefix = hash.slice(0, PREFIX_LENGTH);
+      if (!index.has(prefix)) index.set(prefix, new Set());
+      index.get(prefix).add(hash.slice(PREFIX_LENGTH));
+    }
+    return index;
+  }
+
+  async range(prefix) {
+    const stat = await fs.promises.stat(this.source);
+    if (stat.isDirectory()) {
+      try {
+        const content = await fs.promises.readFile(path.join(this.source, `${prefix}.txt`), 'utf8');
+        return new Set(parseLines(content));
+      } catch (error) {
+        if (error.code === 'ENOENT') return new Set();
+        throw error;
+      }
+    }
+    if (!this.index) {
+      this.index = await this.loadIndex();
+    }
+    return this.index.get(prefix) || new Set();
+  }
+
+  async contains(password) {
+    if (!this.source) return false;
+    const hash = crypto.createHash('sha1').update(password).digest('hex').toUpperCase();
+    const suffixes = await this.range(hash.slice(0, PREFIX_LENGTH));
+    return suffixes.has(hash.slice(PREFIX_LENGTH));
+  }
+}
+
+module.exports = BreachedPasswordList;
@@ -0,0 +1,125 @@
+const redisClient = require('../redisClient');
+
+// Raised when Redis is configured but cannot be reached. Callers fail closed:
+// a revocation marker that cannot be read may exist on another replica.
+class CacheUnavailableError extends Error {
+  constructor() {
+    super('The shared cache is unavailable; please try again later');
+    this.name = 'CacheUnavailableError';
+    this.statusCode = 503;
+  }
+}
+
+// Key/value store with per-key expiry shared by all replicas through Redis.
+// Without Redis configured it keeps entries in process memory, which only
+// suits a single instance (entries are then lost on restart). When Redis is
+// configured but down, every operation throws CacheUnavailableError rather
+// than letting replicas silently disagree.
+class CacheStore {
+  constructor(client) {
+    this.client = client;
+    this.memory = new Map();
+    if (!client) {
+      console.warn('Redis is not configured; keeping cache entries in process memory');
+    }
+  }
+
+  useRedis() {
+    if (!this.client) return false;
+    if (!this.client.isReady) {
+      throw new CacheUnavailableError();
+    }
+    return true;
+  }
+
+  readMemory(key) {
+    const entry = this.memory.get(key);
+    if (!entry) return null;
+    if (entry.expiresAt && entry.expiresAt <= Date.now()) {
+      this.memory.delete(key);
+      return null;
+    }
+    return entry.value;
+  }
+
+  async get(key) {
+    if (this.useRedis()) {
+      return this.client.get(key);
+    }
+    return this.readMemory(key);
+  }
+
+  async set(key, value, ttlSeconds) {
+    const ttl = Math.ceil(ttlSeconds || 0);
+    if (this.useRedis()) {
+      if (ttl > 0) {
+        await this.client.set(key, String(value), { EX: ttl });
+      } else {
+        await this.client.set(key, String(value));
+      }
+      return;
+    }
+    this.memory.set(key, {
+      value: String(value),
+      expiresAt: ttl > 0 ? Date.now() + ttl * 1000 : null,
+    });
+  }
+
+  // Reads and deletes a key in one step, for single-use values
+  async take(key) {
+    if (this.useRedis()) {
+      return this.client.getDel(key);
+    }
+    const value = this.readMemory(key);
+    this.memory.delete(key);
+    return value;
+  }
+
+  // Sets a key only if it is not already set; false when it was. Used to
+  // remember values that may be seen only once.
+  async setIfAbsent(key, value, ttlSeconds) {
+    const ttl = Math.max(1, Math.ceil(ttlSeconds || 0));
+    if (this.useRedis()) {
+      return (await this.client.set(key, String(value), { EX: ttl, NX: true })) === 'OK';
+    }
+    if (this.readMemory(key) !== null) {
+      return false;
+    }
+    this.memory.set(key, { value: String(value), expiresAt: Date.now() + ttl * 1000 });
+    return true;
+  }
+
+  // Adds one to a counter and returns the new value; the counter expires
+  // ttlSeconds after it was first incremented. On Redis the expiry is set in
+  // the same transaction as the increment, so a crash between the two cannot
+  // leave a counter that never expires.
+  async incr(key, ttlSeconds) {
+    const ttl = Math.max(1, Math.ceil(ttlSeconds || 0));
+    if (this.useRedis()) {
+      const [, value] = await this.client.multi()
+        .set(key, '0', { EX: ttl, NX: true })
+        .incr(key)
+        .exec();
+      return Number(value);
+    }
+    const current = this.memory.get(key);
+    if (this.readMemory(key) === null) {
+      this.memory.set(key, { value: '1', expiresAt: Date.now() + ttl * 1000 });
+      return 1;
+    }
+    current.value = String(parseInt(current.value, 10) + 1);
+    return parseInt(current.value, 10);
+  }
+
+  async del(key) {
+    if (this.useRedis()) {
+      await this.client.del(key);
+      return;
+    }
+    this.memory.delete(key);
+  }
+}
+
+module.exports = new CacheStore(redisClient);
+module.exports.CacheStore = CacheStore;
+module.exports.CacheUnavailableError = CacheUnavailableError;
@@ -0,0 +1,112 @@
+// Decoder for the subset of CBOR (RFC 8949) used by WebAuthn attestation
+// objects and COSE keys: integers, byte/text strings, arrays, maps, simple
+// values and floats. Indefinite-length items and tags are not needed there.
+function decodeItem(buffer, offset) {
+  if (offset >= buffer.length) {
+    throw new Error('Unexpected end of CBOR data');
+  }
+  const initial = buffer[offset];
+  const majorType = initial >> 5;
+  const additional = initial & 31;
+  let position = offset + 1;
+
+  const readLength = () => {
+    if (additional < 24) return additional;
+    if (additional === 24) {
+      position += 1;
+      return buffer.readUInt8(position - 1);
+    }
+    if (additional === 25) {
+      position += 2;
+      return buffer.readUInt16BE(position - 2);
+    }
+    if (additional === 26) {
+      position += 4;
+      return buffer.readUInt32BE(position - 4);
+    }
+    if (additional === 27) {
+      position += 8;
+      const value = buffer.readBigUInt64BE(position - 8);
+      if (value > BigInt(Number.MAX_SAFE_INTEGER)) {
+        throw new Error('CBOR integer too large');
+      }
+      return Number(value);
+    }
+    throw new Error('Unsupported CBOR length encoding');
+  };
+
+  switch (majorType) {
+    case 0:
+      return { value: readLength(), offset: position };
+    case 1:
+      return { value: -1 - readLength(), offset: position };
+    case 2: {
+      const length = readLength();
+      if (position + length > buffer.length) throw new Error('Unexpected end of CBOR data');
+      return { value: buffer.subarray(position, position + length), offset: position + length };
+    }
+    case 3: {
+      const length = readLength();
+      if (position + length > buffer.length) throw new Error('Unexpected end of CBOR data');
+      return { value: buffer.toString('utf8', position, position + length), offset: position + length };
+    }
+    case 4: {
+      const length = readLength();
+      const items = [];
+      for (let i = 0; i < length; i += 1) {
+        const item = decodeItem(buffer, position);
+        items.push(item.value);
+        position = item.offset;
+      }
+      return { value: items, offset: position };
+    }
+    case 5: {
+      const length = readLength();
+      const map = new Map();
+      for (let i = 0; i < length; i += 1) {
+        const key = decodeItem(buffer, position);
+        const value = decodeItem(buffer, key.offset);
+        map.set(key.value, value.value);
+        position = value.offset;
+      }
+      return { value: map, offset: position };
+    }
+    case 7:
+      if (additional === 20) return { value: false, offset: position };
+      if (additional === 21) return { value: true, offset: position };
+      if (additional === 22) return { value: null, offset: position };
+      if (additional === 23) return { value: undefined, offset: position };
+      if (additional === 25) return { value: readHalfFloat(buffer.readUInt16BE(position)), offset: position + 2 };
+      if (additional === 26) return { value: buffer.readFloatBE(position), offset: position + 4 };
+      if (additional === 27) return { value: buffer.readDoubleBE(position), offset: position + 8 };
+      throw new Error('Unsupported CBOR simple value');
+    default:
+      throw new Error(`Unsupported CBOR major type ${majorType}`);
+  }
+}
+
+function readHalfFloat(bits) {
+  const exponent = (bits >> 10) & 0x1f;
+  const fraction = bits & 0x3ff;
+  const sign = bits & 0x8000 ? -1 : 1;
+  if (exponent === 0) return sign * 2 ** -14 * (fraction / 1024);
+  if (exponent === 31) return fraction ? NaN : sign * Infinity;
+  return sign * 2 ** (exponent - 15) * (1 + fraction / 1024);
+}
+
+// Decodes the first item and reports where it ended, for data that is
+// followed by more bytes (as COSE keys are inside authenticator data)
+function decodeFirst(buffer) {
+  const { value, offset } = decodeItem(buffer, 0);
+  return { value, length: offset };
+}
+
+function decode(buffer) {
+  const { value, offset } = decodeItem(buffer, 0);
+  if (offset !== buffer.length) {
+    throw new Error('Trailing bytes after CBOR data');
+  }
+  return value;
+}
+
+module.exports = { decode, decodeFirst };
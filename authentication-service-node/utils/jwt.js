@@ -0,0 +1,103 @@
+const crypto = require('crypto');
+
+// Minimal JWS (compact serialization) support for the asymmetric algorithms
+// the key ring can issue. jsonwebtoken has no EdDSA support, so signing and
+// verification go straight through node's crypto module.
+const ALGORITHMS = {
+  RS256: {
+    keyType: 'rsa',
+    generateOptions: { modulusLength: 2048 },
+    sign: (data, key) => crypto.sign('sha256', data, key),
+    verify: (data, key, signature) => crypto.verify('sha256', data, key, signature),
+  },
+  ES256: {
+    keyType: 'ec',
+    generateOptions: { namedCurve: 'P-256' },
+    sign: (data, key) => crypto.sign('sha256', data, { key, dsaEncoding: 'ieee-p1363' }),
+    verify: (data, key, signature) => crypto.verify('sha256', data, { key, dsaEncoding: 'ieee-p1363' }, signature),
+  },
+  EdDSA: {
+    keyType: 'ed25519',
+    generateOptions: {},
+    sign: (data, key) => crypto.sign(null, data, key),
+    verify: (data, key, signature) => crypto.verify(null, data, key, signature),
+  },
+};
+
+const encodeSegment = (value) => Buffer.from(JSON.stringify(value)).toString('base64url');
+
+const decodeSegment = (segment) => JSON.parse(Buffer.from(segment, 'base64url').toString('utf8'));
+
+const isSupportedAlgorithm = (alg) => Object.prototype.hasOwnProperty.call(ALGORITHMS, alg);
+
+// typ values are media types, compared without case or "application/"
+const mediaType = (typ) => String(typ || '').toLowerCase().replace(/^application\//, '');
+
+function sign(payload, { privateKey, alg, kid, expiresIn, jwtid, typ = 'JWT' }) {
+  if (!isSupportedAlgorithm(alg)) {
+    throw new Error(`Unsupported signing algorithm: ${alg}`);
+  }
+  const now = Math.floor(Date.now() / 1000);
+  const claims = { ...payload, iat: now };
+  if (expiresIn) claims.exp = now + expiresIn;
+  if (jwtid) claims.jti = jwtid;
+
+  const header = { alg, typ, kid };
+  const signingInput = `${encodeSegment(header)}.${encodeSegment(claims)}`;
+  const signature = ALGORITHMS[alg].sign(Buffer.from(signingInput), privateKey);
+  return `${signingInput}.${signature.toString('base64url')}`;
+}
+
+function decode(token, { complete = false } = {}) {
+  try {
+    const [header, payload] = token.split('.');
+    const decoded = { header: decodeSegment(header), payload: decodeSegment(payload) };
+    return complete ? decoded : decoded.payload;
+  } catch (error) {
+    return null;
+  }
+}
+
+// resolveKey(header) returns { publicKey, alg } for the header's kid, or null.
+// typ, when given, is the only token type accepted.
+async function verify(token, resolveKey, { ignoreExpiration = false, typ = null } = {}) {
+  const parts = typeof token === 'string' ? token.split('.') : [];
+  if (parts.length !== 3) {
+    throw new Error('jwt malformed');
+  }
+  const decoded = decode(token, { complete: true });
+  if (!decoded) {
+    throw new Error('jwt malformed');
+  }
+  const { header, payload } = decoded;
+  if (!isSupportedAlgorithm(header.alg)) {
+    throw new Error('invalid algorithm');
+  }
+
+  const key = await resolveKey(header);
+  if (!key) {
+    throw new Error('unknown signing key');
+  }
+  // The key decides the algorithm, never the token
+  if (key.alg !== header.alg) {
+    throw new Error('invalid algorithm');
+  }
+  const signingInput = Buffer.from(`${parts[0]}.${parts[1]}`);
+  if (!ALGORITHMS[header.alg].verify(signingInput, key.publicKey, Buffer.from(parts[2], 'base64url'))) {
+    throw new Error('invalid signature');
+  }
+  if (typ && mediaType(header.typ) !== mediaType(typ)) {
+    throw new Error('invalid token type');
+  }
+
+  const now = Math.floor(Date.now() / 1000);
+  if (!ignoreExpiration && payload.exp !== undefined && payload.exp <= now) {
+    throw new Error('jwt expired');
+  }
+  if (payload.nbf !== undefined && payload.nbf > now) {
+    throw new Error('jwt not active');
+  }
+  return payload;
+}
+
+module.exports = { ALGORITHMS, isSupportedAlgorithm, sign, decode, verify };
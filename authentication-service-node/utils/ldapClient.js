@@ -0,0 +1,369 @@
+const net = require('net');
+const tls = require('tls');
+const ber = require('./ber');
+
+// Minimal LDAPv3 client (RFC 4511): simple bind, search and StartTLS over
+// ldap:// or ldaps://. Operations run one at a time on a connection, which
+// is all directory sign-in and sync need. Referrals are not followed:
+// continuation references in search results are skipped, and a referral
+// result fails the operation like any other error.
+
+const DEFAULT_TIMEOUT_MS = 10000;
+// Below the server-side limits of Active Directory (1000) and OpenLDAP (500)
+const DEFAULT_PAGE_SIZE = 500;
+const START_TLS_OID = '1.3.6.1.4.1.1466.20037';
+// Simple paged results (RFC 2696)
+const PAGED_RESULTS_OID = '1.2.840.113556.1.4.319';
+
+const OP = {
+  BIND_REQUEST: 0x60,
+  BIND_RESPONSE: 0x61,
+  UNBIND_REQUEST: 0x42,
+  SEARCH_REQUEST: 0x63,
+  SEARCH_ENTRY: 0x64,
+  SEARCH_DONE: 0x65,
+  SEARCH_REFERENCE: 0x73,
+  EXTENDED_REQUEST: 0x77,
+  EXTENDED_RESPONSE: 0x78,
+};
+
+// Context tag of the controls after the operation in a message
+const CONTROLS_TAG = 0xa0;
+
+const RESULT = {
+  SUCCESS: 0,
+  SIZE_LIMIT_EXCEEDED: 4,
+  REFERRAL: 10,
+  INVALID_CREDENTIALS: 49,
+};
+
+const SCOPE = { base: 0, one: 1, sub: 2 };
+
+class LdapError extends Error {
+  constructor(resultCode, message) {
+    super(`LDAP error ${resultCode}${message ? `: ${message}` : ''}`);
+    this.resultCode = resultCode;
+  }
+}
+
+// RFC 4515 escaping for values placed in a filter string
+const escapeFilterValue = (value) => String(value)
+  .replace(/\\/g, '\\5c')
+  .replace(/\*/g, '\\2a')
+  .replace(/\(/g, '\\28')
+  .replace(/\)/g, '\\29')
+  .replace(/\0/g, '\\00');
+
+const unescapeFilterValue = (value) => Buffer.concat(value.split(/(\\[0-9a-fA-F]{2})/).map((part) => (
+  /^\\[0-9a-fA-F]{2}$/.test(part) ? Buffer.from([parseInt(part.slice(1), 16)]) : Buffer.from(part, 'utf8')
+)));
+
+// Encodes an RFC 4515 filter string: &, |, !, =, >=, <=, ~=, presence and
+// substrings
+function encodeFilter(filter) {
+  let position = 0;
+
+  const parseFilter = () => {
+    if (filter[position] !== '(') {
+      throw new Error(`Invalid LDAP filter: ${filter}`);
+    }
+    position += 1;
+    let encoded;
+    const operator = filter[position];
+    if (operator === '&' || operator === '|') {
+      position += 1;
+      const parts = [];
+      while (filter[position] === '(') parts.push(parseFilter());
+      encoded = ber.sequence(parts, operator === '&' ? 0xa0 : 0xa1);
+    } else if (operator === '!') {
+      position += 1;
+      encoded = ber.sequence([parseFilter()], 0xa2);
+    } else {
+      const end = filter.indexOf(')', position);
+      if (end === -1) {
+        throw new Error(`Invalid LDAP filter: ${filter}`);
+      }
+      encoded = encodeItem(filter.slice(position, end));
+      position = end;
+    }
+    if (filter[position] !== ')') {
+      throw new Error(`Invalid LDAP filter: ${filter}`);
+    }
+    position += 1;
+    return encoded;
+  };
+
+  const encodeItem = (item) => {
+    const match = /^([\w.;-]+)(~=|>=|<=|=)(.*)$/.exec(item);
+    if (!match) {
+      throw new Error(`Invalid LDAP filter item: ${item}`);
+    }
+    const [, attribute, operator, value] = match;
+    const pair = (tag) => ber.sequence([ber.octetString(attribute), ber.element(ber.TAG.OCTET_STRING, unescapeFilterValue(value))], tag);
+    if (operator === '>=') return pair(0xa5);
+    if (operator === '<=') return pair(0xa6);
+    if (operator === '~=') return pair(0xa8);
+    if (value === '*') return ber.octetString(attribute, 0x87);
+    if (!value.includes('*')) return pair(0xa3);
+
+    const pieces = value.split('*');
+    const substrings = [];
+    pieces.forEach((piece, index) => {
+      if (!piece) return;
+      const tag = index === 0 ? 0x80 : index === pieces.length - 1 ? 0x82 : 0x81;
+      substrings.push(ber.element(tag, unescapeFilterValue(piece)));
+    });
+    return ber.sequence([ber.octetString(attribute), ber.sequence(substrings)], 0xa4);
+  };
+
+  const encoded = parseFilter();
+  if (position !== filter.length) {
+    throw new Error(`Invalid LDAP filter: ${filter}`);
+  }
+  return encoded;
+}
+
+// resultCode, matchedDN and diagnosticMessage at the start of a response
+const readResult = (value) => {
+  const [code, , message] = ber.children(value);
+  return { resultCode: ber.toInteger(code.value), message: message ? message.value.toString('utf8') : '' };
+};
+
+// Controls by OID, each the control's value or an empty Buffer
+const readControls = (value) => {
+  const controls = {};
+  for (const control of ber.children(value)) {
+    const [type, ...rest] = ber.children(control.value);
+    const controlValue = rest.find((part) => part.tag === ber.TAG.OCTET_STRING);
+    controls[type.value.toString('utf8')] = controlValue ? controlValue.value : Buffer.alloc(0);
+  }
+  return controls;
+};
+
+const pagedResultsControl = (pageSize, cookie) => ber.sequence([
+  ber.octetString(PAGED_RESULTS_OID),
+  ber.element(ber.TAG.OCTET_STRING, ber.sequence([ber.integer(pageSize), ber.element(ber.TAG.OCTET_STRING, cookie)])),
+]);
+
+const readEntry = (value) => {
+  const [name, attributes] = ber.children(value);
+  const entry = { dn: name.value.toString('utf8'), attributes: {} };
+  for (const attribute of ber.children(attributes.value)) {
+    const [type, values] = ber.children(attribute.value);
+    entry.attributes[type.value.toString('utf8').toLowerCase()] = ber.children(values.value).map((item) => item.value);
+  }
+  return entry;
+};
+
+class LdapClient {
+  constructor(timeoutMs = DEFAULT_TIMEOUT_MS) {
+    this.timeoutMs = timeoutMs;
+    this.buffer = Buffer.alloc(0);
+    this.messages = [];
+    this.waiting = null;
+    this.error = null;
+    this.nextMessageId = 1;
+  }
+
+  attach(socket) {
+    this.socket = socket;
+    socket.setTimeout(this.timeoutMs, () => this.fail(new Error('LDAP connection timed out')));
+    socket.on('data', (chunk) => this.onData(chunk));
+    socket.on('error', (error) => this.fail(error));
+    socket.on('close', () => this.fail(new Error('LDAP connection closed')));
+  }
+
+  detach() {
+    this.socket.removeAllListeners('data');
+    this.socket.removeAllListeners('error');
+    this.socket.removeAllListeners('close');
+    this.socket.setTimeout(0);
+    this.socket.on('error', () => {});
+  }
+
+  onData(chunk) {
+    this.buffer = Buffer.concat([this.buffer, chunk]);
+    try {
+      let message;
+      while ((message = ber.read(this.buffer))) {
+        this.buffer = this.buffer.subarray(message.end);
+        const [id, op, controls] = ber.children(message.value);
+        this.messages.push({
+          id: ber.toInteger(id.value),
+          tag: op.tag,
+          value: op.value,
+          controls: controls && controls.tag === CONTROLS_TAG ? readControls(controls.value) : {},
+        });
+      }
+    } catch (error) {
+      this.fail(error);
+      return;
+    }
+    this.flush();
+  }
+
+  flush() {
+    if (this.waiting && this.messages.length > 0) {
+      const { resolve } = this.waiting;
+      this.waiting = null;
+      resolve(this.messages.shift());
+    }
+  }
+
+  fail(error) {
+    this.error = this.error || error;
+    if (this.waiting) {
+      const { reject } = this.waiting;
+      this.waiting = null;
+      reject(this.error);
+    }
+  }
+
+  receive() {
+    if (this.messages.length === 0 && this.error) return Promise.reject(this.error);
+    return new Promise((resolve, reject) => {
+      this.waiting = { resolve, reject };
+      this.flush();
+    });
+  }
+
+  send(op, controls = []) {
+    if (this.error) throw this.error;
+    const id = this.nextMessageId;
+    this.nextMessageId += 1;
+    const parts = [ber.integer(id), op];
+    if (controls.length > 0) parts.push(ber.sequence(controls, CONTROLS_TAG));
+    this.socket.write(ber.sequence(parts));
+    return id;
+  }
+
+  // Reads responses to request id until one with a tag in finalTags;
+  // onMessage sees the intermediate ones
+  async response(id, finalTags, onMessage = () => {}) {
+    for (;;) {
+      const message = await this.receive();
+      if (message.id === 0) {
+        // Notice of disconnection
+        throw new LdapError(readResult(message.value).resultCode, 'server closed the connection');
+      }
+      if (message.id !== id) continue;
+      if (finalTags.includes(message.tag)) return message;
+      onMessage(message);
+    }
+  }
+
+  // Throws LdapError unless the bind succeeds. An empty password would be an
+  // unauthenticated bind, which servers accept without checking anything.
+  async bind(dn, password) {
+    if (!password) {
+      throw new LdapError(RESULT.INVALID_CREDENTIALS, 'empty password');
+    }
+    const id = this.send(ber.sequence([
+      ber.integer(3),
+      ber.octetString(dn),
+      ber.octetString(password, 0x80),
+    ], OP.BIND_REQUEST));
+    const { resultCode, message } = readResult((await this.response(id, [OP.BIND_RESPONSE])).value);
+    if (resultCode !== RESULT.SUCCESS) {
+      throw new LdapError(resultCode, message);
+    }
+  }
+
+  // Entries as { dn, attributes: { lowercased name: [Buffer] } }. With a
+  // sizeLimit, at most that many entries come back and the rest are dropped;
+  // without one, results are fetched in pages of pageSize and a server limit
+  // that would truncate them is an error.
+  async search(baseDn, { scope = 'sub', filter = '(objectClass=*)', attributes = [], sizeLimit = 0, pageSize = DEFAULT_PAGE_SIZE } = {}) {
+    const request = ber.sequence([
+      ber.octetString(baseDn),
+      ber.enumerated(SCOPE[scope]),
+      ber.enumerated(0),
+      ber.integer(sizeLimit),
+      ber.integer(Math.ceil(this.timeoutMs / 1000)),
+      ber.boolean(false),
+      encodeFilter(filter),
+      ber.sequence(attributes.map((attribute) => ber.octetString(attribute))),
+    ], OP.SEARCH_REQUEST);
+    const entries = [];
+    let cookie = Buffer.alloc(0);
+    do {
+      // Not critical: a server without paging sends everything at once
+      const id = this.send(request, sizeLimit ? [] : [pagedResultsControl(pageSize, cookie)]);
+      const done = await this.response(id, [OP.SEARCH_DONE], (message) => {
+        if (message.tag === OP.SEARCH_ENTRY) entries.push(readEntry(message.value));
+      });
+      const { resultCode, message } = readResult(done.value);
+      if (resultCode === RESULT.SIZE_LIMIT_EXCEEDED && sizeLimit) {
+        break;
+      }
+      if (resultCode !== RESULT.SUCCESS) {
+        throw new LdapError(resultCode, message);
+      }
+      const paged = done.controls[PAGED_RESULTS_OID];
+      cookie = paged ? ber.children(ber.read(paged).value)[1].value : Buffer.alloc(0);
+    } while (cookie.length > 0);
+    return entries;
+  }
+
+  async startTls(options) {
+    const id = this.send(ber.sequence([ber.octetString(START_TLS_OID, 0x80)], OP.EXTENDED_REQUEST));
+    const { resultCode, message } = readResult((await this.response(id, [OP.EXTENDED_RESPONSE])).value);
+    if (resultCode !== RESULT.SUCCESS) {
+      throw new LdapError(resultCode, message);
+    }
+    this.detach();
+    this.attach(await secure({ ...options, socket: this.socket }));
+  }
+
+  close() {
+    if (!this.socket) return;
+    if (!this.error) {
+      this.socket.write(ber.sequence([ber.integer(this.nextMessageId), ber.element(OP.UNBIND_REQUEST, Buffer.alloc(0))]));
+    }
+    this.detach();
+    this.socket.end();
+  }
+}
+
+const open = (socket, readyEvent, timeoutMs) => new Promise((resolve, reject) => {
+  socket.setTimeout(timeoutMs, () => socket.destroy(new Error('LDAP connection timed out')));
+  socket.once(readyEvent, () => {
+    socket.removeListener('error', reject);
+    socket.setTimeout(0);
+    resolve(socket);
+  });
+  socket.once('error', reject);
+});
+
+const secure = ({ timeoutMs, ...options }) => open(tls.connect(options), 'secureConnect', timeoutMs);
+
+const plain = ({ timeoutMs, ...options }) => open(net.connect(options), 'connect', timeoutMs);
+
+// Opens a connection to an ldap:// or ldaps:// URL. ca adds trusted
+// certificates (PEM) for directories with a private CA.
+async function connect(url, { startTls = false, ca, timeoutMs = DEFAULT_TIMEOUT_MS } = {}) {
+  const parsed = new URL(url);
+  const implicitTls = parsed.protocol === 'ldaps:';
+  const host = parsed.hostname;
+  const port = parseInt(parsed.port, 10) || (implicitTls ? 636 : 389);
+  const tlsOptions = { host, port, servername: net.isIP(host) ? undefined : host, ca: ca || undefined, timeoutMs };
+  const client = new LdapClient(timeoutMs);
+  client.attach(implicitTls ? await secure(tlsOptions) : await plain({ host, port, timeoutMs }));
+  if (!implicitTls && startTls) {
+    try {
+      await client.startTls(tlsOptions);
+    } catch (error) {
+      client.close();
+      throw error;
+    }
+  }
+  return client;
+}
+
+module.exports = {
+  connect,
+  encodeFilter,
+  escapeFilterValue,
+  LdapError,
+  RESULT,
+};
@@ -0,0 +1,24 @@
+const jwt = require('./jwt');
+const permissionRules = require('./permissionRules');
+
+// Body returned by every endpoint that completes a login
+const loginResponse = ({ token, refreshToken }) => {
+  // Decode token to extract user info
+  const decoded = jwt.decode(token);
+  const user = {
+    id: decoded.user_id,
+    email: decoded.email,
+    role: decoded.role,
+    // Spelled out; the token itself carries the compressed form
+    permissions: permissionRules.expand(decoded.permissions),
+    patientId: decoded.patientId || null, // Include patientId if available
+    emailVerified: decoded.email_verified !== false,
+  };
+  // Clients show a banner while support staff are acting as the user
+  if (decoded.act) {
+    user.impersonatedBy = { id: decoded.act.sub, email: decoded.act.email, role: decoded.act.role };
+  }
+  return { token, refreshToken, user };
+};
+
+module.exports = loginResponse;
@@ -0,0 +1,82 @@
+const crypto = require('crypto');
+const fs = require('fs');
+const smtp = require('./smtp');
+const env = require('../config/env');
+
+// Encodes a header value as an RFC 2047 encoded word when it is not plain ASCII
+const encodeHeader = (value) => (/^[\x20-\x7e]*$/.test(value)
+  ? value
+  : `=?UTF-8?B?${Buffer.from(value).toString('base64')}?=`);
+
+function buildMessage({ from, to, subject, text }) {
+  const domain = from.split('@')[1] || 'localhost';
+  const body = Buffer.from(text).toString('base64').replace(/.{76}/g, '$&\r\n');
+  return [
+    `From: ${from}`,
+    `To: ${[].concat(to).join(', ')}`,
+    `Subject: ${encodeHeader(subject)}`,
+    `Date: ${new Date().toUTCString()}`,
+    `Message-ID: <${crypto.randomUUID()}@${domain}>`,
+    'MIME-Version: 1.0',
+    'Content-Type: text/plain; charset=utf-8',
+    'Content-Transfer-Encoding: base64',
+    '',
+    body,
+  ].join('\r\n');
+}
+
+// Delivers through the SMTP server configured by EMAIL_HOST/EMAIL_PORT
+class SmtpMailer {
+  constructor({ host, port, username, password, from }) {
+    this.options = {
+      host,
+      port,
+      username,
+      password,
+      // Port 465 expects TLS from the start; others upgrade with STARTTLS
+      secure: port === 465,
+    };
+    this.from = from;
+  }
+
+  async send({ to, subject, text }) {
+    const message = buildMessage({ from: this.from, to, subject, text });
+    await smtp.sendMail(this.options, { from: this.from, to, message });
+  }
+}
+
+// Local development: appends each message to a file. Messages carry reset
+// and verification links, so they are never written to the console.
+class LogMailer {
+  constructor({ from, file }) {
+    if (!file) {
+      throw new Error('The log mail transport needs MAIL_LOG_FILE');
+    }
+    this.from = from;
+    this.file = file;
+  }
+
+  async send({ to, subject, text }) {
+    const entry = { from: this.from, to, subject, text, sentAt: new Date().toISOString() };
+    await fs.promises.appendFile(this.file, `${JSON.stringify(entry)}\n`, { mode: 0o600 });
+  }
+}
+
+const createMailer = (config) => {
+  switch (config.MAIL_TRANSPORT) {
+    case 'smtp':
+      return new SmtpMailer({
+        host: config.EMAIL_HOST,
+        port: config.EMAIL_PORT,
+        username: config.EMAIL_USERNAME,
+        password: config.EMAIL_PASSWORD,
+        from: config.MAIL_FROM,
+      });
+    case 'log':
+      return new LogMailer({ from: config.MAIL_FROM, file: config.MAIL_LOG_FILE });
+    default:
+      throw new Error(`Unknown MAIL_TRANSPORT "${config.MAIL_TRANSPORT}"`);
+  }
+};
+
+module.exports = createMailer(env);
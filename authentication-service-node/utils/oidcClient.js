@@ -0,0 +1,169 @@
+const crypto = require('crypto');
+const jwt = require('./jwt');
+
+// Relying-party side of OpenID Connect, for signing users in through an
+// external identity provider: discovery, JWKS, the code exchange and ID token
+// validation (OpenID Connect Core 1.0, section 3.1.3.7).
+
+const METADATA_TTL_MS = 60 * 60 * 1000;
+// An unknown kid triggers a JWKS refetch at most this often
+const JWKS_REFETCH_INTERVAL_MS = 60 * 1000;
+const CLOCK_SKEW_SECONDS = 60;
+const HTTP_TIMEOUT_MS = 10 * 1000;
+
+const metadataCache = new Map();
+const jwksCache = new Map();
+
+async function fetchJson(url, options = {}) {
+  const response = await fetch(url, {
+    ...options,
+    headers: { Accept: 'application/json', ...options.headers },
+    signal: AbortSignal.timeout(HTTP_TIMEOUT_MS),
+  });
+  const body = await response.json().catch(() => null);
+  if (!response.ok) {
+    const detail = body && (body.error_description || body.error);
+    throw new Error(`${url} responded with ${response.status}${detail ? `: ${detail}` : ''}`);
+  }
+  if (!body) {
+    throw new Error(`${url} did not return JSON`);
+  }
+  return body;
+}
+
+async function discover(issuer) {
+  const cached = metadataCache.get(issuer);
+  if (cached && cached.expiresAt > Date.now()) {
+    return cached.metadata;
+  }
+  const metadata = await fetchJson(`${issuer.replace(/\/$/, '')}/.well-known/openid-configuration`);
+  for (const field of ['issuer', 'authorization_endpoint', 'token_endpoint', 'jwks_uri']) {
+    if (!metadata[field]) {
+      throw new Error(`Discovery document of ${issuer} has no ${field}`);
+    }
+  }
+  metadataCache.set(issuer, { metadata, expiresAt: Date.now() + METADATA_TTL_MS });
+  return metadata;
+}
+
+// Providers often leave "alg" out of their JWKS
+const algorithmFor = (jwk) => {
+  if (jwk.alg) return jwk.alg;
+  if (jwk.kty === 'RSA') return 'RS256';
+  if (jwk.kty === 'EC' && jwk.crv === 'P-256') return 'ES256';
+  if (jwk.kty === 'OKP' && jwk.crv === 'Ed25519') return 'EdDSA';
+  return null;
+};
+
+async function loadJwks(jwksUri, refetch = false) {
+  const cached = jwksCache.get(jwksUri);
+  if (cached) {
+    const age = Date.now() - cached.fetchedAt;
+    if (age < (refetch ? JWKS_REFETCH_INTERVAL_MS : METADATA_TTL_MS)) {
+      return cached.keys;
+    }
+  }
+  const { keys } = await fetchJson(jwksUri);
+  if (!Array.isArray(keys)) {
+    throw new Error(`${jwksUri} is not a JWK set`);
+  }
+  jwksCache.set(jwksUri, { keys, fetchedAt: Date.now() });
+  return keys;
+}
+
+const findKey = (keys, header) => keys.find((jwk) => (!jwk.use || jwk.use === 'sig')
+  && (header.kid ? jwk.kid === header.kid : algorithmFor(jwk) === header.alg));
+
+// Key resolver for jwt.verify; a kid we have not seen means the provider rotated
+async function resolveKey(jwksUri, header) {
+  let jwk = findKey(await loadJwks(jwksUri), header);
+  if (!jwk) {
+    jwk = findKey(await loadJwks(jwksUri, true), header);
+  }
+  if (!jwk) {
+    return null;
+  }
+  return { publicKey: crypto.createPublicKey({ key: jwk, format: 'jwk' }), alg: algorithmFor(jwk) };
+}
+
+const createPkce = () => {
+  const verifier = crypto.randomBytes(32).toString('base64url');
+  const challenge = crypto.createHash('sha256').update(verifier).digest('base64url');
+  return { verifier, challenge };
+};
+
+// client_secret_basic form-encodes the credentials before base64 (RFC 6749, 2.3.1)
+const basicCredentials = (clientId, clientSecret) => Buffer
+  .from(`${encodeURIComponent(clientId)}:${encodeURIComponent(clientSecret)}`)
+  .toString('base64');
+
+async function exchangeCode(tokenEndpoint, {
+  code, redirectUri, codeVerifier, clientId, clientSecret, authMethod,
+}) {
+  const params = new URLSearchParams({
+    grant_type: 'authorization_code',
+    code,
+    redirect_uri: redirectUri,
+    code_verifier: codeVerifier,
+  });
+  const headers = { 'Content-Type': 'application/x-www-form-urlencoded' };
+  if (authMethod === 'client_secret_basic') {
+    headers.Authorization = `Basic ${basicCredentials(clientId, clientSecret)}`;
+  } else {
+    params.set('client_id', clientId);
+    if (authMethod === 'client_secret_post') {
+      params.set('client_secret', clientSecret);
+    }
+  }
+  const tokens = await fetchJson(tokenEndpoint, { method: 'POST', headers, body: params.toString() });
+  if (!tokens.id_token) {
+    throw new Error('The identity provider did not return an ID token');
+  }
+  return tokens;
+}
+
+// issuer may contain "{tenantid}" (Microsoft's multi-tenant endpoints); it is
+// matched against the tenant the token was issued for
+async function validateIdToken(idToken, { issuer, jwksUri, clientId, nonce }) {
+  // Expiry is checked below, with allowance for clock skew
+  const claims = await jwt.verify(idToken, (header) => resolveKey(jwksUri, header), { ignoreExpiration: true });
+  const expectedIssuer = issuer.replace('{tenantid}', claims.tid || '');
+  if (claims.iss !== expectedIssuer) {
+    throw new Error('ID token was issued by another issuer');
+  }
+  const audiences = Array.isArray(claims.aud) ? claims.aud : [claims.aud];
+  if (!audiences.includes(clientId)) {
+    throw new Error('ID token was issued to another client');
+  }
+  if (claims.azp && claims.azp !== clientId) {
+    throw new Error('ID token was issued to another client');
+  }
+  const now = Math.floor(Date.now() / 1000);
+  if (typeof claims.exp !== 'number' || claims.exp + CLOCK_SKEW_SECONDS <= now) {
+    throw new Error('ID token has expired');
+  }
+  if (typeof claims.iat !== 'number' || claims.iat - CLOCK_SKEW_SECONDS > now) {
+    throw new Error('ID token was issued in the future');
+  }
+  if (!nonce || claims.nonce !== nonce) {
+    throw new Error('ID token nonce does not match');
+  }
+  if (!claims.sub) {
+    throw new Error('ID token has no subject');
+  }
+  return claims;
+}
+
+// Sign in with Apple expects a short-lived ES256 JWT as the client secret
+const appleClientSecret = ({ teamId, keyId, privateKey, clientId }) => jwt.sign(
+  { iss: teamId, aud: 'https://appleid.apple.com', sub: clientId },
+  { privateKey: crypto.createPrivateKey(privateKey), alg: 'ES256', kid: keyId, expiresIn: 5 * 60 },
+);
+
+module.exports = {
+  discover,
+  createPkce,
+  exchangeCode,
+  validateIdToken,
+  appleClientSecret,
+};
@@ -0,0 +1,152 @@
+// Guess-count based strength estimate in the spirit of zxcvbn: the password
+// is split into the cheapest sequence of recognisable patterns (common words,
+// the user's own details, repeats, sequences, keyboard runs, years) with
+// brute force for whatever is left, and the total guesses map to a 0-4 score.
+
+const COMMON_WORDS = [
+  'password', 'welcome', 'admin', 'login', 'qwerty', 'letmein', 'monkey', 'dragon', 'football',
+  'baseball', 'master', 'sunshine', 'iloveyou', 'princess', 'shadow', 'superman', 'michael',
+  'hello', 'freedom', 'whatever', 'trustno', 'secret', 'changeme', 'default', 'summer', 'winter',
+  'spring', 'autumn', 'love', 'angel', 'flower', 'soccer', 'hockey', 'batman', 'starwars',
+  'computer', 'internet', 'pass', 'test', 'guest', 'user', 'root', 'access', 'health',
+  'healthcare', 'doctor', 'hospital', 'patient', 'medical', 'clinic', 'nurse', 'pharmacy',
+  'family', 'charlie', 'jordan', 'jennifer', 'thomas', 'ashley', 'daniel', 'matthew', 'andrew',
+  'jessica', 'hunter', 'killer', 'cookie', 'cheese', 'orange', 'banana', 'purple', 'silver',
+  'golden', 'tiger', 'lucky', 'happy', 'money', 'secure', 'office', 'company',
+];
+
+const KEYBOARD_ROWS = ['`1234567890-=', 'qwertyuiop[]\\', "asdfghjkl;'", 'zxcvbnm,./'];
+
+const L33T = { 4: 'a', '@': 'a', 8: 'b', 3: 'e', 6: 'g', 1: 'i', '!': 'i', 0: 'o', 5: 's', $: 's', 7: 't', 2: 'z' };
+
+// Guesses a brute-force attacker needs per unmatched character
+const BRUTEFORCE_GUESSES_PER_CHAR = 10;
+const SCORE_THRESHOLDS = [1e3, 1e6, 1e8, 1e10];
+
+const rankedDictionary = (words) => new Map(words.map((word, index) => [word, index + 1]));
+const COMMON_DICTIONARY = rankedDictionary(COMMON_WORDS);
+
+const unl33t = (value) => value.replace(/[4@8361!05$72]/g, (char) => L33T[char]);
+
+function dictionaryMatches(password, dictionary, pattern) {
+  const matches = [];
+  const lower = password.toLowerCase();
+  const normalized = unl33t(lower);
+  for (let i = 0; i < password.length; i += 1) {
+    for (let j = i + 3; j <= password.length; j += 1) {
+      const plain = lower.slice(i, j);
+      const word = dictionary.has(plain) ? plain : normalized.slice(i, j);
+      const rank = dictionary.get(word);
+      if (rank === undefined) continue;
+      const token = password.slice(i, j);
+      // Capitalisation and l33t substitutions each roughly double the search
+      const caseFactor = token === plain ? 1 : 2;
+      const l33tFactor = word === plain ? 1 : 2;
+      matches.push({ i, j, pattern, guesses: rank * caseFactor * l33tFactor });
+    }
+  }
+  return matches;
+}
+
+function repeatMatches(password) {
+  const matches = [];
+  const regex = /(.)\1{2,}/g;
+  let match;
+  while ((match = regex.exec(password)) !== null) {
+    matches.push({
+      i: match.index,
+      j: match.index + match[0].length,
+      pattern: 'repeat',
+      guesses: BRUTEFORCE_GUESSES_PER_CHAR * match[0].length,
+    });
+  }
+  return matches;
+}
+
+function sequenceMatches(password) {
+  const matches = [];
+  let start = 0;
+  while (start < password.length - 2) {
+    const delta = password.charCodeAt(start + 1) - password.charCodeAt(start);
+    let end = start + 1;
+    if (Math.abs(delta) === 1) {
+      while (end + 1 < password.length
+        && password.charCodeAt(end + 1) - password.charCodeAt(end) === delta) {
+        end += 1;
+      }
+    }
+    const length = end - start + 1;
+    if (Math.abs(delta) === 1 && length >= 3) {
+      const first = password[start];
+      const base = 'aAzZ019'.includes(first) ? 4 : /\d/.test(first) ? 10 : 26;
+      matches.push({ i: start, j: end + 1, pattern: 'sequence', guesses: base * length * (delta < 0 ? 2 : 1) });
+      start = end;
+    } else {
+      start += 1;
+    }
+  }
+  return matches;
+}
+
+function keyboardMatches(password) {
+  const matches = [];
+  const lower = password.toLowerCase();
+  const rows = KEYBOARD_ROWS.flatMap((row) => [row, [...row].reverse().join('')]);
+  for (let i = 0; i < lower.length; i += 1) {
+    for (let j = i + 4; j <= lower.length; j += 1) {
+      const run = lower.slice(i, j);
+      if (rows.some((row) => row.includes(run))) {
+        matches.push({ i, j, pattern: 'keyboard', guesses: 100 * run.length });
+      }
+    }
+  }
+  return matches;
+}
+
+function yearMatches(password) {
+  const matches = [];
+  const regex = /(19|20)\d\d/g;
+  let match;
+  while ((match = regex.exec(password)) !== null) {
+    matches.push({ i: match.index, j: match.index + 4, pattern: 'year', guesses: 120 });
+  }
+  return matches;
+}
+
+// userInputs are strings an attacker would try first, such as the email and name
+function estimate(password, userInputs = []) {
+  const inputs = userInputs
+    .filter(Boolean)
+    .flatMap((input) => String(input).toLowerCase().split(/[^a-z0-9]+/))
+    .filter((part) => part.length >= 3);
+
+  const matches = [
+    ...dictionaryMatches(password, COMMON_DICTIONARY, 'common'),
+    ...dictionaryMatches(password, rankedDictionary(inputs), 'personal'),
+    ...repeatMatches(password),
+    ...sequenceMatches(password),
+    ...keyboardMatches(password),
+    ...yearMatches(password),
+  ];
+
+  // best[k] is the cheapest way to guess the first k characters
+  const best = [{ guesses: 1, patterns: [] }];
+  for (let k = 1; k <= password.length; k += 1) {
+    const previous = best[k - 1];
+    let candidate = { guesses: previous.guesses * BRUTEFORCE_GUESSES_PER_CHAR, patterns: previous.patterns };
+    for (const match of matches) {
+      if (match.j !== k) continue;
+      const guesses = best[match.i].guesses * match.guesses;
+      if (guesses < candidate.guesses) {
+        candidate = { guesses, patterns: [...best[match.i].patterns, match.pattern] };
+      }
+    }
+    best.push(candidate);
+  }
+
+  const { guesses, patterns } = best[password.length];
+  const score = SCORE_THRESHOLDS.filter((threshold) => guesses >= threshold).length;
+  return { score, guesses, patterns: [...new Set(patterns)] };
+}
+
+module.exports = { estimate };
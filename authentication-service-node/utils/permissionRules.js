@@ -0,0 +1,131 @@
+// How granted permissions relate to the permissions routes require.
+// Permissions are named resource:action. A grant satisfies a permission when
+// it is the same string, when it is the resource's wildcard (doctor:*), when
+// it is all_permissions, or when it implies the permission: holding a way to
+// change or act on a record implies being able to see it.
+const permissions = require('./permissions');
+
+const ALL_PERMISSIONS = 'all_permissions';
+const CATALOG = Object.values(permissions);
+
+// Granting more than one holds; a 403 rather than a validation error
+class GrantDeniedError extends Error {
+  constructor(excess) {
+    super(`You cannot grant permissions you do not hold: ${excess.join(', ')}`);
+    this.name = 'GrantDeniedError';
+    this.statusCode = 403;
+    this.excessPermissions = excess;
+  }
+}
+
+// Direct implications; they are followed transitively
+const IMPLIES = {
+  'admin:update': ['admin:view'],
+  'admin:delete': ['admin:view'],
+  'doctor:update': ['doctor:view'],
+  'doctor:delete': ['doctor:view'],
+  'doctor:approve': ['doctor:view', 'doctor:list'],
+  'doctor:reject': ['doctor:view', 'doctor:list'],
+  'patient:create': ['patient:view'],
+  'patient:delete': ['patient:view'],
+  'patient:history': ['patient:view'],
+};
+
+const resourceOf = (permission) => permission.split(':')[0];
+const isWildcard = (grant) => grant.endsWith(':*');
+const actionsOf = (resource) => CATALOG.filter((permission) => resourceOf(permission) === resource);
+
+// The grant plus everything it implies
+const impliedBy = (grant, seen = new Set()) => {
+  if (seen.has(grant)) return seen;
+  seen.add(grant);
+  for (const implied of IMPLIES[grant] || []) {
+    impliedBy(implied, seen);
+  }
+  return seen;
+};
+
+const covers = (grant, permission) => {
+  if (grant === ALL_PERMISSIONS) return true;
+  if (isWildcard(grant)) return resourceOf(grant) === resourceOf(permission);
+  return impliedBy(grant).has(permission);
+};
+
+const holds = (grants, permission) => (grants || []).some((grant) => covers(grant, permission));
+
+// A permission name or wildcard that may be granted; catalog is the list the
+// grant has to come from. Everything the grant covers or implies must be in
+// it too, so a wildcard cannot reach a permission the catalog leaves out.
+const isValidGrant = (grant, catalog = CATALOG) => {
+  if (typeof grant !== 'string') return false;
+  const known = isWildcard(grant)
+    ? CATALOG.some((permission) => resourceOf(permission) === resourceOf(grant))
+    : CATALOG.includes(grant);
+  return known && expand([grant]).every((permission) => catalog.includes(permission));
+};
+
+// Every catalog permission the grants satisfy, plus grants outside the
+// catalog (doctor:self, patient:self) which only ever match themselves
+const expand = (grants) => {
+  const list = grants || [];
+  const outside = list.filter((grant) => grant !== ALL_PERMISSIONS && !isWildcard(grant) && !CATALOG.includes(grant));
+  return [...CATALOG.filter((permission) => holds(list, permission)), ...new Set(outside)];
+};
+
+// The shortest list of grants equivalent to the given ones, for token
+// payloads: complete resources with several actions become wildcards and
+// anything implied by another remaining grant is left out. Services reading
+// tokens expand them again with the published rules (document()).
+const compress = (grants) => {
+  if ((grants || []).includes(ALL_PERMISSIONS)) {
+    return [ALL_PERMISSIONS];
+  }
+  const expanded = expand(grants);
+  const wildcards = [...new Set(CATALOG.map(resourceOf))]
+    .filter((resource) => actionsOf(resource).length > 1)
+    .filter((resource) => actionsOf(resource).every((permission) => expanded.includes(permission)))
+    .map((resource) => `${resource}:*`);
+  const rest = expanded.filter((permission) => !wildcards.some((wildcard) => covers(wildcard, permission)));
+  const kept = rest.filter((permission) => !rest.some((other) => other !== permission && covers(other, permission)));
+  return [...wildcards, ...kept];
+};
+
+// The rules as published at /.well-known/permission-rules.json, enough for
+// another service to expand a token's permissions
+const document = () => ({
+  all_permissions: ALL_PERMISSIONS,
+  wildcard_suffix: ':*',
+  permissions: CATALOG,
+  implies: IMPLIES,
+});
+
+// Permissions among grants that the held grants do not satisfy. Wildcards
+// stand for every permission they cover today.
+const exceeding = (held, grants) => expand(grants)
+  .filter((permission) => !holds(held, permission));
+
+// No one hands out more than they hold. already: what the recipient holds
+// before the change, so narrowing someone who holds more is still allowed.
+const assertCanGrant = (held, grants, already = []) => {
+  const excess = exceeding(held, grants)
+    .filter((permission) => !holds(already, permission));
+  if (excess.length > 0) {
+    throw new GrantDeniedError(excess);
+  }
+};
+
+const isGrantDenied = (error) => error instanceof GrantDeniedError;
+
+module.exports = {
+  ALL_PERMISSIONS,
+  IMPLIES,
+  holds,
+  isValidGrant,
+  expand,
+  compress,
+  document,
+  exceeding,
+  assertCanGrant,
+  isGrantDenied,
+  GrantDeniedError,
+};
@@ -10,7 +10,10 @@ module.exports = {
   PermissionDoctorApprove: 'doctor:approve',
   PermissionDoctorReject: 'doctor:reject',
   PermissionDoctorUpdate: 'doctor:update',
+  PermissionDoctorDelete: 'doctor:delete',
 
+  PermissionPatientCreate: 'patient:create',
+  PermissionPatientDelete: 'patient:delete',
   PermissionPatientList: 'patient:list',
   PermissionPatientView: 'patient:view',
   PermissionPatientHistory: 'patient:history',
@@ -18,4 +21,27 @@ module.exports = {
   PermissionSystemConfig: 'system:config',
   PermissionSystemMetrics: 'system:metrics',
   PermissionSystemLogs: 'system:logs',
+
+  PermissionTokenRevoke: 'token:revoke',
+  PermissionMfaReset: 'mfa:reset',
+  PermissionLockoutManage: 'lockout:manage',
+  PermissionSessionManage: 'session:manage',
+  PermissionApiKeyManage: 'api_key:manage',
+  PermissionUserImpersonate: 'user:impersonate',
+  PermissionAuditView: 'audit:view',
+  PermissionCareTeamManage: 'care_team:manage',
+  PermissionPolicyExplain: 'policy:explain',
+  PermissionRoleManage: 'role:manage',
+  PermissionBreakGlassRequest: 'break_glass:request',
+  PermissionBreakGlassReview: 'break_glass:review',
+
+  PermissionOAuthClientManage: 'oauth_client:manage',
+  PermissionIdentityProviderManage: 'identity_provider:manage',
+  PermissionSamlProviderManage: 'saml_provider:manage',
+  PermissionLdapDirectoryManage: 'ldap_directory:manage',
+  PermissionServiceAccountManage: 'service_account:manage',
+
+  // Held by service accounts rather than people
+  PermissionTokenIntrospect: 'token:introspect',
+  PermissionCareRelationshipSync: 'care_relationship:sync',
 };
@@ -0,0 +1,88 @@
+// The authenticated caller of a request, whichever credential it presented.
+// Fields keep the access token claim names (user_id, role, permissions, sid,
+// ...) so services that take claims accept a principal unchanged.
+//
+// Access tokens act with the user's full authority. API keys are delegated
+// credentials: they carry only the key's scopes. hasRole looks at the role
+// alone, whichever credential was presented; routes whose role gate stands
+// in for "everything this role may do" also require a signed-in session.
+//
+// Impersonation tokens act as their target user, but never manage the
+// target's account; act.sub is the support user who is really calling.
+//
+// Service account tokens (role "service", client_credentials grant) stand
+// for a backend service, not a person: they only carry their permissions.
+//
+// Access tokens issued to OAuth clients (client_id and scope claims) are
+// delegated like API keys: the client acts for the user within its scopes.
+
+const permissionRules = require('./permissionRules');
+
+const AUTH_METHODS = {
+  ACCESS_TOKEN: 'access_token',
+  API_KEY: 'api_key',
+  CLIENT_CREDENTIALS: 'client_credentials',
+  OAUTH_CLIENT: 'oauth_client',
+};
+
+// Granted to super admins in place of an explicit permission list
+const { ALL_PERMISSIONS } = permissionRules;
+
+class Principal {
+  constructor(fields) {
+    Object.assign(this, fields);
+    this.permissions = fields.permissions || [];
+  }
+
+  static fromAccessToken(claims) {
+    let authMethod = AUTH_METHODS.ACCESS_TOKEN;
+    if (claims.role === 'service') {
+      authMethod = AUTH_METHODS.CLIENT_CREDENTIALS;
+    } else if (claims.client_id || claims.scope) {
+      authMethod = AUTH_METHODS.OAUTH_CLIENT;
+    }
+    return new Principal({ ...claims, auth_method: authMethod });
+  }
+
+  static fromApiKey({ identity, apiKeyId, scopes }) {
+    const fields = {
+      user_id: identity.userId.toString(),
+      email: identity.email,
+      role: identity.role,
+      permissions: scopes,
+      email_verified: identity.emailVerified !== false,
+      amr: [AUTH_METHODS.API_KEY],
+      auth_method: AUTH_METHODS.API_KEY,
+      api_key_id: apiKeyId.toString(),
+    };
+    if (identity.patientId) {
+      fields.patientId = identity.patientId.toString();
+    }
+    return new Principal(fields);
+  }
+
+  // True for credentials that stand for a signed-in user rather than a
+  // delegated, scoped grant
+  get interactive() {
+    return this.auth_method === AUTH_METHODS.ACCESS_TOKEN && !this.client_id && !this.scope;
+  }
+
+  // Access tokens issued to support staff acting as another user name the
+  // staff member in an act claim; the principal is still the target user
+  get impersonating() {
+    return Boolean(this.act);
+  }
+
+  hasRole(role) {
+    return this.role === role;
+  }
+
+  // Wildcards and implied permissions count, see permissionRules
+  can(permission) {
+    return permissionRules.holds(this.permissions, permission);
+  }
+}
+
+module.exports = Principal;
+module.exports.AUTH_METHODS = AUTH_METHODS;
+module.exports.ALL_PERMISSIONS = ALL_PERMISSIONS;
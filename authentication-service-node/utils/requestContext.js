@@ -0,0 +1,38 @@
+// Where a request came from, recorded on the sessions it creates
+const BROWSERS = [
+  [/Edg\//, 'Edge'],
+  [/OPR\/|Opera/, 'Opera'],
+  [/Firefox\//, 'Firefox'],
+  [/Chrome\//, 'Chrome'],
+  [/Safari\//, 'Safari'],
+];
+
+const PLATFORMS = [
+  [/iPhone|iPad|iPod/, 'iOS'],
+  [/Android/, 'Android'],
+  [/Windows/, 'Windows'],
+  [/Mac OS X|Macintosh/, 'macOS'],
+  [/CrOS/, 'ChromeOS'],
+  [/Linux/, 'Linux'],
+];
+
+const firstMatch = (list, value) => {
+  const found = list.find(([regex]) => regex.test(value));
+  return found ? found[1] : null;
+};
+
+// Short human readable description such as "Firefox on Windows"
+const describeDevice = (userAgent) => {
+  if (!userAgent) return 'Unknown device';
+  const browser = firstMatch(BROWSERS, userAgent);
+  const platform = firstMatch(PLATFORMS, userAgent);
+  if (browser && platform) return `${browser} on ${platform}`;
+  return browser || platform || userAgent.split(/[\s/]/)[0] || 'Unknown device';
+};
+
+const requestContext = (req) => ({
+  ip: req.ip,
+  userAgent: req.get('user-agent') || null,
+});
+
+module.exports = { requestContext, describeDevice };
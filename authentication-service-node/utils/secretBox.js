@@ -0,0 +1,26 @@
+const crypto = require('crypto');
+
+// AES-256-GCM encryption for secrets stored in MongoDB (signing keys, TOTP
+// seeds). The key is derived from a server-side secret that never leaves the
+// auth service.
+class SecretBox {
+  constructor(secret) {
+    this.key = crypto.createHash('sha256').update(secret).digest();
+  }
+
+  encrypt(plaintext) {
+    const iv = crypto.randomBytes(12);
+    const cipher = crypto.createCipheriv('aes-256-gcm', this.key, iv);
+    const ciphertext = Buffer.concat([cipher.update(plaintext, 'utf8'), cipher.final()]);
+    return [iv, cipher.getAuthTag(), ciphertext].map((part) => part.toString('base64')).join('.');
+  }
+
+  decrypt(value) {
+    const [iv, tag, ciphertext] = value.split('.').map((part) => Buffer.from(part, 'base64'));
+    const decipher = crypto.createDecipheriv('aes-256-gcm', this.key, iv);
+    decipher.setAuthTag(tag);
+    return Buffer.concat([decipher.update(ciphertext), decipher.final()]).toString('utf8');
+  }
+}
+
+module.exports = SecretBox;
@@ -0,0 +1,164 @@
+const net = require('net');
+const tls = require('tls');
+const os = require('os');
+
+// Minimal SMTP submission client (RFC 5321) supporting implicit TLS,
+// STARTTLS and AUTH PLAIN/LOGIN. Enough to deliver transactional mail
+// without pulling in a mail library. Credentials and mail are only sent
+// over TLS when a username is configured or requireTls is set.
+
+const DEFAULT_TIMEOUT_MS = 15000;
+
+class SmtpConnection {
+  constructor(timeoutMs) {
+    this.timeoutMs = timeoutMs;
+    this.buffer = '';
+    this.lines = [];
+    this.waiting = null;
+    this.error = null;
+  }
+
+  attach(socket) {
+    this.socket = socket;
+    this.buffer = '';
+    this.lines = [];
+    socket.setTimeout(this.timeoutMs, () => this.fail(new Error('SMTP connection timed out')));
+    socket.on('data', (chunk) => this.onData(chunk));
+    socket.on('error', (error) => this.fail(error));
+    socket.on('close', () => this.fail(new Error('SMTP connection closed')));
+  }
+
+  detach() {
+    this.socket.removeAllListeners('data');
+    this.socket.removeAllListeners('error');
+    this.socket.removeAllListeners('close');
+    this.socket.setTimeout(0);
+    // Late errors on a socket we are done with must not crash the process
+    this.socket.on('error', () => {});
+  }
+
+  onData(chunk) {
+    this.buffer += chunk.toString('utf8');
+    let index;
+    while ((index = this.buffer.indexOf('\r\n')) !== -1) {
+      this.lines.push(this.buffer.slice(0, index));
+      this.buffer = this.buffer.slice(index + 2);
+    }
+    this.flush();
+  }
+
+  // A reply is complete at the line whose code is followed by a space
+  flush() {
+    if (!this.waiting) return;
+    const last = this.lines.findIndex((line) => line.length < 4 || line[3] !== '-');
+    if (last === -1) return;
+    const lines = this.lines.splice(0, last + 1);
+    const { resolve } = this.waiting;
+    this.waiting = null;
+    resolve({ code: parseInt(lines[0].slice(0, 3), 10), lines: lines.map((line) => line.slice(4)) });
+  }
+
+  fail(error) {
+    this.error = this.error || error;
+    if (this.waiting) {
+      const { reject } = this.waiting;
+      this.waiting = null;
+      reject(this.error);
+    }
+  }
+
+  read() {
+    if (this.error) return Promise.reject(this.error);
+    return new Promise((resolve, reject) => {
+      this.waiting = { resolve, reject };
+      this.flush();
+    });
+  }
+
+  async command(line, expected) {
+    if (line !== null) {
+      this.socket.write(`${line}\r\n`);
+    }
+    const reply = await this.read();
+    if (!expected.includes(reply.code)) {
+      throw new Error(`SMTP error ${reply.code}: ${reply.lines.join(' ')}`);
+    }
+    return reply;
+  }
+}
+
+const connect = (options) => new Promise((resolve, reject) => {
+  const socket = options.secure
+    ? tls.connect({ host: options.host, port: options.port, servername: options.host })
+    : net.connect({ host: options.host, port: options.port });
+  socket.once(options.secure ? 'secureConnect' : 'connect', () => {
+    socket.removeListener('error', reject);
+    resolve(socket);
+  });
+  socket.once('error', reject);
+});
+
+const upgrade = (socket, host) => new Promise((resolve, reject) => {
+  const secureSocket = tls.connect({ socket, servername: host });
+  secureSocket.once('secureConnect', () => {
+    secureSocket.removeListener('error', reject);
+    resolve(secureSocket);
+  });
+  secureSocket.once('error', reject);
+});
+
+const extensions = (reply) => reply.lines.slice(1).map((line) => line.toUpperCase());
+
+// Lines starting with a dot are doubled so they are not read as end of data
+const dotStuff = (message) => message.replace(/\r?\n/g, '\r\n').replace(/^\./gm, '..');
+
+async function sendMail(options, { from, to, message }) {
+  const connection = new SmtpConnection(options.timeoutMs || DEFAULT_TIMEOUT_MS);
+  connection.attach(await connect(options));
+  const hostname = os.hostname();
+
+  try {
+    await connection.command(null, [220]);
+    let ehlo = await connection.command(`EHLO ${hostname}`, [250]);
+
+    const requireTls = Boolean(options.requireTls || options.username);
+    if (!options.secure && extensions(ehlo).includes('STARTTLS')) {
+      await connection.command('STARTTLS', [220]);
+      // Anything read before the handshake came over plain text and could
+      // have been injected; a well-behaved server sends nothing more
+      if (connection.lines.length > 0 || connection.buffer.length > 0) {
+        throw new Error('SMTP server sent data before the TLS handshake');
+      }
+      connection.detach();
+      connection.attach(await upgrade(connection.socket, options.host));
+      ehlo = await connection.command(`EHLO ${hostname}`, [250]);
+    } else if (!options.secure && requireTls) {
+      throw new Error('SMTP server does not offer STARTTLS');
+    }
+
+    if (options.username) {
+      const auth = extensions(ehlo).find((line) => line.startsWith('AUTH')) || '';
+      if (auth.includes('PLAIN')) {
+        const credentials = Buffer.from(`\0${options.username}\0${options.password}`).toString('base64');
+        await connection.command(`AUTH PLAIN ${credentials}`, [235]);
+      } else {
+        await connection.command('AUTH LOGIN', [334]);
+        await connection.command(Buffer.from(options.username).toString('base64'), [334]);
+        await connection.command(Buffer.from(options.password || '').toString('base64'), [235]);
+      }
+    }
+
+    await connection.command(`MAIL FROM:<${from}>`, [250]);
+    for (const recipient of [].concat(to)) {
+      await connection.command(`RCPT TO:<${recipient}>`, [250, 251]);
+    }
+    await connection.command('DATA', [354]);
+    await connection.command(`${dotStuff(message)}\r\n.`, [250]);
+    await connection.command('QUIT', [221]).catch(() => {});
+  } finally {
+    connection.detach();
+    connection.socket.end();
+  }
+}
+
+module.exports = { sendMail };
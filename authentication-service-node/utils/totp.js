@@ -0,0 +1,104 @@
+const crypto = require('crypto');
+
+// RFC 6238 time-based one-time passwords (SHA-1, 6 digits, 30 second steps),
+// the parameters every common authenticator app supports.
+const STEP_SECONDS = 30;
+const DIGITS = 6;
+const BASE32_ALPHABET = 'ABCDEFGHIJKLMNOPQRSTUVWXYZ234567';
+
+function base32Encode(buffer) {
+  let bits = 0;
+  let value = 0;
+  let output = '';
+  for (const byte of buffer) {
+    value = (value << 8) | byte;
+    bits += 8;
+    while (bits >= 5) {
+      output += BASE32_ALPHABET[(value >>> (bits - 5)) & 31];
+      bits -= 5;
+    }
+  }
+  if (bits > 0) {
+    output += BASE32_ALPHABET[(value << (5 - bits)) & 31];
+  }
+  return output;
+}
+
+function base32Decode(input) {
+  const clean = input.replace(/=+$/, '').replace(/\s+/g, '').toUpperCase();
+  let bits = 0;
+  let value = 0;
+  const bytes = [];
+  for (const char of clean) {
+    const index = BASE32_ALPHABET.indexOf(char);
+    if (index === -1) {
+      throw new Error('Invalid base32 character');
+    }
+    value = (value << 5) | index;
+    bits += 5;
+    if (bits >= 8) {
+      bytes.push((value >>> (bits - 8)) & 255);
+      bits -= 8;
+    }
+  }
+  return Buffer.from(bytes);
+}
+
+function generateSecret() {
+  return base32Encode(crypto.randomBytes(20));
+}
+
+function currentStep(now = Date.now()) {
+  return Math.floor(now / 1000 / STEP_SECONDS);
+}
+
+function generateCode(secret, step) {
+  const counter = Buffer.alloc(8);
+  counter.writeBigUInt64BE(BigInt(step));
+  const hmac = crypto.createHmac('sha1', base32Decode(secret)).update(counter).digest();
+  const offset = hmac[hmac.length - 1] & 0xf;
+  const binary = ((hmac[offset] & 0x7f) << 24)
+    | (hmac[offset + 1] << 16)
+    | (hmac[offset + 2] << 8)
+    | hmac[offset + 3];
+  return String(binary % (10 ** DIGITS)).padStart(DIGITS, '0');
+}
+
+// Returns the matching time step, or null. One step of clock drift is allowed
+// either way; callers reject steps at or before the last one used.
+function verifyCode(secret, code, { window = 1, now = Date.now() } = {}) {
+  if (typeof code !== 'string' || !/^\d{6}$/.test(code)) {
+    return null;
+  }
+  const step = currentStep(now);
+  for (let offset = -window; offset <= window; offset += 1) {
+    const expected = Buffer.from(generateCode(secret, step + offset));
+    if (crypto.timingSafeEqual(expected, Buffer.from(code))) {
+      return step + offset;
+    }
+  }
+  return null;
+}
+
+// otpauth:// URI that authenticator apps scan as a QR code
+function provisioningUri(secret, accountName, issuer) {
+  const label = encodeURIComponent(`${issuer}:${accountName}`);
+  const params = new URLSearchParams({
+    secret,
+    issuer,
+    algorithm: 'SHA1',
+    digits: String(DIGITS),
+    period: String(STEP_SECONDS),
+  });
+  return `otpauth://totp/${label}?${params.toString()}`;
+}
+
+module.exports = {
+  base32Encode,
+  base32Decode,
+  generateSecret,
+  generateCode,
+  currentStep,
+  verifyCode,
+  provisioningUri,
+};
@@ -0,0 +1,210 @@
+const crypto = require('crypto');
+const cbor = require('./cbor');
+
+// Relying-party side verification of WebAuthn registration and assertion
+// responses (https://www.w3.org/TR/webauthn-2/#sctn-rp-operations).
+// Attestation statements are not verified: registration requests
+// attestation "none", so authenticators are trusted for possession only.
+
+const COSE_ALGORITHMS = {
+  ES256: -7,
+  EdDSA: -8,
+  RS256: -257,
+};
+
+const FLAG_USER_PRESENT = 0x01;
+const FLAG_USER_VERIFIED = 0x04;
+const FLAG_ATTESTED_CREDENTIAL_DATA = 0x40;
+const FLAG_EXTENSION_DATA = 0x80;
+
+const fromBase64Url = (value, field) => {
+  if (typeof value !== 'string' || value.length === 0) {
+    throw new Error(`Missing ${field}`);
+  }
+  return Buffer.from(value, 'base64url');
+};
+
+const sha256 = (data) => crypto.createHash('sha256').update(data).digest();
+
+// Converts a COSE_Key map into a JWK node's crypto module can import
+function coseToJwk(coseKey) {
+  const kty = coseKey.get(1);
+  const alg = coseKey.get(3);
+  if (kty === 2 && alg === COSE_ALGORITHMS.ES256 && coseKey.get(-1) === 1) {
+    return {
+      alg,
+      jwk: {
+        kty: 'EC',
+        crv: 'P-256',
+        x: Buffer.from(coseKey.get(-2)).toString('base64url'),
+        y: Buffer.from(coseKey.get(-3)).toString('base64url'),
+      },
+    };
+  }
+  if (kty === 1 && alg === COSE_ALGORITHMS.EdDSA && coseKey.get(-1) === 6) {
+    return {
+      alg,
+      jwk: { kty: 'OKP', crv: 'Ed25519', x: Buffer.from(coseKey.get(-2)).toString('base64url') },
+    };
+  }
+  if (kty === 3 && alg === COSE_ALGORITHMS.RS256) {
+    return {
+      alg,
+      jwk: {
+        kty: 'RSA',
+        n: Buffer.from(coseKey.get(-1)).toString('base64url'),
+        e: Buffer.from(coseKey.get(-2)).toString('base64url'),
+      },
+    };
+  }
+  throw new Error('Unsupported credential public key');
+}
+
+function parseAuthenticatorData(buffer) {
+  if (buffer.length < 37) {
+    throw new Error('Authenticator data too short');
+  }
+  const flags = buffer[32];
+  const data = {
+    rpIdHash: buffer.subarray(0, 32),
+    userPresent: Boolean(flags & FLAG_USER_PRESENT),
+    userVerified: Boolean(flags & FLAG_USER_VERIFIED),
+    signCount: buffer.readUInt32BE(33),
+  };
+
+  let offset = 37;
+  if (flags & FLAG_ATTESTED_CREDENTIAL_DATA) {
+    if (buffer.length < offset + 18) {
+      throw new Error('Attested credential data too short');
+    }
+    const aaguid = buffer.subarray(offset, offset + 16);
+    const idLength = buffer.readUInt16BE(offset + 16);
+    offset += 18;
+    const credentialId = buffer.subarray(offset, offset + idLength);
+    offset += idLength;
+    const { value: publicKey, length } = cbor.decodeFirst(buffer.subarray(offset));
+    offset += length;
+    data.attestedCredential = { aaguid, credentialId, publicKey };
+  }
+  if (flags & FLAG_EXTENSION_DATA) {
+    const { length } = cbor.decodeFirst(buffer.subarray(offset));
+    offset += length;
+  }
+  if (offset !== buffer.length) {
+    throw new Error('Trailing bytes in authenticator data');
+  }
+  return data;
+}
+
+function verifyClientData(clientDataJSON, { type, expectedChallenge, expectedOrigins }) {
+  let clientData;
+  try {
+    clientData = JSON.parse(clientDataJSON.toString('utf8'));
+  } catch (error) {
+    throw new Error('Invalid client data');
+  }
+  if (clientData.type !== type) {
+    throw new Error('Unexpected ceremony type');
+  }
+  const challenge = Buffer.from(String(clientData.challenge || ''), 'base64url');
+  const expected = Buffer.from(expectedChallenge, 'base64url');
+  if (challenge.length !== expected.length || !crypto.timingSafeEqual(challenge, expected)) {
+    throw new Error('Challenge mismatch');
+  }
+  if (!expectedOrigins.includes(clientData.origin)) {
+    throw new Error('Unexpected origin');
+  }
+  return clientData;
+}
+
+function verifyAuthenticatorFlags(authData, rpId, requireUserVerification) {
+  if (!crypto.timingSafeEqual(authData.rpIdHash, sha256(rpId))) {
+    throw new Error('Relying party ID mismatch');
+  }
+  if (!authData.userPresent) {
+    throw new Error('User presence required');
+  }
+  if (requireUserVerification && !authData.userVerified) {
+    throw new Error('User verification required');
+  }
+}
+
+function verifyRegistration(credential, { expectedChallenge, expectedOrigins, rpId, requireUserVerification = false }) {
+  const response = credential && credential.response;
+  if (!response || credential.type !== 'public-key') {
+    throw new Error('Invalid credential');
+  }
+  verifyClientData(fromBase64Url(response.clientDataJSON, 'clientDataJSON'), {
+    type: 'webauthn.create',
+    expectedChallenge,
+    expectedOrigins,
+  });
+
+  const attestation = cbor.decode(fromBase64Url(response.attestationObject, 'attestationObject'));
+  if (!(attestation instanceof Map) || !Buffer.isBuffer(attestation.get('authData'))) {
+    throw new Error('Invalid attestation object');
+  }
+  const authData = parseAuthenticatorData(attestation.get('authData'));
+  verifyAuthenticatorFlags(authData, rpId, requireUserVerification);
+  if (!authData.attestedCredential) {
+    throw new Error('No credential in attestation');
+  }
+
+  const { alg, jwk } = coseToJwk(authData.attestedCredential.publicKey);
+  // Fails early on keys node cannot import
+  crypto.createPublicKey({ key: jwk, format: 'jwk' });
+  return {
+    credentialId: authData.attestedCredential.credentialId.toString('base64url'),
+    publicKeyJwk: jwk,
+    coseAlg: alg,
+    signCount: authData.signCount,
+    userVerified: authData.userVerified,
+    aaguid: authData.attestedCredential.aaguid.toString('hex'),
+    transports: Array.isArray(response.transports) ? response.transports : [],
+  };
+}
+
+function verifyAssertion(credential, {
+  expectedChallenge,
+  expectedOrigins,
+  rpId,
+  publicKeyJwk,
+  coseAlg,
+  storedSignCount,
+  requireUserVerification = false,
+}) {
+  const response = credential && credential.response;
+  if (!response || credential.type !== 'public-key') {
+    throw new Error('Invalid credential');
+  }
+  const clientDataJSON = fromBase64Url(response.clientDataJSON, 'clientDataJSON');
+  verifyClientData(clientDataJSON, { type: 'webauthn.get', expectedChallenge, expectedOrigins });
+
+  const authenticatorData = fromBase64Url(response.authenticatorData, 'authenticatorData');
+  const authData = parseAuthenticatorData(authenticatorData);
+  verifyAuthenticatorFlags(authData, rpId, requireUserVerification);
+
+  const signedData = Buffer.concat([authenticatorData, sha256(clientDataJSON)]);
+  const signature = fromBase64Url(response.signature, 'signature');
+  const publicKey = crypto.createPublicKey({ key: publicKeyJwk, format: 'jwk' });
+  // ES256 assertions are DER encoded, which is node's default
+  const digest = coseAlg === COSE_ALGORITHMS.EdDSA ? null : 'sha256';
+  if (!crypto.verify(digest, signedData, publicKey, signature)) {
+    throw new Error('Invalid signature');
+  }
+
+  // A counter that does not move forward suggests a cloned authenticator;
+  // authenticators that do not implement counters always report zero
+  if ((authData.signCount > 0 || storedSignCount > 0) && authData.signCount <= storedSignCount) {
+    throw new Error('Signature counter did not increase');
+  }
+  return { signCount: authData.signCount, userVerified: authData.userVerified };
+}
+
+module.exports = {
+  COSE_ALGORITHMS,
+  coseToJwk,
+  parseAuthenticatorData,
+  verifyRegistration,
+  verifyAssertion,
+};
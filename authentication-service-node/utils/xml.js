@@ -0,0 +1,217 @@
+// Minimal, non-validating XML parser for SAML messages. It builds a small
+// element tree with namespace lookup; comments are dropped and adjacent text
+// is merged. Document type declarations are refused outright, which rules
+// out entity expansion and external entity attacks.
+
+const XML_NAMESPACE = 'http://www.w3.org/XML/1998/namespace';
+
+const ENTITIES = { lt: '<', gt: '>', amp: '&', quot: '"', apos: "'" };
+
+const NAME = /^[A-Za-z_][\w.\-]*(?::[A-Za-z_][\w.\-]*)?/;
+
+const decodeEntities = (value) => value.replace(/&(#x[0-9a-fA-F]+|#[0-9]+|[a-z]+);|&/g, (match, entity) => {
+  if (!entity) {
+    throw new Error('Malformed XML: bare "&"');
+  }
+  if (entity[0] === '#') {
+    const code = entity[1] === 'x' ? parseInt(entity.slice(2), 16) : parseInt(entity.slice(1), 10);
+    return String.fromCodePoint(code);
+  }
+  if (!Object.prototype.hasOwnProperty.call(ENTITIES, entity)) {
+    throw new Error(`Malformed XML: unknown entity &${entity};`);
+  }
+  return ENTITIES[entity];
+});
+
+class XmlElement {
+  constructor(name, attributes, parent) {
+    this.name = name;
+    this.attributes = attributes;
+    this.parent = parent;
+    // XmlElement or string (text) nodes
+    this.children = [];
+  }
+
+  get prefix() {
+    const colon = this.name.indexOf(':');
+    return colon === -1 ? '' : this.name.slice(0, colon);
+  }
+
+  get localName() {
+    return this.name.slice(this.name.indexOf(':') + 1);
+  }
+
+  get namespaceURI() {
+    return this.lookupNamespace(this.prefix) || '';
+  }
+
+  // The namespace bound to prefix ('' for the default namespace) here
+  lookupNamespace(prefix) {
+    if (prefix === 'xml') return XML_NAMESPACE;
+    const declaration = prefix ? `xmlns:${prefix}` : 'xmlns';
+    for (let element = this; element; element = element.parent) {
+      const attribute = element.attributes.find((candidate) => candidate.name === declaration);
+      if (attribute) return attribute.value;
+    }
+    return undefined;
+  }
+
+  getAttribute(name) {
+    const attribute = this.attributes.find((candidate) => candidate.name === name);
+    return attribute ? attribute.value : null;
+  }
+
+  elements() {
+    return this.children.filter((child) => child instanceof XmlElement);
+  }
+
+  // Direct children with the given namespace and local name
+  childrenNamed(namespaceURI, localName) {
+    return this.elements().filter((child) => child.localName === localName && child.namespaceURI === namespaceURI);
+  }
+
+  // The single such child, or null; more than one is an error
+  child(namespaceURI, localName) {
+    const found = this.childrenNamed(namespaceURI, localName);
+    if (found.length > 1) {
+      throw new Error(`Expected at most one ${localName} element`);
+    }
+    return found[0] || null;
+  }
+
+  // All text inside the element, comments removed
+  textContent() {
+    return this.children.map((child) => (typeof child === 'string' ? child : child.textContent())).join('');
+  }
+
+  *descendants() {
+    for (const child of this.elements()) {
+      yield child;
+      yield* child.descendants();
+    }
+  }
+}
+
+function parseAttributes(source) {
+  const attributes = [];
+  const pattern = /\s+([^\s=]+)\s*=\s*("([^"]*)"|'([^']*)')/gy;
+  let position = 0;
+  let match;
+  while ((match = pattern.exec(source))) {
+    const name = match[1];
+    if (!NAME.test(name) || NAME.exec(name)[0] !== name) {
+      throw new Error(`Malformed XML: invalid attribute name ${name}`);
+    }
+    if (attributes.some((attribute) => attribute.name === name)) {
+      throw new Error(`Malformed XML: duplicate attribute ${name}`);
+    }
+    const raw = match[3] !== undefined ? match[3] : match[4];
+    if (raw.includes('<')) {
+      throw new Error('Malformed XML: "<" in attribute value');
+    }
+    // Attribute value normalization: literal whitespace becomes a space
+    attributes.push({ name, value: decodeEntities(raw.replace(/[\t\n\r]/g, ' ')) });
+    position = pattern.lastIndex;
+  }
+  if (source.slice(position).trim()) {
+    throw new Error('Malformed XML: invalid attributes');
+  }
+  return attributes;
+}
+
+function parse(input) {
+  const xml = input.replace(/\r\n?/g, '\n');
+  let position = 0;
+  let root = null;
+  let current = null;
+
+  const appendText = (text) => {
+    if (!current) {
+      if (text.trim()) throw new Error('Malformed XML: text outside the root element');
+      return;
+    }
+    const last = current.children[current.children.length - 1];
+    if (typeof last === 'string') {
+      current.children[current.children.length - 1] = last + text;
+    } else if (text) {
+      current.children.push(text);
+    }
+  };
+
+  while (position < xml.length) {
+    const open = xml.indexOf('<', position);
+    if (open === -1) {
+      appendText(decodeEntities(xml.slice(position)));
+      break;
+    }
+    if (open > position) {
+      appendText(decodeEntities(xml.slice(position, open)));
+    }
+
+    if (xml.startsWith('<!--', open)) {
+      const end = xml.indexOf('-->', open + 4);
+      if (end === -1) throw new Error('Malformed XML: unterminated comment');
+      position = end + 3;
+    } else if (xml.startsWith('<![CDATA[', open)) {
+      const end = xml.indexOf(']]>', open + 9);
+      if (end === -1 || !current) throw new Error('Malformed XML: invalid CDATA section');
+      appendText(xml.slice(open + 9, end));
+      position = end + 3;
+    } else if (xml.startsWith('<!', open)) {
+      throw new Error('XML document type declarations are not allowed');
+    } else if (xml.startsWith('<?', open)) {
+      // Only the XML declaration is accepted
+      const end = xml.indexOf('?>', open + 2);
+      if (end === -1 || root || !/^<\?xml\s/.test(xml.slice(open, end))) {
+        throw new Error('XML processing instructions are not supported');
+      }
+      position = end + 2;
+    } else if (xml[open + 1] === '/') {
+      const end = xml.indexOf('>', open);
+      const name = end === -1 ? null : xml.slice(open + 2, end).trim();
+      if (!current || name !== current.name) {
+        throw new Error('Malformed XML: mismatched end tag');
+      }
+      current = current.parent;
+      position = end + 1;
+    } else {
+      const end = xml.indexOf('>', open);
+      if (end === -1) throw new Error('Malformed XML: unterminated tag');
+      let body = xml.slice(open + 1, end);
+      const selfClosing = body.endsWith('/');
+      if (selfClosing) body = body.slice(0, -1);
+      const nameMatch = NAME.exec(body);
+      if (!nameMatch) throw new Error('Malformed XML: invalid element name');
+      if (root && !current) throw new Error('Malformed XML: more than one root element');
+
+      const element = new XmlElement(nameMatch[0], parseAttributes(body.slice(nameMatch[0].length)), current);
+      const prefixes = [element.prefix, ...element.attributes
+        .filter(({ name }) => name.includes(':') && !name.startsWith('xmlns:'))
+        .map(({ name }) => name.slice(0, name.indexOf(':')))];
+      const unbound = prefixes.find((prefix) => prefix && element.lookupNamespace(prefix) === undefined);
+      if (unbound) {
+        throw new Error(`Malformed XML: unbound prefix ${unbound}`);
+      }
+      if (current) {
+        current.children.push(element);
+      } else {
+        root = element;
+      }
+      if (!selfClosing) current = element;
+      position = end + 1;
+    }
+  }
+
+  if (!root || current) {
+    throw new Error('Malformed XML: unexpected end of document');
+  }
+  return root;
+}
+
+const escapeXml = (value) => String(value)
+  .replace(/&/g, '&amp;')
+  .replace(/</g, '&lt;')
+  .replace(/>/g, '&gt;')
+  .replace(/"/g, '&quot;');
+
+module.exports = { parse, escapeXml, XmlElement, XML_NAMESPACE };
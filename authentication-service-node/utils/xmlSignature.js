@@ -0,0 +1,188 @@
+const crypto = require('crypto');
+const { XmlElement, XML_NAMESPACE } = require('./xml');
+
+// Verification of enveloped XML signatures (XML-DSig) as used by SAML, with
+// exclusive canonicalization. Only the algorithms below are accepted; SHA-1
+// and inclusive canonicalization are refused.
+
+const DSIG_NAMESPACE = 'http://www.w3.org/2000/09/xmldsig#';
+const EXC_C14N = 'http://www.w3.org/2001/10/xml-exc-c14n#';
+const ENVELOPED_SIGNATURE = 'http://www.w3.org/2000/09/xmldsig#enveloped-signature';
+
+const SIGNATURE_ALGORITHMS = {
+  'http://www.w3.org/2001/04/xmldsig-more#rsa-sha256': { hash: 'sha256' },
+  'http://www.w3.org/2001/04/xmldsig-more#rsa-sha512': { hash: 'sha512' },
+  // XML-DSig carries ECDSA signatures as r || s
+  'http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256': { hash: 'sha256', dsaEncoding: 'ieee-p1363' },
+};
+
+const DIGEST_ALGORITHMS = {
+  'http://www.w3.org/2001/04/xmlenc#sha256': 'sha256',
+  'http://www.w3.org/2001/04/xmlenc#sha512': 'sha512',
+};
+
+const escapeText = (value) => value
+  .replace(/&/g, '&amp;')
+  .replace(/</g, '&lt;')
+  .replace(/>/g, '&gt;')
+  .replace(/\r/g, '&#xD;');
+
+const escapeAttribute = (value) => value
+  .replace(/&/g, '&amp;')
+  .replace(/</g, '&lt;')
+  .replace(/"/g, '&quot;')
+  .replace(/\t/g, '&#x9;')
+  .replace(/\n/g, '&#xA;')
+  .replace(/\r/g, '&#xD;');
+
+const isNamespaceDeclaration = (name) => name === 'xmlns' || name.startsWith('xmlns:');
+
+const attributePrefix = (name) => (name.includes(':') ? name.slice(0, name.indexOf(':')) : '');
+
+// Exclusive XML Canonicalization 1.0 without comments of an element subtree.
+// omit is left out of the output (the enveloped-signature transform);
+// inclusivePrefixes is the InclusiveNamespaces PrefixList.
+function canonicalize(element, { omit = null, inclusivePrefixes = [] } = {}) {
+  const output = [];
+
+  const render = (node, rendered) => {
+    const visible = new Set([node.prefix]);
+    for (const { name } of node.attributes) {
+      const prefix = attributePrefix(name);
+      if (prefix && prefix !== 'xml' && !isNamespaceDeclaration(name)) visible.add(prefix);
+    }
+    for (const prefix of inclusivePrefixes) {
+      const key = prefix === '#default' ? '' : prefix;
+      if (node.lookupNamespace(key) !== undefined) visible.add(key);
+    }
+
+    const declarations = [];
+    for (const prefix of visible) {
+      const uri = node.lookupNamespace(prefix) || '';
+      const inherited = prefix ? rendered.get(prefix) : rendered.get('') || '';
+      if (inherited !== uri) {
+        declarations.push([prefix, uri]);
+      }
+    }
+    declarations.sort(([a], [b]) => (a < b ? -1 : a > b ? 1 : 0));
+
+    const attributes = node.attributes
+      .filter(({ name }) => !isNamespaceDeclaration(name))
+      .map(({ name, value }) => {
+        const prefix = attributePrefix(name);
+        const uri = !prefix ? '' : prefix === 'xml' ? XML_NAMESPACE : node.lookupNamespace(prefix);
+        return { name, value, uri, localName: name.slice(name.indexOf(':') + 1) };
+      })
+      .sort((a, b) => (a.uri === b.uri
+        ? (a.localName < b.localName ? -1 : a.localName > b.localName ? 1 : 0)
+        : (a.uri < b.uri ? -1 : 1)));
+
+    output.push(`<${node.name}`);
+    for (const [prefix, uri] of declarations) {
+      output.push(` ${prefix ? `xmlns:${prefix}` : 'xmlns'}="${escapeAttribute(uri)}"`);
+    }
+    for (const { name, value } of attributes) {
+      output.push(` ${name}="${escapeAttribute(value)}"`);
+    }
+    output.push('>');
+
+    const inner = new Map(rendered);
+    for (const [prefix, uri] of declarations) inner.set(prefix, uri);
+    for (const child of node.children) {
+      if (typeof child === 'string') {
+        output.push(escapeText(child));
+      } else if (child !== omit) {
+        render(child, inner);
+      }
+    }
+    output.push(`</${node.name}>`);
+  };
+
+  render(element, new Map());
+  return output.join('');
+}
+
+const inclusivePrefixesOf = (transform) => {
+  const list = transform.child(EXC_C14N, 'InclusiveNamespaces');
+  return list ? (list.getAttribute('PrefixList') || '').split(/\s+/).filter(Boolean) : [];
+};
+
+const requireChild = (element, localName) => {
+  const found = element.child(DSIG_NAMESPACE, localName);
+  if (!found) {
+    throw new Error(`Signature has no ${localName}`);
+  }
+  return found;
+};
+
+// The Signature element directly inside element, or null
+function findSignature(element) {
+  return element.child(DSIG_NAMESPACE, 'Signature');
+}
+
+// Checks that signature is an enveloped signature over exactly element, made
+// with one of the trusted public keys. Certificates inside the signature's
+// KeyInfo are ignored: trust comes only from configuration.
+function verifyEnvelopedSignature(element, signature, publicKeys) {
+  if (!(signature instanceof XmlElement) || signature.parent !== element) {
+    throw new Error('Signature is not enveloped in the signed element');
+  }
+  const id = element.getAttribute('ID');
+  const signedInfo = requireChild(signature, 'SignedInfo');
+
+  const canonicalization = requireChild(signedInfo, 'CanonicalizationMethod');
+  if (canonicalization.getAttribute('Algorithm') !== EXC_C14N) {
+    throw new Error('Unsupported canonicalization algorithm');
+  }
+  const signatureMethod = SIGNATURE_ALGORITHMS[requireChild(signedInfo, 'SignatureMethod').getAttribute('Algorithm')];
+  if (!signatureMethod) {
+    throw new Error('Unsupported signature algorithm');
+  }
+
+  const references = signedInfo.childrenNamed(DSIG_NAMESPACE, 'Reference');
+  if (references.length !== 1 || !id || references[0].getAttribute('URI') !== `#${id}`) {
+    throw new Error('Signature does not reference the signed element');
+  }
+  const reference = references[0];
+  const transforms = reference.child(DSIG_NAMESPACE, 'Transforms');
+  const transformList = transforms ? transforms.childrenNamed(DSIG_NAMESPACE, 'Transform') : [];
+  const algorithms = transformList.map((transform) => transform.getAttribute('Algorithm'));
+  if (!algorithms.includes(ENVELOPED_SIGNATURE) || !algorithms.includes(EXC_C14N)
+    || algorithms.some((algorithm) => ![ENVELOPED_SIGNATURE, EXC_C14N].includes(algorithm))) {
+    throw new Error('Unsupported signature transforms');
+  }
+  const digestAlgorithm = DIGEST_ALGORITHMS[requireChild(reference, 'DigestMethod').getAttribute('Algorithm')];
+  if (!digestAlgorithm) {
+    throw new Error('Unsupported digest algorithm');
+  }
+
+  const c14nTransform = transformList.find((transform) => transform.getAttribute('Algorithm') === EXC_C14N);
+  const digest = crypto.createHash(digestAlgorithm)
+    .update(canonicalize(element, { omit: signature, inclusivePrefixes: inclusivePrefixesOf(c14nTransform) }))
+    .digest();
+  const expectedDigest = Buffer.from(requireChild(reference, 'DigestValue').textContent().replace(/\s+/g, ''), 'base64');
+  if (digest.length !== expectedDigest.length || !crypto.timingSafeEqual(digest, expectedDigest)) {
+    throw new Error('Signed content has been modified');
+  }
+
+  const signedBytes = Buffer.from(canonicalize(signedInfo, { inclusivePrefixes: inclusivePrefixesOf(canonicalization) }));
+  const signatureValue = Buffer.from(requireChild(signature, 'SignatureValue').textContent().replace(/\s+/g, ''), 'base64');
+  const valid = publicKeys.some((key) => crypto.verify(
+    signatureMethod.hash,
+    signedBytes,
+    signatureMethod.dsaEncoding ? { key, dsaEncoding: signatureMethod.dsaEncoding } : key,
+    signatureValue,
+  ));
+  if (!valid) {
+    throw new Error('Invalid signature');
+  }
+}
+
+module.exports = {
+  DSIG_NAMESPACE,
+  EXC_C14N,
+  ENVELOPED_SIGNATURE,
+  canonicalize,
+  findSignature,
+  verifyEnvelopedSignature,
+};
@@ -10,9 +10,15 @@ import AdminDashboard from './pages/AdminDashboard';
 import SuperAdminDashboard from './pages/SuperAdminDashboard';
 import PatientProfile from './pages/PatientProfile';
 import Transactions from './pages/Transactions';
+import OAuthAuthorize from './pages/OAuthAuthorize';
+import ForgotPassword from './pages/ForgotPassword';
+import ResetPassword from './pages/ResetPassword';
+import VerifyEmail from './pages/VerifyEmail';
+import FederatedLogin from './pages/FederatedLogin';
 
-import GoogleLoginButton from './components/GoogleLoginButton';
-import { loginWithGoogle } from './api/auth';
+import IdentityProviderButtons from './components/IdentityProviderButtons';
+import ImpersonationBanner from './components/ImpersonationBanner';
+import { fetchEffectivePermissions, scheduleTokenRefresh, clearSession } from './api/auth';
 
 import RoleBasedRoute from './routes/RoleBasedRoute';
 import DashboardRedirect from './routes/DashboardRedirect';
@@ -24,22 +30,6 @@ function Home() {
   const theme = useTheme();
   const isMobile = useMediaQuery(theme.breakpoints.down('sm'));
 
-  const handleGoogleSuccess = async (tokenResponse) => {
-    setError('');
-    try {
-      const data = await loginWithGoogle(tokenResponse.access_token);
-      localStorage.setItem('token', data.token);
-      localStorage.setItem('user', JSON.stringify(data.user));
-      navigate('/');
-    } catch (err) {
-      setError(err.message);
-    }
-  };
-
-  const handleGoogleError = (error) => {
-    setError('Google login failed');
-  };
-
   return (
     <Box
       sx={{
@@ -151,7 +141,7 @@ function Home() {
             >
               Join as a Doctor
             </Button>
-            <GoogleLoginButton onSuccess={handleGoogleSuccess} onError={handleGoogleError} />
+            <IdentityProviderButtons onError={setError} />
           </Stack>
           {error && (
             <Typography
@@ -189,6 +179,7 @@ function App() {
   useEffect(() => {
     const token = localStorage.getItem('token');
     if (token) {
+      scheduleTokenRefresh();
       try {
         const decoded = jwtDecode(token);
         setUserPermissions(decoded.permissions || []);
@@ -196,6 +187,9 @@ function App() {
         console.error('Failed to decode token:', error);
         setUserPermissions([]);
       }
+      fetchEffectivePermissions()
+        .then(setUserPermissions)
+        .catch((error) => console.error('Failed to fetch permissions:', error));
       if (window.location.pathname === '/') {
         navigate('/dashboard');
       }
@@ -204,8 +198,7 @@ function App() {
   }, [navigate]);
 
   const handleLogout = () => {
-    localStorage.removeItem('token');
-    localStorage.removeItem('user');
+    clearSession();
     setIsAuthenticated(false);
     setUserPermissions([]);
     navigate('/');
@@ -221,6 +214,7 @@ function App() {
 
   return (
     <>
+      <ImpersonationBanner />
       {!isDashboardRoute && (
         <AppBar
           position="static"
@@ -322,6 +316,11 @@ function App() {
       <Routes>
         <Route path="/" element={<Home />} />
         <Route path="/login" element={<Login />} />
+        <Route path="/login/federated" element={<FederatedLogin />} />
+        <Route path="/oauth/authorize" element={<OAuthAuthorize />} />
+        <Route path="/forgot-password" element={<ForgotPassword />} />
+        <Route path="/reset-password" element={<ResetPassword />} />
+        <Route path="/verify-email" element={<VerifyEmail />} />
         <Route path="/signup" element={<SignUp />} />
         <Route path="/doctor-signup" element={<DoctorSignUp />} />
         <Route path="/dashboard" element={
@@ -1,7 +1,10 @@
+import { authFetch } from './auth';
+
+// The appointment service only accepts requests signed in through the auth service
 const API_BASE_URL = process.env.REACT_APP_APPOINTMENT_SERVICE_URL || 'http://localhost:8080/api/appointments';
 
 export async function fetchAppointmentsByPatient(patientId) {
-  const response = await fetch(`${API_BASE_URL}/patient/${patientId}`);
+  const response = await authFetch(`${API_BASE_URL}/patient/${patientId}`);
   if (!response.ok) {
     throw new Error('Failed to fetch appointments');
   }
@@ -9,7 +12,7 @@ export async function fetchAppointmentsByPatient(patientId) {
 }
 
 export async function createAppointment(appointmentData) {
-  const response = await fetch(`${API_BASE_URL}`, {
+  const response = await authFetch(`${API_BASE_URL}`, {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify(appointmentData),
@@ -21,7 +24,7 @@ export async function createAppointment(appointmentData) {
 }
 
 export async function updateAppointmentStatus(appointmentId, status, extraData = {}) {
-  const response = await fetch(`${API_BASE_URL}/${appointmentId}/confirm`, {
+  const response = await authFetch(`${API_BASE_URL}/${appointmentId}/confirm`, {
     method: 'PUT',
     headers: { 'Content-Type': 'application/json' },
     body: JSON.stringify({ ...extraData }),
@@ -33,7 +36,7 @@ export async function updateAppointmentStatus(appointmentId, status, extraData =
 }
 
 export async function deleteAppointment(appointmentId) {
-  const response = await fetch(`${API_BASE_URL}/${appointmentId}`, {
+  const response = await authFetch(`${API_BASE_URL}/${appointmentId}`, {
     method: 'DELETE',
   });
   if (!response.ok) {
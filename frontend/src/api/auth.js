@@ -75,30 +75,11 @@ export async function registerDoctor(doctorData) {
   return data;
 }
 
-export function getGoogleLoginUrl() {
-  return `${API_BASE_URL}/auth/google`;
-}
-
-export async function loginWithGoogle(accessToken) {
-  // Placeholder function to maintain compatibility with existing imports
-  // The new OAuth flow uses getGoogleLoginUrl and loginWithGoogleCallback instead
-  throw new Error("loginWithGoogle is deprecated. Use OAuth flow with getGoogleLoginUrl and loginWithGoogleCallback.");
-}
-
-export async function loginWithGoogleCallback(code) {
-  // This function should handle the OAuth callback with the code parameter
-  // Implementation depends on frontend OAuth flow handling
-  // Placeholder for now
-  throw new Error("Google login callback handling not implemented");
-}
-
 export async function fetchPatientById(patientId) {
-  const token = localStorage.getItem("token");
-  const response = await fetch(`${API_BASE_URL}/patients/${patientId}`, {
+  const response = await authFetch(`${API_BASE_URL}/patients/${patientId}`, {
     method: "GET",
     headers: {
       "Content-Type": "application/json",
-      Authorization: token ? `Bearer ${token}` : "",
     },
   });
 
@@ -121,13 +102,13 @@ export async function fetchPatientById(patientId) {
   return data;
 }
 
-export async function refreshToken(oldToken) {
+export async function refreshToken(currentRefreshToken) {
   const response = await fetch(`${API_BASE_URL}/auth/refresh`, {
     method: "POST",
     headers: {
       "Content-Type": "application/json",
     },
-    body: JSON.stringify({ token: oldToken }),
+    body: JSON.stringify({ refreshToken: currentRefreshToken }),
   });
 
   if (!response.ok) {
@@ -142,9 +123,390 @@ export async function refreshToken(oldToken) {
     } catch {
       errorMessage = errorText || errorMessage;
     }
+    const error = new Error(errorMessage);
+    error.status = response.status;
+    throw error;
+  }
+
+  // Refresh tokens rotate on every use, so callers must store both values
+  const data = await response.json();
+  return data;
+}
+
+// Access tokens last 15 minutes. The session is kept alive by trading the
+// refresh token for a new pair shortly before the access token expires, and
+// again whenever a request comes back 401.
+const REFRESH_MARGIN_MS = 60 * 1000;
+let refreshTimer = null;
+let refreshing = null;
+
+const tokenExpiresAt = (token) => {
+  try {
+    const payload = JSON.parse(atob(token.split(".")[1].replace(/-/g, "+").replace(/_/g, "/")));
+    return payload.exp * 1000;
+  } catch {
+    return 0;
+  }
+};
+
+export function clearSession() {
+  clearTimeout(refreshTimer);
+  for (const key of ["token", "refreshToken", "user", "patientId"]) {
+    localStorage.removeItem(key);
+  }
+}
+
+async function rotateTokens(staleToken) {
+  const current = localStorage.getItem("refreshToken");
+  // Signed out meanwhile (logout removes the access token)
+  if (!staleToken || !localStorage.getItem("token") || !current) {
+    throw new Error("Not signed in");
+  }
+  // Another tab may have rotated the pair already
+  if (localStorage.getItem("token") !== staleToken) {
+    return localStorage.getItem("token");
+  }
+  try {
+    const data = await refreshToken(current);
+    localStorage.setItem("token", data.token);
+    localStorage.setItem("refreshToken", data.refreshToken);
+    return data.token;
+  } catch (error) {
+    // A rejected refresh token (expired, revoked, or reused, which revokes
+    // the whole session) cannot be retried: sign out
+    if (error.status === 401) {
+      clearSession();
+      window.location.assign("/login");
+    }
+    throw error;
+  }
+}
+
+// Refresh tokens are single-use, so a second concurrent refresh would look
+// like reuse and end the session. Refreshes are serialized within the tab,
+// and across tabs where the browser supports locks.
+export function refreshSession() {
+  if (!refreshing) {
+    const staleToken = localStorage.getItem("token");
+    const run = () => rotateTokens(staleToken);
+    refreshing = (navigator.locks ? navigator.locks.request("token-refresh", run) : run())
+      .then((token) => {
+        scheduleTokenRefresh();
+        return token;
+      })
+      .finally(() => {
+        refreshing = null;
+      });
+  }
+  return refreshing;
+}
+
+// Call after storing a new session and when the app loads
+export function scheduleTokenRefresh() {
+  clearTimeout(refreshTimer);
+  const token = localStorage.getItem("token");
+  if (!token || !localStorage.getItem("refreshToken")) return;
+  const delay = Math.max(0, tokenExpiresAt(token) - Date.now() - REFRESH_MARGIN_MS);
+  refreshTimer = setTimeout(() => {
+    refreshSession().catch((error) => console.error("Failed to refresh session:", error));
+  }, delay);
+}
+
+// fetch with the stored access token; a 401 triggers one refresh and retry
+export async function authFetch(url, options = {}) {
+  const send = () => {
+    const token = localStorage.getItem("token");
+    const headers = { ...options.headers };
+    if (token) headers.Authorization = `Bearer ${token}`;
+    return fetch(url, { ...options, headers });
+  };
+  const response = await send();
+  if (response.status !== 401 || !localStorage.getItem("refreshToken")) {
+    return response;
+  }
+  try {
+    await refreshSession();
+  } catch {
+    return response;
+  }
+  return send();
+}
+
+export async function approveAuthorization(params) {
+  const response = await authFetch(`${API_BASE_URL.replace("/api/v1", "")}/oauth/authorize`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify(params),
+  });
+
+  if (!response.ok) {
+    let errorMessage = "Authorization failed";
+    const errorText = await response.clone().text();
+    try {
+      const errorData = JSON.parse(errorText);
+      errorMessage = errorData.error_description || errorData.message || errorMessage;
+    } catch {
+      errorMessage = errorText || errorMessage;
+    }
+    const error = new Error(errorMessage);
+    error.status = response.status;
+    throw error;
+  }
+
+  // { redirectTo } or { consentRequired, client, scopes }
+  return response.json();
+}
+
+async function postMfa(path, body, fallbackMessage) {
+  const response = await fetch(`${API_BASE_URL}/auth/mfa/${path}`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify(body),
+  });
+
+  if (!response.ok) {
+    let errorMessage = fallbackMessage;
+    const errorText = await response.clone().text();
+    try {
+      const errorData = JSON.parse(errorText);
+      errorMessage = errorData.message || errorMessage;
+    } catch {
+      errorMessage = errorText || errorMessage;
+    }
+    throw new Error(errorMessage);
+  }
+
+  return response.json();
+}
+
+export async function verifyMfa(mfaToken, { code, recoveryCode }) {
+  return postMfa("verify", { mfaToken, code, recoveryCode }, "Verification failed");
+}
+
+export async function beginMfaEnrollment(mfaToken) {
+  return postMfa("challenge/enroll", { mfaToken }, "Could not start MFA enrollment");
+}
+
+export async function confirmMfaEnrollment(mfaToken, code) {
+  return postMfa("challenge/enroll/confirm", { mfaToken, code }, "Verification failed");
+}
+
+const toBase64Url = (buffer) => btoa(String.fromCharCode(...new Uint8Array(buffer)))
+  .replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+
+const fromBase64Url = (value) => {
+  const base64 = value.replace(/-/g, "+").replace(/_/g, "/");
+  return Uint8Array.from(atob(base64.padEnd(Math.ceil(base64.length / 4) * 4, "=")), (c) => c.charCodeAt(0));
+};
+
+// Runs navigator.credentials.get with server options and returns a JSON-safe assertion
+async function getPasskeyAssertion(publicKey) {
+  const credential = await navigator.credentials.get({
+    publicKey: {
+      ...publicKey,
+      challenge: fromBase64Url(publicKey.challenge),
+      allowCredentials: (publicKey.allowCredentials || []).map((item) => ({ ...item, id: fromBase64Url(item.id) })),
+    },
+  });
+  return {
+    id: credential.id,
+    type: credential.type,
+    response: {
+      clientDataJSON: toBase64Url(credential.response.clientDataJSON),
+      authenticatorData: toBase64Url(credential.response.authenticatorData),
+      signature: toBase64Url(credential.response.signature),
+      userHandle: credential.response.userHandle ? toBase64Url(credential.response.userHandle) : undefined,
+    },
+  };
+}
+
+async function postAuth(path, body, fallbackMessage) {
+  const response = await authFetch(`${API_BASE_URL}/auth/${path}`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify(body),
+  });
+
+  if (!response.ok) {
+    let errorMessage = fallbackMessage;
+    const errorText = await response.clone().text();
+    try {
+      const errorData = JSON.parse(errorText);
+      errorMessage = errorData.message || errorMessage;
+    } catch {
+      errorMessage = errorText || errorMessage;
+    }
+    throw new Error(errorMessage);
+  }
+
+  return response.json();
+}
+
+export async function loginWithPasskey(email) {
+  const options = await postAuth("webauthn/login/options", { email }, "Passkey sign-in failed");
+  const credential = await getPasskeyAssertion(options.publicKey);
+  return postAuth("webauthn/login", { ceremonyId: options.ceremonyId, credential }, "Passkey sign-in failed");
+}
+
+export async function verifyMfaWithPasskey(mfaToken) {
+  const options = await postMfa("webauthn/options", { mfaToken }, "Passkey verification failed");
+  const credential = await getPasskeyAssertion(options.publicKey);
+  return postMfa("webauthn/verify", { mfaToken, ceremonyId: options.ceremonyId, credential }, "Passkey verification failed");
+}
+
+export async function registerPasskey(name) {
+  const options = await postAuth("webauthn/register/options", {}, "Could not start passkey registration");
+  const credential = await navigator.credentials.create({
+    publicKey: {
+      ...options,
+      challenge: fromBase64Url(options.challenge),
+      user: { ...options.user, id: fromBase64Url(options.user.id) },
+      excludeCredentials: options.excludeCredentials.map((item) => ({ ...item, id: fromBase64Url(item.id) })),
+    },
+  });
+  return postAuth("webauthn/register", {
+    name,
+    credential: {
+      id: credential.id,
+      type: credential.type,
+      response: {
+        clientDataJSON: toBase64Url(credential.response.clientDataJSON),
+        attestationObject: toBase64Url(credential.response.attestationObject),
+        transports: credential.response.getTransports ? credential.response.getTransports() : [],
+      },
+    },
+  }, "Passkey registration failed");
+}
+
+export async function requestPasswordReset(email) {
+  return postAuth("password/forgot", { email }, "Could not send reset email");
+}
+
+export async function resetPassword(token, password) {
+  return postAuth("password/reset", { token, password }, "Could not reset password");
+}
+
+export async function verifyEmail(token) {
+  return postAuth("email/verify", { token }, "Could not verify email");
+}
+
+export async function resendVerificationEmail(email) {
+  return postAuth("email/verify/resend", { email }, "Could not resend verification email");
+}
+
+export async function fetchIdentityProviders() {
+  const response = await fetch(`${API_BASE_URL}/auth/providers`);
+  if (!response.ok) {
+    throw new Error("Could not load sign-in providers");
+  }
+  return response.json();
+}
+
+// The callback page proves it belongs to this browser with the key kept here
+const FEDERATION_KEY = "federationBrowserKey";
+
+// SAML providers (hospital staff sign-in) have their own endpoints
+const loginPath = (protocol) => (protocol === "saml" ? "saml" : "providers");
+
+export async function beginProviderLogin(slug, returnTo, protocol = "oidc") {
+  const { authorizationUrl, browserKey } = await postAuth(`${loginPath(protocol)}/${slug}/login`, { returnTo }, "Could not start sign-in");
+  sessionStorage.setItem(FEDERATION_KEY, browserKey);
+  window.location.assign(authorizationUrl);
+}
+
+export async function beginIdentityLink(provider) {
+  const { authorizationUrl, browserKey } = await postAuth("identities", { provider }, "Could not start linking");
+  sessionStorage.setItem(FEDERATION_KEY, browserKey);
+  window.location.assign(authorizationUrl);
+}
+
+function takeFederationKey() {
+  const browserKey = sessionStorage.getItem(FEDERATION_KEY);
+  sessionStorage.removeItem(FEDERATION_KEY);
+  return browserKey;
+}
+
+export async function completeProviderLogin(code, protocol = "oidc") {
+  return postAuth(`${loginPath(protocol)}/complete`, { code, browserKey: takeFederationKey() }, "Sign-in failed");
+}
+
+export async function completeIdentityLink(code) {
+  return postAuth("identities/complete", { code, browserKey: takeFederationKey() }, "Could not link identity");
+}
+
+// Tokens carry permissions compressed (doctor:*, implied ones left out);
+// the server spells them out for permission checks in the UI
+export async function fetchEffectivePermissions() {
+  const response = await authFetch(`${API_BASE_URL}/auth/permissions`);
+  if (!response.ok) {
+    throw new Error("Failed to fetch permissions");
+  }
+  const data = await response.json();
+  return data.permissions;
+}
+
+// Support staff keep their own session here while acting as another user
+const IMPERSONATOR_SESSION = "impersonatorSession";
+
+export async function startImpersonation(userId, role, reason) {
+  const token = localStorage.getItem("token");
+  const response = await fetch(`${API_BASE_URL}/admins/users/${userId}/impersonate`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: JSON.stringify({ role, reason }),
+  });
+
+  if (!response.ok) {
+    let errorMessage = "Could not start impersonation";
+    const errorText = await response.clone().text();
+    try {
+      const errorData = JSON.parse(errorText);
+      errorMessage = errorData.message || errorMessage;
+    } catch {
+      errorMessage = errorText || errorMessage;
+    }
     throw new Error(errorMessage);
   }
 
   const data = await response.json();
-  return data.token;
+  localStorage.setItem(IMPERSONATOR_SESSION, JSON.stringify({
+    token,
+    refreshToken: localStorage.getItem("refreshToken"),
+    user: localStorage.getItem("user"),
+    patientId: localStorage.getItem("patientId"),
+  }));
+  localStorage.setItem("token", data.token);
+  localStorage.removeItem("refreshToken");
+  localStorage.setItem("user", JSON.stringify(data.user));
+  localStorage.setItem("patientId", data.user.patientId);
+  return data;
+}
+
+// Ends the impersonation and puts the staff member's own session back
+export async function endImpersonation() {
+  const token = localStorage.getItem("token");
+  await fetch(`${API_BASE_URL}/auth/impersonation`, {
+    method: "DELETE",
+    headers: { Authorization: `Bearer ${token}` },
+  }).catch(() => {});
+
+  const saved = JSON.parse(localStorage.getItem(IMPERSONATOR_SESSION) || "null");
+  localStorage.removeItem(IMPERSONATOR_SESSION);
+  for (const key of ["token", "refreshToken", "user", "patientId"]) {
+    if (saved && saved[key]) {
+      localStorage.setItem(key, saved[key]);
+    } else {
+      localStorage.removeItem(key);
+    }
+  }
+  scheduleTokenRefresh();
 }
@@ -1,15 +1,14 @@
-const API_BASE_URL = process.env.REACT_APP_AUTH_SERVICE_URL || 'http://localhost:8000/api/v1'; // Adjust port as needed
+import { authFetch } from './auth';
 
-// Helper to get auth token from localStorage
-const getAuthToken = () => localStorage.getItem('token');
+const API_BASE_URL = process.env.REACT_APP_AUTH_SERVICE_URL || 'http://localhost:8000/api/v1'; // Adjust port as needed
 
+// authFetch adds the stored access token and refreshes it when it has expired
 const headers = () => ({
   'Content-Type': 'application/json',
-  Authorization: 'Bearer ' + getAuthToken(),
 });
 
 export const fetchDoctors = async () => {
-  const response = await fetch(API_BASE_URL + '/doctors', {
+  const response = await authFetch(API_BASE_URL + '/doctors', {
     headers: headers(),
   });
   if (!response.ok) {
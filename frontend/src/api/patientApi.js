@@ -1,15 +1,14 @@
-const API_BASE_URL = process.env.REACT_APP_AUTH_SERVICE_URL || 'http://localhost:8000';
+import { authFetch } from './auth';
 
-// Helper to get auth token from localStorage
-const getAuthToken = () => localStorage.getItem('token');
+const API_BASE_URL = process.env.REACT_APP_AUTH_SERVICE_URL || 'http://localhost:8000';
 
+// authFetch adds the stored access token and refreshes it when it has expired
 const headers = () => ({
   'Content-Type': 'application/json',
-  Authorization: 'Bearer ' + getAuthToken(),
 });
 
 export const fetchPatientProfile = async (patientId) => {
-  const response = await fetch(`${API_BASE_URL}/patients/${patientId}`, {
+  const response = await authFetch(`${API_BASE_URL}/patients/${patientId}`, {
     headers: headers(),
   });
   if (!response.ok) {
@@ -19,7 +18,7 @@ export const fetchPatientProfile = async (patientId) => {
 };
 
 export const updatePatientProfile = async (patientId, profileData) => {
-  const response = await fetch(`${API_BASE_URL}/patients/${patientId}`, {
+  const response = await authFetch(`${API_BASE_URL}/patients/${patientId}`, {
     method: 'PUT',
     headers: headers(),
     body: JSON.stringify(profileData),
@@ -1,15 +1,14 @@
-const API_BASE_URL = process.env.REACT_APP_APPOINTMENT_SERVICE_URL || 'http://localhost:8080';
+import { authFetch } from './auth';
 
-// Helper to get auth token from localStorage
-const getAuthToken = () => localStorage.getItem('token');
+const API_BASE_URL = process.env.REACT_APP_APPOINTMENT_SERVICE_URL || 'http://localhost:8080';
 
+// authFetch adds the stored access token and refreshes it when it has expired
 const headers = () => ({
   'Content-Type': 'application/json',
-  Authorization: 'Bearer ' + getAuthToken(),
 });
 
 export const fetchPrescriptionsByPatient = async (patientId) => {
-  const response = await fetch(`${API_BASE_URL}/prescriptions/${patientId}`, {
+  const response = await authFetch(`${API_BASE_URL}/prescriptions/${patientId}`, {
     headers: headers(),
   });
   if (!response.ok) {
@@ -19,7 +18,7 @@ export const fetchPrescriptionsByPatient = async (patientId) => {
 };
 
 export const createPrescription = async (prescriptionData) => {
-  const response = await fetch(`${API_BASE_URL}/prescriptions`, {
+  const response = await authFetch(`${API_BASE_URL}/prescriptions`, {
     method: 'POST',
     headers: headers(),
     body: JSON.stringify(prescriptionData),
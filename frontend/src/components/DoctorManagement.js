@@ -17,6 +17,7 @@ import {
   TextField,
   Tooltip,
 } from '@mui/material';
+import { startImpersonation } from '../api/auth';
 
 const DoctorManagement = ({ userPermissions = [] }) => {
   const [doctors, setDoctors] = useState([]);
@@ -119,6 +120,17 @@ const DoctorManagement = ({ userPermissions = [] }) => {
     }
   };
 
+  const handleImpersonate = async (doctor) => {
+    const reason = window.prompt(`Why do you need to view the app as ${doctor.name}? This is recorded.`);
+    if (!reason) return;
+    try {
+      await startImpersonation(doctor._id, 'doctor', reason);
+      window.location.assign('/dashboard');
+    } catch (err) {
+      setError(err.message);
+    }
+  };
+
   const handleDelete = async (doctorId) => {
     if (!window.confirm('Are you sure you want to delete this doctor?')) return;
     try {
@@ -321,9 +333,18 @@ const DoctorManagement = ({ userPermissions = [] }) => {
                   <Button
                     color="error"
                     onClick={() => handleDelete(doctor._id)}
+                    sx={{ mr: 1 }}
                   >
                     Delete
                   </Button>
+                  {userPermissions.includes('user:impersonate') && (
+                    <Button
+                      color="warning"
+                      onClick={() => handleImpersonate(doctor)}
+                    >
+                      View as doctor
+                    </Button>
+                  )}
                 </TableCell>
               </TableRow>
             ))}
@@ -0,0 +1,56 @@
+import React, { useEffect, useState } from 'react';
+import { Button, Box, Stack } from '@mui/material';
+import { fetchIdentityProviders, beginProviderLogin } from '../api/auth';
+
+const PROVIDER_ICONS = {
+  google: '/7123025_logo_google_g_icon.png',
+};
+
+// One "Continue with ..." button per identity provider configured on the server
+function IdentityProviderButtons({ returnTo, onError }) {
+  const [providers, setProviders] = useState([]);
+
+  useEffect(() => {
+    fetchIdentityProviders()
+      .then(setProviders)
+      .catch(() => setProviders([]));
+  }, []);
+
+  const handleClick = async (provider) => {
+    try {
+      await beginProviderLogin(provider.slug, returnTo, provider.protocol);
+    } catch (err) {
+      onError(err.message);
+    }
+  };
+
+  if (providers.length === 0) {
+    return null;
+  }
+
+  return (
+    <Stack spacing={1}>
+      {providers.map((provider) => (
+        <Button
+          key={`${provider.protocol}:${provider.slug}`}
+          variant="outlined"
+          color="secondary"
+          fullWidth
+          onClick={() => handleClick(provider)}
+          startIcon={PROVIDER_ICONS[provider.preset] && (
+            <Box
+              component="img"
+              src={PROVIDER_ICONS[provider.preset]}
+              alt=""
+              sx={{ width: 20, height: 20 }}
+            />
+          )}
+        >
+          Continue with {provider.displayName}
+        </Button>
+      ))}
+    </Stack>
+  );
+}
+
+export default IdentityProviderButtons;
@@ -0,0 +1,37 @@
+import React from 'react';
+import { Alert, Button } from '@mui/material';
+import { endImpersonation } from '../api/auth';
+
+// Shown on every page while support staff are signed in as another user
+function ImpersonationBanner() {
+  let user = null;
+  try {
+    user = JSON.parse(localStorage.getItem('user'));
+  } catch {
+    user = null;
+  }
+  if (!user || !user.impersonatedBy) {
+    return null;
+  }
+
+  const handleStop = async () => {
+    await endImpersonation();
+    window.location.assign('/dashboard');
+  };
+
+  return (
+    <Alert
+      severity="warning"
+      square
+      action={
+        <Button color="inherit" size="small" onClick={handleStop}>
+          Stop impersonating
+        </Button>
+      }
+    >
+      {user.impersonatedBy.email} is viewing the app as {user.email}. Every action is recorded.
+    </Alert>
+  );
+}
+
+export default ImpersonationBanner;
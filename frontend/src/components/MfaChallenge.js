@@ -0,0 +1,119 @@
+import React, { useEffect, useState } from 'react';
+import { Alert, Box, Button, Stack, TextField, Typography, Link } from '@mui/material';
+import { beginMfaEnrollment, confirmMfaEnrollment, verifyMfa, verifyMfaWithPasskey } from '../api/auth';
+
+// Second login step for accounts with (or required to have) a second factor.
+// Calls onComplete with the login response once a factor is accepted.
+function MfaChallenge({ mfaToken, enrollmentRequired, methods = [], onComplete }) {
+  const [code, setCode] = useState('');
+  const [useRecoveryCode, setUseRecoveryCode] = useState(false);
+  const [enrollment, setEnrollment] = useState(null);
+  const [recoveryCodes, setRecoveryCodes] = useState(null);
+  const [pendingLogin, setPendingLogin] = useState(null);
+  const [error, setError] = useState('');
+
+  useEffect(() => {
+    if (enrollmentRequired) {
+      beginMfaEnrollment(mfaToken).then(setEnrollment).catch((err) => setError(err.message));
+    }
+  }, [mfaToken, enrollmentRequired]);
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setError('');
+    try {
+      if (enrollmentRequired) {
+        const data = await confirmMfaEnrollment(mfaToken, code);
+        // Show the recovery codes once before continuing
+        setRecoveryCodes(data.recoveryCodes);
+        setPendingLogin(data);
+        return;
+      }
+      const data = await verifyMfa(mfaToken, useRecoveryCode ? { recoveryCode: code } : { code });
+      onComplete(data);
+    } catch (err) {
+      setError(err.message);
+    }
+  };
+
+  const handlePasskey = async () => {
+    setError('');
+    try {
+      onComplete(await verifyMfaWithPasskey(mfaToken));
+    } catch (err) {
+      setError(err.message);
+    }
+  };
+
+  if (recoveryCodes) {
+    return (
+      <Stack spacing={2}>
+        <Alert severity="warning">
+          Save these recovery codes somewhere safe. Each one can be used once if you lose your authenticator.
+        </Alert>
+        <Box component="pre" sx={{ p: 2, bgcolor: 'grey.100', borderRadius: 1 }}>
+          {recoveryCodes.join('\n')}
+        </Box>
+        <Button variant="contained" onClick={() => onComplete(pendingLogin)}>
+          Continue
+        </Button>
+      </Stack>
+    );
+  }
+
+  return (
+    <form onSubmit={handleSubmit}>
+      <Stack spacing={2}>
+        {error && <Alert severity="error">{error}</Alert>}
+        {enrollmentRequired ? (
+          <>
+            <Typography variant="body2">
+              Your account requires two-factor authentication. Add this account to your authenticator app,
+              then enter the 6-digit code it shows.
+            </Typography>
+            {enrollment && (
+              <>
+                <Link href={enrollment.provisioningUri} sx={{ wordBreak: 'break-all' }}>
+                  {enrollment.provisioningUri}
+                </Link>
+                <Typography variant="body2">
+                  Setup key: <strong>{enrollment.secret}</strong>
+                </Typography>
+              </>
+            )}
+          </>
+        ) : (
+          <Typography variant="body2">
+            {useRecoveryCode
+              ? 'Enter one of your recovery codes.'
+              : 'Enter the 6-digit code from your authenticator app.'}
+          </Typography>
+        )}
+        <TextField
+          label={useRecoveryCode ? 'Recovery code' : 'Verification code'}
+          value={code}
+          onChange={(e) => setCode(e.target.value.trim())}
+          required
+          fullWidth
+          autoComplete="one-time-code"
+          inputProps={useRecoveryCode ? {} : { inputMode: 'numeric', maxLength: 6 }}
+        />
+        <Button type="submit" variant="contained" size="large" fullWidth>
+          Verify
+        </Button>
+        {methods.includes('webauthn') && (
+          <Button variant="outlined" fullWidth onClick={handlePasskey}>
+            Use a passkey
+          </Button>
+        )}
+        {!enrollmentRequired && (
+          <Button size="small" onClick={() => { setUseRecoveryCode((value) => !value); setCode(''); }}>
+            {useRecoveryCode ? 'Use authenticator code' : 'Use a recovery code'}
+          </Button>
+        )}
+      </Stack>
+    </form>
+  );
+}
+
+export default MfaChallenge;
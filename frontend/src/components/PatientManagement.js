@@ -17,6 +17,7 @@ import {
   TextField,
   Tooltip,
 } from '@mui/material';
+import { startImpersonation } from '../api/auth';
 
 const PatientManagement = ({ userPermissions = [] }) => {
   const [patients, setPatients] = useState([]);
@@ -59,6 +60,17 @@ const PatientManagement = ({ userPermissions = [] }) => {
     }
   };
 
+  const handleImpersonate = async (patient) => {
+    const reason = window.prompt(`Why do you need to view the app as ${patient.name}? This is recorded.`);
+    if (!reason) return;
+    try {
+      await startImpersonation(patient._id, 'patient', reason);
+      window.location.assign('/dashboard');
+    } catch (err) {
+      setError(err.message);
+    }
+  };
+
   const handleDelete = async (patientId) => {
     if (!window.confirm('Are you sure you want to delete this patient?')) return;
     try {
@@ -193,9 +205,18 @@ const PatientManagement = ({ userPermissions = [] }) => {
                   <Button
                     color="error"
                     onClick={() => handleDelete(patient._id)}
+                    sx={{ mr: 1 }}
                   >
                     Delete
                   </Button>
+                  {userPermissions.includes('user:impersonate') && (
+                    <Button
+                      color="warning"
+                      onClick={() => handleImpersonate(patient)}
+                    >
+                      View as patient
+                    </Button>
+                  )}
                 </TableCell>
               </TableRow>
             ))}
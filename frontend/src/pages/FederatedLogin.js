@@ -0,0 +1,85 @@
+import React, { useEffect, useRef, useState } from 'react';
+import { Link as RouterLink, useLocation, useNavigate } from 'react-router-dom';
+import { Container, Paper, Typography, Alert, Link, CircularProgress } from '@mui/material';
+import { completeProviderLogin, completeIdentityLink, scheduleTokenRefresh } from '../api/auth';
+import MfaChallenge from '../components/MfaChallenge';
+
+// Where the auth service sends the browser back after an external identity
+// provider; finishes the sign-in or the linking of an identity
+function FederatedLogin() {
+  const location = useLocation();
+  const navigate = useNavigate();
+  const params = new URLSearchParams(location.search);
+  const code = params.get('code');
+  const intent = params.get('intent');
+  const protocol = params.get('protocol') || 'oidc';
+  const returnTo = params.get('returnTo');
+  const nextPath = returnTo && returnTo.startsWith('/') && !returnTo.startsWith('//') ? returnTo : '/dashboard';
+  const [error, setError] = useState(params.get('error') || '');
+  const [linked, setLinked] = useState(false);
+  const [mfaChallenge, setMfaChallenge] = useState(null);
+  // The code is single-use; don't redeem it twice under StrictMode
+  const redeemed = useRef(false);
+
+  const completeLogin = (data) => {
+    localStorage.setItem('token', data.token);
+    localStorage.setItem('refreshToken', data.refreshToken);
+    localStorage.setItem('user', JSON.stringify(data.user));
+    localStorage.setItem('patientId', data.user.patientId);
+    scheduleTokenRefresh();
+    navigate(nextPath);
+  };
+
+  useEffect(() => {
+    if (!code || redeemed.current) return;
+    redeemed.current = true;
+    if (intent === 'link') {
+      completeIdentityLink(code)
+        .then(() => setLinked(true))
+        .catch((err) => setError(err.message));
+      return;
+    }
+    completeProviderLogin(code, protocol)
+      .then((data) => {
+        if (data.mfaRequired) {
+          setMfaChallenge(data);
+          return;
+        }
+        completeLogin(data);
+      })
+      .catch((err) => setError(err.message));
+  }, [code, intent, protocol]);
+
+  return (
+    <Container maxWidth="sm">
+      <Paper elevation={6} sx={{ p: 4, mt: 8, borderRadius: 3 }}>
+        <Typography variant="h5" fontWeight={700} gutterBottom>
+          {intent === 'link' ? 'Link account' : 'Signing in'}
+        </Typography>
+        {error && (
+          <Alert severity="error">
+            {error}{' '}
+            <Link component={RouterLink} to={intent === 'link' ? '/dashboard' : '/login'}>Back</Link>
+          </Alert>
+        )}
+        {linked && (
+          <Alert severity="success">
+            The identity is now linked to your account.{' '}
+            <Link component={RouterLink} to="/dashboard">Continue</Link>
+          </Alert>
+        )}
+        {mfaChallenge && (
+          <MfaChallenge
+            mfaToken={mfaChallenge.mfaToken}
+            enrollmentRequired={mfaChallenge.enrollmentRequired}
+            methods={mfaChallenge.methods}
+            onComplete={completeLogin}
+          />
+        )}
+        {!error && !linked && !mfaChallenge && <CircularProgress />}
+      </Paper>
+    </Container>
+  );
+}
+
+export default FederatedLogin;
@@ -0,0 +1,60 @@
+import React, { useState } from 'react';
+import { Link as RouterLink } from 'react-router-dom';
+import { Container, Paper, Typography, TextField, Button, Alert, Stack, Link } from '@mui/material';
+import { requestPasswordReset } from '../api/auth';
+
+function ForgotPassword() {
+  const [email, setEmail] = useState('');
+  const [message, setMessage] = useState('');
+  const [error, setError] = useState('');
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setError('');
+    try {
+      const data = await requestPasswordReset(email);
+      setMessage(data.message);
+    } catch (err) {
+      setError(err.message);
+    }
+  };
+
+  return (
+    <Container maxWidth="sm">
+      <Paper elevation={6} sx={{ p: 4, mt: 8, borderRadius: 3 }}>
+        <Typography variant="h5" fontWeight={700} gutterBottom>
+          Forgot your password?
+        </Typography>
+        {message ? (
+          <Alert severity="success">{message}</Alert>
+        ) : (
+          <form onSubmit={handleSubmit}>
+            <Stack spacing={2}>
+              <Typography variant="body2" color="text.secondary">
+                Enter your email and we will send you a link to choose a new password.
+              </Typography>
+              {error && <Alert severity="error">{error}</Alert>}
+              <TextField
+                label="Email"
+                type="email"
+                value={email}
+                onChange={(e) => setEmail(e.target.value)}
+                required
+                fullWidth
+                autoComplete="email"
+              />
+              <Button type="submit" variant="contained" size="large" fullWidth>
+                Send reset link
+              </Button>
+            </Stack>
+          </form>
+        )}
+        <Link component={RouterLink} to="/login" underline="hover" sx={{ display: 'block', mt: 2 }}>
+          Back to sign in
+        </Link>
+      </Paper>
+    </Container>
+  );
+}
+
+export default ForgotPassword;
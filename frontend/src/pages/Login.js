@@ -1,5 +1,5 @@
 import React, { useState, useEffect } from 'react';
-import { useNavigate, Link as RouterLink } from 'react-router-dom';
+import { useNavigate, useLocation, Link as RouterLink } from 'react-router-dom';
 import {
   Container,
   Typography,
@@ -15,55 +15,67 @@ import {
   Link,
 } from '@mui/material';
 import { Visibility, VisibilityOff, LockOutlined } from '@mui/icons-material';
-import { login, loginWithGoogle } from '../api/auth';
-import GoogleLoginButton from '../components/GoogleLoginButton';
+import { login, loginWithPasskey, scheduleTokenRefresh } from '../api/auth';
+import IdentityProviderButtons from '../components/IdentityProviderButtons';
+import MfaChallenge from '../components/MfaChallenge';
 
 function Login() {
   const [email, setEmail] = useState('');
   const [password, setPassword] = useState('');
   const [showPassword, setShowPassword] = useState(false);
   const [error, setError] = useState('');
+  const [mfaChallenge, setMfaChallenge] = useState(null);
   const navigate = useNavigate();
+  const location = useLocation();
+  // Only follow same-origin paths, e.g. back to /oauth/authorize
+  const returnTo = new URLSearchParams(location.search).get('returnTo');
+  const nextPath = returnTo && returnTo.startsWith('/') && !returnTo.startsWith('//') ? returnTo : '/dashboard';
 
   useEffect(() => {
     // Redirect to dashboard if user is already logged in
     const token = localStorage.getItem('token');
     if (token) {
-      navigate('/dashboard');
+      navigate(nextPath);
     }
-  }, [navigate]);
+  }, [navigate, nextPath]);
+
+  const completeLogin = (data) => {
+    localStorage.setItem('token', data.token);
+    localStorage.setItem('refreshToken', data.refreshToken);
+    localStorage.setItem('user', JSON.stringify(data.user));
+    localStorage.setItem('patientId', data.user.patientId); // Store patientId in localStorage
+    scheduleTokenRefresh();
+    navigate(nextPath);
+  };
 
   const handleSubmit = async (e) => {
     e.preventDefault();
     setError('');
     try {
       const data = await login(email, password);
-      localStorage.setItem('token', data.token);
-      localStorage.setItem('user', JSON.stringify(data.user));
-      localStorage.setItem('patientId', data.user.patientId); // Store patientId in localStorage
-      navigate('/dashboard');
+      if (data.mfaRequired) {
+        setMfaChallenge({
+          mfaToken: data.mfaToken,
+          enrollmentRequired: data.enrollmentRequired,
+          methods: data.methods,
+        });
+        return;
+      }
+      completeLogin(data);
     } catch (err) {
       setError(err.message);
     }
   };
 
-  const handleGoogleSuccess = async (tokenResponse) => {
+  const handlePasskeyLogin = async () => {
     setError('');
     try {
-      const data = await loginWithGoogle(tokenResponse.access_token);
-      localStorage.setItem('token', data.token);
-      localStorage.setItem('user', JSON.stringify(data.user));
-      localStorage.setItem('patientId', data.user.patientId); // Store patientId in localStorage
-      navigate('/dashboard');
+      completeLogin(await loginWithPasskey(email || undefined));
     } catch (err) {
       setError(err.message);
     }
   };
 
-  const handleGoogleError = () => {
-    setError('Google login failed');
-  };
-
   return (
     <Box
       sx={{
@@ -86,6 +98,14 @@ function Login() {
             </Typography>
           </Box>
           {error && <Alert severity="error" sx={{ mb: 2 }}>{error}</Alert>}
+          {mfaChallenge ? (
+            <MfaChallenge
+              mfaToken={mfaChallenge.mfaToken}
+              enrollmentRequired={mfaChallenge.enrollmentRequired}
+              methods={mfaChallenge.methods}
+              onComplete={completeLogin}
+            />
+          ) : (
           <form onSubmit={handleSubmit}>
             <Stack spacing={2}>
               <TextField
@@ -122,7 +142,7 @@ function Login() {
                 }}
               />
               <Box sx={{ display: 'flex', justifyContent: 'flex-end' }}>
-                <Link component={RouterLink} to="#" underline="hover" color="primary" fontSize={14}>
+                <Link component={RouterLink} to="/forgot-password" underline="hover" color="primary" fontSize={14}>
                   Forgot password?
                 </Link>
               </Box>
@@ -137,9 +157,15 @@ function Login() {
                 Sign In
               </Button>
               <Divider sx={{ my: 1 }}>or</Divider>
-              <GoogleLoginButton onSuccess={handleGoogleSuccess} onError={handleGoogleError} />
+              {window.PublicKeyCredential && (
+                <Button variant="outlined" size="large" fullWidth onClick={handlePasskeyLogin}>
+                  Sign in with a passkey
+                </Button>
+              )}
+              <IdentityProviderButtons returnTo={nextPath} onError={setError} />
             </Stack>
           </form>
+          )}
           <Box sx={{ mt: 3, textAlign: 'center' }}>
             <Typography variant="body2" color="text.secondary">
               Don&apos;t have an account?{' '}
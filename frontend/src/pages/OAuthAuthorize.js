@@ -0,0 +1,99 @@
+import React, { useCallback, useEffect, useState } from 'react';
+import { useLocation, useNavigate } from 'react-router-dom';
+import { Container, Typography, Alert, CircularProgress, Box, Paper, List, ListItem, ListItemText, Stack, Button } from '@mui/material';
+import { approveAuthorization } from '../api/auth';
+
+// What each identity scope releases; permission scopes are shown by name
+const SCOPE_DESCRIPTIONS = {
+  openid: 'Sign you in with your Healthcare account',
+  profile: 'See your name',
+  email: 'See your email address',
+  roles: 'See your role and permissions',
+  offline_access: 'Stay signed in when you are not using it',
+};
+
+// Landing page for the auth service's OpenID Connect authorize endpoint.
+// Signs the user in if needed, asks them to approve the client's scopes,
+// then sends them back to the requesting client.
+function OAuthAuthorize() {
+  const location = useLocation();
+  const navigate = useNavigate();
+  const [error, setError] = useState('');
+  const [consent, setConsent] = useState(null);
+
+  // The answer to the consent screen comes from the user, never the URL
+  const submit = useCallback((answer) => {
+    const params = Object.fromEntries(new URLSearchParams(location.search));
+    delete params.consent;
+    if (answer) params.consent = answer;
+    setConsent(null);
+    approveAuthorization(params)
+      .then((result) => {
+        if (result.consentRequired) {
+          setConsent(result);
+          return;
+        }
+        window.location.assign(result.redirectTo);
+      })
+      .catch((err) => {
+        if (err.status === 401) {
+          localStorage.removeItem('token');
+          const returnTo = `${location.pathname}${location.search}`;
+          navigate(`/login?returnTo=${encodeURIComponent(returnTo)}`, { replace: true });
+          return;
+        }
+        setError(err.message);
+      });
+  }, [location, navigate]);
+
+  useEffect(() => {
+    const token = localStorage.getItem('token');
+    if (!token) {
+      const returnTo = `${location.pathname}${location.search}`;
+      navigate(`/login?returnTo=${encodeURIComponent(returnTo)}`, { replace: true });
+      return;
+    }
+    submit(null);
+  }, [location, navigate, submit]);
+
+  if (consent) {
+    return (
+      <Container maxWidth="sm">
+        <Paper sx={{ mt: 8, p: 4 }}>
+          <Typography variant="h5" gutterBottom>
+            {consent.client.name} wants to access your account
+          </Typography>
+          <Typography color="text.secondary">It will be able to:</Typography>
+          <List dense>
+            {consent.scopes.map((scope) => (
+              <ListItem key={scope}>
+                <ListItemText primary={SCOPE_DESCRIPTIONS[scope] || `Use the ${scope} permission`} />
+              </ListItem>
+            ))}
+          </List>
+          <Stack direction="row" spacing={2} justifyContent="flex-end">
+            <Button onClick={() => submit('deny')}>Deny</Button>
+            <Button variant="contained" onClick={() => submit('approve')}>Allow</Button>
+          </Stack>
+        </Paper>
+      </Container>
+    );
+  }
+
+  return (
+    <Container maxWidth="sm">
+      <Box sx={{ mt: 8, textAlign: 'center' }}>
+        {error ? (
+          <Alert severity="error">{error}</Alert>
+        ) : (
+          <>
+            <CircularProgress />
+            <Typography sx={{ mt: 2 }}>Signing you in...</Typography>
+          </>
+        )}
+      </Box>
+    </Container>
+  );
+}
+
+export default OAuthAuthorize;
@@ -130,7 +130,9 @@ const PatientDashboard = () => {
       await Promise.all(
         appointmentsData.map(async (appointment) => {
           try {
-            const response = await fetch(`/api/appointments/${appointment._id}/payment`);
+            const response = await fetch(`/api/appointments/${appointment._id}/payment`, {
+              headers: { Authorization: `Bearer ${localStorage.getItem('token')}` },
+            });
             if (response.ok) {
               const payment = await response.json();
               paymentsData[appointment._id] = payment;
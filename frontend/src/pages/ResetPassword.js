@@ -0,0 +1,78 @@
+import React, { useState } from 'react';
+import { Link as RouterLink, useLocation } from 'react-router-dom';
+import { Container, Paper, Typography, TextField, Button, Alert, Stack, Link } from '@mui/material';
+import { resetPassword } from '../api/auth';
+
+function ResetPassword() {
+  const location = useLocation();
+  const token = new URLSearchParams(location.search).get('token');
+  const [password, setPassword] = useState('');
+  const [confirmPassword, setConfirmPassword] = useState('');
+  const [done, setDone] = useState(false);
+  const [error, setError] = useState('');
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setError('');
+    if (password !== confirmPassword) {
+      setError('Passwords do not match');
+      return;
+    }
+    try {
+      await resetPassword(token, password);
+      // Every existing session was signed out by the reset
+      localStorage.removeItem('token');
+      localStorage.removeItem('refreshToken');
+      setDone(true);
+    } catch (err) {
+      setError(err.message);
+    }
+  };
+
+  return (
+    <Container maxWidth="sm">
+      <Paper elevation={6} sx={{ p: 4, mt: 8, borderRadius: 3 }}>
+        <Typography variant="h5" fontWeight={700} gutterBottom>
+          Choose a new password
+        </Typography>
+        {done ? (
+          <Alert severity="success">
+            Your password has been reset.{' '}
+            <Link component={RouterLink} to="/login">Sign in</Link>
+          </Alert>
+        ) : !token ? (
+          <Alert severity="error">This reset link is missing its token.</Alert>
+        ) : (
+          <form onSubmit={handleSubmit}>
+            <Stack spacing={2}>
+              {error && <Alert severity="error">{error}</Alert>}
+              <TextField
+                label="New password"
+                type="password"
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                required
+                fullWidth
+                autoComplete="new-password"
+              />
+              <TextField
+                label="Confirm new password"
+                type="password"
+                value={confirmPassword}
+                onChange={(e) => setConfirmPassword(e.target.value)}
+                required
+                fullWidth
+                autoComplete="new-password"
+              />
+              <Button type="submit" variant="contained" size="large" fullWidth>
+                Reset password
+              </Button>
+            </Stack>
+          </form>
+        )}
+      </Paper>
+    </Container>
+  );
+}
+
+export default ResetPassword;
@@ -11,8 +11,8 @@ import {
   MenuItem,
   Snackbar
 } from '@mui/material';
-import { registerPatient, loginWithGoogle } from '../api/auth';
-import GoogleLoginButton from '../components/GoogleLoginButton';
+import { registerPatient } from '../api/auth';
+import IdentityProviderButtons from '../components/IdentityProviderButtons';
 
 const genders = [
   { value: 'male', label: 'Male' },
@@ -110,22 +110,6 @@ function SignUp() {
     }
   };
 
-  const handleGoogleSuccess = async (tokenResponse) => {
-    setError('');
-    try {
-      const data = await loginWithGoogle(tokenResponse.access_token);
-      localStorage.setItem('token', data.token);
-      localStorage.setItem('user', JSON.stringify(data.user));
-      navigate('/');
-    } catch (err) {
-      setError(err.message);
-    }
-  };
-
-  const handleGoogleError = (error) => {
-    setError('Google login failed');
-  };
-
   const handleCloseSnackbar = () => {
     setSuccess(false);
   };
@@ -184,7 +168,7 @@ function SignUp() {
             <Button type="submit" variant="contained" color="primary" fullWidth disabled={submitting}>
               {submitting ? 'Registering...' : 'Register'}
             </Button>
-            <GoogleLoginButton onSuccess={handleGoogleSuccess} onError={handleGoogleError} />
+            <IdentityProviderButtons onError={setError} />
           </Stack>
         </form>
       </Box>
@@ -30,6 +30,7 @@ const SuperAdminDashboard = ({ userPermissions = ['all_permissions'] }) => {
       'doctor:list', 'doctor:view', 'doctor:approve', 'doctor:reject', 'doctor:delete',
       'patient:create', 'patient:delete', 'patient:list', 'patient:view',
       'system:config', 'system:metrics', 'system:logs',
+      'user:impersonate',
     ]
     : permissions;
 
@@ -0,0 +1,82 @@
+import React, { useEffect, useState } from 'react';
+import { Link as RouterLink, useLocation } from 'react-router-dom';
+import { Container, Paper, Typography, TextField, Button, Alert, Stack, Link, CircularProgress } from '@mui/material';
+import { verifyEmail, resendVerificationEmail } from '../api/auth';
+
+// Target of the link in the verification email; also lets users ask for a new link
+function VerifyEmail() {
+  const location = useLocation();
+  const token = new URLSearchParams(location.search).get('token');
+  const [status, setStatus] = useState(token ? 'verifying' : 'idle');
+  const [error, setError] = useState('');
+  const [email, setEmail] = useState('');
+  const [message, setMessage] = useState('');
+
+  useEffect(() => {
+    if (!token) return;
+    verifyEmail(token)
+      .then(() => setStatus('verified'))
+      .catch((err) => {
+        setError(err.message);
+        setStatus('idle');
+      });
+  }, [token]);
+
+  const handleResend = async (e) => {
+    e.preventDefault();
+    setError('');
+    try {
+      const data = await resendVerificationEmail(email);
+      setMessage(data.message);
+    } catch (err) {
+      setError(err.message);
+    }
+  };
+
+  return (
+    <Container maxWidth="sm">
+      <Paper elevation={6} sx={{ p: 4, mt: 8, borderRadius: 3 }}>
+        <Typography variant="h5" fontWeight={700} gutterBottom>
+          Verify your email
+        </Typography>
+        {status === 'verifying' && <CircularProgress />}
+        {status === 'verified' && (
+          <Alert severity="success">
+            Your email address is verified.{' '}
+            <Link component={RouterLink} to="/login">Sign in</Link>
+          </Alert>
+        )}
+        {status === 'idle' && (
+          <form onSubmit={handleResend}>
+            <Stack spacing={2}>
+              {error && <Alert severity="error">{error}</Alert>}
+              {message ? (
+                <Alert severity="success">{message}</Alert>
+              ) : (
+                <>
+                  <Typography variant="body2" color="text.secondary">
+                    Enter your email to receive a new verification link.
+                  </Typography>
+                  <TextField
+                    label="Email"
+                    type="email"
+                    value={email}
+                    onChange={(e) => setEmail(e.target.value)}
+                    required
+                    fullWidth
+                    autoComplete="email"
+                  />
+                  <Button type="submit" variant="contained" size="large" fullWidth>
+                    Send verification link
+                  </Button>
+                </>
+              )}
+            </Stack>
+          </form>
+        )}
+      </Paper>
+    </Container>
+  );
+}
+
+export default VerifyEmail;
@@ -1,5 +1,6 @@
 const axios = require('axios');
 const serviceConfig = require('../config/serviceConfig');
+const { serviceAuthHeaders } = require('./serviceToken');
 
 class AppointmentClient {
   constructor() {
@@ -8,7 +9,9 @@ class AppointmentClient {
 
   async getAppointmentById(id) {
     try {
-      const response = await axios.get(`${this.baseUrl}/api/appointments/${id}`);
+      const response = await axios.get(`${this.baseUrl}/api/appointments/${id}`, {
+        headers: await serviceAuthHeaders(),
+      });
       return response.data;
     } catch (error) {
       throw new Error('Failed to get appointment from appointment service');
@@ -1,5 +1,6 @@
 const axios = require('axios');
 const serviceConfig = require('../config/serviceConfig');
+const { serviceAuthHeaders } = require('./serviceToken');
 
 class AuthClient {
   constructor() {
@@ -8,7 +9,9 @@ class AuthClient {
 
   async getUserById(id) {
     try {
-      const response = await axios.get(`${this.baseUrl}/api/users/${id}`);
+      const response = await axios.get(`${this.baseUrl}/api/users/${id}`, {
+        headers: await serviceAuthHeaders(),
+      });
       return response.data;
     } catch (error) {
       throw new Error('Failed to get user from authentication service');
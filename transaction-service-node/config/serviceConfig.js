@@ -2,4 +2,8 @@ module.exports = {
   port: process.env.PORT || 8082,
   mongoUri: process.env.MONGO_URI || `mongodb://${process.env.MONGO_HOST || 'localhost'}:${process.env.MONGO_PORT || 27017}`,
   mongoDbName: process.env.MONGO_DB_NAME || 'transactiondb',
+  // Service account used for calls to the other services
+  authTokenUrl: process.env.AUTH_TOKEN_URL || 'http://localhost:8000/oauth/token',
+  authClientId: process.env.AUTH_CLIENT_ID,
+  authClientSecret: process.env.AUTH_CLIENT_SECRET,
 };